@@ -155,6 +155,21 @@ def convert(infile):
             amode = chdata.setdefault('amode', {})
             amode[target] = mode
             chdata['amode'] = amode
+        elif obj.type == 'AutoKick':
+            # the target is either an account (nc) or a mask
+            chname = obj.kv['ci']
+            akick = {
+                'reason': obj.kv.get('reason', ''),
+                'setBy': obj.kv.get('creator', ''),
+                'setAt': to_unixnano(obj.kv.get('addtime', 0)),
+            }
+            if obj.kv.get('nc'):
+                akick['account'] = obj.kv['nc']
+            elif obj.kv.get('mask'):
+                akick['mask'] = obj.kv['mask']
+            else:
+                continue
+            out['channels'][chname].setdefault('akicks', []).append(akick)
 
     # do some basic integrity checks
     for chname, chdata in out['channels'].items():
@@ -31,6 +31,9 @@ def convert(infile):
 
     channel_to_founder = defaultdict(lambda: (None, None))
 
+    # (channel, target) -> akick entry, so that MDA lines can fill in the reason
+    akicks = {}
+
     for line in infile:
         line = line.rstrip('\r\n')
         parts = line.split(' ')
@@ -132,6 +135,18 @@ def convert(infile):
                 # but multiple people can receive the 'q' amode
                 chdata['amode'][username] = 'q'
                 continue
+            # +b is AKICK; the target can be a mask or an account
+            if 'b' in flags:
+                if username.startswith('!'):
+                    continue
+                akick = {'setBy': parts[5] if len(parts) > 5 else '', 'setAt': to_unixnano(set_at)}
+                if MASK_MAGIC_REGEX.search(username):
+                    akick['mask'] = username
+                else:
+                    akick['account'] = username
+                chdata.setdefault('akicks', []).append(akick)
+                akicks[(chname, username)] = akick
+                continue
             if MASK_MAGIC_REGEX.search(username):
                 # ignore groups, masks, etc. for any field other than founder
                 continue
@@ -150,6 +165,19 @@ def convert(infile):
                 chdata['amode'][username] = 'h'
             elif 'v' in flags or 'V' in flags:
                 chdata['amode'][username] = 'v'
+        elif category == 'MDA':
+            # auxiliary data for a channel access entry; for AKICK, the reason
+            # (public|private) and the expiration time
+            # MDA #mychannel:baduser reason spamming|reported by dan
+            # MDA #mychannel:baduser expires 1600467343
+            chname, _, target = parts[1].partition(':')
+            akick = akicks.get((chname, target))
+            if akick is None:
+                continue
+            if parts[2] == 'reason':
+                akick['reason'] = line.split(maxsplit=3)[3]
+            elif parts[2] == 'expires':
+                akick['expiresAt'] = to_unixnano(parts[3])
         else:
             pass
 
@@ -177,6 +177,30 @@ CAPDEFS = [
         url="https://github.com/ircv3/ircv3-specifications/pull/435",
         standard="draft IRCv3",
     ),
+    CapDef(
+        identifier="Metadata",
+        name="draft/metadata-2",
+        url="https://github.com/ircv3/ircv3-specifications/pull/501",
+        standard="draft IRCv3",
+    ),
+    CapDef(
+        identifier="ReadMarker",
+        name="draft/read-marker",
+        url="https://github.com/ircv3/ircv3-specifications/pull/489",
+        standard="draft IRCv3",
+    ),
+    CapDef(
+        identifier="MessageRedaction",
+        name="draft/message-redaction",
+        url="https://github.com/ircv3/ircv3-specifications/pull/524",
+        standard="draft IRCv3",
+    ),
+    CapDef(
+        identifier="MessageEdit",
+        name="draft/message-edit",
+        url="https://github.com/ergochat/ergo/blob/master/docs/MANUAL.md#history",
+        standard="draft IRCv3",
+    ),
 ]
 
 def validate_defs():
@@ -5,6 +5,9 @@ package irc
 
 import (
 	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"crypto/x509"
 	"encoding/json"
 	"fmt"
@@ -19,9 +22,12 @@ import (
 
 	"github.com/ergochat/ergo/irc/connection_limits"
 	"github.com/ergochat/ergo/irc/email"
+	"github.com/ergochat/ergo/irc/ldap"
 	"github.com/ergochat/ergo/irc/migrations"
 	"github.com/ergochat/ergo/irc/modes"
 	"github.com/ergochat/ergo/irc/passwd"
+	"github.com/ergochat/ergo/irc/scram"
+	"github.com/ergochat/ergo/irc/totp"
 	"github.com/ergochat/ergo/irc/utils"
 	"github.com/tidwall/buntdb"
 )
@@ -41,9 +47,15 @@ const (
 	keyCertToAccount           = "account.creds.certfp %s"
 	keyAccountChannels         = "account.channels %s" // channels registered to the account
 	keyAccountLastSeen         = "account.lastseen %s"
-	keyAccountModes            = "account.modes %s"     // user modes for the always-on client as a string
-	keyAccountRealname         = "account.realname %s"  // client realname stored as string
-	keyAccountSuspended        = "account.suspended %s" // client realname stored as string
+	keyAccountModes            = "account.modes %s"       // user modes for the always-on client as a string
+	keyAccountRealname         = "account.realname %s"    // client realname stored as string
+	keyAccountSuspended        = "account.suspended %s"   // client realname stored as string
+	keyAccountSilence          = "account.silence %s"     // JSON list of SILENCE entries
+	keyAccountAccept           = "account.accept %s"      // JSON list of ACCEPT entries for the always-on client
+	keyAccountReadMarkers      = "account.readmarkers %s" // JSON map of casefolded targets to read markers
+	keyAccountMetadata         = "account.metadata %s"    // JSON map of draft/metadata-2 keys to values
+	keyAccountPwReset          = "account.pwreset %s"     // JSON PasswordResetRecord
+	keyAccountEmailChange      = "account.emailchange %s" // JSON EmailChangeRecord
 	// for an always-on client, a map of channel names they're in to their current modes
 	// (not to be confused with their amodes, which a non-always-on client can have):
 	keyAccountChannelToModes = "account.channeltomodes %s"
@@ -64,6 +76,10 @@ type AccountManager struct {
 	skeletonToAccount map[string]string
 	accountToMethod   map[string]NickEnforcementMethod
 	registerThrottle  connection_limits.GenericThrottle
+	// per-IP throttling of password reset requests:
+	pwResetThrottles map[string]*connection_limits.GenericThrottle
+	// per-IP throttling of email address changes:
+	emailChangeThrottles map[string]*connection_limits.GenericThrottle
 }
 
 func (am *AccountManager) Initialize(server *Server) {
@@ -71,6 +87,8 @@ func (am *AccountManager) Initialize(server *Server) {
 	am.nickToAccount = make(map[string]string)
 	am.skeletonToAccount = make(map[string]string)
 	am.accountToMethod = make(map[string]NickEnforcementMethod)
+	am.pwResetThrottles = make(map[string]*connection_limits.GenericThrottle)
+	am.emailChangeThrottles = make(map[string]*connection_limits.GenericThrottle)
 	am.server = server
 
 	config := server.Config()
@@ -124,11 +142,13 @@ func (am *AccountManager) createAlwaysOnClients(config *Config) {
 		account, err := am.LoadAccount(accountName)
 		if err == nil && (account.Verified && account.Suspended == nil) &&
 			persistenceEnabled(config.Accounts.Multiclient.AlwaysOn, account.Settings.AlwaysOn) {
+			uModes, accept := am.loadModes(accountName)
 			am.server.AddAlwaysOnClient(
 				account,
 				am.loadChannels(accountName),
 				am.loadLastSeen(accountName),
-				am.loadModes(accountName),
+				uModes,
+				accept,
 				am.loadRealname(accountName),
 			)
 		}
@@ -572,6 +592,183 @@ func (am *AccountManager) setPassword(account string, password string, hasPrivs
 	return err
 }
 
+// adds SCRAM credentials for an account whose credentials predate them;
+// the passphrase must already have been verified against passphraseHash
+func (am *AccountManager) addScramCredentials(account string, passphraseHash []byte, passphrase string) (err error) {
+	cfAccount, err := CasefoldName(account)
+	if err != nil {
+		return errAccountDoesNotExist
+	}
+	// PBKDF2 is slow, so do it outside the transaction
+	sha256Creds, sha512Creds, err := newScramCredentials(passphrase)
+	if err != nil {
+		return err
+	}
+
+	credKey := fmt.Sprintf(keyAccountCredentials, cfAccount)
+	return am.server.store.Update(func(tx *buntdb.Tx) error {
+		credStr, err := tx.Get(credKey)
+		if err != nil {
+			return errAccountDoesNotExist
+		}
+		var creds AccountCredentials
+		if err = json.Unmarshal([]byte(credStr), &creds); err != nil {
+			return err
+		}
+		// don't clobber a concurrent change of passphrase
+		if creds.Version != CredentialsSHA3Bcrypt || !bytes.Equal(creds.PassphraseHash, passphraseHash) {
+			return errCASFailed
+		}
+		creds.ScramSHA256, creds.ScramSHA512 = sha256Creds, sha512Creds
+		newCredStr, err := creds.Serialize()
+		if err != nil {
+			return err
+		}
+		_, _, err = tx.Set(credKey, newCredStr, nil)
+		return err
+	})
+}
+
+// modifyCredentials atomically updates the stored credentials of an account;
+// unlike (*AccountCredentials).Serialize, it preserves the credentials version
+func (am *AccountManager) modifyCredentials(account string, munger func(creds *AccountCredentials) error) (err error) {
+	cfAccount, err := CasefoldName(account)
+	if err != nil {
+		return errAccountDoesNotExist
+	}
+	credKey := fmt.Sprintf(keyAccountCredentials, cfAccount)
+	return am.server.store.Update(func(tx *buntdb.Tx) error {
+		credStr, err := tx.Get(credKey)
+		if err != nil {
+			return errAccountDoesNotExist
+		}
+		var creds AccountCredentials
+		if err = json.Unmarshal([]byte(credStr), &creds); err != nil {
+			return err
+		}
+		if err = munger(&creds); err != nil {
+			return err
+		}
+		newCredStr, err := json.Marshal(creds)
+		if err != nil {
+			return err
+		}
+		_, _, err = tx.Set(credKey, string(newCredStr), nil)
+		return err
+	})
+}
+
+const (
+	totpBackupCodeCount = 10
+)
+
+func hashTOTPBackupCode(code string) []byte {
+	code = strings.ToLower(strings.Replace(code, "-", "", -1))
+	hash := sha256.Sum256([]byte(code))
+	return hash[:]
+}
+
+// checkCode verifies a TOTP code, or consumes a backup code
+func (tc *TOTPCredentials) checkCode(code string, now time.Time) bool {
+	if counter, ok := totp.Validate(tc.Secret, code, now, tc.LastCounter); ok {
+		tc.LastCounter = counter
+		return true
+	}
+	hash := hashTOTPBackupCode(code)
+	for i, backupCode := range tc.BackupCodes {
+		if subtle.ConstantTimeCompare(hash, backupCode) == 1 {
+			tc.BackupCodes = append(tc.BackupCodes[:i], tc.BackupCodes[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// checkTOTPCode verifies the second factor of a login to an account with 2FA
+func (am *AccountManager) checkTOTPCode(account, code string) (err error) {
+	if code == "" {
+		return errTOTPRequired
+	}
+	return am.modifyCredentials(account, func(creds *AccountCredentials) error {
+		if creds.TOTPEnabled() && !creds.TOTP.checkCode(code, time.Now()) {
+			return errInvalidTOTPCode
+		}
+		return nil
+	})
+}
+
+// totpRequired returns whether logging in with the credentials requires a TOTP
+// code: 2FA is enabled for the account, and hasn't been disabled server-wide.
+func (am *AccountManager) totpRequired(creds *AccountCredentials) bool {
+	return am.server.Config().Accounts.TOTP.Enabled && creds.TOTPEnabled()
+}
+
+// StartTOTPEnrolment generates a new TOTP secret for an account; it is not
+// required to log in until the enrolment is confirmed with ConfirmTOTPEnrolment.
+func (am *AccountManager) StartTOTPEnrolment(account string) (secret []byte, err error) {
+	secret, err = totp.GenerateSecret()
+	if err != nil {
+		return
+	}
+	err = am.modifyCredentials(account, func(creds *AccountCredentials) error {
+		if creds.TOTPEnabled() {
+			return errNoop
+		}
+		creds.TOTP = &TOTPCredentials{Secret: secret}
+		return nil
+	})
+	return
+}
+
+// ConfirmTOTPEnrolment enables 2FA for an account, given a valid code for its
+// new secret. It returns single-use backup codes, which can be used instead of
+// TOTP codes (e.g., if the user loses their authenticator).
+func (am *AccountManager) ConfirmTOTPEnrolment(account, code string) (backupCodes []string, err error) {
+	hashes := make([][]byte, totpBackupCodeCount)
+	for i := range hashes {
+		var buf [5]byte
+		if _, err = rand.Read(buf[:]); err != nil {
+			return
+		}
+		code := utils.B32Encoder.EncodeToString(buf[:])
+		backupCodes = append(backupCodes, code[:4]+"-"+code[4:])
+		hashes[i] = hashTOTPBackupCode(code)
+	}
+
+	err = am.modifyCredentials(account, func(creds *AccountCredentials) error {
+		if creds.TOTP == nil || creds.TOTP.Enabled {
+			return errNoop
+		}
+		counter, ok := totp.Validate(creds.TOTP.Secret, code, time.Now(), 0)
+		if !ok {
+			return errInvalidTOTPCode
+		}
+		creds.TOTP.Enabled = true
+		creds.TOTP.LastCounter = counter
+		creds.TOTP.BackupCodes = hashes
+		return nil
+	})
+	if err != nil {
+		backupCodes = nil
+	}
+	return
+}
+
+// DisableTOTP removes 2FA (or cancels an enrolment) for an account; unless
+// the caller is an operator, this requires a valid TOTP or backup code.
+func (am *AccountManager) DisableTOTP(account, code string, hasPrivs bool) (err error) {
+	return am.modifyCredentials(account, func(creds *AccountCredentials) error {
+		if creds.TOTP == nil {
+			return errNoop
+		}
+		if creds.TOTP.Enabled && !hasPrivs && !creds.TOTP.checkCode(code, time.Now()) {
+			return errInvalidTOTPCode
+		}
+		creds.TOTP = nil
+		return nil
+	})
+}
+
 type alwaysOnChannelStatus struct {
 	Modes    string
 	JoinTime int64
@@ -609,25 +806,45 @@ func (am *AccountManager) loadChannels(account string) (channelToModes map[strin
 	return
 }
 
-func (am *AccountManager) saveModes(account string, uModes modes.Modes) {
+// saveModes stores the user modes of an always-on client, together with
+// its caller-ID accept list
+func (am *AccountManager) saveModes(account string, uModes modes.Modes, accept []string) {
 	modeStr := uModes.String()
 	key := fmt.Sprintf(keyAccountModes, account)
+	acceptKey := fmt.Sprintf(keyAccountAccept, account)
+	var acceptStr string
+	if len(accept) != 0 {
+		text, _ := json.Marshal(accept)
+		acceptStr = string(text)
+	}
 	am.server.store.Update(func(tx *buntdb.Tx) error {
 		tx.Set(key, modeStr, nil)
+		if acceptStr != "" {
+			tx.Set(acceptKey, acceptStr, nil)
+		} else {
+			tx.Delete(acceptKey)
+		}
 		return nil
 	})
 }
 
-func (am *AccountManager) loadModes(account string) (uModes modes.Modes) {
+func (am *AccountManager) loadModes(account string) (uModes modes.Modes, accept []string) {
 	key := fmt.Sprintf(keyAccountModes, account)
-	var modeStr string
+	acceptKey := fmt.Sprintf(keyAccountAccept, account)
+	var modeStr, acceptStr string
 	am.server.store.View(func(tx *buntdb.Tx) error {
 		modeStr, _ = tx.Get(key)
+		acceptStr, _ = tx.Get(acceptKey)
 		return nil
 	})
 	for _, m := range modeStr {
 		uModes = append(uModes, modes.Mode(m))
 	}
+	if acceptStr != "" {
+		if err := json.Unmarshal([]byte(acceptStr), &accept); err != nil {
+			am.server.logger.Error("internal", "couldn't unmarshal accept list", account, err.Error())
+		}
+	}
 	return
 }
 
@@ -680,6 +897,128 @@ func (am *AccountManager) saveRealname(account string, realname string) {
 	})
 }
 
+// saveSilence stores the account's silence list and applies it to all
+// clients logged into the account.
+func (am *AccountManager) saveSilence(account string, masks []string) (err error) {
+	key := fmt.Sprintf(keyAccountSilence, account)
+	var val string
+	if len(masks) != 0 {
+		text, _ := json.Marshal(masks)
+		val = string(text)
+	}
+	err = am.server.store.Update(func(tx *buntdb.Tx) error {
+		if val != "" {
+			tx.Set(key, val, nil)
+		} else {
+			tx.Delete(key)
+		}
+		return nil
+	})
+	if err != nil {
+		am.server.logger.Error("internal", "couldn't persist silence list", account, err.Error())
+		return errAccountUpdateFailed
+	}
+
+	silence := newSilenceList(masks)
+	am.RLock()
+	clients := am.accountToClients[account]
+	am.RUnlock()
+	for _, client := range clients {
+		client.setSilence(silence)
+	}
+	return nil
+}
+
+// saveReadMarker advances the account's read marker for `cftarget` to `timestamp`
+// (unless the stored marker is already at or after it), and applies the result
+// to all clients logged into the account.
+func (am *AccountManager) saveReadMarker(account, cftarget string, timestamp time.Time) (result time.Time, changed bool, err error) {
+	key := fmt.Sprintf(keyAccountReadMarkers, account)
+	err = am.server.store.Update(func(tx *buntdb.Tx) error {
+		var markers map[string]time.Time
+		if text, err := tx.Get(key); err == nil {
+			if err := json.Unmarshal([]byte(text), &markers); err != nil {
+				am.server.logger.Warning("internal", "could not unmarshal read markers for account", account, err.Error())
+			}
+		}
+		result = markers[cftarget]
+		if !timestamp.After(result) {
+			return nil
+		}
+		if markers == nil {
+			markers = make(map[string]time.Time)
+		}
+		markers[cftarget] = timestamp
+		text, err := json.Marshal(markers)
+		if err != nil {
+			return err
+		}
+		_, _, err = tx.Set(key, string(text), nil)
+		result, changed = timestamp, err == nil
+		return err
+	})
+	if err != nil {
+		am.server.logger.Error("internal", "couldn't persist read marker", account, err.Error())
+		return time.Time{}, false, errAccountUpdateFailed
+	}
+
+	if changed {
+		am.RLock()
+		clients := am.accountToClients[account]
+		am.RUnlock()
+		for _, client := range clients {
+			client.setReadMarker(cftarget, result)
+		}
+	}
+	return
+}
+
+// saveMetadata applies a draft/metadata-2 change to the account's stored metadata,
+// applies the result to all clients logged into the account, and returns them.
+func (am *AccountManager) saveMetadata(account, key, value string, set bool, maxKeys int) (clients []*Client, changed bool, err error) {
+	dbKey := fmt.Sprintf(keyAccountMetadata, account)
+	var metadata map[string]string
+	err = am.server.store.Update(func(tx *buntdb.Tx) error {
+		var current map[string]string
+		if text, err := tx.Get(dbKey); err == nil {
+			if err := json.Unmarshal([]byte(text), &current); err != nil {
+				am.server.logger.Warning("internal", "could not unmarshal metadata for account", account, err.Error())
+			}
+		}
+		var err error
+		metadata, changed, err = updateMetadata(current, key, value, set, maxKeys)
+		if err != nil || !changed {
+			return err
+		}
+		if len(metadata) == 0 {
+			_, err = tx.Delete(dbKey)
+			return err
+		}
+		text, err := json.Marshal(metadata)
+		if err != nil {
+			return err
+		}
+		_, _, err = tx.Set(dbKey, string(text), nil)
+		return err
+	})
+	if err == errMetadataLimitReached {
+		return
+	} else if err != nil {
+		am.server.logger.Error("internal", "couldn't persist metadata", account, err.Error())
+		return nil, false, errAccountUpdateFailed
+	}
+
+	am.RLock()
+	clients = am.accountToClients[account]
+	am.RUnlock()
+	if changed {
+		for _, client := range clients {
+			client.setMetadata(metadata)
+		}
+	}
+	return
+}
+
 func (am *AccountManager) loadRealname(account string) (realname string) {
 	key := fmt.Sprintf(keyAccountRealname, account)
 	am.server.store.Update(func(tx *buntdb.Tx) error {
@@ -780,15 +1119,7 @@ func (am *AccountManager) dispatchMailtoCallback(client *Client, account string,
 		subject = fmt.Sprintf(client.t("Verify your account on %s"), am.server.name)
 	}
 
-	var message bytes.Buffer
-	fmt.Fprintf(&message, "From: %s\r\n", config.Sender)
-	fmt.Fprintf(&message, "To: %s\r\n", callbackValue)
-	if config.DKIM.Domain != "" {
-		fmt.Fprintf(&message, "Message-ID: <%s@%s>\r\n", utils.GenerateSecretKey(), config.DKIM.Domain)
-	}
-	fmt.Fprintf(&message, "Date: %s\r\n", time.Now().UTC().Format(time.RFC1123Z))
-	fmt.Fprintf(&message, "Subject: %s\r\n", subject)
-	message.WriteString("\r\n") // blank line: end headers, begin message body
+	message := email.ComposeMail(config, callbackValue, subject)
 	fmt.Fprintf(&message, client.t("Account: %s"), account)
 	message.WriteString("\r\n")
 	fmt.Fprintf(&message, client.t("Verification code: %s"), code)
@@ -805,6 +1136,348 @@ func (am *AccountManager) dispatchMailtoCallback(client *Client, account string,
 	return
 }
 
+// PasswordResetRecord is a pending password reset (NS RESETPASS).
+type PasswordResetRecord struct {
+	Code      string
+	CreatedAt time.Time
+}
+
+func (am *AccountManager) touchPasswordResetThrottle(config *Config, ip string) (throttled bool, remainingTime time.Duration) {
+	return am.touchIPThrottle(am.pwResetThrottles, config.Accounts.PasswordReset.IPThrottling, ip)
+}
+
+func (am *AccountManager) touchEmailChangeThrottle(config *Config, ip string) (throttled bool, remainingTime time.Duration) {
+	return am.touchIPThrottle(am.emailChangeThrottles, config.Accounts.EmailChange.IPThrottling, ip)
+}
+
+func (am *AccountManager) touchIPThrottle(throttles map[string]*connection_limits.GenericThrottle, throttleConfig ThrottleConfig, ip string) (throttled bool, remainingTime time.Duration) {
+	if throttleConfig.MaxAttempts == 0 {
+		return
+	}
+
+	am.Lock()
+	defer am.Unlock()
+
+	throttle, ok := throttles[ip]
+	if !ok {
+		// prune expired entries before adding a new one
+		now := time.Now().UTC()
+		for ip, throttle := range throttles {
+			if now.Sub(throttle.Start) > throttleConfig.Duration {
+				delete(throttles, ip)
+			}
+		}
+		throttle = &connection_limits.GenericThrottle{
+			Duration: throttleConfig.Duration,
+			Limit:    throttleConfig.MaxAttempts,
+		}
+		throttles[ip] = throttle
+	}
+	return throttle.Touch()
+}
+
+// SendPasswordReset emails a password reset code to the verified address of an
+// account. To avoid revealing whether the account exists (or has an address),
+// it succeeds regardless, unless the client is throttled; the email is sent
+// asynchronously, so that the timing of the response doesn't reveal it either.
+func (am *AccountManager) SendPasswordReset(client *Client, accountName string) (err error) {
+	config := am.server.Config()
+	if !config.Accounts.PasswordReset.Enabled {
+		return errFeatureDisabled
+	}
+	if throttled, remainingTime := am.touchPasswordResetThrottle(config, client.IP().String()); throttled {
+		return &ThrottleError{remainingTime}
+	}
+	go am.sendPasswordReset(client, accountName, config)
+	return nil
+}
+
+func (am *AccountManager) sendPasswordReset(client *Client, accountName string, config *Config) {
+	account, err := am.LoadAccount(accountName)
+	if err != nil || !account.Verified || account.Suspended != nil || account.Email == "" {
+		return
+	}
+
+	resetConfig := config.Accounts.PasswordReset
+	record := PasswordResetRecord{
+		Code:      utils.GenerateSecretToken(),
+		CreatedAt: time.Now().UTC(),
+	}
+	recordBytes, _ := json.Marshal(record)
+	// keep the record until both the code and the cooldown have expired
+	ttl := time.Duration(resetConfig.Timeout)
+	if cooldown := time.Duration(resetConfig.Cooldown); ttl < cooldown {
+		ttl = cooldown
+	}
+	recordKey := fmt.Sprintf(keyAccountPwReset, account.NameCasefolded)
+	err = am.server.store.Update(func(tx *buntdb.Tx) error {
+		if existingStr, err := tx.Get(recordKey); err == nil {
+			var existing PasswordResetRecord
+			if json.Unmarshal([]byte(existingStr), &existing) == nil && time.Since(existing.CreatedAt) < time.Duration(resetConfig.Cooldown) {
+				return errLimitExceeded
+			}
+		}
+		_, _, err := tx.Set(recordKey, string(recordBytes), &buntdb.SetOptions{Expires: true, TTL: ttl})
+		return err
+	})
+	if err != nil {
+		if err != errLimitExceeded {
+			am.server.logger.Error("internal", "could not store password reset code", account.Name, err.Error())
+		}
+		return
+	}
+
+	mailConfig := config.Accounts.Registration.EmailVerification
+	subject := fmt.Sprintf(client.t("Reset your password on %s"), am.server.name)
+	message := email.ComposeMail(mailConfig, account.Email, subject)
+	fmt.Fprintf(&message, client.t("Account: %s"), account.Name)
+	message.WriteString("\r\n")
+	fmt.Fprintf(&message, client.t("Password reset code: %s"), record.Code)
+	message.WriteString("\r\n")
+	message.WriteString("\r\n")
+	message.WriteString(client.t("To reset your password, issue the following command (replacing <password> with your new password):"))
+	message.WriteString("\r\n")
+	fmt.Fprintf(&message, "/MSG NickServ RESETPASS %s %s <password>\r\n", account.Name, record.Code)
+	message.WriteString("\r\n")
+	message.WriteString(client.t("If you didn't request a password reset, you can ignore this message."))
+	message.WriteString("\r\n")
+
+	err = email.SendMail(mailConfig, account.Email, message.Bytes())
+	if err != nil {
+		am.server.logger.Error("internal", "Failed to dispatch e-mail to", account.Email, err.Error())
+	} else {
+		am.server.logger.Info("accounts", "sent password reset code for account", account.Name)
+	}
+}
+
+// ResetPassword completes a password reset, given a code from SendPasswordReset.
+func (am *AccountManager) ResetPassword(client *Client, accountName, code, passphrase string) (err error) {
+	config := am.server.Config()
+	if !config.Accounts.PasswordReset.Enabled {
+		return errFeatureDisabled
+	}
+	if throttled, remainingTime := client.checkLoginThrottle(); throttled {
+		return &ThrottleError{remainingTime}
+	}
+	if validatePassphrase(passphrase) != nil {
+		return errAccountBadPassphrase
+	}
+	cfAccount, err := CasefoldName(accountName)
+	if err != nil {
+		return errInvalidPasswordResetCode
+	}
+
+	// the code is single-use: consume it whether or not the rest succeeds
+	recordKey := fmt.Sprintf(keyAccountPwReset, cfAccount)
+	err = am.server.store.Update(func(tx *buntdb.Tx) error {
+		recordStr, err := tx.Get(recordKey)
+		if err != nil {
+			return errInvalidPasswordResetCode
+		}
+		var record PasswordResetRecord
+		if json.Unmarshal([]byte(recordStr), &record) != nil || record.Code == "" ||
+			time.Since(record.CreatedAt) > time.Duration(config.Accounts.PasswordReset.Timeout) ||
+			!utils.SecretTokensMatch(record.Code, code) {
+			return errInvalidPasswordResetCode
+		}
+		// keep the record (without the code) until the cooldown expires
+		record.Code = ""
+		recordBytes, _ := json.Marshal(record)
+		_, _, err = tx.Set(recordKey, string(recordBytes), &buntdb.SetOptions{Expires: true, TTL: time.Duration(config.Accounts.PasswordReset.Cooldown)})
+		return err
+	})
+	if err != nil {
+		return
+	}
+
+	account, err := am.LoadAccount(cfAccount)
+	if err != nil {
+		return errInvalidPasswordResetCode
+	} else if account.Suspended != nil {
+		return errAccountSuspended
+	}
+	// the reset is authorized by the email address, like an operator's SAPASSWD,
+	// so it can also add a passphrase to an account that only has certfps
+	err = am.setPassword(account.Name, passphrase, true)
+	if err != nil {
+		return
+	}
+
+	if config.Accounts.PasswordReset.KillSessions {
+		for _, session := range am.AccountToClients(cfAccount) {
+			session.Logout()
+			session.Quit(session.t("Your password was reset"), nil)
+			session.destroy(nil)
+		}
+	}
+	return nil
+}
+
+// EmailChangeRecord is an email address change awaiting verification (NS SET EMAIL).
+// After verification, it is kept (without the address and code) until the
+// cooldown expires.
+type EmailChangeRecord struct {
+	Email     string
+	Code      string
+	CreatedAt time.Time
+}
+
+// StartEmailChange sends a verification code to a new email address for an
+// account; the address replaces the current one after VerifyEmailChange.
+func (am *AccountManager) StartEmailChange(client *Client, accountName, address string) (err error) {
+	config := am.server.Config()
+	mailConfig := config.Accounts.Registration.EmailVerification
+	if !mailConfig.Enabled {
+		return errFeatureDisabled
+	}
+	if throttled, remainingTime := am.touchEmailChangeThrottle(config, client.IP().String()); throttled {
+		return &ThrottleError{remainingTime}
+	}
+	account, err := am.LoadAccount(accountName)
+	if err != nil {
+		return
+	} else if !account.Verified {
+		return errAccountUnverified
+	}
+
+	record := EmailChangeRecord{
+		Email:     address,
+		Code:      utils.GenerateSecretToken(),
+		CreatedAt: time.Now().UTC(),
+	}
+	recordBytes, _ := json.Marshal(record)
+	cooldown := time.Duration(config.Accounts.EmailChange.Cooldown)
+	var setOptions *buntdb.SetOptions
+	if ttl := time.Duration(config.Accounts.Registration.VerifyTimeout); ttl != 0 {
+		// keep the record until both the code and the cooldown have expired
+		if ttl < cooldown {
+			ttl = cooldown
+		}
+		setOptions = &buntdb.SetOptions{Expires: true, TTL: ttl}
+	}
+	recordKey := fmt.Sprintf(keyAccountEmailChange, account.NameCasefolded)
+	err = am.server.store.Update(func(tx *buntdb.Tx) error {
+		if existingStr, err := tx.Get(recordKey); err == nil {
+			var existing EmailChangeRecord
+			if json.Unmarshal([]byte(existingStr), &existing) == nil && time.Since(existing.CreatedAt) < cooldown {
+				return errLimitExceeded
+			}
+		}
+		_, _, err := tx.Set(recordKey, string(recordBytes), setOptions)
+		return err
+	})
+	if err != nil {
+		return
+	}
+
+	subject := fmt.Sprintf(client.t("Verify your email address on %s"), am.server.name)
+	message := email.ComposeMail(mailConfig, address, subject)
+	fmt.Fprintf(&message, client.t("Account: %s"), account.Name)
+	message.WriteString("\r\n")
+	fmt.Fprintf(&message, client.t("Verification code: %s"), record.Code)
+	message.WriteString("\r\n")
+	message.WriteString("\r\n")
+	message.WriteString(client.t("To verify your new email address, issue the following command:"))
+	message.WriteString("\r\n")
+	fmt.Fprintf(&message, "/MSG NickServ VERIFY-EMAIL %s\r\n", record.Code)
+
+	err = email.SendMail(mailConfig, address, message.Bytes())
+	if err != nil {
+		am.server.logger.Error("internal", "Failed to dispatch e-mail to", address, err.Error())
+		am.server.store.Update(func(tx *buntdb.Tx) error {
+			tx.Delete(recordKey)
+			return nil
+		})
+		return &registrationCallbackError{underlying: err}
+	}
+	return nil
+}
+
+// VerifyEmailChange completes a change of email address started with StartEmailChange.
+func (am *AccountManager) VerifyEmailChange(client *Client, accountName, code string) (newAddress string, err error) {
+	cfAccount, err := CasefoldName(accountName)
+	if err != nil {
+		return "", errAccountDoesNotExist
+	}
+	config := am.server.Config()
+	verifyTimeout := time.Duration(config.Accounts.Registration.VerifyTimeout)
+	cooldown := time.Duration(config.Accounts.EmailChange.Cooldown)
+	var oldAddress string
+	recordKey := fmt.Sprintf(keyAccountEmailChange, cfAccount)
+	callbackKey := fmt.Sprintf(keyAccountCallback, cfAccount)
+	err = am.server.store.Update(func(tx *buntdb.Tx) error {
+		recordStr, err := tx.Get(recordKey)
+		if err != nil {
+			return errAccountVerificationInvalidCode
+		}
+		var record EmailChangeRecord
+		if json.Unmarshal([]byte(recordStr), &record) != nil || record.Code == "" ||
+			(verifyTimeout != 0 && !record.CreatedAt.IsZero() && time.Since(record.CreatedAt) > verifyTimeout) ||
+			!utils.SecretTokensMatch(record.Code, code) {
+			return errAccountVerificationInvalidCode
+		}
+		callback, _ := tx.Get(callbackKey)
+		oldAddress = strings.TrimPrefix(callback, "mailto:")
+		if oldAddress == callback {
+			oldAddress = "" // it wasn't an email address
+		}
+		newAddress = record.Email
+		if ttl := cooldown - time.Since(record.CreatedAt); ttl > 0 {
+			// keep the record (without the code) until the cooldown expires
+			record = EmailChangeRecord{CreatedAt: record.CreatedAt}
+			recordBytes, _ := json.Marshal(record)
+			tx.Set(recordKey, string(recordBytes), &buntdb.SetOptions{Expires: true, TTL: ttl})
+		} else {
+			tx.Delete(recordKey)
+		}
+		_, _, err = tx.Set(callbackKey, "mailto:"+newAddress, nil)
+		return err
+	})
+	if err != nil {
+		return
+	}
+
+	mailConfig := config.Accounts.Registration.EmailVerification
+	if mailConfig.NotifyOldAddress && oldAddress != "" && oldAddress != newAddress {
+		go am.sendEmailChangeNotification(client, accountName, oldAddress, newAddress)
+	}
+	return
+}
+
+func (am *AccountManager) sendEmailChangeNotification(client *Client, accountName, oldAddress, newAddress string) {
+	mailConfig := am.server.Config().Accounts.Registration.EmailVerification
+	subject := fmt.Sprintf(client.t("Your email address on %s was changed"), am.server.name)
+	message := email.ComposeMail(mailConfig, oldAddress, subject)
+	fmt.Fprintf(&message, client.t("Account: %s"), accountName)
+	message.WriteString("\r\n")
+	fmt.Fprintf(&message, client.t("The email address for your account was changed to %s."), newAddress)
+	message.WriteString("\r\n")
+	message.WriteString(client.t("If you didn't make this change, please contact the server administrators."))
+	message.WriteString("\r\n")
+	if err := email.SendMail(mailConfig, oldAddress, message.Bytes()); err != nil {
+		am.server.logger.Error("internal", "Failed to dispatch e-mail to", oldAddress, err.Error())
+	}
+}
+
+// SetEmail sets the email address of an account without verification (SASET EMAIL).
+func (am *AccountManager) SetEmail(accountName, address string) (err error) {
+	cfAccount, err := CasefoldName(accountName)
+	if err != nil {
+		return errAccountDoesNotExist
+	}
+	existsKey := fmt.Sprintf(keyAccountExists, cfAccount)
+	callbackKey := fmt.Sprintf(keyAccountCallback, cfAccount)
+	recordKey := fmt.Sprintf(keyAccountEmailChange, cfAccount)
+	return am.server.store.Update(func(tx *buntdb.Tx) error {
+		if _, err := tx.Get(existsKey); err != nil {
+			return errAccountDoesNotExist
+		}
+		tx.Delete(recordKey)
+		_, _, err := tx.Set(callbackKey, "mailto:"+address, nil)
+		return err
+	})
+}
+
 func (am *AccountManager) Verify(client *Client, account string, code string) error {
 	casefoldedAccount, err := CasefoldName(account)
 	var skeleton string
@@ -1075,7 +1748,9 @@ func (am *AccountManager) SetNickReserved(client *Client, nick string, saUnreser
 	return nil
 }
 
-func (am *AccountManager) checkPassphrase(accountName, passphrase string) (account ClientAccount, err error) {
+// loadAccountForLogin loads an account (possibly by one of its grouped nicknames)
+// that a client is trying to log into, checking that it is verified and not suspended.
+func (am *AccountManager) loadAccountForLogin(accountName string) (account ClientAccount, err error) {
 	account, err = am.LoadAccount(accountName)
 	// #1476: if grouped nicks are allowed, attempt to interpret accountName as a grouped nick
 	if err == errAccountDoesNotExist && !am.server.Config().Accounts.NickReservation.ForceNickEqualsAccount {
@@ -1098,11 +1773,24 @@ func (am *AccountManager) checkPassphrase(accountName, passphrase string) (accou
 
 	if !account.Verified {
 		err = errAccountUnverified
-		return
 	} else if account.Suspended != nil {
 		err = errAccountSuspended
+	}
+	return
+}
+
+func (am *AccountManager) checkPassphrase(accountName, passphrase string) (account ClientAccount, err error) {
+	account, err = am.loadAccountForLogin(accountName)
+	if err != nil {
 		return
 	}
+	accountName = account.Name
+
+	var totpCode string
+	totpEnabled := am.totpRequired(&account.Credentials)
+	if totpEnabled {
+		passphrase, totpCode = splitTOTPCode(passphrase)
+	}
 
 	switch account.Credentials.Version {
 	case 0:
@@ -1110,6 +1798,12 @@ func (am *AccountManager) checkPassphrase(accountName, passphrase string) (accou
 	case 1:
 		if passwd.CompareHashAndPassword(account.Credentials.PassphraseHash, []byte(passphrase)) != nil {
 			err = errAccountInvalidCredentials
+		} else if account.Credentials.ScramSHA256 == nil || account.Credentials.ScramSHA512 == nil {
+			// accounts registered before SCRAM support get SCRAM credentials
+			// the next time the passphrase is available to us
+			if scramErr := am.addScramCredentials(accountName, account.Credentials.PassphraseHash, passphrase); scramErr != nil {
+				am.server.logger.Error("internal", "could not add SCRAM credentials", accountName, scramErr.Error())
+			}
 		}
 	case -1:
 		err = am.checkLegacyPassphrase(migrations.CheckAthemePassphrase, accountName, account.Credentials.PassphraseHash, passphrase)
@@ -1118,9 +1812,75 @@ func (am *AccountManager) checkPassphrase(accountName, passphrase string) (accou
 	default:
 		err = errAccountInvalidCredentials
 	}
+	// check the code only after the passphrase, since checking it consumes it
+	if err == nil && totpEnabled {
+		err = am.checkTOTPCode(accountName, totpCode)
+	}
 	return
 }
 
+// with 2FA enabled, the code follows the passphrase: `passphrase:code`
+func splitTOTPCode(input string) (passphrase, code string) {
+	if colonIndex := strings.LastIndexByte(input, ':'); colonIndex != -1 {
+		return input[:colonIndex], input[colonIndex+1:]
+	}
+	return input, ""
+}
+
+// splitExternalPassphrase splits the 2FA code off a passphrase that will be
+// checked by an auth-script or LDAP: they only check the passphrase itself, so
+// an existing account with 2FA enabled still needs the code.
+func (am *AccountManager) splitExternalPassphrase(accountName, input string) (passphrase, totpCode string) {
+	if account, err := am.LoadAccount(accountName); err == nil && am.totpRequired(&account.Credentials) {
+		return splitTOTPCode(input)
+	}
+	return input, ""
+}
+
+// checkExternalLogin checks an account whose passphrase was verified by an
+// auth-script or LDAP as checkPassphrase checks a local one: it must be verified
+// and not suspended, and the 2FA code is required if 2FA is enabled.
+func (am *AccountManager) checkExternalLogin(account ClientAccount, totpCode string) (err error) {
+	if !account.Verified {
+		return errAccountUnverified
+	} else if account.Suspended != nil {
+		return errAccountSuspended
+	} else if am.totpRequired(&account.Credentials) {
+		return am.checkTOTPCode(account.Name, totpCode)
+	}
+	return nil
+}
+
+// LoadScramCredentials loads an account for a SCRAM login, returning its
+// stored credentials for the mechanism. SCRAM can't carry a TOTP code, but
+// the caller must not refuse accounts with TOTP enabled until the client's
+// proof is verified, so as not to reveal which accounts have it enabled.
+func (am *AccountManager) LoadScramCredentials(client *Client, accountName, mechanism string) (account ClientAccount, creds scram.Credentials, err error) {
+	// see AuthenticateByPassphrase
+	if client.registered {
+		if clientAlready := am.server.clients.Get(accountName); clientAlready != nil && clientAlready.AlwaysOn() {
+			err = errNickAccountMismatch
+			return
+		}
+	}
+
+	if throttled, remainingTime := client.checkLoginThrottle(); throttled {
+		err = &ThrottleError{remainingTime}
+		return
+	}
+
+	account, err = am.loadAccountForLogin(accountName)
+	if err != nil {
+		return
+	}
+	stored := account.Credentials.ScramCredentials(mechanism)
+	if stored == nil {
+		err = errNoScramCredentials
+		return
+	}
+	return account, *stored, nil
+}
+
 func (am *AccountManager) checkLegacyPassphrase(check migrations.PassphraseCheck, account string, hash []byte, passphrase string) (err error) {
 	err = check(hash, []byte(passphrase))
 	if err != nil {
@@ -1174,9 +1934,10 @@ func (am *AccountManager) AuthenticateByPassphrase(client *Client, accountName s
 
 	config := am.server.Config()
 	if config.Accounts.AuthScript.Enabled {
+		scriptPassphrase, totpCode := am.splitExternalPassphrase(accountName, passphrase)
 		var output AuthScriptOutput
 		output, err = CheckAuthScript(am.server.semaphores.AuthScript, config.Accounts.AuthScript.ScriptConfig,
-			AuthScriptInput{AccountName: accountName, Passphrase: passphrase, IP: client.IP().String()})
+			AuthScriptInput{AccountName: accountName, Passphrase: scriptPassphrase, IP: client.IP().String()})
 		if err != nil {
 			am.server.logger.Error("internal", "failed shell auth invocation", err.Error())
 		} else if output.Success {
@@ -1184,10 +1945,31 @@ func (am *AccountManager) AuthenticateByPassphrase(client *Client, accountName s
 				accountName = output.AccountName
 			}
 			account, err = am.loadWithAutocreation(accountName, config.Accounts.AuthScript.Autocreate)
+			if err == nil {
+				err = am.checkExternalLogin(account, totpCode)
+			}
 			return
 		}
 	}
 
+	if config.Accounts.LDAP.Enabled {
+		ldapPassphrase, totpCode := am.splitExternalPassphrase(accountName, passphrase)
+		var groups []string
+		groups, err = ldap.CheckLDAPPassphrase(&config.Accounts.LDAP, accountName, ldapPassphrase)
+		if err == nil {
+			account, err = am.loadWithAutocreation(accountName, config.Accounts.LDAP.Autocreate)
+			if err == nil {
+				err = am.checkExternalLogin(account, totpCode)
+			}
+			if err == nil {
+				client.setPendingOper(config.ldapOper(account.NameCasefolded, groups))
+			}
+			return
+		} else if err != ldap.ErrInvalidCredentials && err != ldap.ErrUserNotFound && err != ldap.ErrNotInGroup {
+			am.server.logger.Error("internal", "failed LDAP authentication", err.Error())
+		}
+	}
+
 	account, err = am.checkPassphrase(accountName, passphrase)
 	return err
 }
@@ -1309,6 +2091,24 @@ func (am *AccountManager) deserializeRawAccount(raw rawClientAccount, cfName str
 			result.Suspended = sus
 		}
 	}
+	if raw.Silence != "" {
+		e := json.Unmarshal([]byte(raw.Silence), &result.Silence)
+		if e != nil {
+			am.server.logger.Warning("internal", "could not unmarshal silence list for account", result.Name, e.Error())
+		}
+	}
+	if raw.ReadMarkers != "" {
+		e := json.Unmarshal([]byte(raw.ReadMarkers), &result.ReadMarkers)
+		if e != nil {
+			am.server.logger.Warning("internal", "could not unmarshal read markers for account", result.Name, e.Error())
+		}
+	}
+	if raw.Metadata != "" {
+		e := json.Unmarshal([]byte(raw.Metadata), &result.Metadata)
+		if e != nil {
+			am.server.logger.Warning("internal", "could not unmarshal metadata for account", result.Name, e.Error())
+		}
+	}
 	return
 }
 
@@ -1323,6 +2123,9 @@ func (am *AccountManager) loadRawAccount(tx *buntdb.Tx, casefoldedAccount string
 	vhostKey := fmt.Sprintf(keyAccountVHost, casefoldedAccount)
 	settingsKey := fmt.Sprintf(keyAccountSettings, casefoldedAccount)
 	suspendedKey := fmt.Sprintf(keyAccountSuspended, casefoldedAccount)
+	silenceKey := fmt.Sprintf(keyAccountSilence, casefoldedAccount)
+	readMarkersKey := fmt.Sprintf(keyAccountReadMarkers, casefoldedAccount)
+	metadataKey := fmt.Sprintf(keyAccountMetadata, casefoldedAccount)
 
 	_, e := tx.Get(accountKey)
 	if e == buntdb.ErrNotFound {
@@ -1338,6 +2141,9 @@ func (am *AccountManager) loadRawAccount(tx *buntdb.Tx, casefoldedAccount string
 	result.VHost, _ = tx.Get(vhostKey)
 	result.Settings, _ = tx.Get(settingsKey)
 	result.Suspended, _ = tx.Get(suspendedKey)
+	result.Silence, _ = tx.Get(silenceKey)
+	result.ReadMarkers, _ = tx.Get(readMarkersKey)
+	result.Metadata, _ = tx.Get(metadataKey)
 
 	if _, e = tx.Get(verifiedKey); e == nil {
 		result.Verified = true
@@ -1526,6 +2332,12 @@ func (am *AccountManager) Unregister(account string, erase bool) error {
 	modesKey := fmt.Sprintf(keyAccountModes, casefoldedAccount)
 	realnameKey := fmt.Sprintf(keyAccountRealname, casefoldedAccount)
 	suspendedKey := fmt.Sprintf(keyAccountSuspended, casefoldedAccount)
+	silenceKey := fmt.Sprintf(keyAccountSilence, casefoldedAccount)
+	acceptKey := fmt.Sprintf(keyAccountAccept, casefoldedAccount)
+	readMarkersKey := fmt.Sprintf(keyAccountReadMarkers, casefoldedAccount)
+	metadataKey := fmt.Sprintf(keyAccountMetadata, casefoldedAccount)
+	pwResetKey := fmt.Sprintf(keyAccountPwReset, casefoldedAccount)
+	emailChangeKey := fmt.Sprintf(keyAccountEmailChange, casefoldedAccount)
 
 	var clients []*Client
 	defer func() {
@@ -1586,6 +2398,12 @@ func (am *AccountManager) Unregister(account string, erase bool) error {
 		tx.Delete(modesKey)
 		tx.Delete(realnameKey)
 		tx.Delete(suspendedKey)
+		tx.Delete(silenceKey)
+		tx.Delete(acceptKey)
+		tx.Delete(readMarkersKey)
+		tx.Delete(metadataKey)
+		tx.Delete(pwResetKey)
+		tx.Delete(emailChangeKey)
 
 		return nil
 	})
@@ -1660,6 +2478,7 @@ func (am *AccountManager) AuthenticateByCertificate(client *Client, certfp strin
 	}
 
 	var clientAccount ClientAccount
+	config := am.server.Config()
 
 	defer func() {
 		if err != nil {
@@ -1670,6 +2489,9 @@ func (am *AccountManager) AuthenticateByCertificate(client *Client, certfp strin
 		} else if clientAccount.Suspended != nil {
 			err = errAccountSuspended
 			return
+		} else if am.totpRequired(&clientAccount.Credentials) && !config.Accounts.TOTP.CertfpBypass {
+			err = errTOTPRequired
+			return
 		}
 		// TODO(#1109) clean this check up?
 		if client.registered {
@@ -1682,7 +2504,6 @@ func (am *AccountManager) AuthenticateByCertificate(client *Client, certfp strin
 		return
 	}()
 
-	config := am.server.Config()
 	if config.Accounts.AuthScript.Enabled {
 		var output AuthScriptOutput
 		output, err = CheckAuthScript(am.server.semaphores.AuthScript, config.Accounts.AuthScript.ScriptConfig,
@@ -1719,6 +2540,52 @@ func (am *AccountManager) AuthenticateByCertificate(client *Client, certfp strin
 	return err
 }
 
+// AuthenticateByOAuthBearer logs the client in with a JWT from one of the
+// issuers configured in accounts.jwt-auth (SASL OAUTHBEARER).
+func (am *AccountManager) AuthenticateByOAuthBearer(client *Client, token, authzid string) (err error) {
+	config := am.server.Config()
+	if !config.Accounts.JWTAuth.Enabled {
+		return errFeatureDisabled
+	}
+
+	if throttled, remainingTime := client.checkLoginThrottle(); throttled {
+		return &ThrottleError{remainingTime}
+	}
+
+	accountName, err := config.Accounts.JWTAuth.Validate(token)
+	if err != nil {
+		am.server.logger.Debug("accounts", "invalid JWT for OAUTHBEARER", err.Error())
+		return errAccountInvalidCredentials
+	}
+	cfAccount, err := CasefoldName(accountName)
+	if err != nil {
+		return errAccountInvalidCredentials
+	}
+	if authzid != "" {
+		if cfAuthzid, err := CasefoldName(authzid); err != nil || cfAuthzid != cfAccount {
+			return errAuthzidAuthcidMismatch
+		}
+	}
+
+	// see AuthenticateByPassphrase
+	if client.registered {
+		if clientAlready := am.server.clients.Get(cfAccount); clientAlready != nil && clientAlready.AlwaysOn() {
+			return errNickAccountMismatch
+		}
+	}
+
+	account, err := am.loadWithAutocreation(accountName, config.Accounts.JWTAuth.Autocreate)
+	if err != nil {
+		return err
+	} else if !account.Verified {
+		return errAccountUnverified
+	} else if account.Suspended != nil {
+		return errAccountSuspended
+	}
+	am.Login(client, account)
+	return nil
+}
+
 type settingsMunger func(input AccountSettings) (output AccountSettings, err error)
 
 func (am *AccountManager) ModifyAccountSettings(account string, munger settingsMunger) (newSettings AccountSettings, err error) {
@@ -1913,8 +2780,11 @@ var (
 	// EnabledSaslMechanisms contains the SASL mechanisms that exist and that we support.
 	// This can be moved to some other data structure/place if we need to load/unload mechs later.
 	EnabledSaslMechanisms = map[string]func(*Server, *Client, string, []byte, *ResponseBuffer) bool{
-		"PLAIN":    authPlainHandler,
-		"EXTERNAL": authExternalHandler,
+		"PLAIN":         authPlainHandler,
+		"EXTERNAL":      authExternalHandler,
+		"SCRAM-SHA-256": authScramHandler,
+		"SCRAM-SHA-512": authScramHandler,
+		"OAUTHBEARER":   authOauthbearerHandler,
 	}
 )
 
@@ -1933,6 +2803,27 @@ type AccountCredentials struct {
 	Version        CredentialsVersion
 	PassphraseHash []byte
 	Certfps        []string
+	// salted credentials for the SCRAM SASL mechanisms, derived from the passphrase:
+	ScramSHA256 *scram.Credentials `json:",omitempty"`
+	ScramSHA512 *scram.Credentials `json:",omitempty"`
+	// TOTP second factor, if enrolled:
+	TOTP *TOTPCredentials `json:",omitempty"`
+}
+
+// TOTPCredentials are an account's TOTP secret and backup codes (NS 2FA).
+type TOTPCredentials struct {
+	Secret []byte
+	// false until the enrolment is confirmed with a valid code:
+	Enabled bool
+	// SHA-256 hashes of the unused backup codes:
+	BackupCodes [][]byte
+	// the time step of the last code used, which can't be used again:
+	LastCounter uint64
+}
+
+// TOTPEnabled returns whether the account has 2FA enabled; see totpRequired.
+func (ac *AccountCredentials) TOTPEnabled() bool {
+	return ac.TOTP != nil && ac.TOTP.Enabled
 }
 
 func (ac *AccountCredentials) Empty() bool {
@@ -1952,6 +2843,7 @@ func (ac *AccountCredentials) Serialize() (result string, err error) {
 func (ac *AccountCredentials) SetPassphrase(passphrase string, bcryptCost uint) (err error) {
 	if passphrase == "" {
 		ac.PassphraseHash = nil
+		ac.ScramSHA256, ac.ScramSHA512 = nil, nil
 		return nil
 	}
 
@@ -1964,7 +2856,32 @@ func (ac *AccountCredentials) SetPassphrase(passphrase string, bcryptCost uint)
 		return errAccountBadPassphrase
 	}
 
-	return nil
+	ac.ScramSHA256, ac.ScramSHA512, err = newScramCredentials(passphrase)
+	return err
+}
+
+func newScramCredentials(passphrase string) (sha256Creds, sha512Creds *scram.Credentials, err error) {
+	creds256, err := scram.NewCredentials(scram.SHA256, passphrase, scram.DefaultIterations)
+	if err != nil {
+		return
+	}
+	creds512, err := scram.NewCredentials(scram.SHA512, passphrase, scram.DefaultIterations)
+	if err != nil {
+		return
+	}
+	return &creds256, &creds512, nil
+}
+
+// ScramCredentials returns the stored credentials for a SCRAM mechanism, or nil.
+func (ac *AccountCredentials) ScramCredentials(mechanism string) *scram.Credentials {
+	switch mechanism {
+	case "SCRAM-SHA-256":
+		return ac.ScramSHA256
+	case "SCRAM-SHA-512":
+		return ac.ScramSHA512
+	default:
+		return nil
+	}
 }
 
 func (ac *AccountCredentials) AddCertfp(certfp string) (err error) {
@@ -2066,6 +2983,9 @@ type ClientAccount struct {
 	AdditionalNicks []string
 	VHost           VHostInfo
 	Settings        AccountSettings
+	Silence         []string
+	ReadMarkers     map[string]time.Time
+	Metadata        map[string]string
 }
 
 // convenience for passing around raw serialized account data
@@ -2079,4 +2999,7 @@ type rawClientAccount struct {
 	VHost           string
 	Settings        string
 	Suspended       string
+	Silence         string
+	ReadMarkers     string
+	Metadata        string
 }
@@ -0,0 +1,243 @@
+// Copyright (c) 2026 agent <agent@local>
+// released under the MIT license
+
+package irc
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/pbkdf2"
+
+	"github.com/ergochat/ergo/irc/totp"
+)
+
+func TestTOTPCheckCode(t *testing.T) {
+	secret, _ := totp.GenerateSecret()
+	now := time.Now()
+	creds := TOTPCredentials{
+		Secret:      secret,
+		Enabled:     true,
+		BackupCodes: [][]byte{hashTOTPBackupCode("abcdefgh"), hashTOTPBackupCode("stuvwxyz")},
+	}
+
+	code := totp.GenerateCode(secret, totp.Counter(now))
+	if !creds.checkCode(code, now) {
+		t.Errorf("valid code should be accepted")
+	}
+	if creds.checkCode(code, now) {
+		t.Errorf("code should not be accepted twice")
+	}
+
+	if !creds.checkCode("ABCD-EFGH", now) {
+		t.Errorf("backup code should be accepted, ignoring case and dashes")
+	}
+	if creds.checkCode("abcdefgh", now) || len(creds.BackupCodes) != 1 {
+		t.Errorf("backup code should only be accepted once")
+	}
+	if creds.checkCode("000000", now.Add(-time.Hour)) {
+		t.Errorf("invalid code should be rejected")
+	}
+}
+
+func TestSplitTOTPCode(t *testing.T) {
+	passphrase, code := splitTOTPCode("pass:word:123456")
+	assertEqual(passphrase, "pass:word", t)
+	assertEqual(code, "123456", t)
+	passphrase, code = splitTOTPCode("password")
+	assertEqual(passphrase, "password", t)
+	assertEqual(code, "", t)
+}
+
+func TestCheckExternalLogin(t *testing.T) {
+	server, _ := newTestServer(t, nil)
+	am := &server.accounts
+	registerTestAccount(t, server, "alice", "")
+
+	account, _ := am.LoadAccount("alice")
+	assertEqual(am.checkExternalLogin(account, ""), nil, t)
+
+	secret, err := am.StartTOTPEnrolment("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	backupCodes, err := am.ConfirmTOTPEnrolment("alice", totp.GenerateCode(secret, totp.Counter(time.Now())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	account, _ = am.LoadAccount("alice")
+	assertEqual(am.checkExternalLogin(account, ""), errTOTPRequired, t)
+	assertEqual(am.checkExternalLogin(account, "000000"), errInvalidTOTPCode, t)
+	assertEqual(am.checkExternalLogin(account, backupCodes[0]), nil, t)
+
+	if err := am.Suspend("alice", 0, "oper", "test"); err != nil {
+		t.Fatal(err)
+	}
+	account, _ = am.LoadAccount("alice")
+	assertEqual(am.checkExternalLogin(account, backupCodes[1]), errAccountSuspended, t)
+}
+
+// scramAuthenticate runs a SCRAM-SHA-256 exchange as the account, returning
+// the line that ends it (RPL_SASLSUCCESS or ERR_SASLFAIL).
+func (c *testClient) scramAuthenticate(account, password string) string {
+	c.t.Helper()
+	hmacSHA256 := func(key []byte, data string) []byte {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(data))
+		return mac.Sum(nil)
+	}
+	c.Send("AUTHENTICATE SCRAM-SHA-256")
+	c.Expect("AUTHENTICATE")
+	clientFirstBare := "n=" + account + ",r=clientnonce"
+	c.Send("AUTHENTICATE " + base64.StdEncoding.EncodeToString([]byte("n,,"+clientFirstBare)))
+	line := c.Expect("AUTHENTICATE", ERR_SASLFAIL)
+	if lineCommand(line) == ERR_SASLFAIL {
+		return line
+	}
+	serverFirstBytes, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(strings.Fields(line)[1], ":"))
+	serverFirst := string(serverFirstBytes)
+	var nonce string
+	var salt []byte
+	var iterations int
+	for _, attr := range strings.Split(serverFirst, ",") {
+		switch attr[:2] {
+		case "r=":
+			nonce = attr[2:]
+		case "s=":
+			salt, _ = base64.StdEncoding.DecodeString(attr[2:])
+		case "i=":
+			iterations, _ = strconv.Atoi(attr[2:])
+		}
+	}
+
+	saltedPassword := pbkdf2.Key([]byte(password), salt, iterations, sha256.Size, sha256.New)
+	clientKey := hmacSHA256(saltedPassword, "Client Key")
+	storedKey := sha256.Sum256(clientKey)
+	withoutProof := "c=" + base64.StdEncoding.EncodeToString([]byte("n,,")) + ",r=" + nonce
+	clientSignature := hmacSHA256(storedKey[:], clientFirstBare+","+serverFirst+","+withoutProof)
+	for i := range clientKey {
+		clientKey[i] ^= clientSignature[i]
+	}
+	clientFinal := withoutProof + ",p=" + base64.StdEncoding.EncodeToString(clientKey)
+	c.Send("AUTHENTICATE " + base64.StdEncoding.EncodeToString([]byte(clientFinal)))
+	line = c.Expect("AUTHENTICATE", ERR_SASLFAIL)
+	if lineCommand(line) == ERR_SASLFAIL {
+		return line
+	}
+	c.Send("AUTHENTICATE +")
+	return c.Expect(RPL_SASLSUCCESS, ERR_SASLFAIL)
+}
+
+func TestScramTOTP(t *testing.T) {
+	server, addr := newTestServer(t, map[string]interface{}{
+		"accounts.login-throttling.enabled": false,
+	})
+	registerTestAccount(t, server, "alice", "password")
+	c := newTestClient(t, addr, "alice")
+	c.Send("CAP REQ sasl")
+	c.Expect("CAP")
+
+	assertEqual(lineCommand(c.scramAuthenticate("alice", "password")), RPL_SASLSUCCESS, t)
+
+	am := &server.accounts
+	secret, err := am.StartTOTPEnrolment("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := am.ConfirmTOTPEnrolment("alice", totp.GenerateCode(secret, totp.Counter(time.Now()))); err != nil {
+		t.Fatal(err)
+	}
+	c = newTestClient(t, addr, "alice")
+	c.Send("CAP REQ sasl")
+	c.Expect("CAP")
+
+	// a wrong password doesn't reveal that the account has TOTP enabled:
+	line := c.scramAuthenticate("alice", "wrong")
+	if lineCommand(line) != ERR_SASLFAIL || strings.Contains(line, errTOTPRequired.Error()) {
+		t.Errorf("unexpected reply to an invalid proof: %q", line)
+	}
+	// the right one does, but doesn't log in:
+	line = c.scramAuthenticate("alice", "password")
+	if lineCommand(line) != ERR_SASLFAIL || !strings.Contains(line, errTOTPRequired.Error()) {
+		t.Errorf("unexpected reply to a valid proof: %q", line)
+	}
+}
+
+func TestAuthScriptTOTP(t *testing.T) {
+	// the script accepts one passphrase, which differs from the local one:
+	script := filepath.Join(t.TempDir(), "auth.sh")
+	err := os.WriteFile(script, []byte(`#!/bin/sh
+read input
+case "$input" in
+	*'"passphrase":"scriptpass"'*) echo '{"success": true}' ;;
+	*) echo '{"success": false}' ;;
+esac
+`), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, addr := newTestServer(t, map[string]interface{}{
+		"accounts.login-throttling.enabled": false,
+		"accounts.auth-script.enabled":      true,
+		"accounts.auth-script.command":      script,
+	})
+	registerTestAccount(t, server, "alice", "localpass")
+	attempts := 0
+	identify := func(params string) string {
+		attempts++
+		c := connectTestClient(t, addr, "guest"+strconv.Itoa(attempts), "")
+		reply := c.serviceCommand("NickServ", "IDENTIFY alice "+params)
+		c.Send("QUIT")
+		c.Expect("ERROR")
+		return reply
+	}
+	assertEqual(identify("scriptpass"), "You're now logged in as alice", t)
+
+	am := &server.accounts
+	secret, err := am.StartTOTPEnrolment("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if _, err := am.ConfirmTOTPEnrolment("alice", totp.GenerateCode(secret, totp.Counter(now)-1)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the script's approval doesn't replace the code:
+	assertEqual(identify("scriptpass"), "Authentication failed: "+errTOTPRequired.Error(), t)
+	assertEqual(identify("scriptpass 000000"), "Authentication failed: "+errInvalidTOTPCode.Error(), t)
+	// and the script only sees the passphrase:
+	assertEqual(identify("scriptpass "+totp.GenerateCode(secret, totp.Counter(now))), "You're now logged in as alice", t)
+}
+
+func TestTOTPDisabled(t *testing.T) {
+	server, addr := newTestServer(t, map[string]interface{}{
+		"accounts.totp.enabled": false,
+	})
+	registerTestAccount(t, server, "alice", "password")
+	am := &server.accounts
+	secret, err := am.StartTOTPEnrolment("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := am.ConfirmTOTPEnrolment("alice", totp.GenerateCode(secret, totp.Counter(time.Now()))); err != nil {
+		t.Fatal(err)
+	}
+
+	// with 2FA disabled server-wide, accounts that have it on don't need codes:
+	account, _ := am.LoadAccount("alice")
+	assertEqual(am.checkExternalLogin(account, ""), nil, t)
+	_, err = am.checkPassphrase("alice", "password")
+	assertEqual(err, nil, t)
+	c := newTestClient(t, addr, "alice")
+	c.Send("CAP REQ sasl")
+	c.Expect("CAP")
+	assertEqual(lineCommand(c.scramAuthenticate("alice", "password")), RPL_SASLSUCCESS, t)
+}
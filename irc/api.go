@@ -0,0 +1,696 @@
+// Copyright (c) 2026 agent <agent@local>
+// released under the MIT license
+
+package irc
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ergochat/ergo/irc/custime"
+	"github.com/ergochat/ergo/irc/flatip"
+	"github.com/ergochat/ergo/irc/sno"
+	"github.com/ergochat/ergo/irc/utils"
+)
+
+// HTTP/JSON administration API. Every endpoint takes a POST with a JSON object
+// as its body, and requires an `Authorization: Bearer <token>` header; tokens
+// are configured in the `api` section of the config, and each one is restricted
+// to a subset of the operator capabilities in `apiCapabilities`. Responses look
+// like {"success": true, "result": ...} or {"success": false, "error": "..."}.
+
+const (
+	apiMinTokenLength  = 16
+	apiMaxRequestBytes = 1 << 16
+	apiTimeout         = 30 * time.Second
+)
+
+var (
+	// operator capabilities that can be granted to API tokens
+	apiCapabilities = utils.StringSet{
+		"accreg":  {},
+		"ban":     {},
+		"chanreg": {},
+		"defcon":  {},
+	}
+)
+
+// apiError is an error that is reported with a specific HTTP status.
+type apiError struct {
+	status  int
+	message string
+}
+
+func (e *apiError) Error() string {
+	return e.message
+}
+
+func apiBadRequest(message string) error {
+	return &apiError{status: http.StatusBadRequest, message: message}
+}
+
+func apiNotFound(message string) error {
+	return &apiError{status: http.StatusNotFound, message: message}
+}
+
+type apiResponse struct {
+	Success bool        `json:"success"`
+	Error   string      `json:"error,omitempty"`
+	Result  interface{} `json:"result,omitempty"`
+}
+
+// apiToken is the authenticated identity of an API request.
+type apiToken struct {
+	name         string
+	capabilities utils.StringSet
+}
+
+// operName is recorded as the "operator" responsible for bans, purges, etc.
+func (token apiToken) operName() string {
+	return fmt.Sprintf("api:%s", token.name)
+}
+
+type apiEndpoint struct {
+	capab   string // required capability, or "" if any valid token may use it
+	handler func(server *Server, token apiToken, body []byte) (result interface{}, err error)
+}
+
+// apiEndpoints holds the endpoints of the HTTP API, keyed by path
+var apiEndpoints map[string]apiEndpoint
+
+func init() {
+	apiEndpoints = map[string]apiEndpoint{
+		"/v1/account/rename": {
+			capab:   "accreg",
+			handler: apiAccountRenameHandler,
+		},
+		"/v1/account/suspend": {
+			capab:   "accreg",
+			handler: apiAccountSuspendHandler,
+		},
+		"/v1/account/suspensions": {
+			capab:   "accreg",
+			handler: apiAccountSuspensionsHandler,
+		},
+		"/v1/account/unsuspend": {
+			capab:   "accreg",
+			handler: apiAccountUnsuspendHandler,
+		},
+		"/v1/channel/purge": {
+			capab:   "chanreg",
+			handler: apiChannelPurgeHandler,
+		},
+		"/v1/channel/purged": {
+			capab:   "chanreg",
+			handler: apiChannelPurgedHandler,
+		},
+		"/v1/channel/unpurge": {
+			capab:   "chanreg",
+			handler: apiChannelUnpurgeHandler,
+		},
+		"/v1/channels": {
+			handler: apiChannelsHandler,
+		},
+		"/v1/clients": {
+			handler: apiClientsHandler,
+		},
+		"/v1/defcon": {
+			capab:   "defcon",
+			handler: apiDefconHandler,
+		},
+		"/v1/dline/add": {
+			capab:   "ban",
+			handler: apiDlineAddHandler,
+		},
+		"/v1/dline/del": {
+			capab:   "ban",
+			handler: apiDlineDelHandler,
+		},
+		"/v1/dline/list": {
+			capab:   "ban",
+			handler: apiDlineListHandler,
+		},
+		"/v1/kline/add": {
+			capab:   "ban",
+			handler: apiKlineAddHandler,
+		},
+		"/v1/kline/del": {
+			capab:   "ban",
+			handler: apiKlineDelHandler,
+		},
+		"/v1/kline/list": {
+			capab:   "ban",
+			handler: apiKlineListHandler,
+		},
+	}
+}
+
+// setupAPIListener starts or stops the API listener, as required by the config.
+func (server *Server) setupAPIListener(config *Config) {
+	listener := ""
+	if config.API.Enabled {
+		listener = config.API.Listener
+	}
+	useTLS := config.API.tlsConfig != nil
+	if server.apiServer != nil {
+		if listener != server.apiServer.Addr || useTLS != (server.apiServer.TLSConfig != nil) {
+			server.logger.Info("server", "Stopping API listener", server.apiServer.Addr)
+			server.apiServer.Close()
+			server.apiServer = nil
+		}
+	}
+	if listener != "" && server.apiServer == nil {
+		as := &http.Server{
+			Addr:         listener,
+			Handler:      http.HandlerFunc(server.serveAPI),
+			ReadTimeout:  apiTimeout,
+			WriteTimeout: apiTimeout,
+		}
+		if useTLS {
+			// look up the certificate on each handshake, so that it can be rehashed:
+			as.TLSConfig = &tls.Config{
+				GetCertificate: server.getAPICertificate,
+				MinVersion:     tls.VersionTLS12,
+			}
+		}
+		go func() {
+			var err error
+			if as.TLSConfig != nil {
+				err = as.ListenAndServeTLS("", "")
+			} else {
+				err = as.ListenAndServe()
+			}
+			if err != nil && err != http.ErrServerClosed {
+				server.logger.Error("server", "API listener failed", err.Error())
+			}
+		}()
+		server.apiServer = as
+		server.logger.Info("server", "Started API listener", server.apiServer.Addr)
+	}
+}
+
+func (server *Server) getAPICertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	tlsConfig := server.Config().API.tlsConfig
+	if tlsConfig == nil {
+		return nil, errors.New("API TLS is not configured")
+	}
+	return &tlsConfig.Certificates[0], nil
+}
+
+// authenticateAPIRequest checks the request's bearer token against the configured tokens.
+func (server *Server) authenticateAPIRequest(r *http.Request) (token apiToken, ok bool) {
+	authorization := r.Header.Get("Authorization")
+	const prefix = "bearer "
+	if len(authorization) <= len(prefix) || strings.ToLower(authorization[:len(prefix)]) != prefix {
+		return
+	}
+	supplied := authorization[len(prefix):]
+	for name, tokenConfig := range server.Config().API.Tokens {
+		if utils.SecretTokensMatch(tokenConfig.Token, supplied) {
+			return apiToken{name: name, capabilities: tokenConfig.capabilities}, true
+		}
+	}
+	return
+}
+
+func (server *Server) serveAPI(w http.ResponseWriter, r *http.Request) {
+	endpoint, ok := apiEndpoints[r.URL.Path]
+	if !ok {
+		writeAPIResponse(w, http.StatusNotFound, apiResponse{Error: "Unknown endpoint"})
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeAPIResponse(w, http.StatusMethodNotAllowed, apiResponse{Error: "Method not allowed"})
+		return
+	}
+	token, ok := server.authenticateAPIRequest(r)
+	if !ok {
+		server.logger.Warning("api", "Unauthorized API request", r.RemoteAddr, r.URL.Path)
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeAPIResponse(w, http.StatusUnauthorized, apiResponse{Error: "Invalid or missing bearer token"})
+		return
+	}
+	if endpoint.capab != "" && !token.capabilities.Has(endpoint.capab) {
+		writeAPIResponse(w, http.StatusForbidden, apiResponse{Error: "Insufficient privileges"})
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, apiMaxRequestBytes+1))
+	if err != nil {
+		writeAPIResponse(w, http.StatusBadRequest, apiResponse{Error: "Could not read request body"})
+		return
+	} else if len(body) > apiMaxRequestBytes {
+		writeAPIResponse(w, http.StatusRequestEntityTooLarge, apiResponse{Error: "Request body too large"})
+		return
+	}
+
+	server.logger.Debug("api", "API request", token.name, r.URL.Path)
+	result, err := endpoint.handler(server, token, body)
+	if err == nil {
+		writeAPIResponse(w, http.StatusOK, apiResponse{Success: true, Result: result})
+	} else if apiErr, ok := err.(*apiError); ok {
+		writeAPIResponse(w, apiErr.status, apiResponse{Error: apiErr.message})
+	} else {
+		server.logger.Error("api", "API request failed", token.name, r.URL.Path, err.Error())
+		writeAPIResponse(w, http.StatusInternalServerError, apiResponse{Error: "An error occurred"})
+	}
+}
+
+func writeAPIResponse(w http.ResponseWriter, status int, response apiResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// decodeAPIRequest unmarshals the request body; an empty body is equivalent to {}.
+func decodeAPIRequest(body []byte, request interface{}) error {
+	if len(body) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(body, request); err != nil {
+		return apiBadRequest("Invalid JSON in request body")
+	}
+	return nil
+}
+
+func parseAPIDuration(durationString string) (duration time.Duration, err error) {
+	if durationString == "" {
+		return 0, nil
+	}
+	duration, err = custime.ParseDuration(durationString)
+	if err != nil {
+		return 0, apiBadRequest("Invalid duration")
+	}
+	return
+}
+
+// announceAPIAction reports an action taken through the API to operators and the log.
+func (server *Server) announceAPIAction(mask sno.Mask, token apiToken, format string, args ...interface{}) {
+	line := fmt.Sprintf("API token %s %s", token.name, fmt.Sprintf(format, args...))
+	server.snomasks.Send(mask, line)
+	server.logger.Info("opers", line)
+}
+
+//
+// bans
+//
+
+type apiBan struct {
+	Mask        string     `json:"mask"`
+	Reason      string     `json:"reason"`
+	OperReason  string     `json:"oper_reason,omitempty"`
+	OperName    string     `json:"oper_name"`
+	RequireSASL bool       `json:"require_sasl,omitempty"`
+	Created     time.Time  `json:"created"`
+	Expires     *time.Time `json:"expires,omitempty"`
+}
+
+func apiBansFromInfo(bans map[string]IPBanInfo) (result []apiBan) {
+	result = make([]apiBan, 0, len(bans))
+	for mask, info := range bans {
+		ban := apiBan{
+			Mask:        mask,
+			Reason:      info.Reason,
+			OperReason:  info.OperReason,
+			OperName:    info.OperName,
+			RequireSASL: info.RequireSASL,
+			Created:     info.TimeCreated,
+		}
+		if info.Duration != 0 {
+			expires := info.TimeCreated.Add(info.Duration)
+			ban.Expires = &expires
+		}
+		result = append(result, ban)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Mask < result[j].Mask })
+	return
+}
+
+type apiBanRequest struct {
+	Mask        string `json:"mask"`
+	Duration    string `json:"duration"`
+	Reason      string `json:"reason"`
+	OperReason  string `json:"oper_reason"`
+	RequireSASL bool   `json:"require_sasl"`
+}
+
+type apiBanResult struct {
+	Killed   []string `json:"killed"`
+	AlwaysOn []string `json:"always_on,omitempty"`
+}
+
+func describeAPIBanDuration(duration time.Duration) string {
+	if duration == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" [duration: %v]", duration)
+}
+
+func apiKlineAddHandler(server *Server, token apiToken, body []byte) (result interface{}, err error) {
+	var request apiBanRequest
+	if err = decodeAPIRequest(body, &request); err != nil {
+		return
+	}
+	duration, err := parseAPIDuration(request.Duration)
+	if err != nil {
+		return
+	}
+	mask, err := CanonicalizeMaskWildcard(request.Mask)
+	if err != nil || request.Mask == "" {
+		return nil, apiBadRequest("Invalid mask")
+	}
+	matcher, err := utils.CompileGlob(mask, false)
+	if err != nil {
+		return nil, apiBadRequest("Invalid mask")
+	}
+	reason := request.Reason
+	if reason == "" {
+		reason = "No reason given"
+	}
+
+	if err = server.klines.AddMask(mask, duration, reason, request.OperReason, token.operName()); err != nil {
+		return
+	}
+	server.announceAPIAction(sno.LocalXline, token, "added K-Line for %s%s", mask, describeAPIBanDuration(duration))
+	killed, alwaysOn := killClientsForNickmask(server, matcher, nil)
+	return apiBanResult{Killed: killed, AlwaysOn: alwaysOn}, nil
+}
+
+func apiKlineDelHandler(server *Server, token apiToken, body []byte) (result interface{}, err error) {
+	var request apiBanRequest
+	if err = decodeAPIRequest(body, &request); err != nil {
+		return
+	}
+	mask, err := CanonicalizeMaskWildcard(request.Mask)
+	if err != nil || request.Mask == "" {
+		return nil, apiBadRequest("Invalid mask")
+	}
+	if err = server.klines.RemoveMask(mask); err != nil {
+		return nil, apiNotFound(err.Error())
+	}
+	server.announceAPIAction(sno.LocalXline, token, "removed K-Line for %s", mask)
+	return nil, nil
+}
+
+func apiKlineListHandler(server *Server, token apiToken, body []byte) (result interface{}, err error) {
+	return apiBansFromInfo(server.klines.AllBans()), nil
+}
+
+func apiDlineAddHandler(server *Server, token apiToken, body []byte) (result interface{}, err error) {
+	var request apiBanRequest
+	if err = decodeAPIRequest(body, &request); err != nil {
+		return
+	}
+	duration, err := parseAPIDuration(request.Duration)
+	if err != nil {
+		return
+	}
+	network, err := flatip.ParseToNormalizedNet(request.Mask)
+	if err != nil {
+		return nil, apiBadRequest("Invalid IP or network")
+	}
+	reason := request.Reason
+	if reason == "" {
+		reason = "No reason given"
+	}
+
+	if err = server.dlines.AddNetwork(network, duration, request.RequireSASL, reason, request.OperReason, token.operName()); err != nil {
+		return
+	}
+	server.announceAPIAction(sno.LocalXline, token, "added D-Line for %s%s", network.HumanReadableString(), describeAPIBanDuration(duration))
+	_, killed := killSessionsForCIDR(server, network, nil, request.RequireSASL)
+	return apiBanResult{Killed: killed}, nil
+}
+
+func apiDlineDelHandler(server *Server, token apiToken, body []byte) (result interface{}, err error) {
+	var request apiBanRequest
+	if err = decodeAPIRequest(body, &request); err != nil {
+		return
+	}
+	network, err := flatip.ParseToNormalizedNet(request.Mask)
+	if err != nil {
+		return nil, apiBadRequest("Invalid IP or network")
+	}
+	if err = server.dlines.RemoveNetwork(network); err != nil {
+		return nil, apiNotFound(err.Error())
+	}
+	server.announceAPIAction(sno.LocalXline, token, "removed D-Line for %s", network.HumanReadableString())
+	return nil, nil
+}
+
+func apiDlineListHandler(server *Server, token apiToken, body []byte) (result interface{}, err error) {
+	return apiBansFromInfo(server.dlines.AllBans()), nil
+}
+
+//
+// accounts
+//
+
+type apiAccountRequest struct {
+	Account  string `json:"account"`
+	Duration string `json:"duration"`
+	Reason   string `json:"reason"`
+	NewName  string `json:"new_name"`
+}
+
+type apiSuspension struct {
+	Account  string     `json:"account"`
+	OperName string     `json:"oper_name"`
+	Reason   string     `json:"reason,omitempty"`
+	Created  time.Time  `json:"created"`
+	Expires  *time.Time `json:"expires,omitempty"`
+}
+
+func apiAccountSuspendHandler(server *Server, token apiToken, body []byte) (result interface{}, err error) {
+	var request apiAccountRequest
+	if err = decodeAPIRequest(body, &request); err != nil {
+		return
+	}
+	duration, err := parseAPIDuration(request.Duration)
+	if err != nil {
+		return
+	}
+	switch err = server.accounts.Suspend(request.Account, duration, token.operName(), request.Reason); err {
+	case nil:
+		server.announceAPIAction(sno.LocalXline, token, "suspended account %s%s", request.Account, describeAPIBanDuration(duration))
+		return nil, nil
+	case errAccountDoesNotExist:
+		return nil, apiNotFound("No such account")
+	default:
+		return
+	}
+}
+
+func apiAccountUnsuspendHandler(server *Server, token apiToken, body []byte) (result interface{}, err error) {
+	var request apiAccountRequest
+	if err = decodeAPIRequest(body, &request); err != nil {
+		return
+	}
+	switch err = server.accounts.Unsuspend(request.Account); err {
+	case nil:
+		server.announceAPIAction(sno.LocalXline, token, "unsuspended account %s", request.Account)
+		return nil, nil
+	case errAccountDoesNotExist:
+		return nil, apiNotFound("No such account")
+	case errNoop:
+		return nil, apiBadRequest("Account was not suspended")
+	default:
+		return
+	}
+}
+
+func apiAccountSuspensionsHandler(server *Server, token apiToken, body []byte) (result interface{}, err error) {
+	suspensions := server.accounts.ListSuspended()
+	list := make([]apiSuspension, 0, len(suspensions))
+	for _, suspension := range suspensions {
+		item := apiSuspension{
+			Account:  suspension.AccountName,
+			OperName: suspension.OperName,
+			Reason:   suspension.Reason,
+			Created:  suspension.TimeCreated,
+		}
+		if suspension.Duration != 0 {
+			expires := suspension.TimeCreated.Add(suspension.Duration)
+			item.Expires = &expires
+		}
+		list = append(list, item)
+	}
+	return list, nil
+}
+
+func apiAccountRenameHandler(server *Server, token apiToken, body []byte) (result interface{}, err error) {
+	var request apiAccountRequest
+	if err = decodeAPIRequest(body, &request); err != nil {
+		return
+	}
+	switch err = server.accounts.Rename(request.Account, request.NewName); err {
+	case nil:
+		server.announceAPIAction(sno.LocalAccounts, token, "renamed account %s to %s", request.Account, request.NewName)
+		return nil, nil
+	case errAccountDoesNotExist:
+		return nil, apiNotFound("No such account")
+	case errInvalidAccountRename, errNicknameInvalid:
+		return nil, apiBadRequest(err.Error())
+	default:
+		return
+	}
+}
+
+//
+// channels
+//
+
+type apiChannelRequest struct {
+	Channel string `json:"channel"`
+	Reason  string `json:"reason"`
+}
+
+func apiChannelPurgeHandler(server *Server, token apiToken, body []byte) (result interface{}, err error) {
+	var request apiChannelRequest
+	if err = decodeAPIRequest(body, &request); err != nil {
+		return
+	}
+	record := ChannelPurgeRecord{
+		Oper:     token.operName(),
+		PurgedAt: time.Now().UTC(),
+		Reason:   request.Reason,
+	}
+	// this also kicks the channel's members (see Channel.Purge), so they
+	// don't remain in a channel that is no longer tracked:
+	switch err = server.channels.Purge(request.Channel, record); err {
+	case nil:
+		server.announceAPIAction(sno.LocalAnnouncements, token, "purged channel %s", request.Channel)
+		return nil, nil
+	case errInvalidChannelName:
+		return nil, apiBadRequest("Invalid channel name")
+	default:
+		return
+	}
+}
+
+func apiChannelUnpurgeHandler(server *Server, token apiToken, body []byte) (result interface{}, err error) {
+	var request apiChannelRequest
+	if err = decodeAPIRequest(body, &request); err != nil {
+		return
+	}
+	switch err = server.channels.Unpurge(request.Channel); err {
+	case nil:
+		server.announceAPIAction(sno.LocalAnnouncements, token, "unpurged channel %s", request.Channel)
+		return nil, nil
+	case errNoSuchChannel:
+		return nil, apiNotFound("Channel was not purged")
+	default:
+		return
+	}
+}
+
+func apiChannelPurgedHandler(server *Server, token apiToken, body []byte) (result interface{}, err error) {
+	return server.channels.ListPurged(), nil
+}
+
+type apiChannel struct {
+	Name       string    `json:"name"`
+	Topic      string    `json:"topic,omitempty"`
+	Members    int       `json:"members"`
+	Registered bool      `json:"registered"`
+	Created    time.Time `json:"created"`
+}
+
+func apiChannelsHandler(server *Server, token apiToken, body []byte) (result interface{}, err error) {
+	channels := server.channels.Channels()
+	list := make([]apiChannel, 0, len(channels))
+	for _, channel := range channels {
+		channel.stateMutex.RLock()
+		item := apiChannel{
+			Name:       channel.name,
+			Topic:      channel.topic,
+			Members:    len(channel.members),
+			Registered: channel.registeredFounder != "",
+			Created:    channel.createdTime,
+		}
+		channel.stateMutex.RUnlock()
+		list = append(list, item)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+//
+// clients and server state
+//
+
+type apiClient struct {
+	Nick     string    `json:"nick"`
+	Username string    `json:"username"`
+	Hostname string    `json:"hostname"`
+	Realname string    `json:"realname"`
+	Account  string    `json:"account,omitempty"`
+	IP       string    `json:"ip,omitempty"`
+	Server   string    `json:"server"`
+	Signon   time.Time `json:"signon"`
+	Channels []string  `json:"channels"`
+}
+
+func apiClientsHandler(server *Server, token apiToken, body []byte) (result interface{}, err error) {
+	// as with WHOIS, IPs are only visible with the ban capability:
+	showIPs := token.capabilities.Has("ban")
+	clients := server.clients.AllClients()
+	list := make([]apiClient, 0, len(clients))
+	for _, client := range clients {
+		details := client.Details()
+		item := apiClient{
+			Nick:     details.nick,
+			Username: details.username,
+			Hostname: details.hostname,
+			Realname: details.realname,
+			Server:   client.ServerName(),
+			Signon:   client.ctime,
+			Channels: make([]string, 0),
+		}
+		if details.accountName != "*" {
+			item.Account = details.accountName
+		}
+		if showIPs {
+			item.IP = client.IPString()
+		}
+		for _, channel := range client.Channels() {
+			item.Channels = append(item.Channels, channel.Name())
+		}
+		sort.Strings(item.Channels)
+		list = append(list, item)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Nick < list[j].Nick })
+	return list, nil
+}
+
+type apiDefconRequest struct {
+	Level *int `json:"level"`
+}
+
+type apiDefconResult struct {
+	Level uint32 `json:"level"`
+}
+
+func apiDefconHandler(server *Server, token apiToken, body []byte) (result interface{}, err error) {
+	var request apiDefconRequest
+	if err = decodeAPIRequest(body, &request); err != nil {
+		return
+	}
+	if request.Level != nil {
+		level := *request.Level
+		if level < 1 || 5 < level {
+			return nil, apiBadRequest("Invalid DEFCON level")
+		}
+		server.SetDefcon(uint32(level))
+		server.announceAPIAction(sno.LocalAnnouncements, token, "set DEFCON level to %d", level)
+	}
+	return apiDefconResult{Level: server.Defcon()}, nil
+}
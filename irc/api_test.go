@@ -0,0 +1,262 @@
+// Copyright (c) 2026 agent <agent@local>
+// released under the MIT license
+
+package irc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const (
+	testAPIAdminToken    = "admin-token-0123456789"
+	testAPIReadOnlyToken = "readonly-token-0123456789"
+)
+
+func newTestAPIServer(t *testing.T) (server *Server, addr string) {
+	return newTestServer(t, map[string]interface{}{
+		"api.enabled":  true,
+		"api.listener": "127.0.0.1:0",
+		"api.tokens": map[interface{}]interface{}{
+			"admin": map[interface{}]interface{}{
+				"token":        testAPIAdminToken,
+				"capabilities": []interface{}{"ban", "accreg", "chanreg", "defcon"},
+			},
+			"readonly": map[interface{}]interface{}{
+				"token": testAPIReadOnlyToken,
+			},
+		},
+	})
+}
+
+type testAPIResponse struct {
+	Success bool
+	Error   string
+	Result  json.RawMessage
+}
+
+// apiRequest makes a request with the given authorization header, and decodes the response.
+func apiRequest(t *testing.T, server *Server, method, authorization, path, body string) (status int, response testAPIResponse) {
+	t.Helper()
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	if authorization != "" {
+		request.Header.Set("Authorization", authorization)
+	}
+	recorder := httptest.NewRecorder()
+	server.serveAPI(recorder, request)
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Fatalf("%s: unexpected content type %q", path, contentType)
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("%s: invalid response %q: %v", path, recorder.Body.String(), err)
+	}
+	assertEqual(response.Success, recorder.Code == http.StatusOK, t)
+	return recorder.Code, response
+}
+
+// apiCall makes an authenticated POST request, and checks its status.
+func apiCall(t *testing.T, server *Server, path, body string, expectedStatus int) (result json.RawMessage) {
+	t.Helper()
+	status, response := apiRequest(t, server, http.MethodPost, "Bearer "+testAPIAdminToken, path, body)
+	if status != expectedStatus {
+		t.Fatalf("%s %s: expected status %d, got %d (%s)", path, body, expectedStatus, status, response.Error)
+	}
+	return response.Result
+}
+
+func decodeAPIResult(t *testing.T, result json.RawMessage, value interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(result, value); err != nil {
+		t.Fatalf("invalid result %q: %v", result, err)
+	}
+}
+
+func TestAPIAuthentication(t *testing.T) {
+	server, _ := newTestAPIServer(t)
+	admin := "Bearer " + testAPIAdminToken
+	readOnly := "Bearer " + testAPIReadOnlyToken
+
+	status, _ := apiRequest(t, server, http.MethodPost, admin, "/v1/nonexistent", "")
+	assertEqual(status, http.StatusNotFound, t)
+	status, _ = apiRequest(t, server, http.MethodGet, admin, "/v1/clients", "")
+	assertEqual(status, http.StatusMethodNotAllowed, t)
+
+	for _, authorization := range []string{
+		"",
+		"Bearer ",
+		"Bearer wrong-token-0123456789",
+		"Bearer " + testAPIAdminToken[:len(testAPIAdminToken)-1],
+		"Basic " + testAPIAdminToken,
+		testAPIAdminToken,
+	} {
+		status, response := apiRequest(t, server, http.MethodPost, authorization, "/v1/clients", "")
+		if status != http.StatusUnauthorized {
+			t.Errorf("authorization %q: expected 401, got %d", authorization, status)
+		}
+		assertEqual(response.Error, "Invalid or missing bearer token", t)
+	}
+
+	// the scheme is case-insensitive:
+	status, _ = apiRequest(t, server, http.MethodPost, "bearer "+testAPIAdminToken, "/v1/clients", "")
+	assertEqual(status, http.StatusOK, t)
+
+	// tokens are restricted to their capabilities, but any token can list clients and channels:
+	status, _ = apiRequest(t, server, http.MethodPost, readOnly, "/v1/clients", "")
+	assertEqual(status, http.StatusOK, t)
+	status, _ = apiRequest(t, server, http.MethodPost, readOnly, "/v1/channels", "")
+	assertEqual(status, http.StatusOK, t)
+	for _, path := range []string{"/v1/kline/list", "/v1/dline/add", "/v1/account/suspend", "/v1/channel/purge", "/v1/defcon"} {
+		status, response := apiRequest(t, server, http.MethodPost, readOnly, path, "")
+		if status != http.StatusForbidden {
+			t.Errorf("%s: expected 403, got %d", path, status)
+		}
+		assertEqual(response.Error, "Insufficient privileges", t)
+	}
+
+	status, _ = apiRequest(t, server, http.MethodPost, admin, "/v1/kline/add", "{")
+	assertEqual(status, http.StatusBadRequest, t)
+	status, _ = apiRequest(t, server, http.MethodPost, admin, "/v1/kline/add", strings.Repeat(" ", apiMaxRequestBytes+1))
+	assertEqual(status, http.StatusRequestEntityTooLarge, t)
+}
+
+func TestAPIBans(t *testing.T) {
+	server, addr := newTestAPIServer(t)
+	connectTestClient(t, addr, "mallory", "")
+	connectTestClient(t, addr, "alice", "")
+
+	var banResult apiBanResult
+	decodeAPIResult(t, apiCall(t, server, "/v1/kline/add", `{"mask": "mallory", "duration": "1h", "reason": "spam"}`, http.StatusOK), &banResult)
+	assertEqual(banResult.Killed, []string{"mallory"}, t)
+	apiCall(t, server, "/v1/kline/add", `{"mask": "evil!*@*", "duration": "forever"}`, http.StatusBadRequest)
+	apiCall(t, server, "/v1/kline/add", `{}`, http.StatusBadRequest)
+
+	var bans []apiBan
+	decodeAPIResult(t, apiCall(t, server, "/v1/kline/list", "", http.StatusOK), &bans)
+	assertEqual(len(bans), 1, t)
+	assertEqual(bans[0].Mask, "mallory!*@*", t)
+	assertEqual(bans[0].Reason, "spam", t)
+	assertEqual(bans[0].OperName, "api:admin", t)
+	assertEqual(bans[0].Expires != nil, true, t)
+
+	apiCall(t, server, "/v1/kline/del", `{"mask": "mallory"}`, http.StatusOK)
+	apiCall(t, server, "/v1/kline/del", `{"mask": "mallory"}`, http.StatusNotFound)
+	decodeAPIResult(t, apiCall(t, server, "/v1/kline/list", "", http.StatusOK), &bans)
+	assertEqual(len(bans), 0, t)
+
+	decodeAPIResult(t, apiCall(t, server, "/v1/dline/add", `{"mask": "192.0.2.0/24", "reason": "abuse"}`, http.StatusOK), &banResult)
+	assertEqual(len(banResult.Killed), 0, t)
+	apiCall(t, server, "/v1/dline/add", `{"mask": "not an ip"}`, http.StatusBadRequest)
+	decodeAPIResult(t, apiCall(t, server, "/v1/dline/list", "", http.StatusOK), &bans)
+	assertEqual(len(bans), 1, t)
+	assertEqual(bans[0].Mask, "192.0.2.0/24", t)
+	assertEqual(bans[0].Expires == nil, true, t)
+	apiCall(t, server, "/v1/dline/del", `{"mask": "192.0.2.0/24"}`, http.StatusOK)
+	apiCall(t, server, "/v1/dline/del", `{"mask": "192.0.2.0/24"}`, http.StatusNotFound)
+}
+
+func TestAPIAccounts(t *testing.T) {
+	server, _ := newTestAPIServer(t)
+	registerTestAccount(t, server, "alice", "pw")
+
+	apiCall(t, server, "/v1/account/suspend", `{"account": "alice", "duration": "1d", "reason": "spam"}`, http.StatusOK)
+	apiCall(t, server, "/v1/account/suspend", `{"account": "bob"}`, http.StatusNotFound)
+	var suspensions []apiSuspension
+	decodeAPIResult(t, apiCall(t, server, "/v1/account/suspensions", "", http.StatusOK), &suspensions)
+	assertEqual(len(suspensions), 1, t)
+	assertEqual(suspensions[0].Account, "alice", t)
+	assertEqual(suspensions[0].Reason, "spam", t)
+	assertEqual(suspensions[0].OperName, "api:admin", t)
+	assertEqual(suspensions[0].Expires != nil, true, t)
+
+	apiCall(t, server, "/v1/account/unsuspend", `{"account": "alice"}`, http.StatusOK)
+	apiCall(t, server, "/v1/account/unsuspend", `{"account": "alice"}`, http.StatusBadRequest)
+	apiCall(t, server, "/v1/account/unsuspend", `{"account": "bob"}`, http.StatusNotFound)
+	decodeAPIResult(t, apiCall(t, server, "/v1/account/suspensions", "", http.StatusOK), &suspensions)
+	assertEqual(len(suspensions), 0, t)
+
+	// renames can only change the case of the name:
+	apiCall(t, server, "/v1/account/rename", `{"account": "alice", "new_name": "Alice"}`, http.StatusOK)
+	apiCall(t, server, "/v1/account/rename", `{"account": "alice", "new_name": "alicia"}`, http.StatusBadRequest)
+	apiCall(t, server, "/v1/account/rename", `{"account": "bob", "new_name": "Bob"}`, http.StatusNotFound)
+	account, err := server.accounts.LoadAccount("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(account.Name, "Alice", t)
+}
+
+func TestAPIChannelsAndClients(t *testing.T) {
+	server, addr := newTestAPIServer(t)
+	alice := connectTestClient(t, addr, "alice", "")
+	alice.Send("JOIN #ergo")
+	alice.Expect(RPL_ENDOFNAMES)
+	alice.Send("TOPIC #ergo :welcome")
+	alice.Expect("TOPIC")
+	connectTestClient(t, addr, "bob", "")
+
+	var channels []apiChannel
+	decodeAPIResult(t, apiCall(t, server, "/v1/channels", "", http.StatusOK), &channels)
+	assertEqual(len(channels), 1, t)
+	assertEqual(channels[0].Name, "#ergo", t)
+	assertEqual(channels[0].Topic, "welcome", t)
+	assertEqual(channels[0].Members, 1, t)
+	assertEqual(channels[0].Registered, false, t)
+
+	// IPs are only shown to tokens with the ban capability:
+	var clients []apiClient
+	decodeAPIResult(t, apiCall(t, server, "/v1/clients", "", http.StatusOK), &clients)
+	assertEqual(len(clients), 2, t)
+	assertEqual(clients[0].Nick, "alice", t)
+	assertEqual(clients[0].Channels, []string{"#ergo"}, t)
+	assertEqual(clients[0].IP, "127.0.0.1", t)
+	assertEqual(clients[1].Nick, "bob", t)
+	assertEqual(clients[1].Channels, []string{}, t)
+	_, response := apiRequest(t, server, http.MethodPost, "Bearer "+testAPIReadOnlyToken, "/v1/clients", "")
+	var unprivileged []apiClient
+	decodeAPIResult(t, response.Result, &unprivileged)
+	assertEqual(unprivileged[0].IP, "", t)
+
+	// purging a channel removes it, kicking its members:
+	apiCall(t, server, "/v1/channel/purge", `{"channel": "#ergo", "reason": "spam"}`, http.StatusOK)
+	if kick := alice.Expect("KICK"); !strings.HasPrefix(kick, ":ergo.test KICK #ergo alice ") {
+		t.Errorf("unexpected KICK: %q", kick)
+	}
+	apiCall(t, server, "/v1/channel/purge", `{"channel": "ergo"}`, http.StatusBadRequest)
+	var purged []string
+	decodeAPIResult(t, apiCall(t, server, "/v1/channel/purged", "", http.StatusOK), &purged)
+	assertEqual(purged, []string{"#ergo"}, t)
+	decodeAPIResult(t, apiCall(t, server, "/v1/channels", "", http.StatusOK), &channels)
+	assertEqual(len(channels), 0, t)
+	decodeAPIResult(t, apiCall(t, server, "/v1/clients", "", http.StatusOK), &clients)
+	assertEqual(clients[0].Channels, []string{}, t)
+	alice.Send("JOIN #ergo")
+	if reply := alice.Expect(ERR_NOSUCHCHANNEL); !strings.Contains(reply, errChannelPurged.Error()) {
+		t.Errorf("unexpected JOIN reply: %q", reply)
+	}
+
+	apiCall(t, server, "/v1/channel/unpurge", `{"channel": "#ergo"}`, http.StatusOK)
+	apiCall(t, server, "/v1/channel/unpurge", `{"channel": "#ergo"}`, http.StatusNotFound)
+	// after which it can be created afresh:
+	alice.Send("JOIN #ergo")
+	alice.Expect(RPL_ENDOFNAMES)
+	decodeAPIResult(t, apiCall(t, server, "/v1/channels", "", http.StatusOK), &channels)
+	assertEqual(len(channels), 1, t)
+	assertEqual(channels[0].Topic, "", t)
+	assertEqual(channels[0].Members, 1, t)
+}
+
+func TestAPIDefcon(t *testing.T) {
+	server, _ := newTestAPIServer(t)
+
+	var defcon apiDefconResult
+	decodeAPIResult(t, apiCall(t, server, "/v1/defcon", "", http.StatusOK), &defcon)
+	assertEqual(defcon.Level, uint32(5), t)
+	decodeAPIResult(t, apiCall(t, server, "/v1/defcon", `{"level": 3}`, http.StatusOK), &defcon)
+	assertEqual(defcon.Level, uint32(3), t)
+	assertEqual(server.Defcon(), uint32(3), t)
+	apiCall(t, server, "/v1/defcon", `{"level": 0}`, http.StatusBadRequest)
+	apiCall(t, server, "/v1/defcon", `{"level": 6}`, http.StatusBadRequest)
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2026 agent <agent@local>
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ergochat/ergo/irc/modes"
+)
+
+// Caller-ID (user mode +g): a client with +g only receives direct messages
+// from clients on its accept list (maintained with ACCEPT). Entries are nicknames,
+// which also match clients logged into an account of that name. For always-on
+// clients, the list is persisted together with the user modes.
+
+const (
+	// minimum interval between notifications to a +g client that someone
+	// not on its accept list is trying to message it
+	callerIDNotifyInterval = time.Minute
+)
+
+// AcceptList returns the nicknames on the client's accept list, sorted.
+func (client *Client) AcceptList() (result []string) {
+	client.stateMutex.RLock()
+	result = make([]string, 0, len(client.accept))
+	for _, nick := range client.accept {
+		result = append(result, nick)
+	}
+	client.stateMutex.RUnlock()
+	sort.Strings(result)
+	return
+}
+
+func (client *Client) setAcceptList(nicks []string) {
+	accept := make(map[string]string, len(nicks))
+	for _, nick := range nicks {
+		if cfnick, err := CasefoldName(nick); err == nil {
+			accept[cfnick] = nick
+		}
+	}
+	client.stateMutex.Lock()
+	client.accept = accept
+	client.stateMutex.Unlock()
+}
+
+// addAccept adds `nick` (casefolded as `cfnick`) to the accept list.
+func (client *Client) addAccept(nick, cfnick string, limit int) (err error) {
+	client.stateMutex.Lock()
+	defer client.stateMutex.Unlock()
+
+	if _, ok := client.accept[cfnick]; ok {
+		return errAcceptExists
+	}
+	if len(client.accept) >= limit {
+		return errAcceptListFull
+	}
+	if client.accept == nil {
+		client.accept = make(map[string]string)
+	}
+	client.accept[cfnick] = nick
+	return nil
+}
+
+func (client *Client) removeAccept(cfnick string) (err error) {
+	client.stateMutex.Lock()
+	defer client.stateMutex.Unlock()
+
+	if _, ok := client.accept[cfnick]; !ok {
+		return errAcceptNotFound
+	}
+	delete(client.accept, cfnick)
+	return nil
+}
+
+// acceptsMessagesFrom returns whether a direct message from `sender` is
+// allowed by the client's caller-ID mode.
+func (client *Client) acceptsMessagesFrom(sender *Client) bool {
+	if client == sender || !client.HasMode(modes.CallerID) {
+		return true
+	}
+	cfnick := sender.NickCasefolded()
+	account := sender.Account()
+
+	client.stateMutex.RLock()
+	defer client.stateMutex.RUnlock()
+	if _, ok := client.accept[cfnick]; ok {
+		return true
+	}
+	if account != "" {
+		if _, ok := client.accept[account]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// checkCallerIDNotify returns whether the client should be notified of a
+// message blocked by caller-ID, recording the notification if so.
+func (client *Client) checkCallerIDNotify() (notify bool) {
+	now := time.Now().UTC()
+	client.stateMutex.Lock()
+	defer client.stateMutex.Unlock()
+	if now.Sub(client.lastCallerIDNotify) < callerIDNotifyInterval {
+		return false
+	}
+	client.lastCallerIDNotify = now
+	return true
+}
+
+// rejectCallerID handles a direct message from `sender` that was blocked by
+// the client's caller-ID mode. `rb` is the sender's response buffer, or nil
+// if the sender should not receive any replies (e.g., for NOTICE).
+func (client *Client) rejectCallerID(sender *Client, rb *ResponseBuffer) {
+	server := client.server
+	nick := client.Nick()
+	if rb != nil {
+		rb.Add(nil, server.name, ERR_TARGUMODEG, sender.Nick(), nick, sender.t("is in +g mode (server-side ignore)"))
+	}
+	if client.isSilenced(sender) || !client.checkCallerIDNotify() {
+		return
+	}
+	if rb != nil {
+		rb.Add(nil, server.name, RPL_TARGNOTIFY, sender.Nick(), nick, sender.t("has been informed that you messaged them"))
+	}
+	details := sender.Details()
+	userhost := fmt.Sprintf("%s@%s", details.username, details.hostname)
+	for _, session := range client.Sessions() {
+		session.Send(nil, server.name, RPL_UMODEGMSG, nick, details.nick, userhost, client.t("is messaging you, and you have user mode +g set"))
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright (c) 2026 agent <agent@local>
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+
+	"github.com/ergochat/ergo/irc/modes"
+)
+
+func TestCallerID(t *testing.T) {
+	target := &Client{nickCasefolded: "alice"}
+	friend := &Client{nickCasefolded: "bob"}
+	stranger := &Client{nickCasefolded: "mallory"}
+	loggedIn := &Client{nickCasefolded: "guest", account: "bob"}
+
+	if !target.acceptsMessagesFrom(stranger) {
+		t.Errorf("messages should be accepted without +g")
+	}
+
+	target.SetMode(modes.CallerID, true)
+	if !target.acceptsMessagesFrom(target) {
+		t.Errorf("messages to self should always be accepted")
+	}
+	if target.acceptsMessagesFrom(friend) {
+		t.Errorf("empty accept list should block messages")
+	}
+
+	if err := target.addAccept("Bob", "bob", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := target.addAccept("Bob", "bob", 1); err != errAcceptExists {
+		t.Errorf("expected errAcceptExists, got %v", err)
+	}
+	if err := target.addAccept("Carol", "carol", 1); err != errAcceptListFull {
+		t.Errorf("expected errAcceptListFull, got %v", err)
+	}
+	assertEqual(target.AcceptList(), []string{"Bob"}, t)
+
+	if !target.acceptsMessagesFrom(friend) {
+		t.Errorf("accepted nickname should be allowed")
+	}
+	if !target.acceptsMessagesFrom(loggedIn) {
+		t.Errorf("accepted account should be allowed")
+	}
+	if target.acceptsMessagesFrom(stranger) {
+		t.Errorf("other clients should be blocked")
+	}
+
+	if err := target.removeAccept("bob"); err != nil {
+		t.Fatal(err)
+	}
+	if err := target.removeAccept("bob"); err != errAcceptNotFound {
+		t.Errorf("expected errAcceptNotFound, got %v", err)
+	}
+	if target.acceptsMessagesFrom(friend) {
+		t.Errorf("removed nickname should be blocked")
+	}
+}
@@ -62,6 +62,8 @@ const (
 	RelaymsgTagName = "draft/relaymsg"
 	// BOT mode: https://github.com/ircv3/ircv3-specifications/pull/439
 	BotTagName = "draft/bot"
+	// draft/message-edit: the msgid of the message being edited
+	MessageEditTagName = "+draft/edit"
 )
 
 func init() {
@@ -7,7 +7,7 @@ package caps
 
 const (
 	// number of recognized capabilities:
-	numCapabs = 27
+	numCapabs = 31
 	// length of the uint64 array that represents the bitset:
 	bitsetLen = 1
 )
@@ -57,10 +57,26 @@ const (
 	// https://gist.github.com/DanielOaks/8126122f74b26012a3de37db80e4e0c6
 	Languages Capability = iota
 
+	// MessageEdit is the draft IRCv3 capability named "draft/message-edit":
+	// https://github.com/ergochat/ergo/blob/master/docs/MANUAL.md#history
+	MessageEdit Capability = iota
+
+	// MessageRedaction is the draft IRCv3 capability named "draft/message-redaction":
+	// https://github.com/ircv3/ircv3-specifications/pull/524
+	MessageRedaction Capability = iota
+
+	// Metadata is the draft IRCv3 capability named "draft/metadata-2":
+	// https://github.com/ircv3/ircv3-specifications/pull/501
+	Metadata Capability = iota
+
 	// Multiline is the proposed IRCv3 capability named "draft/multiline":
 	// https://github.com/ircv3/ircv3-specifications/pull/398
 	Multiline Capability = iota
 
+	// ReadMarker is the draft IRCv3 capability named "draft/read-marker":
+	// https://github.com/ircv3/ircv3-specifications/pull/489
+	ReadMarker Capability = iota
+
 	// Relaymsg is the proposed IRCv3 capability named "draft/relaymsg":
 	// https://github.com/ircv3/ircv3-specifications/pull/417
 	Relaymsg Capability = iota
@@ -136,7 +152,11 @@ var (
 		"draft/chathistory",
 		"draft/event-playback",
 		"draft/languages",
+		"draft/message-edit",
+		"draft/message-redaction",
+		"draft/metadata-2",
 		"draft/multiline",
+		"draft/read-marker",
 		"draft/relaymsg",
 		"echo-message",
 		"extended-join",
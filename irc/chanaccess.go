@@ -0,0 +1,337 @@
+// Copyright (c) 2026 agent <agent@local>
+// released under the MIT license
+
+package irc
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/ergochat/ergo/irc/modes"
+)
+
+// ChanAccessFlags are the fine-grained privileges that CS FLAGS can grant
+// to accounts on a registered channel. They are checked alongside the channel
+// prefix modes: holding either the flag or a sufficient prefix is enough.
+type ChanAccessFlags uint32
+
+const (
+	ChanAccessBans     ChanAccessFlags = 1 << iota // b: manage +b, +e, and +I (including CS TBAN)
+	ChanAccessEdit                                 // f: modify the access list
+	ChanAccessInvite                               // i: INVITE users, and join while +i
+	ChanAccessKick                                 // k: KICK users, as a channel operator could
+	ChanAccessList                                 // l: view the access list
+	ChanAccessOp                                   // o: op yourself with CS OP
+	ChanAccessSettings                             // s: view and change settings with CS GET and CS SET
+	ChanAccessTopic                                // t: change the topic while +t
+)
+
+var chanAccessFlagLetters = []struct {
+	flag   ChanAccessFlags
+	letter byte
+}{
+	{ChanAccessBans, 'b'},
+	{ChanAccessEdit, 'f'},
+	{ChanAccessInvite, 'i'},
+	{ChanAccessKick, 'k'},
+	{ChanAccessList, 'l'},
+	{ChanAccessOp, 'o'},
+	{ChanAccessSettings, 's'},
+	{ChanAccessTopic, 't'},
+}
+
+// uppercase FLAGS letters set the automatic prefix mode that is also managed
+// by CS AMODE; an account can have at most one of them
+var chanAccessAmodeLetters = []struct {
+	mode   modes.Mode
+	letter byte
+}{
+	{modes.ChannelFounder, 'Q'},
+	{modes.ChannelAdmin, 'A'},
+	{modes.ChannelOperator, 'O'},
+	{modes.Halfop, 'H'},
+	{modes.Voice, 'V'},
+}
+
+type chanAccessTemplate struct {
+	flags ChanAccessFlags
+	amode modes.Mode
+}
+
+var chanAccessTemplates = map[string]chanAccessTemplate{
+	"sop": {ChanAccessBans | ChanAccessEdit | ChanAccessInvite | ChanAccessKick | ChanAccessList | ChanAccessOp | ChanAccessSettings | ChanAccessTopic, modes.ChannelAdmin},
+	"aop": {ChanAccessBans | ChanAccessInvite | ChanAccessKick | ChanAccessList | ChanAccessOp | ChanAccessTopic, modes.ChannelOperator},
+	"hop": {ChanAccessInvite | ChanAccessKick | ChanAccessList | ChanAccessTopic, modes.Halfop},
+	"vop": {ChanAccessList, modes.Voice},
+}
+
+// String returns the flags as a string of letters, e.g. "bikt".
+func (flags ChanAccessFlags) String() string {
+	var buf strings.Builder
+	for _, entry := range chanAccessFlagLetters {
+		if flags&entry.flag != 0 {
+			buf.WriteByte(entry.letter)
+		}
+	}
+	return buf.String()
+}
+
+// parseChanAccessFlags parses the persisted form of the flags (the output of String()).
+func parseChanAccessFlags(str string) (result ChanAccessFlags) {
+	for i := 0; i < len(str); i++ {
+		for _, entry := range chanAccessFlagLetters {
+			if str[i] == entry.letter {
+				result |= entry.flag
+			}
+		}
+	}
+	return
+}
+
+// formatChanAccess displays an access list entry, e.g. "+Obikt".
+func formatChanAccess(flags ChanAccessFlags, amode modes.Mode) string {
+	var buf strings.Builder
+	buf.WriteByte('+')
+	for _, entry := range chanAccessAmodeLetters {
+		if entry.mode == amode {
+			buf.WriteByte(entry.letter)
+		}
+	}
+	buf.WriteString(flags.String())
+	return buf.String()
+}
+
+// chanAccessTemplateName returns the name of the template matching the entry, if any.
+func chanAccessTemplateName(flags ChanAccessFlags, amode modes.Mode) string {
+	for name, template := range chanAccessTemplates {
+		if template.flags == flags && template.amode == amode {
+			return strings.ToUpper(name)
+		}
+	}
+	return ""
+}
+
+// applyChanAccessChange applies a CS FLAGS argument (either a template name
+// like AOP, or a list of changes like +tk-b) to an account's current flags
+// and automatic mode. -* removes everything.
+func applyChanAccessChange(flags ChanAccessFlags, amode modes.Mode, change string) (ChanAccessFlags, modes.Mode, error) {
+	if template, ok := chanAccessTemplates[strings.ToLower(change)]; ok {
+		return template.flags, template.amode, nil
+	}
+	if change == "-*" {
+		return 0, modes.Mode(0), nil
+	}
+	if change == "" || (change[0] != '+' && change[0] != '-') {
+		return flags, amode, errInvalidParams
+	}
+
+	add := true
+	for i := 0; i < len(change); i++ {
+		letter := change[i]
+		switch letter {
+		case '+':
+			add = true
+			continue
+		case '-':
+			add = false
+			continue
+		}
+		found := false
+		for _, entry := range chanAccessFlagLetters {
+			if entry.letter == letter {
+				found = true
+				if add {
+					flags |= entry.flag
+				} else {
+					flags &^= entry.flag
+				}
+			}
+		}
+		for _, entry := range chanAccessAmodeLetters {
+			if entry.letter == letter {
+				found = true
+				if add {
+					amode = entry.mode
+				} else if amode == entry.mode {
+					amode = modes.Mode(0)
+				}
+			}
+		}
+		if !found {
+			return flags, amode, errInvalidParams
+		}
+	}
+	return flags, amode, nil
+}
+
+// ChanAccessEntry is an account's entry in a channel's access list.
+type ChanAccessEntry struct {
+	Account string
+	Flags   ChanAccessFlags
+	Amode   modes.Mode
+}
+
+// ClientHasAccess returns whether the client's account holds the given access flag;
+// the founder implicitly holds all of them.
+func (channel *Channel) ClientHasAccess(client *Client, flag ChanAccessFlags) bool {
+	return channel.accountHasAccess(client.Account(), flag)
+}
+
+func (channel *Channel) accountHasAccess(account string, flag ChanAccessFlags) bool {
+	if account == "" {
+		return false
+	}
+	channel.stateMutex.RLock()
+	defer channel.stateMutex.RUnlock()
+	if channel.registeredFounder == "" {
+		return false
+	}
+	return account == channel.registeredFounder || channel.accountToFlags[account]&flag != 0
+}
+
+// hasRegisteredPrivs returns whether the account holds the given flag, or an
+// automatic mode of at least the given level. Unlike channel modes, these can
+// only be granted through ChanServ, so TOPICLOCK and SECUREOPS rely on them.
+func (channel *Channel) hasRegisteredPrivs(account string, flag ChanAccessFlags, minimum modes.Mode) bool {
+	if account == "" {
+		return false
+	}
+	channel.stateMutex.RLock()
+	defer channel.stateMutex.RUnlock()
+	if channel.registeredFounder == "" {
+		return false
+	}
+	if account == channel.registeredFounder || channel.accountToFlags[account]&flag != 0 {
+		return true
+	}
+	amode := channel.accountToUMode[account]
+	return amode == minimum || umodeGreaterThan(amode, minimum)
+}
+
+// secureOpsForbids returns whether the SECUREOPS setting forbids the target
+// from holding prefix modes, i.e., whether their account lacks both an
+// automatic mode and the o flag.
+func (channel *Channel) secureOpsForbids(target *Client) bool {
+	channel.stateMutex.RLock()
+	enabled := channel.registeredFounder != "" && channel.settings.SecureOps
+	channel.stateMutex.RUnlock()
+	return enabled && !channel.hasRegisteredPrivs(target.Account(), ChanAccessOp, modes.Voice)
+}
+
+// enforceSecureOps strips prefix modes from the members that SECUREOPS
+// forbids from holding them, returning the changes that were made.
+func (channel *Channel) enforceSecureOps() (applied modes.ModeChanges) {
+	for _, member := range channel.Members() {
+		if !channel.secureOpsForbids(member) {
+			continue
+		}
+		stripped := false
+		channel.stateMutex.Lock()
+		if memberData, ok := channel.members[member]; ok {
+			for _, mode := range memberData.modes.AllModes() {
+				if memberData.modes.SetMode(mode, false) {
+					stripped = true
+					applied = append(applied, modes.ModeChange{Mode: mode, Op: modes.Remove, Arg: member.Nick()})
+				}
+			}
+		}
+		channel.stateMutex.Unlock()
+		if stripped {
+			member.markDirty(IncludeChannels)
+		}
+	}
+	return
+}
+
+// hasKickAccessOver returns whether the client can kick the target by virtue
+// of the kick flag, which confers the kicking privileges of a channel operator.
+func (channel *Channel) hasKickAccessOver(client *Client, target *Client) bool {
+	if !channel.ClientHasAccess(client, ChanAccessKick) {
+		return false
+	}
+	channel.stateMutex.RLock()
+	founder := channel.registeredFounder
+	targetModes := channel.members[target].modes
+	channel.stateMutex.RUnlock()
+
+	if founder == target.Account() {
+		return false
+	}
+	return channelUserModeHasPrivsOver(modes.ChannelOperator, targetModes.HighestChannelUserMode())
+}
+
+// AccessList returns the channel's access list: every account with flags
+// or an automatic mode, sorted by account name.
+func (channel *Channel) AccessList() (result []ChanAccessEntry) {
+	channel.stateMutex.RLock()
+	entries := make(map[string]ChanAccessEntry)
+	for account, flags := range channel.accountToFlags {
+		entries[account] = ChanAccessEntry{Account: account, Flags: flags}
+	}
+	for account, amode := range channel.accountToUMode {
+		entry := entries[account]
+		entry.Account = account
+		entry.Amode = amode
+		entries[account] = entry
+	}
+	channel.stateMutex.RUnlock()
+
+	result = make([]ChanAccessEntry, 0, len(entries))
+	for _, entry := range entries {
+		result = append(result, entry)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Account < result[j].Account })
+	return
+}
+
+// ProcessAccessChange applies a CS FLAGS change to the given account,
+// returning the account's previous and new access list entries.
+func (channel *Channel) ProcessAccessChange(client *Client, account string, change string) (before, after ChanAccessEntry, err error) {
+	clientAccount := client.Account()
+	isOperChange := client.HasRoleCapabs("chanreg")
+
+	channel.stateMutex.Lock()
+	defer channel.stateMutex.Unlock()
+
+	before = ChanAccessEntry{Account: account, Flags: channel.accountToFlags[account], Amode: channel.accountToUMode[account]}
+	after = before
+	after.Flags, after.Amode, err = applyChanAccessChange(before.Flags, before.Amode, change)
+	if err != nil {
+		return
+	}
+
+	// server operators and founders can do anything:
+	hasPrivs := isOperChange || (clientAccount != "" && clientAccount == channel.registeredFounder)
+	if !hasPrivs && clientAccount != "" {
+		clientFlags := channel.accountToFlags[clientAccount]
+		clientMode := channel.accountToUMode[clientAccount]
+		if account == clientAccount && after.Flags&^before.Flags == 0 && (after.Amode == before.Amode || after.Amode == modes.Mode(0)) {
+			// you can always remove your own access
+			hasPrivs = true
+		} else if clientFlags&ChanAccessEdit != 0 && (before.Flags|after.Flags)&ChanAccessEdit == 0 {
+			// holders of f can edit the access list, except for f itself
+			// (and the accounts that hold it); they can only grant or remove
+			// flags they hold themselves, and automatic modes can be changed
+			// at the levels you have "privileges over", as with CS AMODE:
+			hasPrivs = (before.Flags^after.Flags)&^clientFlags == 0 &&
+				(before.Amode == after.Amode ||
+					(channelUserModeHasPrivsOver(clientMode, before.Amode) && channelUserModeHasPrivsOver(clientMode, after.Amode)))
+		}
+	}
+	if !hasPrivs {
+		err = errInsufficientPrivs
+		return
+	}
+
+	if after.Flags == 0 {
+		delete(channel.accountToFlags, account)
+	} else {
+		channel.accountToFlags[account] = after.Flags
+	}
+	if after.Amode == modes.Mode(0) {
+		delete(channel.accountToUMode, account)
+	} else {
+		channel.accountToUMode[account] = after.Amode
+	}
+	return
+}
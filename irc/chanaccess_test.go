@@ -0,0 +1,98 @@
+// Copyright (c) 2026 agent <agent@local>
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+
+	"github.com/ergochat/ergo/irc/modes"
+)
+
+func TestApplyChanAccessChange(t *testing.T) {
+	flags, amode, err := applyChanAccessChange(0, modes.Mode(0), "+tk")
+	assertEqual(err, nil, t)
+	assertEqual(formatChanAccess(flags, amode), "+kt", t)
+
+	flags, amode, err = applyChanAccessChange(flags, amode, "+bO-k")
+	assertEqual(err, nil, t)
+	assertEqual(formatChanAccess(flags, amode), "+Obt", t)
+	assertEqual(parseChanAccessFlags(flags.String()), flags, t)
+
+	// removing an automatic mode the account doesn't have is a no-op:
+	flags, amode, err = applyChanAccessChange(flags, amode, "-V")
+	assertEqual(err, nil, t)
+	assertEqual(amode, modes.ChannelOperator, t)
+
+	flags, amode, err = applyChanAccessChange(flags, amode, "vop")
+	assertEqual(err, nil, t)
+	assertEqual(formatChanAccess(flags, amode), "+Vl", t)
+	assertEqual(chanAccessTemplateName(flags, amode), "VOP", t)
+
+	flags, amode, err = applyChanAccessChange(flags, amode, "-*")
+	assertEqual(err, nil, t)
+	assertEqual(formatChanAccess(flags, amode), "+", t)
+
+	for _, invalid := range []string{"", "tk", "+x", "+t-z"} {
+		if _, _, err := applyChanAccessChange(0, modes.Mode(0), invalid); err != errInvalidParams {
+			t.Errorf("expected %#v to be rejected", invalid)
+		}
+	}
+}
+
+func TestChanAccessPrivileges(t *testing.T) {
+	channel := &Channel{registeredFounder: "alice"}
+	channel.initializeLists()
+	founder := &Client{account: "alice"}
+	editor := &Client{account: "bob"}
+	stranger := &Client{account: "mallory"}
+
+	_, _, err := channel.ProcessAccessChange(stranger, "mallory", "+b")
+	assertEqual(err, errInsufficientPrivs, t)
+
+	_, after, err := channel.ProcessAccessChange(founder, "bob", "+fAbikot")
+	assertEqual(err, nil, t)
+	assertEqual(after.Amode, modes.ChannelAdmin, t)
+	assertEqual(channel.ClientHasAccess(editor, ChanAccessTopic), true, t)
+	assertEqual(channel.ClientHasAccess(editor, ChanAccessList), false, t)
+	assertEqual(channel.ClientHasAccess(founder, ChanAccessList), true, t)
+
+	// +f holders can only grant flags they hold themselves:
+	_, _, err = channel.ProcessAccessChange(editor, "carol", "aop")
+	assertEqual(err, errInsufficientPrivs, t)
+	_, _, err = channel.ProcessAccessChange(founder, "bob", "+l")
+	assertEqual(err, nil, t)
+	// and automatic modes below their own:
+	_, _, err = channel.ProcessAccessChange(editor, "carol", "aop")
+	assertEqual(err, nil, t)
+	// but not +f itself, or automatic modes at their own level:
+	_, _, err = channel.ProcessAccessChange(editor, "carol", "+f")
+	assertEqual(err, errInsufficientPrivs, t)
+	_, _, err = channel.ProcessAccessChange(editor, "carol", "sop")
+	assertEqual(err, errInsufficientPrivs, t)
+	// nor remove flags they don't hold:
+	_, _, err = channel.ProcessAccessChange(founder, "dave", "+st")
+	assertEqual(err, nil, t)
+	_, _, err = channel.ProcessAccessChange(editor, "dave", "-s")
+	assertEqual(err, errInsufficientPrivs, t)
+	_, _, err = channel.ProcessAccessChange(editor, "dave", "-*")
+	assertEqual(err, errInsufficientPrivs, t)
+	_, _, err = channel.ProcessAccessChange(editor, "dave", "-t")
+	assertEqual(err, nil, t)
+
+	// anyone can remove their own access:
+	_, _, err = channel.ProcessAccessChange(&Client{account: "carol"}, "carol", "-k")
+	assertEqual(err, nil, t)
+	_, _, err = channel.ProcessAccessChange(&Client{account: "carol"}, "carol", "+s")
+	assertEqual(err, errInsufficientPrivs, t)
+
+	accessList := channel.AccessList()
+	assertEqual(len(accessList), 3, t)
+	assertEqual(formatChanAccess(accessList[0].Flags, accessList[0].Amode), "+Abfiklot", t)
+	assertEqual(formatChanAccess(accessList[1].Flags, accessList[1].Amode), "+Obilot", t)
+	assertEqual(formatChanAccess(accessList[2].Flags, accessList[2].Amode), "+s", t)
+
+	// flags are meaningless on unregistered channels:
+	channel.registeredFounder = ""
+	assertEqual(channel.ClientHasAccess(editor, ChanAccessTopic), false, t)
+}
@@ -24,12 +24,20 @@ import (
 type ChannelSettings struct {
 	History     HistoryStatus
 	QueryCutoff HistoryCutoff
+	NoKnock     bool
+	// MLock is the canonical form of the CS SET MLOCK setting, e.g. "+nt-k"
+	MLock     string
+	TopicLock bool
+	SecureOps bool
+	// the topic is kept while the channel is empty unless KEEPTOPIC is off
+	NoKeepTopic bool
 }
 
 // Channel represents a channel that clients can join.
 type Channel struct {
 	flags             modes.ModeSet
 	lists             map[modes.Mode]*UserMaskSet
+	akicks            *UserMaskSet // CS AKICK entries
 	key               string
 	forward           string
 	members           MemberSet
@@ -46,6 +54,7 @@ type Channel struct {
 	topicSetTime      time.Time
 	userLimit         int
 	accountToUMode    map[string]modes.Mode
+	accountToFlags    map[string]ChanAccessFlags
 	history           history.Buffer
 	stateMutex        sync.RWMutex    // tier 1
 	writerSemaphore   utils.Semaphore // tier 1.5
@@ -53,6 +62,9 @@ type Channel struct {
 	ensureLoaded      utils.Once      // manages loading stored registration info from the database
 	dirtyBits         uint
 	settings          ChannelSettings
+	lastKnock         time.Time
+	maskExpiryTimer   *time.Timer       // removes timed +b/+e/+I entries
+	metadata          map[string]string // draft/metadata-2; immutable, replaced wholesale on change
 }
 
 // NewChannel creates a new channel from a `Server` and a `name`
@@ -91,6 +103,8 @@ func (channel *Channel) initializeLists() {
 		modes.InviteMask: NewUserMaskSet(),
 	}
 	channel.accountToUMode = make(map[string]modes.Mode)
+	channel.accountToFlags = make(map[string]ChanAccessFlags)
+	channel.akicks = NewUserMaskSet()
 }
 
 // EnsureLoaded blocks until the channel's registration info has been loaded
@@ -123,6 +137,7 @@ func (channel *Channel) resizeHistory(config *Config) {
 // read in channel state that was persisted in the DB
 func (channel *Channel) applyRegInfo(chanReg RegisteredChannel) {
 	defer channel.resizeHistory(channel.server.Config())
+	defer channel.scheduleMaskExpiry()
 
 	channel.stateMutex.Lock()
 	defer channel.stateMutex.Unlock()
@@ -138,6 +153,7 @@ func (channel *Channel) applyRegInfo(chanReg RegisteredChannel) {
 	channel.userLimit = chanReg.UserLimit
 	channel.settings = chanReg.Settings
 	channel.forward = chanReg.Forward
+	channel.metadata = chanReg.Metadata
 
 	for _, mode := range chanReg.Modes {
 		channel.flags.SetMode(mode, true)
@@ -145,9 +161,13 @@ func (channel *Channel) applyRegInfo(chanReg RegisteredChannel) {
 	for account, mode := range chanReg.AccountToUMode {
 		channel.accountToUMode[account] = mode
 	}
+	for account, flags := range chanReg.AccountToFlags {
+		channel.accountToFlags[account] = parseChanAccessFlags(flags)
+	}
 	channel.lists[modes.BanMask].SetMasks(chanReg.Bans)
 	channel.lists[modes.InviteMask].SetMasks(chanReg.Invites)
 	channel.lists[modes.ExceptMask].SetMasks(chanReg.Excepts)
+	channel.akicks.SetMasks(chanReg.AKicks)
 }
 
 // obtain a consistent snapshot of the channel state that can be persisted to the DB
@@ -177,16 +197,25 @@ func (channel *Channel) ExportRegistration(includeFlags uint) (info RegisteredCh
 		info.Bans = channel.lists[modes.BanMask].Masks()
 		info.Invites = channel.lists[modes.InviteMask].Masks()
 		info.Excepts = channel.lists[modes.ExceptMask].Masks()
+		info.AKicks = channel.akicks.Masks()
 		info.AccountToUMode = make(map[string]modes.Mode)
 		for account, mode := range channel.accountToUMode {
 			info.AccountToUMode[account] = mode
 		}
+		info.AccountToFlags = make(map[string]string)
+		for account, flags := range channel.accountToFlags {
+			info.AccountToFlags[account] = flags.String()
+		}
 	}
 
 	if includeFlags&IncludeSettings != 0 {
 		info.Settings = channel.settings
 	}
 
+	if includeFlags&IncludeMetadata != 0 {
+		info.Metadata = channel.metadata
+	}
+
 	return
 }
 
@@ -328,6 +357,8 @@ func (channel *Channel) SetUnregistered(expectedFounder string) {
 	var zeroTime time.Time
 	channel.registeredTime = zeroTime
 	channel.accountToUMode = make(map[string]modes.Mode)
+	channel.accountToFlags = make(map[string]ChanAccessFlags)
+	channel.akicks.SetMasks(nil)
 }
 
 // implements `CHANSERV CLEAR #chan ACCESS` (resets bans, invites, excepts, and amodes)
@@ -450,7 +481,7 @@ func (channel *Channel) Names(client *Client, rb *ResponseBuffer) {
 	maxNamLen := 480 - len(client.server.name) - len(client.Nick())
 	var namesLines []string
 	var buffer strings.Builder
-	if isJoined || !channel.flags.HasMode(modes.Secret) || isOper {
+	if isJoined || isOper || channel.visibleToOutsider(client) {
 		for _, target := range channel.Members() {
 			var nick string
 			if isUserhostInNames {
@@ -721,7 +752,7 @@ func (channel *Channel) AddHistoryItem(item history.Item, account string) (err e
 
 	status, target, _ := channel.historyStatus(channel.server.Config())
 	if status == HistoryPersistent {
-		err = channel.server.historyDB.AddChannelItem(target, item, account)
+		err = channel.server.HistoryDB().AddChannelItem(target, item, account)
 	} else if status == HistoryEphemeral {
 		channel.history.Add(item)
 	}
@@ -751,6 +782,14 @@ func (channel *Channel) Join(client *Client, key string, isSajoin bool, rb *Resp
 		return nil, ""
 	}
 
+	// AKICK applies even to invited users
+	if !isSajoin {
+		if mask, info, found := channel.matchAKick(client, &details); found {
+			channel.enforceAKick(client, mask, info, chanservService.prefix, rb)
+			return errBanned, ""
+		}
+	}
+
 	// 0. SAJOIN always succeeds
 	// 1. the founder can always join (even if they disabled auto +q on join)
 	// 2. anyone who automatically receives halfop or higher can always join
@@ -768,13 +807,13 @@ func (channel *Channel) Join(client *Client, key string, isSajoin bool, rb *Resp
 		}
 
 		if channel.flags.HasMode(modes.InviteOnly) &&
-			!channel.lists[modes.InviteMask].Match(details.nickMaskCasefolded) {
+			!channel.lists[modes.InviteMask].MatchClient(client, &details) &&
+			!channel.accountHasAccess(details.account, ChanAccessInvite) {
 			return errInviteOnly, forward
 		}
 
-		if channel.lists[modes.BanMask].Match(details.nickMaskCasefolded) &&
-			!channel.lists[modes.ExceptMask].Match(details.nickMaskCasefolded) &&
-			!channel.lists[modes.InviteMask].Match(details.nickMaskCasefolded) {
+		if channel.isBanned(client, &details) &&
+			!channel.lists[modes.InviteMask].MatchClient(client, &details) {
 			// do not forward people who are banned:
 			return errBanned, ""
 		}
@@ -837,6 +876,8 @@ func (channel *Channel) Join(client *Client, key string, isSajoin bool, rb *Resp
 		return nil, ""
 	}
 
+	channel.server.links.ChannelJoined(channel, client, givenMode, message)
+
 	var modestr string
 	if givenMode != 0 {
 		modestr = fmt.Sprintf("+%v", givenMode)
@@ -846,6 +887,7 @@ func (channel *Channel) Join(client *Client, key string, isSajoin bool, rb *Resp
 	var cache MessageCache
 	cache.Initialize(channel.server, message.Time, message.Msgid, details.nickMask, details.accountName, isBot, nil, "JOIN", chname)
 	isAway, awayMessage := client.Away()
+	metadata := client.Metadata()
 	for _, member := range channel.Members() {
 		if respectAuditorium {
 			channel.stateMutex.RLock()
@@ -873,6 +915,7 @@ func (channel *Channel) Join(client *Client, key string, isSajoin bool, rb *Resp
 			if isAway && session.capabilities.Has(caps.AwayNotify) {
 				session.sendFromClientInternal(false, time.Time{}, "", details.nickMask, details.accountName, isBot, nil, "AWAY", awayMessage)
 			}
+			session.sendJoinMetadata(details.nick, metadata)
 		}
 	}
 
@@ -885,7 +928,11 @@ func (channel *Channel) Join(client *Client, key string, isSajoin bool, rb *Resp
 	if rb.session.client == client {
 		// don't send topic and names for a SAJOIN of a different client
 		channel.SendTopic(client, rb, false)
+		client.addReadMarker(rb, chname, channel.NameCasefolded())
 		channel.Names(client, rb)
+		if rb.session.capabilities.Has(caps.Metadata) {
+			channel.syncChannelMetadata(rb)
+		}
 	} else {
 		// ensure that SAJOIN sends a MODE line to the originating client, if applicable
 		if givenMode != 0 {
@@ -909,11 +956,17 @@ func (channel *Channel) autoReplayHistory(client *Client, rb *ResponseBuffer, sk
 	if rb.session.zncPlaybackTimes.ValidFor(channel.NameCasefolded()) {
 		hasAutoreplayTimestamps = true
 		start, end = rb.session.zncPlaybackTimes.start, rb.session.zncPlaybackTimes.end
+		// with no timestamp given, replay everything after the read marker, if any
+		if end.IsZero() {
+			if marker := client.ReadMarker(channel.NameCasefolded()); !marker.IsZero() {
+				start, end = time.Now().UTC(), marker
+			}
+		}
 	} else if !rb.session.autoreplayMissedSince.IsZero() {
 		// we already checked for history caps in `playReattachMessages`
 		hasAutoreplayTimestamps = true
 		start = time.Now().UTC()
-		end = rb.session.autoreplayMissedSince
+		end = client.readMarkerSince(channel.NameCasefolded(), rb.session.autoreplayMissedSince)
 	}
 
 	if hasAutoreplayTimestamps {
@@ -971,6 +1024,7 @@ func (channel *Channel) playJoinForSession(session *Session) {
 		sessionRb.Add(nil, details.nickMask, "JOIN", channel.Name())
 	}
 	channel.SendTopic(client, sessionRb, false)
+	client.addReadMarker(sessionRb, channel.Name(), channel.NameCasefolded())
 	channel.Names(client, sessionRb)
 	sessionRb.Send(false)
 }
@@ -990,6 +1044,7 @@ func (channel *Channel) Part(client *Client, message string, rb *ResponseBuffer)
 	channel.Quit(client)
 
 	splitMessage := utils.MakeMessage(message)
+	channel.server.links.ChannelParted(channel, client, message, splitMessage)
 
 	details := client.Details()
 	isBot := client.HasMode(modes.Bot)
@@ -1053,6 +1108,10 @@ func (channel *Channel) replayHistoryItems(rb *ResponseBuffer, items []history.I
 		}
 	}
 
+	if rb.session.capabilities.Has(caps.MessageEdit) {
+		items = applyMessageEdits(items)
+	}
+
 	batchID := rb.StartNestedHistoryBatch(chname)
 	defer rb.EndNestedBatch(batchID)
 
@@ -1177,11 +1236,20 @@ func (channel *Channel) SetTopic(client *Client, topic string, rb *ResponseBuffe
 		return
 	}
 
-	if channel.flags.HasMode(modes.OpOnlyTopic) && !(channel.ClientIsAtLeast(client, modes.Halfop) || client.HasRoleCapabs("samode")) {
+	if channel.flags.HasMode(modes.OpOnlyTopic) && !(channel.ClientIsAtLeast(client, modes.Halfop) || channel.ClientHasAccess(client, ChanAccessTopic) || client.HasRoleCapabs("samode")) {
 		rb.Add(nil, client.server.name, ERR_CHANOPRIVSNEEDED, client.Nick(), channel.Name(), client.t("You're not a channel operator"))
 		return
 	}
 
+	// TOPICLOCK: channel modes aren't enough, only access granted through ChanServ
+	channel.stateMutex.RLock()
+	topicLock := channel.registeredFounder != "" && channel.settings.TopicLock
+	channel.stateMutex.RUnlock()
+	if topicLock && !(channel.hasRegisteredPrivs(client.Account(), ChanAccessTopic, modes.Halfop) || client.HasRoleCapabs("samode")) {
+		rb.Add(nil, client.server.name, ERR_CHANOPRIVSNEEDED, client.Nick(), channel.Name(), client.t("The topic of this channel is locked"))
+		return
+	}
+
 	topic = ircutils.TruncateUTF8Safe(topic, client.server.Config().Limits.TopicLen)
 
 	channel.stateMutex.Lock()
@@ -1194,6 +1262,7 @@ func (channel *Channel) SetTopic(client *Client, topic string, rb *ResponseBuffe
 	details := client.Details()
 	isBot := client.HasMode(modes.Bot)
 	message := utils.MakeMessage(topic)
+	channel.server.links.TopicChanged(channel, client, topic, message)
 	rb.AddFromClient(message.Time, message.Msgid, details.nickMask, details.accountName, isBot, nil, "TOPIC", chname, topic)
 	for _, member := range channel.Members() {
 		for _, session := range member.Sessions() {
@@ -1243,12 +1312,29 @@ func (channel *Channel) CanSpeak(client *Client) (bool, modes.Mode) {
 }
 
 func (channel *Channel) isMuted(client *Client) bool {
-	muteRe := channel.lists[modes.BanMask].MuteRegexp()
-	if muteRe == nil {
+	if !channel.lists[modes.BanMask].HasMutes() {
 		return false
 	}
-	nuh := client.NickMaskCasefolded()
-	return muteRe.MatchString(nuh) && !channel.lists[modes.ExceptMask].MatchMute(nuh)
+	details := client.Details()
+	return channel.lists[modes.BanMask].MatchMuteClient(client, &details) &&
+		!channel.lists[modes.ExceptMask].MatchMuteClient(client, &details)
+}
+
+// isBanned returns whether the client matches a ban (+b) and no exception (+e).
+func (channel *Channel) isBanned(client *Client, details *ClientDetails) bool {
+	return channel.lists[modes.BanMask].MatchClient(client, details) &&
+		!channel.lists[modes.ExceptMask].MatchClient(client, details)
+}
+
+// visibleToOutsider returns whether a client who is not a member can see
+// the channel's members with NAMES or WHO: not if the channel is secret,
+// or if the client is banned from it.
+func (channel *Channel) visibleToOutsider(client *Client) bool {
+	if channel.flags.HasMode(modes.Secret) {
+		return false
+	}
+	details := client.Details()
+	return !channel.isBanned(client, &details)
 }
 
 func (channel *Channel) relayNickMuted(relayNick string) bool {
@@ -1322,6 +1408,13 @@ func (channel *Channel) SendSplitMessage(command string, minPrefixMode modes.Mod
 	// send echo-message
 	rb.addEchoMessage(clientOnlyTags, details.nickMask, details.accountName, command, chname, message)
 
+	// relay with the effective minimum prefix, so that other servers respect +U
+	linkTarget := channel.Name()
+	if minPrefixMode != modes.Mode(0) {
+		linkTarget = fmt.Sprintf("%s%s", modes.ChannelModePrefixes[minPrefixMode], linkTarget)
+	}
+	channel.server.links.ChannelMessage(client, clientOnlyTags, command, linkTarget, message)
+
 	var cache MessageCache
 	cache.InitializeSplitMessage(channel.server, details.nickMask, details.accountName, isBot, clientOnlyTags, command, chname, message)
 	for _, member := range channel.Members() {
@@ -1401,14 +1494,130 @@ func (channel *Channel) ShowMaskList(client *Client, mode modes.Mode, rb *Respon
 	nick := client.Nick()
 	chname := channel.Name()
 	for mask, info := range channel.lists[mode].Masks() {
-		rb.Add(nil, client.server.name, rpllist, nick, chname, mask, info.CreatorNickmask, strconv.FormatInt(info.TimeCreated.Unix(), 10))
+		// timed entries carry their expiration time as an extra parameter, after
+		// the standard <mask> <setter> <time> that clients parse positionally
+		if expiration := info.Expiration(); !expiration.IsZero() {
+			rb.Add(nil, client.server.name, rpllist, nick, chname, mask, info.CreatorNickmask, strconv.FormatInt(info.TimeCreated.Unix(), 10), strconv.FormatInt(expiration.Unix(), 10))
+		} else {
+			rb.Add(nil, client.server.name, rpllist, nick, chname, mask, info.CreatorNickmask, strconv.FormatInt(info.TimeCreated.Unix(), 10))
+		}
 	}
 
 	rb.Add(nil, client.server.name, rplendoflist, nick, chname, client.t("End of list"))
 }
 
+// AddTimedMask adds a +b, +e or +I entry that expires after the given duration,
+// announcing the change as coming from the client.
+func (channel *Channel) AddTimedMask(client *Client, mode modes.Mode, mask string, duration time.Duration, rb *ResponseBuffer) (maskAdded string, err error) {
+	details := client.Details()
+	if !channel.ClientIsAtLeast(client, modes.ChannelOperator) && !channel.ClientHasAccess(client, ChanAccessBans) {
+		return "", errInsufficientPrivs
+	}
+	if channel.lists[mode].Length() >= channel.server.Config().Limits.ChanListModes {
+		return "", errLimitExceeded
+	}
+
+	maskAdded, err = channel.lists[mode].AddTimed(mask, details.nickMask, details.accountName, duration)
+	if maskAdded == "" {
+		return
+	}
+	channel.MarkDirty(IncludeLists)
+	channel.scheduleMaskExpiry()
+	applied := modes.ModeChanges{{Mode: mode, Op: modes.Add, Arg: maskAdded}}
+	announceCmodeChanges(channel, applied, details.nickMask, details.accountName, details.account, client.HasMode(modes.Bot), rb)
+	return
+}
+
+// matchAKick returns the CS AKICK entry matching the client, if any;
+// the founder, and users who automatically receive halfop or higher, are exempt.
+func (channel *Channel) matchAKick(client *Client, details *ClientDetails) (mask string, info MaskInfo, found bool) {
+	channel.stateMutex.RLock()
+	founder := channel.registeredFounder
+	persistentMode := channel.accountToUMode[details.account]
+	channel.stateMutex.RUnlock()
+
+	if founder == "" || founder == details.account || (persistentMode != 0 && persistentMode != modes.Voice) {
+		return
+	}
+	return channel.akicks.FindMatch(client, details)
+}
+
+// enforceAKick bans a client who matched an AKICK entry, setting a ban (from
+// ChanServ, whose prefix is passed as source) on the entry's mask that expires
+// along with the entry.
+func (channel *Channel) enforceAKick(client *Client, mask string, info MaskInfo, source string, rb *ResponseBuffer) {
+	var duration time.Duration
+	if expiration := info.Expiration(); !expiration.IsZero() {
+		duration = time.Until(expiration)
+		if duration <= 0 {
+			return
+		}
+	}
+	if maskAdded, _ := channel.lists[modes.BanMask].AddTimed(mask, source, "", duration); maskAdded != "" {
+		channel.MarkDirty(IncludeLists)
+		if duration != 0 {
+			channel.scheduleMaskExpiry()
+		}
+		applied := modes.ModeChanges{{Mode: modes.BanMask, Op: modes.Add, Arg: maskAdded}}
+		announceCmodeChanges(channel, applied, source, "*", "", false, nil)
+	}
+	if rb != nil {
+		reason := info.Reason
+		if reason == "" {
+			reason = client.t("No reason given")
+		}
+		rb.Add(nil, source, "NOTICE", client.Nick(), fmt.Sprintf(client.t("You are banned from %[1]s: %[2]s"), channel.Name(), reason))
+	}
+}
+
+// scheduleMaskExpiry (re)arms the timer that removes timed +b/+e/+I entries.
+func (channel *Channel) scheduleMaskExpiry() {
+	channel.stateMutex.Lock()
+	defer channel.stateMutex.Unlock()
+
+	if channel.maskExpiryTimer != nil {
+		channel.maskExpiryTimer.Stop()
+		channel.maskExpiryTimer = nil
+	}
+	next := channel.akicks.NextExpiration()
+	for _, mode := range []modes.Mode{modes.BanMask, modes.ExceptMask, modes.InviteMask} {
+		if expiration := channel.lists[mode].NextExpiration(); !expiration.IsZero() && (next.IsZero() || expiration.Before(next)) {
+			next = expiration
+		}
+	}
+	if !next.IsZero() {
+		channel.maskExpiryTimer = time.AfterFunc(time.Until(next), channel.processMaskExpiry)
+	}
+}
+
+// processMaskExpiry removes expired +b/+e/+I entries and announces their removal.
+func (channel *Channel) processMaskExpiry() {
+	server := channel.server
+	if server.channels.Get(channel.NameCasefolded()) != channel {
+		// the channel was destroyed
+		return
+	}
+
+	now := time.Now().UTC()
+	var removed modes.ModeChanges
+	for _, mode := range []modes.Mode{modes.BanMask, modes.ExceptMask, modes.InviteMask} {
+		for _, mask := range channel.lists[mode].RemoveExpired(now) {
+			removed = append(removed, modes.ModeChange{Mode: mode, Op: modes.Remove, Arg: mask})
+		}
+	}
+	if len(removed) != 0 {
+		channel.MarkDirty(IncludeLists)
+		announceCmodeChanges(channel, removed, server.name, "*", "", false, nil)
+	}
+	if len(channel.akicks.RemoveExpired(now)) != 0 {
+		channel.MarkDirty(IncludeLists)
+	}
+	channel.scheduleMaskExpiry()
+}
+
 // Quit removes the given client from the channel
 func (channel *Channel) Quit(client *Client) {
+	var topicCleared bool
 	channelEmpty := func() bool {
 		channel.joinPartMutex.Lock()
 		defer channel.joinPartMutex.Unlock()
@@ -1416,11 +1625,20 @@ func (channel *Channel) Quit(client *Client) {
 		channel.stateMutex.Lock()
 		channel.members.Remove(client)
 		channelEmpty := len(channel.members) == 0
+		if channelEmpty && channel.registeredFounder != "" && channel.settings.NoKeepTopic && channel.topic != "" {
+			channel.topic = ""
+			channel.topicSetBy = ""
+			channel.topicSetTime = time.Time{}
+			topicCleared = true
+		}
 		channel.stateMutex.Unlock()
 		channel.regenerateMembersCache()
 		return channelEmpty
 	}()
 
+	if topicCleared {
+		channel.MarkDirty(IncludeTopic)
+	}
 	if channelEmpty {
 		client.server.channels.Cleanup(channel)
 	}
@@ -1429,7 +1647,7 @@ func (channel *Channel) Quit(client *Client) {
 
 func (channel *Channel) Kick(client *Client, target *Client, comment string, rb *ResponseBuffer, hasPrivs bool) {
 	if !hasPrivs {
-		if !channel.ClientHasPrivsOver(client, target) {
+		if !(channel.ClientHasPrivsOver(client, target) || channel.hasKickAccessOver(client, target)) {
 			rb.Add(nil, client.server.name, ERR_CHANOPRIVSNEEDED, client.Nick(), channel.Name(), client.t("You don't have enough channel privileges"))
 			return
 		}
@@ -1466,6 +1684,7 @@ func (channel *Channel) Kick(client *Client, target *Client, comment string, rb
 	histItem.Params[0] = targetNick
 	channel.AddHistoryItem(histItem, details.account)
 
+	channel.server.links.ChannelKicked(channel, client, target, comment, message)
 	channel.Quit(target)
 }
 
@@ -1511,7 +1730,7 @@ func (channel *Channel) Invite(invitee *Client, inviter *Client, rb *ResponseBuf
 	}
 
 	inviteOnly := channel.flags.HasMode(modes.InviteOnly)
-	if inviteOnly && !channel.ClientIsAtLeast(inviter, modes.ChannelOperator) {
+	if inviteOnly && !(channel.ClientIsAtLeast(inviter, modes.ChannelOperator) || channel.ClientHasAccess(inviter, ChanAccessInvite)) {
 		rb.Add(nil, inviter.server.name, ERR_CHANOPRIVSNEEDED, inviter.Nick(), chname, inviter.t("You're not a channel operator"))
 		return
 	}
@@ -1547,8 +1766,10 @@ func (channel *Channel) Invite(invitee *Client, inviter *Client, rb *ResponseBuf
 	}
 
 	rb.Add(nil, inviter.server.name, RPL_INVITING, details.nick, tnick, chname)
-	for _, iSession := range invitee.Sessions() {
-		iSession.sendFromClientInternal(false, message.Time, message.Msgid, details.nickMask, details.accountName, isBot, nil, "INVITE", tnick, chname)
+	if !invitee.isSilenced(inviter) {
+		for _, iSession := range invitee.Sessions() {
+			iSession.sendFromClientInternal(false, message.Time, message.Msgid, details.nickMask, details.accountName, isBot, nil, "INVITE", tnick, chname)
+		}
 	}
 	if away, awayMessage := invitee.Away(); away {
 		rb.Add(nil, inviter.server.name, RPL_AWAY, details.nick, tnick, awayMessage)
@@ -1572,6 +1793,78 @@ func (channel *Channel) Uninvite(invitee *Client, inviter *Client, rb *ResponseB
 	rb.Add(nil, channel.server.name, "UNINVITE", invitee.Nick(), channel.Name())
 }
 
+// Knock asks the channel operators of an invite-only channel for an invitation.
+func (channel *Channel) Knock(client *Client, reason string, rb *ResponseBuffer) {
+	server := channel.server
+	details := client.Details()
+	chname := channel.Name()
+
+	channel.stateMutex.RLock()
+	_, present := channel.members[client]
+	noKnock := channel.settings.NoKnock
+	channel.stateMutex.RUnlock()
+
+	if present {
+		rb.Add(nil, server.name, ERR_KNOCKONCHAN, details.nick, chname, client.t("You're already on that channel"))
+		return
+	}
+	if !channel.flags.HasMode(modes.InviteOnly) {
+		rb.Add(nil, server.name, ERR_CHANOPEN, details.nick, chname, client.t("Channel is open"))
+		return
+	}
+	if noKnock || channel.flags.HasMode(modes.NoKnock) {
+		rb.Add(nil, server.name, ERR_CANNOTKNOCK, details.nick, chname, client.t("KNOCK is disabled for that channel"))
+		return
+	}
+	if channel.isBanned(client, &details) {
+		rb.Add(nil, server.name, ERR_CANNOTKNOCK, details.nick, chname, client.t("You're banned from that channel"))
+		return
+	}
+
+	// check both rate limits before recording the KNOCK against either of them
+	throttling := server.Config().Channels.KnockThrottling
+	now := time.Now().UTC()
+	if client.knockThrottled(now, throttling.ClientDelay) {
+		rb.Add(nil, server.name, ERR_TOOMANYKNOCK, details.nick, chname, client.t("Too many KNOCKs (user)"))
+		return
+	}
+	channel.stateMutex.Lock()
+	channelThrottled := now.Sub(channel.lastKnock) < throttling.ChannelDelay
+	if !channelThrottled {
+		channel.lastKnock = now
+	}
+	channel.stateMutex.Unlock()
+	if channelThrottled {
+		rb.Add(nil, server.name, ERR_TOOMANYKNOCK, details.nick, chname, client.t("Too many KNOCKs (channel)"))
+		return
+	}
+	client.recordKnock(now)
+
+	channel.notifyKnock(details.nickMask, reason)
+	server.links.Knock(client, channel, reason)
+	rb.Add(nil, server.name, RPL_KNOCKDLVR, details.nick, chname, client.t("Your KNOCK has been delivered"))
+}
+
+// notifyKnock sends a KNOCK, as a STATUSMSG notice, to the local channel operators.
+func (channel *Channel) notifyKnock(nickMask, reason string) {
+	server := channel.server
+	target := "@" + channel.Name()
+	for _, member := range channel.Members() {
+		if member.remote != nil || !channel.ClientIsAtLeast(member, modes.ChannelOperator) {
+			continue
+		}
+		var notice string
+		if reason == "" {
+			notice = fmt.Sprintf(member.t("[Knock] by %s (no reason specified)"), nickMask)
+		} else {
+			notice = fmt.Sprintf(member.t("[Knock] by %[1]s (%[2]s)"), nickMask, reason)
+		}
+		for _, session := range member.Sessions() {
+			session.Send(nil, server.name, "NOTICE", target, notice)
+		}
+	}
+}
+
 // returns who the client can "see" in the channel, respecting the auditorium mode
 func (channel *Channel) auditoriumFriends(client *Client) (friends []*Client) {
 	channel.stateMutex.RLock()
@@ -127,8 +127,8 @@ func (cm *ChannelManager) Join(client *Client, name string, key string, isSajoin
 		entry := cm.chans[casefoldedName]
 		if entry == nil {
 			registered := cm.registeredChannels.Has(casefoldedName)
-			// enforce OpOnlyCreation
-			if !registered && server.Config().Channels.OpOnlyCreation && !client.HasRoleCapabs("chanreg") {
+			// enforce OpOnlyCreation (other servers enforce it for their own clients)
+			if !registered && server.Config().Channels.OpOnlyCreation && !client.HasRoleCapabs("chanreg") && client.remote == nil {
 				return nil, errInsufficientPrivs
 			}
 			// enforce confusables
@@ -30,12 +30,15 @@ const (
 	keyChannelBanlist        = "channel.banlist %s"
 	keyChannelExceptlist     = "channel.exceptlist %s"
 	keyChannelInvitelist     = "channel.invitelist %s"
+	keyChannelAKicks         = "channel.akicks %s"
 	keyChannelPassword       = "channel.key %s"
 	keyChannelModes          = "channel.modes %s"
 	keyChannelAccountToUMode = "channel.accounttoumode %s"
+	keyChannelAccountToFlags = "channel.accounttoflags %s"
 	keyChannelUserLimit      = "channel.userlimit %s"
 	keyChannelSettings       = "channel.settings %s"
 	keyChannelForward        = "channel.forward %s"
+	keyChannelMetadata       = "channel.metadata %s"
 
 	keyChannelPurged = "channel.purged %s"
 )
@@ -52,12 +55,15 @@ var (
 		keyChannelBanlist,
 		keyChannelExceptlist,
 		keyChannelInvitelist,
+		keyChannelAKicks,
 		keyChannelPassword,
 		keyChannelModes,
 		keyChannelAccountToUMode,
+		keyChannelAccountToFlags,
 		keyChannelUserLimit,
 		keyChannelSettings,
 		keyChannelForward,
+		keyChannelMetadata,
 	}
 )
 
@@ -69,6 +75,7 @@ const (
 	IncludeModes
 	IncludeLists
 	IncludeSettings
+	IncludeMetadata
 )
 
 // this is an OR of all possible flags
@@ -102,14 +109,20 @@ type RegisteredChannel struct {
 	UserLimit int
 	// AccountToUMode maps user accounts to their persistent channel modes (e.g., +q, +h)
 	AccountToUMode map[string]modes.Mode
+	// AccountToFlags maps user accounts to their CS FLAGS access flags (e.g., "bikt")
+	AccountToFlags map[string]string
 	// Bans represents the bans set on the channel.
 	Bans map[string]MaskInfo
 	// Excepts represents the exceptions set on the channel.
 	Excepts map[string]MaskInfo
 	// Invites represents the invite exceptions set on the channel.
 	Invites map[string]MaskInfo
+	// AKicks represents the CS AKICK entries of the channel.
+	AKicks map[string]MaskInfo
 	// Settings are the chanserv-modifiable settings
 	Settings ChannelSettings
+	// Metadata is the draft/metadata-2 key-value data of the channel
+	Metadata map[string]string
 }
 
 type ChannelPurgeRecord struct {
@@ -216,8 +229,11 @@ func (reg *ChannelRegistry) LoadChannel(nameCasefolded string) (info RegisteredC
 		banlistString, _ := tx.Get(fmt.Sprintf(keyChannelBanlist, channelKey))
 		exceptlistString, _ := tx.Get(fmt.Sprintf(keyChannelExceptlist, channelKey))
 		invitelistString, _ := tx.Get(fmt.Sprintf(keyChannelInvitelist, channelKey))
+		akicksString, _ := tx.Get(fmt.Sprintf(keyChannelAKicks, channelKey))
 		accountToUModeString, _ := tx.Get(fmt.Sprintf(keyChannelAccountToUMode, channelKey))
+		accountToFlagsString, _ := tx.Get(fmt.Sprintf(keyChannelAccountToFlags, channelKey))
 		settingsString, _ := tx.Get(fmt.Sprintf(keyChannelSettings, channelKey))
+		metadataString, _ := tx.Get(fmt.Sprintf(keyChannelMetadata, channelKey))
 
 		modeSlice := make([]modes.Mode, len(modeString))
 		for i, mode := range modeString {
@@ -232,12 +248,19 @@ func (reg *ChannelRegistry) LoadChannel(nameCasefolded string) (info RegisteredC
 		_ = json.Unmarshal([]byte(exceptlistString), &exceptlist)
 		var invitelist map[string]MaskInfo
 		_ = json.Unmarshal([]byte(invitelistString), &invitelist)
+		var akicks map[string]MaskInfo
+		_ = json.Unmarshal([]byte(akicksString), &akicks)
 		accountToUMode := make(map[string]modes.Mode)
 		_ = json.Unmarshal([]byte(accountToUModeString), &accountToUMode)
+		accountToFlags := make(map[string]string)
+		_ = json.Unmarshal([]byte(accountToFlagsString), &accountToFlags)
 
 		var settings ChannelSettings
 		_ = json.Unmarshal([]byte(settingsString), &settings)
 
+		var metadata map[string]string
+		_ = json.Unmarshal([]byte(metadataString), &metadata)
+
 		info = RegisteredChannel{
 			Name:           name,
 			NameCasefolded: nameCasefolded,
@@ -251,10 +274,13 @@ func (reg *ChannelRegistry) LoadChannel(nameCasefolded string) (info RegisteredC
 			Bans:           banlist,
 			Excepts:        exceptlist,
 			Invites:        invitelist,
+			AKicks:         akicks,
 			AccountToUMode: accountToUMode,
+			AccountToFlags: accountToFlags,
 			UserLimit:      int(userLimit),
 			Settings:       settings,
 			Forward:        forward,
+			Metadata:       metadata,
 		}
 		return nil
 	})
@@ -377,14 +403,23 @@ func (reg *ChannelRegistry) saveChannel(tx *buntdb.Tx, channelInfo RegisteredCha
 		tx.Set(fmt.Sprintf(keyChannelExceptlist, channelKey), string(exceptlistString), nil)
 		invitelistString, _ := json.Marshal(channelInfo.Invites)
 		tx.Set(fmt.Sprintf(keyChannelInvitelist, channelKey), string(invitelistString), nil)
+		akicksString, _ := json.Marshal(channelInfo.AKicks)
+		tx.Set(fmt.Sprintf(keyChannelAKicks, channelKey), string(akicksString), nil)
 		accountToUModeString, _ := json.Marshal(channelInfo.AccountToUMode)
 		tx.Set(fmt.Sprintf(keyChannelAccountToUMode, channelKey), string(accountToUModeString), nil)
+		accountToFlagsString, _ := json.Marshal(channelInfo.AccountToFlags)
+		tx.Set(fmt.Sprintf(keyChannelAccountToFlags, channelKey), string(accountToFlagsString), nil)
 	}
 
 	if includeFlags&IncludeSettings != 0 {
 		settingsString, _ := json.Marshal(channelInfo.Settings)
 		tx.Set(fmt.Sprintf(keyChannelSettings, channelKey), string(settingsString), nil)
 	}
+
+	if includeFlags&IncludeMetadata != 0 {
+		metadataString, _ := json.Marshal(channelInfo.Metadata)
+		tx.Set(fmt.Sprintf(keyChannelMetadata, channelKey), string(metadataString), nil)
+	}
 }
 
 // PurgeChannel records a channel purge.
@@ -10,6 +10,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/ergochat/ergo/irc/custime"
 	"github.com/ergochat/ergo/irc/modes"
 	"github.com/ergochat/ergo/irc/sno"
 	"github.com/ergochat/ergo/irc/utils"
@@ -29,7 +30,8 @@ var (
 			help: `Syntax: $bOP #channel [nickname]$b
 
 OP makes the given nickname, or yourself, a channel admin. You can only use
-this command if you're a founder or in the AMODEs of the channel.`,
+this command if you're a founder or in the AMODEs of the channel, or if you
+have the +o flag (see $bHELP FLAGS$b).`,
 			helpShort:    `$bOP$b makes the given user (or yourself) a channel admin.`,
 			authRequired: true,
 			enabled:      chanregEnabled,
@@ -86,6 +88,36 @@ the ordinary /MODE command.`,
 			enabled:   chanregEnabled,
 			minParams: 1,
 		},
+		"flags": {
+			handler: csFlagsHandler,
+			help: `Syntax: $bFLAGS #channel [account] [changes | template]$b
+
+FLAGS lists or modifies the access list of a registered channel, which grants
+accounts privileges on the channel without giving them a channel mode.
+$bFLAGS #channel$b lists all accounts with access, $bFLAGS #channel dan$b
+shows the access of the "dan" account, and $bFLAGS #channel dan +tk-b$b
+modifies it. The available flags are:
+
++b: manage bans, exceptions and invite exceptions (+b, +e, +I, and CS TBAN)
++f: modify the access list (only the flags you hold, except for +f itself)
++i: INVITE users, and join the channel while it is invite-only
++k: KICK users (anyone a channel operator could kick)
++l: view the access list
++o: op yourself with CS OP, without being opped automatically
++s: view and change the channel's settings with CS GET and CS SET
++t: change the topic, even when the channel is +t
+
+The flags +Q, +A, +O, +H, and +V grant a channel mode automatically on join
+(+q, +a, +o, +h, or +v respectively); these are the same settings that
+CS AMODE modifies. Instead of a list of changes, you can pass one of the
+templates SOP, AOP, HOP, or VOP, which replace the account's access with
+a typical set of flags for an admin, operator, halfop, or voiced user.
+$bFLAGS #channel dan -*$b removes all of dan's access.`,
+			helpShort: `$bFLAGS$b lists or modifies the access flags of a channel.`,
+			enabled:   chanregEnabled,
+			minParams: 1,
+			maxParams: 3,
+		},
 		"clear": {
 			handler: csClearHandler,
 			help: `Syntax: $bCLEAR #channel target$b
@@ -182,6 +214,27 @@ by unprivileged users. Your options are:
                          channel; note that history will be effectively
                          unavailable to clients that are not always-on]
 4. 'default'            [use the server default]`,
+				`$bKNOCK$b
+'knock' lets you control whether users can use /KNOCK to request an invite
+to the channel while it is invite-only. Your options are 'on' and 'off'.`,
+				`$bMLOCK$b
+'mlock' locks channel modes on or off, so that only the founder can change
+them. For example, '+nt-ik' keeps the channel +n and +t, and prevents it
+from being made +i or +k. Modes that take a parameter, like +k and +l, can
+only be locked off. Use 'off' to remove the lock.`,
+				`$bTOPICLOCK$b
+'topiclock' restricts changing the topic to the founder and to accounts
+with the +t flag or an automatic mode of halfop or higher, regardless of
+their current channel modes. Your options are 'on' and 'off'.`,
+				`$bSECUREOPS$b
+'secureops' (also available as 'guard') prevents users from holding prefix
+modes such as +o and +v unless their account has an automatic mode, or the
++o flag, in the channel. Enabling it removes these modes from anyone who
+lacks such access. Your options are 'on' and 'off'.`,
+				`$bKEEPTOPIC$b
+'keeptopic' lets you control whether the topic is kept while the channel
+is empty. If it is 'off', the topic is cleared when the last user leaves.
+Your options are 'on' (the default) and 'off'.`,
 			},
 			enabled:   chanregEnabled,
 			minParams: 3,
@@ -197,6 +250,43 @@ connected to the server. $bHOWTOBAN$b suggests a ban command that will
 			enabled:   chanregEnabled,
 			minParams: 2,
 		},
+		"tban": {
+			handler: csTbanHandler,
+			help: `Syntax: $bTBAN #channel <duration> <mask> [+b | +e | +I]$b
+        $bTBAN #channel LIST$b
+
+TBAN adds a ban that is automatically removed after the given duration.
+For example, $bTBAN #channel 1h bob!*@*$b bans bob!*@* for one hour.
+The mask can be anything accepted by /MODE +b, including extbans. To add
+a timed ban exception or invite exception instead, pass +e or +I as the
+final parameter. $bTBAN #channel LIST$b displays the channel's timed
+entries, along with the time remaining until they expire.`,
+			helpShort: `$bTBAN$b adds a ban (or exception) that expires automatically.`,
+			enabled:   chanregEnabled,
+			minParams: 2,
+			maxParams: 4,
+		},
+		"akick": {
+			handler: csAKickHandler,
+			help: `Syntax: $bAKICK #channel <ADD | DEL | LIST> [target] [duration] [reason]$b
+
+AKICK manages the automatic kick list of a registered channel. Users matching
+an entry are banned (with a ban set by ChanServ) and prevented from joining;
+the founder, and accounts that automatically receive halfop or higher, are
+exempt. The target can be an account name, which matches the account even
+while it is offline, or any mask accepted by /MODE +b, including extbans.
+
+$bAKICK #channel ADD dan 7d spamming$b adds an entry for the "dan" account
+that expires in seven days. The duration is optional. The reason is shown to
+the affected user; anything after a | character in the reason is a private
+reason, visible only in $bAKICK #channel LIST$b. $bAKICK #channel DEL dan$b
+removes the entry, along with the ban ChanServ set for it, if any.`,
+			helpShort:         `$bAKICK$b manages a channel's automatic kick list.`,
+			enabled:           chanregEnabled,
+			minParams:         2,
+			maxParams:         5,
+			unsplitFinalParam: true,
+		},
 	}
 )
 
@@ -314,18 +404,23 @@ func csOpHandler(service *ircService, server *Server, client *Client, command st
 			givenMode = modes.ChannelFounder
 		} else {
 			givenMode = channelInfo.getAmode(clientAccount)
+			if channelInfo.ClientHasAccess(client, ChanAccessOp) && umodeGreaterThan(modes.ChannelOperator, givenMode) {
+				givenMode = modes.ChannelOperator
+			}
 			if givenMode == modes.Mode(0) {
 				service.Notice(rb, client.t("You don't have any stored privileges on that channel"))
 				return
 			}
 		}
 	} else {
-		if clientAccount == founder {
-			givenMode = modes.ChannelOperator
-		} else {
+		if clientAccount != founder {
 			service.Notice(rb, client.t("Only the channel founder can do this"))
 			return
+		} else if channelInfo.secureOpsForbids(target) {
+			service.Notice(rb, client.t("That user can't receive channel privileges while SECUREOPS is enabled"))
+			return
 		}
+		givenMode = modes.ChannelOperator
 	}
 
 	applied, change := channelInfo.applyModeToMember(client,
@@ -470,6 +565,14 @@ func csPrivsCheck(service *ircService, channel RegisteredChannel, client *Client
 	return true
 }
 
+// like csPrivsCheck, but also accepts holders of the given access flag
+func csAccessCheck(service *ircService, channel *Channel, info RegisteredChannel, client *Client, flag ChanAccessFlags, rb *ResponseBuffer) (success bool) {
+	if info.Founder != "" && channel.ClientHasAccess(client, flag) {
+		return true
+	}
+	return csPrivsCheck(service, info, client, rb)
+}
+
 func csUnregisterHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
 	channelName := params[0]
 	var verificationCode string
@@ -776,7 +879,7 @@ func csInfoHandler(service *ircService, server *Server, client *Client, command
 	var chinfo RegisteredChannel
 	channel := server.channels.Get(params[0])
 	if channel != nil {
-		chinfo = channel.ExportRegistration(0)
+		chinfo = channel.ExportRegistration(IncludeSettings)
 	} else {
 		chinfo, err = server.channelRegistry.LoadChannel(chname)
 		if err != nil && !(err == errNoSuchChannel || err == errFeatureDisabled) {
@@ -793,6 +896,18 @@ func csInfoHandler(service *ircService, server *Server, client *Client, command
 	service.Notice(rb, fmt.Sprintf(client.t("Channel %s is registered"), chinfo.Name))
 	service.Notice(rb, fmt.Sprintf(client.t("Founder: %s"), chinfo.Founder))
 	service.Notice(rb, fmt.Sprintf(client.t("Registered at: %s"), chinfo.RegisteredAt.Format(time.RFC1123)))
+	if chinfo.Settings.MLock != "" {
+		service.Notice(rb, fmt.Sprintf(client.t("Mode lock: %s"), chinfo.Settings.MLock))
+	}
+	if chinfo.Settings.TopicLock {
+		service.Notice(rb, client.t("Topic lock: enabled"))
+	}
+	if chinfo.Settings.SecureOps {
+		service.Notice(rb, client.t("Secure ops: enabled"))
+	}
+	if chinfo.Settings.NoKeepTopic {
+		service.Notice(rb, client.t("Keep topic: disabled"))
+	}
 }
 
 func displayChannelSetting(service *ircService, settingName string, settings ChannelSettings, client *Client, rb *ResponseBuffer) {
@@ -810,6 +925,36 @@ func displayChannelSetting(service *ircService, settingName string, settings Cha
 		}
 		service.Notice(rb, fmt.Sprintf(client.t("The stored channel history query cutoff setting is: %s"), historyCutoffToString(settings.QueryCutoff)))
 		service.Notice(rb, fmt.Sprintf(client.t("Given current server settings, the channel history query cutoff setting is: %s"), historyCutoffToString(effectiveValue)))
+	case "knock":
+		if settings.NoKnock {
+			service.Notice(rb, client.t("Users cannot KNOCK on this channel"))
+		} else {
+			service.Notice(rb, client.t("Users can KNOCK on this channel"))
+		}
+	case "mlock":
+		if settings.MLock != "" {
+			service.Notice(rb, fmt.Sprintf(client.t("The channel mode lock is: %s"), settings.MLock))
+		} else {
+			service.Notice(rb, client.t("The channel modes are not locked"))
+		}
+	case "topiclock":
+		if settings.TopicLock {
+			service.Notice(rb, client.t("The channel topic is locked"))
+		} else {
+			service.Notice(rb, client.t("The channel topic is not locked"))
+		}
+	case "secureops", "guard":
+		if settings.SecureOps {
+			service.Notice(rb, client.t("Only users with access can hold channel privileges"))
+		} else {
+			service.Notice(rb, client.t("Anyone can hold channel privileges"))
+		}
+	case "keeptopic":
+		if settings.NoKeepTopic {
+			service.Notice(rb, client.t("The topic is cleared when the channel is empty"))
+		} else {
+			service.Notice(rb, client.t("The topic is kept when the channel is empty"))
+		}
 	default:
 		service.Notice(rb, client.t("Invalid params"))
 	}
@@ -823,7 +968,7 @@ func csGetHandler(service *ircService, server *Server, client *Client, command s
 		return
 	}
 	info := channel.ExportRegistration(IncludeSettings)
-	if !csPrivsCheck(service, info, client, rb) {
+	if !csAccessCheck(service, channel, info, client, ChanAccessSettings, rb) {
 		return
 	}
 
@@ -839,7 +984,7 @@ func csSetHandler(service *ircService, server *Server, client *Client, command s
 	}
 	info := channel.ExportRegistration(IncludeSettings)
 	settings := info.Settings
-	if !csPrivsCheck(service, info, client, rb) {
+	if !csAccessCheck(service, channel, info, client, ChanAccessSettings, rb) {
 		return
 	}
 
@@ -860,6 +1005,57 @@ func csSetHandler(service *ircService, server *Server, client *Client, command s
 			break
 		}
 		channel.SetSettings(settings)
+	case "knock":
+		var knock bool
+		knock, err = utils.StringToBool(value)
+		if err != nil {
+			err = errInvalidParams
+			break
+		}
+		settings.NoKnock = !knock
+		channel.SetSettings(settings)
+	case "mlock":
+		if strings.ToLower(value) == "off" {
+			settings.MLock = ""
+		} else {
+			var on, off modes.Modes
+			on, off, err = parseMLock(value)
+			if err != nil {
+				err = errInvalidParams
+				break
+			}
+			settings.MLock = formatMLock(on, off)
+		}
+		channel.SetSettings(settings)
+		if applied := channel.enforceMLock(); len(applied) != 0 {
+			announceCmodeChanges(channel, applied, service.prefix, "*", "", false, rb)
+		}
+	case "topiclock":
+		settings.TopicLock, err = utils.StringToBool(value)
+		if err != nil {
+			err = errInvalidParams
+			break
+		}
+		channel.SetSettings(settings)
+	case "secureops", "guard":
+		settings.SecureOps, err = utils.StringToBool(value)
+		if err != nil {
+			err = errInvalidParams
+			break
+		}
+		channel.SetSettings(settings)
+		if applied := channel.enforceSecureOps(); len(applied) != 0 {
+			announceCmodeChanges(channel, applied, service.prefix, "*", "", false, rb)
+		}
+	case "keeptopic":
+		var keepTopic bool
+		keepTopic, err = utils.StringToBool(value)
+		if err != nil {
+			err = errInvalidParams
+			break
+		}
+		settings.NoKeepTopic = !keepTopic
+		channel.SetSettings(settings)
 	}
 
 	switch err {
@@ -953,3 +1149,349 @@ func csHowToBanHandler(service *ircService, server *Server, client *Client, comm
 		}
 	}
 }
+
+func csTbanHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	channel := server.channels.Get(params[0])
+	if channel == nil {
+		service.Notice(rb, client.t("No such channel"))
+		return
+	}
+
+	if strings.ToLower(params[1]) == "list" {
+		csTbanListHandler(service, client, channel, rb)
+		return
+	} else if len(params) < 3 {
+		service.Notice(rb, client.t("Invalid parameters"))
+		return
+	}
+
+	duration, err := custime.ParseDuration(params[1])
+	if err != nil || duration <= 0 {
+		service.Notice(rb, client.t("Invalid duration"))
+		return
+	}
+
+	mode := modes.BanMask
+	if len(params) > 3 {
+		switch strings.TrimPrefix(params[3], "+") {
+		case "b":
+		case "e":
+			mode = modes.ExceptMask
+		case "I":
+			mode = modes.InviteMask
+		default:
+			service.Notice(rb, client.t("Invalid list mode; use +b, +e, or +I"))
+			return
+		}
+	}
+
+	maskAdded, err := channel.AddTimedMask(client, mode, params[2], duration, rb)
+	switch {
+	case maskAdded != "":
+		service.Notice(rb, fmt.Sprintf(client.t("Added %[1]s to the +%[2]s list of %[3]s for %[4]s"), maskAdded, mode.String(), channel.Name(), duration))
+	case err == errInsufficientPrivs:
+		service.Notice(rb, client.t("Insufficient privileges"))
+	case err == errLimitExceeded:
+		service.Notice(rb, client.t("Channel list is full"))
+	case err != nil:
+		service.Notice(rb, client.t("Invalid mask"))
+	default:
+		service.Notice(rb, fmt.Sprintf(client.t("Channel %[1]s list already contains %[2]s"), channel.Name(), params[2]))
+	}
+}
+
+func csFlagsHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	channel := server.channels.Get(params[0])
+	if channel == nil {
+		service.Notice(rb, client.t("Channel does not exist"))
+		return
+	} else if channel.Founder() == "" {
+		service.Notice(rb, client.t("Channel is not registered"))
+		return
+	}
+	chname := channel.Name()
+
+	describe := func(entry ChanAccessEntry) string {
+		result := formatChanAccess(entry.Flags, entry.Amode)
+		if template := chanAccessTemplateName(entry.Flags, entry.Amode); template != "" {
+			result = fmt.Sprintf("%s [%s]", result, template)
+		}
+		return result
+	}
+
+	var account string
+	if len(params) > 1 {
+		var err error
+		account, err = CasefoldName(params[1])
+		if err != nil {
+			service.Notice(rb, client.t("Account does not exist"))
+			return
+		}
+	}
+
+	if len(params) < 3 {
+		// viewing your own access doesn't require the +l flag:
+		if !(account != "" && account == client.Account()) &&
+			!(channel.ClientHasAccess(client, ChanAccessList) || client.HasRoleCapabs("chanreg")) {
+			service.Notice(rb, client.t("Insufficient privileges"))
+			return
+		}
+		accessList := channel.AccessList()
+		if account == "" {
+			service.Notice(rb, fmt.Sprintf(client.t("Channel %[1]s has %[2]d accounts with access"), chname, len(accessList)))
+		}
+		for _, entry := range accessList {
+			if account == "" || account == entry.Account {
+				service.Notice(rb, fmt.Sprintf(client.t("Account %[1]s has access %[2]s"), entry.Account, describe(entry)))
+				if account != "" {
+					return
+				}
+			}
+		}
+		if account != "" {
+			service.Notice(rb, fmt.Sprintf(client.t("Account %[1]s has no access to %[2]s"), params[1], chname))
+		}
+		return
+	}
+
+	// if we're adding access, the account must exist; allow removal of access
+	// from accounts that may have been deleted
+	change := params[2]
+	if !(strings.HasPrefix(change, "-") && !strings.Contains(change, "+")) {
+		if _, err := server.accounts.LoadAccount(account); err != nil {
+			service.Notice(rb, client.t("Account does not exist"))
+			return
+		}
+	}
+
+	before, after, err := channel.ProcessAccessChange(client, account, change)
+	switch err {
+	case nil:
+	case errInvalidParams:
+		service.Notice(rb, client.t("Invalid flags; see HELP FLAGS"))
+		return
+	case errInsufficientPrivs:
+		service.Notice(rb, client.t("Insufficient privileges"))
+		return
+	default:
+		service.Notice(rb, client.t("An error occurred"))
+		return
+	}
+
+	if before == after {
+		service.Notice(rb, client.t("No changes were made"))
+		return
+	}
+	channel.MarkDirty(IncludeLists)
+	if after.Flags == 0 && after.Amode == modes.Mode(0) {
+		service.Notice(rb, fmt.Sprintf(client.t("Account %[1]s no longer has access to %[2]s"), account, chname))
+	} else {
+		service.Notice(rb, fmt.Sprintf(client.t("Account %[1]s now has access %[2]s on %[3]s"), account, describe(after), chname))
+	}
+
+	// #729: apply any change to the automatic mode to current membership
+	if before.Amode != after.Amode {
+		var changes modes.ModeChanges
+		if before.Amode != modes.Mode(0) {
+			changes = append(changes, modes.ModeChange{Mode: before.Amode, Op: modes.Remove})
+		}
+		if after.Amode != modes.Mode(0) {
+			changes = append(changes, modes.ModeChange{Mode: after.Amode, Op: modes.Add})
+		}
+		for _, member := range channel.Members() {
+			if member.Account() != account {
+				continue
+			}
+			for _, change := range changes {
+				change.Arg = member.Nick()
+				if applied, change := channel.applyModeToMember(client, change, rb); applied {
+					announceCmodeChanges(channel, modes.ModeChanges{change}, server.name, "*", "", false, rb)
+				}
+			}
+		}
+	}
+}
+
+// resolveAKickTarget converts a CS AKICK target (an account name or a mask)
+// to the mask stored in the AKICK list.
+func resolveAKickTarget(server *Server, target string, mustExist bool) (mask string, err error) {
+	if !strings.ContainsAny(target, "!@$*?:") {
+		if mustExist {
+			account, err := server.accounts.LoadAccount(target)
+			if err != nil {
+				return "", errAccountDoesNotExist
+			}
+			return extbanPrefix + "a:" + account.NameCasefolded, nil
+		}
+		cfname, err := CasefoldName(target)
+		if err != nil {
+			return "", errAccountDoesNotExist
+		}
+		return extbanPrefix + "a:" + cfname, nil
+	}
+	mask, err = canonicalizeListMask(target)
+	if err != nil || strings.HasPrefix(mask, "m:") {
+		return "", errInvalidParams
+	}
+	return mask, nil
+}
+
+func csAKickHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	channel := server.channels.Get(params[0])
+	if channel == nil {
+		service.Notice(rb, client.t("Channel does not exist"))
+		return
+	} else if channel.Founder() == "" {
+		service.Notice(rb, client.t("Channel is not registered"))
+		return
+	}
+	if !(channel.ClientIsAtLeast(client, modes.ChannelOperator) || channel.ClientHasAccess(client, ChanAccessBans) || client.HasRoleCapabs("chanreg")) {
+		service.Notice(rb, client.t("Insufficient privileges"))
+		return
+	}
+	chname := channel.Name()
+
+	subcommand := strings.ToLower(params[1])
+	if subcommand == "list" {
+		akicks := channel.akicks.Masks()
+		masks := make([]string, 0, len(akicks))
+		for mask := range akicks {
+			masks = append(masks, mask)
+		}
+		sort.Strings(masks)
+		service.Notice(rb, fmt.Sprintf(client.t("Channel %[1]s has %[2]d AKICK entries"), chname, len(masks)))
+		for _, mask := range masks {
+			info := akicks[mask]
+			expires := client.t("never")
+			if expiration := info.Expiration(); !expiration.IsZero() {
+				expires = time.Until(expiration).Truncate(time.Second).String()
+			}
+			service.Notice(rb, fmt.Sprintf(client.t("%[1]s (set by %[2]s, expires: %[3]s)"), mask, info.CreatorNickmask, expires))
+			if info.Reason != "" {
+				service.Notice(rb, fmt.Sprintf(client.t("  Reason: %s"), info.Reason))
+			}
+			if info.PrivateReason != "" {
+				service.Notice(rb, fmt.Sprintf(client.t("  Private reason: %s"), info.PrivateReason))
+			}
+		}
+		return
+	}
+
+	if len(params) < 3 || (subcommand != "add" && subcommand != "del") {
+		service.Notice(rb, client.t("Invalid parameters"))
+		return
+	}
+
+	// deleted accounts can still be removed from the list
+	mask, err := resolveAKickTarget(server, params[2], subcommand == "add")
+	if err == errAccountDoesNotExist {
+		service.Notice(rb, client.t("Account does not exist"))
+		return
+	} else if err != nil {
+		service.Notice(rb, client.t("Invalid mask"))
+		return
+	}
+
+	if subcommand == "del" {
+		if maskRemoved, _ := channel.akicks.Remove(mask); maskRemoved == "" {
+			service.Notice(rb, fmt.Sprintf(client.t("%[1]s is not on the AKICK list of %[2]s"), mask, chname))
+			return
+		}
+		channel.MarkDirty(IncludeLists)
+		// remove the corresponding ban, unless someone else set it
+		if info, ok := channel.lists[modes.BanMask].Masks()[mask]; ok && info.CreatorNickmask == service.prefix {
+			if maskRemoved, _ := channel.lists[modes.BanMask].Remove(mask); maskRemoved != "" {
+				applied := modes.ModeChanges{{Mode: modes.BanMask, Op: modes.Remove, Arg: maskRemoved}}
+				announceCmodeChanges(channel, applied, service.prefix, "*", "", false, rb)
+			}
+		}
+		service.Notice(rb, fmt.Sprintf(client.t("Removed %[1]s from the AKICK list of %[2]s"), mask, chname))
+		return
+	}
+
+	if channel.akicks.Length() >= server.Config().Limits.ChanListModes {
+		service.Notice(rb, client.t("Channel list is full"))
+		return
+	}
+	details := client.Details()
+	info := MaskInfo{
+		CreatorNickmask: details.nickMask,
+		CreatorAccount:  details.accountName,
+	}
+	reasonParams := params[3:]
+	if len(reasonParams) != 0 {
+		if duration, err := custime.ParseDuration(reasonParams[0]); err == nil {
+			if duration <= 0 {
+				service.Notice(rb, client.t("Invalid duration"))
+				return
+			}
+			info.Duration = duration
+			reasonParams = reasonParams[1:]
+		}
+	}
+	reason := strings.Join(reasonParams, " ")
+	if separator := strings.IndexByte(reason, '|'); separator != -1 {
+		info.PrivateReason = strings.TrimSpace(reason[separator+1:])
+		reason = reason[:separator]
+	}
+	info.Reason = strings.TrimSpace(reason)
+
+	maskAdded, _ := channel.akicks.AddInfo(mask, info)
+	if maskAdded == "" {
+		service.Notice(rb, fmt.Sprintf(client.t("%[1]s is already on the AKICK list of %[2]s"), mask, chname))
+		return
+	}
+	channel.MarkDirty(IncludeLists)
+	channel.scheduleMaskExpiry()
+	if info.Duration != 0 {
+		service.Notice(rb, fmt.Sprintf(client.t("Added %[1]s to the AKICK list of %[2]s for %[3]s"), maskAdded, chname, info.Duration))
+	} else {
+		service.Notice(rb, fmt.Sprintf(client.t("Added %[1]s to the AKICK list of %[2]s"), maskAdded, chname))
+	}
+
+	// enforce the new entry against current members
+	kickReason := info.Reason
+	if kickReason == "" {
+		kickReason = client.t("No reason given")
+	}
+	for _, member := range channel.Members() {
+		memberDetails := member.Details()
+		if mask, info, found := channel.matchAKick(member, &memberDetails); found && mask == maskAdded {
+			channel.enforceAKick(member, mask, info, service.prefix, nil)
+			channel.Kick(client, member, kickReason, rb, true)
+		}
+	}
+}
+
+// csTbanListHandler lists the timed entries of the channel's +b, +e and +I
+// lists, with the time remaining until they expire.
+func csTbanListHandler(service *ircService, client *Client, channel *Channel, rb *ResponseBuffer) {
+	if !channel.ClientIsAtLeast(client, modes.ChannelOperator) && !channel.ClientHasAccess(client, ChanAccessBans) {
+		service.Notice(rb, client.t("Insufficient privileges"))
+		return
+	}
+
+	type timedEntry struct {
+		mode       modes.Mode
+		mask       string
+		info       MaskInfo
+		expiration time.Time
+	}
+	var entries []timedEntry
+	for _, mode := range []modes.Mode{modes.BanMask, modes.ExceptMask, modes.InviteMask} {
+		for mask, info := range channel.lists[mode].Masks() {
+			if expiration := info.Expiration(); !expiration.IsZero() {
+				entries = append(entries, timedEntry{mode: mode, mask: mask, info: info, expiration: expiration})
+			}
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].expiration.Before(entries[j].expiration)
+	})
+
+	service.Notice(rb, fmt.Sprintf(client.t("Channel %[1]s has %[2]d timed entries"), channel.Name(), len(entries)))
+	for _, entry := range entries {
+		timeLeft := time.Until(entry.expiration).Truncate(time.Second)
+		service.Notice(rb, fmt.Sprintf(client.t("+%[1]s %[2]s (set by %[3]s, expires in %[4]s)"), entry.mode.String(), entry.mask, entry.info.CreatorNickmask, timeLeft))
+	}
+}
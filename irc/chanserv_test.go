@@ -0,0 +1,89 @@
+// Copyright (c) 2026 agent <agent@local>
+// released under the MIT license
+
+package irc
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimedBans(t *testing.T) {
+	_, addr := newTestServer(t, nil)
+	alice := connectTestClient(t, addr, "alice", "")
+	bob := connectTestClient(t, addr, "bob", "")
+	alice.Send("JOIN #test")
+	alice.Expect(RPL_ENDOFNAMES)
+	alice.Sync()
+
+	assertEqual(alice.serviceCommand("ChanServ", "TBAN #test 1h bob!*@*"), "Added bob!*@* to the +b list of #test for 1h0m0s", t)
+	assertEqual(alice.serviceCommand("ChanServ", "TBAN #test 2h $a:bob +e"), "Added $a:bob to the +e list of #test for 2h0m0s", t)
+	alice.Send("MODE #test +b permanent!*@*")
+	alice.Sync()
+
+	// timed entries are listed with the standard parameters, followed by
+	// their expiration time:
+	alice.Send("MODE #test +b")
+	expiration := time.Now().Add(time.Hour).Unix()
+	for i := 0; i < 2; i++ {
+		fields := strings.Fields(alice.Expect(RPL_BANLIST))
+		if len(fields) < 7 || strings.HasPrefix(fields[6], ":") {
+			t.Fatalf("unexpected ban list entry: %q", fields)
+		}
+		switch fields[4] {
+		case "permanent!*@*":
+			assertEqual(len(fields), 7, t)
+		case "bob!*@*":
+			assertEqual(len(fields), 8, t)
+			if listed, _ := strconv.ParseInt(fields[7], 10, 64); listed < expiration-5 || listed > expiration+5 {
+				t.Errorf("unexpected expiration time: %q", fields)
+			}
+		default:
+			t.Errorf("unexpected ban list entry: %q", fields)
+		}
+	}
+	alice.Expect(RPL_ENDOFBANLIST)
+
+	// their expiry is available from ChanServ, soonest first:
+	assertEqual(alice.serviceCommand("ChanServ", "TBAN #test LIST"), "Channel #test has 2 timed entries", t)
+	for _, entry := range [][2]string{{"+b bob!*@*", "59m"}, {"+e $a:bob", "1h59m"}} {
+		notice := alice.Expect("NOTICE")
+		if !strings.Contains(notice, " :"+entry[0]+" (set by alice!") || !strings.Contains(notice, ", expires in "+entry[1]) {
+			t.Errorf("unexpected timed entry: %q", notice)
+		}
+	}
+	assertEqual(bob.serviceCommand("ChanServ", "TBAN #test LIST"), "Insufficient privileges", t)
+	assertEqual(alice.serviceCommand("ChanServ", "TBAN #test 1h"), "Invalid parameters", t)
+}
+
+func TestKeepTopic(t *testing.T) {
+	server, addr := newTestServer(t, nil)
+	registerTestAccount(t, server, "alice", "pw")
+	alice := connectTestClient(t, addr, "alice", "pw")
+	alice.Send("JOIN #test")
+	alice.Expect(RPL_ENDOFNAMES)
+	if reply := alice.serviceCommand("ChanServ", "REGISTER #test"); !strings.Contains(reply, "registered") {
+		t.Fatalf("unexpected reply: %q", reply)
+	}
+	alice.Send("TOPIC #test :hello")
+	alice.Expect("TOPIC")
+
+	// registered channels keep their topic while empty by default:
+	cycle := func() string {
+		alice.Send("PART #test")
+		alice.Expect("PART")
+		alice.Send("JOIN #test")
+		alice.Expect(RPL_ENDOFNAMES)
+		alice.Send("TOPIC #test")
+		return lineCommand(alice.Expect(RPL_TOPIC, RPL_NOTOPIC))
+	}
+	assertEqual(cycle(), RPL_TOPIC, t)
+
+	assertEqual(alice.serviceCommand("ChanServ", "SET #test KEEPTOPIC off"), "Successfully changed the channel settings", t)
+	assertEqual(alice.serviceCommand("ChanServ", "GET #test KEEPTOPIC"), "The topic is cleared when the channel is empty", t)
+	assertEqual(cycle(), RPL_NOTOPIC, t)
+	channel := server.channels.Get("#test")
+	assertEqual(channel.ExportRegistration(IncludeTopic).Topic, "", t)
+}
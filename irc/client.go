@@ -26,6 +26,7 @@ import (
 	"github.com/ergochat/ergo/irc/flatip"
 	"github.com/ergochat/ergo/irc/history"
 	"github.com/ergochat/ergo/irc/modes"
+	"github.com/ergochat/ergo/irc/scram"
 	"github.com/ergochat/ergo/irc/sno"
 	"github.com/ergochat/ergo/irc/utils"
 )
@@ -67,6 +68,7 @@ var (
 
 // Client is an IRC client.
 type Client struct {
+	accept             map[string]string // caller-ID accept list: casefolded nick -> nick
 	account            string
 	accountName        string // display name of the account: uncasefolded, '*' if not logged in
 	accountRegDate     time.Time
@@ -81,29 +83,37 @@ type Client struct {
 	isSTSOnly          bool
 	languages          []string
 	lastActive         time.Time            // last time they sent a command that wasn't PONG or similar
+	lastKnock          time.Time            // last time they sent a KNOCK, for rate limiting
+	lastCallerIDNotify time.Time            // last time they were told about a message blocked by +g
 	lastSeen           map[string]time.Time // maps device ID (including "") to time of last received command
 	lastSeenLastWrite  time.Time            // last time `lastSeen` was written to the datastore
+	linkID             string               // network-wide identifier used on server links
 	loginThrottle      connection_limits.GenericThrottle
-	nextSessionID      int64 // Incremented when a new session is established
+	metadata           map[string]string // draft/metadata-2; immutable, replaced wholesale on change
+	nextSessionID      int64             // Incremented when a new session is established
 	nick               string
 	nickCasefolded     string
 	nickMaskCasefolded string
 	nickMaskString     string // cache for nickmask string since it's used with lots of replies
 	oper               *Oper
+	pendingOper        *Oper // granted by LDAP group membership, applied once registered
 	preregNick         string
 	proxiedIP          net.IP // actual remote IP if using the PROXY protocol
 	rawHostname        string
 	cloakedHostname    string
 	realname           string
+	readMarkers        map[string]time.Time // casefolded target to draft/read-marker timestamp
 	realIP             net.IP
 	requireSASLMessage string
 	requireSASL        bool
 	registered         bool
 	registerCmdSent    bool // already sent the draft/register command, can't send it again
 	registrationTimer  *time.Timer
+	remote             *remoteClient // non-nil iff the client is connected to another server
 	server             *Server
 	skeleton           string
 	sessions           []*Session
+	silence            *silenceList
 	stateMutex         sync.RWMutex // tier 1
 	alwaysOn           bool
 	username           string
@@ -116,6 +126,9 @@ type Client struct {
 type saslStatus struct {
 	mechanism string
 	value     string
+	// state of a multi-step SCRAM exchange:
+	scramConv    *scram.ServerConversation
+	scramAccount ClientAccount
 }
 
 func (s *saslStatus) Clear() {
@@ -177,6 +190,8 @@ type Session struct {
 	zncPlaybackTimes      *zncPlaybackTimes
 	autoreplayMissedSince time.Time
 
+	metadataSubscriptions utils.StringSet // guarded by the client's stateMutex
+
 	batch MultilineBatch
 }
 
@@ -295,7 +310,7 @@ func (server *Server) RunClient(conn IRCConn) {
 		// cover up details of the tor proxying infrastructure (not a user privacy concern,
 		// but a hardening measure):
 		proxiedIP = utils.IPv4LoopbackAddress
-		isBanned, banMsg = server.checkTorLimits()
+		isBanned, banMsg = server.checkTorLimits(wConn.Config.Addr)
 	} else {
 		ipToCheck := realIP
 		if wConn.ProxiedIP != nil {
@@ -305,7 +320,7 @@ func (server *Server) RunClient(conn IRCConn) {
 		// XXX only run the check script now if the IP cannot be replaced by PROXY or WEBIRC,
 		// otherwise we'll do it in ApplyProxiedIP.
 		checkScripts := proxiedIP != nil || !utils.IPInNets(realIP, config.Server.proxyAllowedFromNets)
-		isBanned, requireSASL, banMsg = server.checkBans(config, ipToCheck, checkScripts)
+		isBanned, requireSASL, banMsg = server.checkBans(config, ipToCheck, checkScripts, wConn.Config.Addr)
 	}
 
 	if isBanned {
@@ -316,6 +331,7 @@ func (server *Server) RunClient(conn IRCConn) {
 		return
 	}
 
+	server.metrics.acceptConnection(wConn.Config.Addr)
 	server.logger.Info("connect-ip", fmt.Sprintf("Client connecting: real IP %v, proxied IP %v", realIP, proxiedIP))
 
 	now := time.Now().UTC()
@@ -385,7 +401,7 @@ func (server *Server) RunClient(conn IRCConn) {
 	client.run(session)
 }
 
-func (server *Server) AddAlwaysOnClient(account ClientAccount, channelToStatus map[string]alwaysOnChannelStatus, lastSeen map[string]time.Time, uModes modes.Modes, realname string) {
+func (server *Server) AddAlwaysOnClient(account ClientAccount, channelToStatus map[string]alwaysOnChannelStatus, lastSeen map[string]time.Time, uModes modes.Modes, accept []string, realname string) {
 	now := time.Now().UTC()
 	config := server.Config()
 	if lastSeen == nil && account.Settings.AutoreplayMissed {
@@ -432,6 +448,7 @@ func (server *Server) AddAlwaysOnClient(account ClientAccount, channelToStatus m
 	for _, m := range uModes {
 		client.SetMode(m, true)
 	}
+	client.setAcceptList(accept)
 	client.history.Initialize(0, 0)
 
 	server.accounts.Login(client, account)
@@ -692,7 +709,9 @@ func (client *Client) run(session *Session) {
 			touches := session.deferredFakelagCount + 1
 			session.deferredFakelagCount = 0
 			for i := 0; i < touches; i++ {
-				session.fakelag.Touch()
+				if session.fakelag.Touch() {
+					client.server.metrics.fakelagThrottles.Inc()
+				}
 			}
 		} else {
 			// DoS hardening, #505
@@ -717,6 +736,7 @@ func (client *Client) run(session *Session) {
 			break
 		}
 
+		client.server.metrics.command(msg.Command)
 		cmd, exists := Commands[msg.Command]
 		if !exists {
 			cmd = unknownCommand
@@ -753,7 +773,7 @@ func (client *Client) playReattachMessages(session *Session) {
 	}
 	if !session.autoreplayMissedSince.IsZero() && !hasHistoryCaps {
 		rb := NewResponseBuffer(session)
-		zncPlayPrivmsgsFromAll(client, rb, time.Now().UTC(), session.autoreplayMissedSince)
+		zncPlayPrivmsgsFromAll(client, rb, time.Now().UTC(), session.autoreplayMissedSince, true)
 		rb.Send(true)
 	}
 	session.autoreplayMissedSince = time.Time{}
@@ -894,6 +914,9 @@ func (client *Client) replayPrivmsgHistory(rb *ResponseBuffer, items []history.I
 
 	hasEventPlayback := rb.session.capabilities.Has(caps.EventPlayback)
 	hasTags := rb.session.capabilities.Has(caps.MessageTags)
+	if rb.session.capabilities.Has(caps.MessageEdit) {
+		items = applyMessageEdits(items)
+	}
 	for _, item := range items {
 		var command string
 		switch item.Type {
@@ -1179,6 +1202,10 @@ func (client *Client) Quit(message string, session *Session) {
 	client.stateMutex.Lock()
 	defer client.stateMutex.Unlock()
 
+	if client.remote != nil && client.remote.quitMessage == "" {
+		client.remote.quitMessage = message
+	}
+
 	var sessions []*Session
 	if session != nil {
 		sessions = []*Session{session}
@@ -1273,6 +1300,13 @@ func (client *Client) destroy(session *Session) {
 		client.registrationTimer.Stop()
 	}
 
+	// clients on other servers have no sessions; they are not counted in our stats
+	isRemote := client.remote != nil
+	if isRemote {
+		quitMessage = client.remote.quitMessage
+		shouldDecrement = false
+	}
+
 	client.stateMutex.Unlock()
 
 	// XXX there is no particular reason to persist this state here rather than
@@ -1380,6 +1414,7 @@ func (client *Client) destroy(session *Session) {
 	if quitMessage == "" {
 		quitMessage = "Exited"
 	}
+	client.server.links.ClientExited(client, quitMessage)
 	splitQuitMessage := utils.MakeMessage(quitMessage)
 	isBot := client.HasMode(modes.Bot)
 	quitItem = history.Item{
@@ -1397,7 +1432,7 @@ func (client *Client) destroy(session *Session) {
 		}
 	}
 
-	if registered {
+	if registered && !isRemote {
 		client.server.snomasks.Send(sno.LocalQuits, fmt.Sprintf(ircfmt.Unescape("%s$r exited the network"), details.nick))
 	}
 }
@@ -1531,6 +1566,9 @@ func (session *Session) sendBytes(line []byte, blocking bool) (err error) {
 	} else {
 		err = session.socket.Write(line)
 	}
+	if err == errSendQExceeded {
+		session.client.server.metrics.sendQExceeded.Inc()
+	}
 	if err != nil {
 		session.client.server.logger.Info("quit", "send error to client", fmt.Sprintf("%s [%d]", session.client.Nick(), session.sessionID), err.Error())
 	}
@@ -1581,7 +1619,7 @@ func (client *Client) addChannel(channel *Channel, simulated bool) (err error) {
 	alwaysOn := client.alwaysOn
 	if client.destroyed {
 		err = errClientDestroyed
-	} else if client.oper == nil && len(client.channels) >= config.Channels.MaxChannelsPerClient {
+	} else if client.oper == nil && client.remote == nil && len(client.channels) >= config.Channels.MaxChannelsPerClient {
 		err = errTooManyChannels
 	} else {
 		client.channels[channel] = empty{} // success
@@ -1669,12 +1707,37 @@ func (client *Client) attemptAutoOper(session *Session) {
 	}
 }
 
+// applies operator privileges that the client's authentication backend granted
+// at login (currently, LDAP groups mapped to oper classes)
+func (client *Client) applyPendingOper(rb *ResponseBuffer) {
+	client.stateMutex.Lock()
+	oper := client.pendingOper
+	client.pendingOper = nil
+	client.stateMutex.Unlock()
+
+	if oper != nil && !client.HasMode(modes.Operator) {
+		applyOper(client, oper, rb)
+	}
+}
+
 func (client *Client) checkLoginThrottle() (throttled bool, remainingTime time.Duration) {
 	client.stateMutex.Lock()
 	defer client.stateMutex.Unlock()
 	return client.loginThrottle.Touch()
 }
 
+func (client *Client) knockThrottled(now time.Time, delay time.Duration) bool {
+	client.stateMutex.RLock()
+	defer client.stateMutex.RUnlock()
+	return now.Sub(client.lastKnock) < delay
+}
+
+func (client *Client) recordKnock(now time.Time) {
+	client.stateMutex.Lock()
+	defer client.stateMutex.Unlock()
+	client.lastKnock = now
+}
+
 func (client *Client) historyStatus(config *Config) (status HistoryStatus, target string) {
 	if !config.History.Enabled {
 		return HistoryDisabled, ""
@@ -1707,6 +1770,12 @@ func (client *Client) addHistoryItem(target *Client, item history.Item, details,
 
 	cStatus, _ := client.historyStatus(config)
 	tStatus, _ := target.historyStatus(config)
+	// messages from a silenced sender are kept out of the target's history
+	tAccount := tDetails.account
+	if client != target && target.isSilenced(client) {
+		tStatus = HistoryDisabled
+		tAccount = ""
+	}
 	// add to ephemeral history
 	if cStatus == HistoryEphemeral {
 		targetedItem.CfCorrespondent = tDetails.nickCasefolded
@@ -1718,7 +1787,7 @@ func (client *Client) addHistoryItem(target *Client, item history.Item, details,
 	}
 	if cStatus == HistoryPersistent || tStatus == HistoryPersistent {
 		targetedItem.CfCorrespondent = ""
-		client.server.historyDB.AddDirectMessage(details.nickCasefolded, details.account, tDetails.nickCasefolded, tDetails.account, targetedItem)
+		client.server.HistoryDB().AddDirectMessage(details.nickCasefolded, details.account, tDetails.nickCasefolded, tAccount, targetedItem)
 	}
 	return nil
 }
@@ -1743,9 +1812,11 @@ func (client *Client) listTargets(start, end history.Selector, limit int) (resul
 			chcfnames = append(chcfnames, channel.NameCasefolded())
 		}
 	}
-	persistentExtras, err := client.server.historyDB.ListChannels(chcfnames)
-	if err == nil && len(persistentExtras) != 0 {
-		extras = append(extras, persistentExtras...)
+	if historyDB := client.server.HistoryDB(); len(chcfnames) != 0 && historyDB != nil {
+		persistentExtras, err := historyDB.ListChannels(chcfnames)
+		if err == nil && len(persistentExtras) != 0 {
+			extras = append(extras, persistentExtras...)
+		}
 	}
 
 	_, cSeq, err := client.server.GetHistorySequence(nil, client, "")
@@ -1877,7 +1948,7 @@ func (client *Client) performWrite(additionalDirtyBits uint) {
 				}
 			}
 		}
-		client.server.accounts.saveModes(account, uModes)
+		client.server.accounts.saveModes(account, uModes, client.AcceptList())
 	}
 	if (dirtyBits & IncludeRealname) != 0 {
 		client.server.accounts.saveRealname(account, client.realname)
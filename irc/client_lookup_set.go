@@ -235,6 +235,39 @@ func (clients *ClientManager) SetNick(client *Client, session *Session, newNick
 	return newNick, nil, false
 }
 
+// setRemoteNick assigns a nickname to a client on another server. The other
+// server already validated the nickname, so this only enforces uniqueness;
+// in case of a collision, the client holding the nickname is returned.
+func (clients *ClientManager) setRemoteNick(client *Client, newNick string) (holder *Client, err error) {
+	newCfNick, err := CasefoldName(newNick)
+	if err != nil {
+		return nil, errNicknameInvalid
+	}
+	newSkeleton, err := Skeleton(newNick)
+	if err != nil {
+		return nil, errNicknameInvalid
+	}
+
+	clients.Lock()
+	defer clients.Unlock()
+
+	if holder = clients.byNick[newCfNick]; holder != nil && holder != client {
+		return holder, errNicknameInUse
+	}
+	if holder = clients.bySkeleton[newSkeleton]; holder != nil && holder != client {
+		return holder, errNicknameInUse
+	}
+
+	formercfnick, formerskeleton := client.uniqueIdentifiers()
+	if changeSuccess := client.SetNick(newNick, newCfNick, newSkeleton); !changeSuccess {
+		return nil, errClientDestroyed
+	}
+	clients.removeInternal(client, formercfnick, formerskeleton)
+	clients.byNick[newCfNick] = client
+	clients.bySkeleton[newSkeleton] = client
+	return nil, nil
+}
+
 func (clients *ClientManager) AllClients() (result []*Client) {
 	clients.RLock()
 	defer clients.RUnlock()
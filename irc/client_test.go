@@ -5,6 +5,7 @@ package irc
 
 import (
 	"testing"
+	"time"
 
 	"github.com/ergochat/ergo/irc/utils"
 )
@@ -88,3 +89,18 @@ func TestWhoFields(t *testing.T) {
 		t.Error("failed to set and get")
 	}
 }
+
+func TestLogoutClearsAccountState(t *testing.T) {
+	client := &Client{
+		account:     "alice",
+		accountName: "Alice",
+		silence:     newSilenceList([]string{"$a:mallory"}),
+		readMarkers: map[string]time.Time{"#ergo": time.Now()},
+		metadata:    map[string]string{"avatar": "https://example.com/alice.png"},
+	}
+	client.Logout()
+	assertEqual(client.Account(), "", t)
+	assertEqual(client.silence.match("mallory!u@h", "mallory"), false, t)
+	assertEqual(len(client.readMarkers), 0, t)
+	assertEqual(len(client.metadata), 0, t)
+}
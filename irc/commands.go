@@ -75,6 +75,10 @@ var Commands map[string]Command
 
 func init() {
 	Commands = map[string]Command{
+		"ACCEPT": {
+			handler:   acceptHandler,
+			minParams: 1,
+		},
 		"AMBIANCE": {
 			handler:   sceneHandler,
 			minParams: 2,
@@ -102,6 +106,11 @@ func init() {
 			handler:   chathistoryHandler,
 			minParams: 4,
 		},
+		"CONNECT": {
+			handler:   connectHandler,
+			minParams: 1,
+			capabs:    []string{"linking"},
+		},
 		"DEBUG": {
 			handler:   debugHandler,
 			minParams: 1,
@@ -165,11 +174,19 @@ func init() {
 			minParams: 1,
 			capabs:    []string{"ban"},
 		},
+		"KNOCK": {
+			handler:   knockHandler,
+			minParams: 1,
+		},
 		"LANGUAGE": {
 			handler:      languageHandler,
 			usablePreReg: true,
 			minParams:    1,
 		},
+		"LINKS": {
+			handler:   linksHandler,
+			minParams: 0,
+		},
 		"LIST": {
 			handler:   listHandler,
 			minParams: 0,
@@ -178,6 +195,18 @@ func init() {
 			handler:   lusersHandler,
 			minParams: 0,
 		},
+		"MAP": {
+			handler:   mapHandler,
+			minParams: 0,
+		},
+		"MARKREAD": {
+			handler:   markReadHandler,
+			minParams: 0,
+		},
+		"METADATA": {
+			handler:   metadataHandler,
+			minParams: 2,
+		},
 		"MODE": {
 			handler:   modeHandler,
 			minParams: 1,
@@ -240,6 +269,10 @@ func init() {
 			minParams:      2,
 			allowedInBatch: true,
 		},
+		"REDACT": {
+			handler:   redactHandler,
+			minParams: 2,
+		},
 		"RELAYMSG": {
 			handler:   relaymsgHandler,
 			minParams: 3,
@@ -276,6 +309,18 @@ func init() {
 			handler:   setnameHandler,
 			minParams: 1,
 		},
+		"SILENCE": {
+			handler: silenceHandler,
+		},
+		"SQUIT": {
+			handler:   squitHandler,
+			minParams: 1,
+			capabs:    []string{"linking"},
+		},
+		"STATS": {
+			handler:   statsHandler,
+			minParams: 1,
+		},
 		"SUMMON": {
 			handler: summonHandler,
 		},
@@ -341,6 +386,11 @@ func init() {
 			handler:   versionHandler,
 			minParams: 0,
 		},
+		"WALLOPS": {
+			handler:   wallopsHandler,
+			minParams: 1,
+			capabs:    []string{"wallops"},
+		},
 		"WEBIRC": {
 			handler:      webircHandler,
 			usablePreReg: true,
@@ -35,10 +35,13 @@ import (
 	"github.com/ergochat/ergo/irc/isupport"
 	"github.com/ergochat/ergo/irc/jwt"
 	"github.com/ergochat/ergo/irc/languages"
+	"github.com/ergochat/ergo/irc/ldap"
 	"github.com/ergochat/ergo/irc/logger"
 	"github.com/ergochat/ergo/irc/modes"
 	"github.com/ergochat/ergo/irc/mysql"
 	"github.com/ergochat/ergo/irc/passwd"
+	"github.com/ergochat/ergo/irc/postgresql"
+	"github.com/ergochat/ergo/irc/sqlite"
 	"github.com/ergochat/ergo/irc/utils"
 )
 
@@ -330,7 +333,36 @@ type AccountConfig struct {
 	Multiclient MulticlientConfig
 	Bouncer     *MulticlientConfig // # handle old name for 'multiclient'
 	VHosts      VHostConfig
-	AuthScript  AuthScriptConfig `yaml:"auth-script"`
+	AuthScript  AuthScriptConfig  `yaml:"auth-script"`
+	JWTAuth     jwt.JwtAuthConfig `yaml:"jwt-auth"`
+	LDAP        ldap.ServerConfig
+	// normalized group DN to the operator class it grants:
+	ldapOperClasses map[string]*OperClass
+	TOTP            TOTPConfig
+	// self-service password reset by email (NS RESETPASS):
+	PasswordReset struct {
+		Enabled bool
+		// minimum time between codes sent for an account:
+		Cooldown custime.Duration
+		// how long a code is valid:
+		Timeout      custime.Duration
+		IPThrottling ThrottleConfig `yaml:"ip-throttling"`
+		// log out the account's sessions after a reset:
+		KillSessions bool `yaml:"kill-sessions"`
+	} `yaml:"password-reset"`
+	// rate limits on changing an account's email address (NS SET EMAIL):
+	EmailChange struct {
+		// minimum time between codes sent for an account:
+		Cooldown     custime.Duration
+		IPThrottling ThrottleConfig `yaml:"ip-throttling"`
+	} `yaml:"email-change"`
+}
+
+// TOTPConfig controls two-factor authentication with TOTP codes (NS 2FA).
+type TOTPConfig struct {
+	Enabled bool
+	// can a certfp login skip the second factor?
+	CertfpBypass bool `yaml:"certfp-bypass"`
 }
 
 type ScriptConfig struct {
@@ -474,6 +506,7 @@ type OperConfig struct {
 
 // Various server-enforced limits on data size.
 type Limits struct {
+	AcceptEntries        int `yaml:"accept-entries"`
 	AwayLen              int `yaml:"awaylen"`
 	ChanListModes        int `yaml:"chan-list-modes"`
 	ChannelLen           int `yaml:"channellen"`
@@ -481,6 +514,7 @@ type Limits struct {
 	KickLen              int `yaml:"kicklen"`
 	MonitorEntries       int `yaml:"monitor-entries"`
 	NickLen              int `yaml:"nicklen"`
+	SilenceEntries       int `yaml:"silence-entries"`
 	TopicLen             int `yaml:"topiclen"`
 	WhowasEntries        int `yaml:"whowas-entries"`
 	RegistrationMessages int `yaml:"registration-messages"`
@@ -490,6 +524,54 @@ type Limits struct {
 	}
 }
 
+// LinkConfig describes another server that we can link to.
+type LinkConfig struct {
+	Address            string
+	Password           string
+	Certfp             string
+	certfp             string
+	InsecureSkipVerify bool `yaml:"insecure-skip-verify"`
+	Autoconnect        bool
+}
+
+// LinkingConfig controls server-to-server linking.
+type LinkingConfig struct {
+	Enabled        bool
+	Description    string
+	Listener       string
+	TLS            TLSListenConfig
+	tlsConfig      *tls.Config
+	MaxSendQString string `yaml:"max-sendq"`
+	maxSendQBytes  int
+	Links          map[string]LinkConfig
+	// casefolded server name to link config:
+	links map[string]LinkConfig
+}
+
+// APITokenConfig is a bearer token for the HTTP API, along with the
+// operator capabilities it grants.
+type APITokenConfig struct {
+	Token        string
+	Capabilities []string
+	capabilities utils.StringSet
+}
+
+// APIConfig controls the HTTP administration API.
+type APIConfig struct {
+	Enabled   bool
+	Listener  string
+	TLS       TLSListenConfig
+	tlsConfig *tls.Config
+	// token name to token:
+	Tokens map[string]APITokenConfig
+}
+
+// MetricsConfig controls the Prometheus-format metrics endpoint.
+type MetricsConfig struct {
+	Enabled  bool
+	Listener string
+}
+
 // STSConfig controls the STS configuration/
 type STSConfig struct {
 	Enabled       bool
@@ -618,6 +700,8 @@ type Config struct {
 		Path        string
 		AutoUpgrade bool
 		MySQL       mysql.Config
+		PostgreSQL  postgresql.Config
+		SQLite      sqlite.Config
 	}
 
 	Accounts AccountConfig
@@ -634,6 +718,10 @@ type Config struct {
 		}
 		ListDelay        time.Duration    `yaml:"list-delay"`
 		InviteExpiration custime.Duration `yaml:"invite-expiration"`
+		KnockThrottling  struct {
+			ClientDelay  time.Duration `yaml:"client-delay"`
+			ChannelDelay time.Duration `yaml:"channel-delay"`
+		} `yaml:"knock-throttling"`
 	}
 
 	OperClasses map[string]*OperClassConfig `yaml:"oper-classes"`
@@ -656,6 +744,19 @@ type Config struct {
 
 	Fakelag FakelagConfig
 
+	Linking LinkingConfig
+
+	API APIConfig
+
+	Metrics MetricsConfig
+
+	Metadata struct {
+		Enabled       bool
+		MaxSubs       int `yaml:"max-subs"`
+		MaxKeys       int `yaml:"max-keys"`
+		MaxValueBytes int `yaml:"max-value-bytes"`
+	}
+
 	History struct {
 		Enabled          bool
 		ChannelLength    int              `yaml:"channel-length"`
@@ -679,8 +780,10 @@ type Config struct {
 			DirectMessages       PersistentStatus `yaml:"direct-messages"`
 		}
 		Retention struct {
-			AllowIndividualDelete bool `yaml:"allow-individual-delete"`
-			EnableAccountIndexing bool `yaml:"enable-account-indexing"`
+			AllowIndividualDelete bool             `yaml:"allow-individual-delete"`
+			EnableAccountIndexing bool             `yaml:"enable-account-indexing"`
+			RedactWindow          custime.Duration `yaml:"redact-window"`
+			EditWindow            custime.Duration `yaml:"edit-window"`
 		}
 		TagmsgStorage struct {
 			Default   bool
@@ -694,6 +797,7 @@ type Config struct {
 
 // OperClass defines an assembled operator class.
 type OperClass struct {
+	Name         string
 	Title        string
 	WhoisLine    string          `yaml:"whois-line"`
 	Capabilities utils.StringSet // map to make lookups much easier
@@ -734,6 +838,7 @@ func (conf *Config) OperatorClasses() (map[string]*OperClass, error) {
 
 			// create new operclass
 			var oc OperClass
+			oc.Name = name
 			oc.Capabilities = make(utils.StringSet)
 
 			// get inhereted info from other operclasses
@@ -793,6 +898,21 @@ func (oper *Oper) HasRoleCapab(capab string) bool {
 	return oper != nil && oper.Class.Capabilities.Has(capab)
 }
 
+// ldapOper returns the operator privileges (if any) that an LDAP user's
+// groups grant; if several groups are mapped, the first one listed wins.
+func (conf *Config) ldapOper(accountName string, groups []string) *Oper {
+	for _, group := range groups {
+		if class, ok := conf.Accounts.ldapOperClasses[group]; ok {
+			return &Oper{
+				Name:      accountName,
+				Class:     class,
+				WhoisLine: class.WhoisLine,
+			}
+		}
+	}
+	return nil
+}
+
 // Operators returns a map of operator configs from the given OperClass and config.
 func (conf *Config) Operators(oc map[string]*OperClass) (map[string]*Oper, error) {
 	operators := make(map[string]*Oper)
@@ -935,6 +1055,7 @@ func (conf *Config) prepareListeners() (err error) {
 	conf.Server.trueListeners = make(map[string]utils.ListenerConfig)
 	for addr, block := range conf.Server.Listeners {
 		var lconf utils.ListenerConfig
+		lconf.Addr = addr
 		lconf.ProxyDeadline = RegisterTimeout
 		lconf.Tor = block.Tor
 		lconf.STSOnly = block.STSOnly
@@ -1142,6 +1263,12 @@ func LoadConfig(filename string) (config *Config, err error) {
 	if config.Limits.RegistrationMessages == 0 {
 		config.Limits.RegistrationMessages = 1024
 	}
+	if config.Limits.SilenceEntries == 0 {
+		config.Limits.SilenceEntries = 32
+	}
+	if config.Limits.AcceptEntries == 0 {
+		config.Limits.AcceptEntries = 32
+	}
 	if config.Server.MaxLineLen < DefaultMaxLineLen {
 		config.Server.MaxLineLen = DefaultMaxLineLen
 	}
@@ -1150,6 +1277,31 @@ func LoadConfig(filename string) (config *Config, err error) {
 			return nil, fmt.Errorf("to use MySQL, nick and channel length limits must be %d or lower", mysql.MaxTargetLength)
 		}
 	}
+	if config.Datastore.PostgreSQL.Enabled {
+		if config.Limits.NickLen > postgresql.MaxTargetLength || config.Limits.ChannelLen > postgresql.MaxTargetLength {
+			return nil, fmt.Errorf("to use PostgreSQL, nick and channel length limits must be %d or lower", postgresql.MaxTargetLength)
+		}
+	}
+	if config.Datastore.SQLite.Enabled {
+		if !sqlite.Supported {
+			return nil, fmt.Errorf("this build of Ergo does not support SQLite, which requires cgo; see the manual")
+		}
+		if config.Datastore.SQLite.DatabasePath == "" {
+			return nil, fmt.Errorf("to use SQLite, you must set datastore.sqlite.database-path")
+		}
+		if config.Datastore.SQLite.Timeout == 0 {
+			config.Datastore.SQLite.Timeout = 3 * time.Second
+		}
+	}
+	historyDatabases := 0
+	for _, enabled := range []bool{config.Datastore.MySQL.Enabled, config.Datastore.PostgreSQL.Enabled, config.Datastore.SQLite.Enabled} {
+		if enabled {
+			historyDatabases++
+		}
+	}
+	if historyDatabases > 1 {
+		return nil, fmt.Errorf("Only one of MySQL, PostgreSQL, and SQLite can be enabled")
+	}
 
 	if config.Server.CoerceIdent != "" {
 		if config.Server.CheckIdent {
@@ -1330,6 +1482,15 @@ func LoadConfig(filename string) (config *Config, err error) {
 		}
 	}
 
+	if config.Accounts.PasswordReset.Enabled {
+		if !config.Accounts.Registration.EmailVerification.Enabled {
+			return nil, errors.New("password reset requires email verification to be enabled")
+		}
+		if config.Accounts.PasswordReset.Timeout == 0 {
+			config.Accounts.PasswordReset.Timeout = custime.Duration(24 * time.Hour)
+		}
+	}
+
 	config.Accounts.defaultUserModes = ParseDefaultUserModes(config.Accounts.DefaultUserModes)
 
 	if config.Server.Password != "" {
@@ -1379,7 +1540,20 @@ func LoadConfig(filename string) (config *Config, err error) {
 		config.Accounts.VHosts.validRegexp = defaultValidVhostRegex
 	}
 
-	config.Server.capValues[caps.SASL] = "PLAIN,EXTERNAL"
+	config.Server.capValues[caps.SASL] = "PLAIN,EXTERNAL,SCRAM-SHA-256,SCRAM-SHA-512"
+	if config.Accounts.JWTAuth.Enabled {
+		err = config.Accounts.JWTAuth.Postprocess()
+		if err != nil {
+			return nil, err
+		}
+		config.Server.capValues[caps.SASL] += ",OAUTHBEARER"
+	}
+	if config.Accounts.LDAP.Enabled {
+		err = config.Accounts.LDAP.Postprocess()
+		if err != nil {
+			return nil, err
+		}
+	}
 	if !config.Accounts.AuthenticationEnabled {
 		config.Server.supportedCaps.Disable(caps.SASL)
 	}
@@ -1408,6 +1582,18 @@ func LoadConfig(filename string) (config *Config, err error) {
 	}
 	config.Server.MaxSendQBytes = int(maxSendQBytes)
 
+	if err = config.prepareLinking(); err != nil {
+		return nil, err
+	}
+
+	if err = config.prepareAPI(); err != nil {
+		return nil, err
+	}
+
+	if config.Metrics.Enabled && config.Metrics.Listener == "" {
+		return nil, fmt.Errorf("The metrics endpoint requires a listener address")
+	}
+
 	config.languageManager, err = languages.NewManager(config.Languages.Enabled, config.Languages.Path, config.Languages.Default)
 	if err != nil {
 		return nil, fmt.Errorf("Could not load languages: %s", err.Error())
@@ -1425,6 +1611,22 @@ func LoadConfig(filename string) (config *Config, err error) {
 		config.Server.supportedCaps.Disable(caps.Relaymsg)
 	}
 
+	if config.Metadata.Enabled {
+		if config.Metadata.MaxSubs == 0 {
+			config.Metadata.MaxSubs = 100
+		}
+		if config.Metadata.MaxKeys == 0 {
+			config.Metadata.MaxKeys = 100
+		}
+		if config.Metadata.MaxValueBytes == 0 {
+			config.Metadata.MaxValueBytes = 1024
+		}
+		config.Server.capValues[caps.Metadata] = fmt.Sprintf("max-subs=%d,max-keys=%d,max-value-bytes=%d",
+			config.Metadata.MaxSubs, config.Metadata.MaxKeys, config.Metadata.MaxValueBytes)
+	} else {
+		config.Server.supportedCaps.Disable(caps.Metadata)
+	}
+
 	config.Debug.recoverFromErrors = utils.BoolDefaultTrue(config.Debug.RecoverFromErrors)
 
 	// process operator definitions, store them to config.operators
@@ -1438,6 +1640,17 @@ func LoadConfig(filename string) (config *Config, err error) {
 	}
 	config.operators = opers
 
+	if config.Accounts.LDAP.Enabled {
+		config.Accounts.ldapOperClasses = make(map[string]*OperClass)
+		for group, className := range config.Accounts.LDAP.OperClasses {
+			class, exists := operclasses[className]
+			if !exists {
+				return nil, fmt.Errorf("LDAP group %s maps to operclass [%s], which does not exist", group, className)
+			}
+			config.Accounts.ldapOperClasses[group] = class
+		}
+	}
+
 	// parse default channel modes
 	config.Channels.defaultModes = ParseDefaultChannelModes(config.Channels.DefaultModes)
 
@@ -1451,6 +1664,12 @@ func LoadConfig(filename string) (config *Config, err error) {
 	if config.Channels.Registration.MaxChannelsPerAccount == 0 {
 		config.Channels.Registration.MaxChannelsPerAccount = 15
 	}
+	if config.Channels.KnockThrottling.ClientDelay == 0 {
+		config.Channels.KnockThrottling.ClientDelay = 5 * time.Minute
+	}
+	if config.Channels.KnockThrottling.ChannelDelay == 0 {
+		config.Channels.KnockThrottling.ChannelDelay = time.Minute
+	}
 
 	config.Server.Compatibility.forceTrailing = utils.BoolDefaultTrue(config.Server.Compatibility.ForceTrailing)
 	config.Server.Compatibility.allowTruncation = utils.BoolDefaultTrue(config.Server.Compatibility.AllowTruncation)
@@ -1468,6 +1687,11 @@ func LoadConfig(filename string) (config *Config, err error) {
 		config.Server.supportedCaps.Disable(caps.ZNCPlayback)
 	}
 
+	// edits are checked against the history of the original message
+	if !config.History.Enabled || config.History.Retention.EditWindow == 0 {
+		config.Server.supportedCaps.Disable(caps.MessageEdit)
+	}
+
 	if !config.History.Enabled || !config.History.Persistent.Enabled {
 		config.History.Persistent.Enabled = false
 		config.History.Persistent.UnregisteredChannels = false
@@ -1475,8 +1699,8 @@ func LoadConfig(filename string) (config *Config, err error) {
 		config.History.Persistent.DirectMessages = PersistentDisabled
 	}
 
-	if config.History.Persistent.Enabled && !config.Datastore.MySQL.Enabled {
-		return nil, fmt.Errorf("You must configure a MySQL server in order to enable persistent history")
+	if config.History.Persistent.Enabled && !(config.Datastore.MySQL.Enabled || config.Datastore.PostgreSQL.Enabled || config.Datastore.SQLite.Enabled) {
+		return nil, fmt.Errorf("You must configure a MySQL, PostgreSQL, or SQLite database in order to enable persistent history")
 	}
 
 	if config.History.ZNCMax == 0 {
@@ -1506,6 +1730,13 @@ func LoadConfig(filename string) (config *Config, err error) {
 		// same machine:
 		config.Datastore.MySQL.MaxConns = runtime.NumCPU()
 	}
+	config.Datastore.PostgreSQL.ExpireTime = time.Duration(config.History.Restrictions.ExpireTime)
+	config.Datastore.PostgreSQL.TrackAccountMessages = config.History.Retention.EnableAccountIndexing
+	if config.Datastore.PostgreSQL.MaxConns == 0 {
+		config.Datastore.PostgreSQL.MaxConns = runtime.NumCPU()
+	}
+	config.Datastore.SQLite.ExpireTime = time.Duration(config.History.Restrictions.ExpireTime)
+	config.Datastore.SQLite.TrackAccountMessages = config.History.Retention.EnableAccountIndexing
 
 	config.Server.Cloaks.Initialize()
 	if config.Server.Cloaks.Enabled {
@@ -1537,6 +1768,109 @@ func (config *Config) getOutputPath(filename string) string {
 	return filepath.Join(config.Server.OutputPath, filename)
 }
 
+// prepareLinking validates the linking configuration and populates its
+// unexported fields
+func (config *Config) prepareLinking() (err error) {
+	linking := &config.Linking
+	linking.links = make(map[string]LinkConfig, len(linking.Links))
+	if !linking.Enabled {
+		return nil
+	}
+
+	if linking.TLS.Cert == "" || linking.TLS.Key == "" {
+		return fmt.Errorf("Server linking requires a TLS certificate and key")
+	}
+	cert, err := loadCertWithLeaf(linking.TLS.Cert, linking.TLS.Key)
+	if err != nil {
+		return fmt.Errorf("Could not load linking certificate: %s", err.Error())
+	}
+	linking.tlsConfig = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequestClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if linking.MaxSendQString == "" {
+		linking.MaxSendQString = "16M"
+	}
+	maxSendQBytes, err := bytefmt.ToBytes(linking.MaxSendQString)
+	if err != nil {
+		return fmt.Errorf("Could not parse linking max-sendq: %s", err.Error())
+	}
+	linking.maxSendQBytes = int(maxSendQBytes)
+
+	if linking.Description == "" {
+		linking.Description = config.Network.Name
+	}
+
+	for name, link := range linking.Links {
+		if !utils.IsServerName(name) {
+			return fmt.Errorf("Linked server name is invalid: %s", name)
+		}
+		cfname := strings.ToLower(name)
+		if cfname == config.Server.nameCasefolded {
+			return fmt.Errorf("Cannot link to a server with our own name: %s", name)
+		}
+		if link.Password == "" {
+			return fmt.Errorf("Link to %s must have a password", name)
+		}
+		if link.Certfp != "" {
+			link.certfp, err = utils.NormalizeCertfp(link.Certfp)
+			if err != nil {
+				return fmt.Errorf("Invalid certfp for link to %s: %s", name, err.Error())
+			}
+		}
+		if link.Address == "" && link.Autoconnect {
+			return fmt.Errorf("Link to %s cannot autoconnect without an address", name)
+		}
+		linking.links[cfname] = link
+	}
+	return nil
+}
+
+// prepareAPI validates the HTTP API configuration and populates its
+// unexported fields
+func (config *Config) prepareAPI() (err error) {
+	api := &config.API
+	if !api.Enabled {
+		return nil
+	}
+
+	if api.Listener == "" {
+		return fmt.Errorf("The HTTP API requires a listener address")
+	}
+	if api.TLS.Cert != "" {
+		cert, err := loadCertWithLeaf(api.TLS.Cert, api.TLS.Key)
+		if err != nil {
+			return fmt.Errorf("Could not load API certificate: %s", err.Error())
+		}
+		api.tlsConfig = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		}
+	}
+
+	seen := make(utils.StringSet)
+	for name, token := range api.Tokens {
+		if len(token.Token) < apiMinTokenLength {
+			return fmt.Errorf("API token %s must be at least %d characters long", name, apiMinTokenLength)
+		}
+		if seen.Has(token.Token) {
+			return fmt.Errorf("API token %s duplicates another token", name)
+		}
+		seen.Add(token.Token)
+		token.capabilities = make(utils.StringSet)
+		for _, capab := range token.Capabilities {
+			if !apiCapabilities.Has(capab) {
+				return fmt.Errorf("API token %s has unknown capability %s", name, capab)
+			}
+			token.capabilities.Add(capab)
+		}
+		api.Tokens[name] = token
+	}
+	return nil
+}
+
 func (config *Config) isRelaymsgIdentifier(nick string) bool {
 	if !config.Server.Relaymsg.Enabled {
 		return false
@@ -1559,6 +1893,7 @@ func (config *Config) generateISupport() (err error) {
 	isupport.Initialize()
 	isupport.Add("AWAYLEN", strconv.Itoa(config.Limits.AwayLen))
 	isupport.Add("BOT", "B")
+	isupport.Add("CALLERID", "g")
 	isupport.Add("CASEMAPPING", "ascii")
 	isupport.Add("CHANLIMIT", fmt.Sprintf("%s:%d", chanTypes, config.Channels.MaxChannelsPerClient))
 	isupport.Add("CHANMODES", chanmodesToken)
@@ -1572,12 +1907,16 @@ func (config *Config) generateISupport() (err error) {
 	if config.Extjwt.Default.Enabled() || len(config.Extjwt.Services) != 0 {
 		isupport.Add("EXTJWT", "1")
 	}
-	isupport.Add("EXTBAN", ",m")
+	isupport.Add("EXTBAN", extbanPrefix+","+extbanTypes)
 	isupport.Add("FORWARD", "f")
 	isupport.Add("INVEX", "")
 	isupport.Add("KICKLEN", strconv.Itoa(config.Limits.KickLen))
+	isupport.Add("KNOCK", "")
 	isupport.Add("MAXLIST", fmt.Sprintf("beI:%s", strconv.Itoa(config.Limits.ChanListModes)))
 	isupport.Add("MAXTARGETS", maxTargetsString)
+	if config.Metadata.Enabled {
+		isupport.Add("METADATA", strconv.Itoa(config.Metadata.MaxKeys))
+	}
 	isupport.Add("MODES", "")
 	isupport.Add("MONITOR", strconv.Itoa(config.Limits.MonitorEntries))
 	isupport.Add("NETWORK", config.Network.Name)
@@ -1587,6 +1926,7 @@ func (config *Config) generateISupport() (err error) {
 		isupport.Add("RPCHAN", "E")
 		isupport.Add("RPUSER", "E")
 	}
+	isupport.Add("SILENCE", strconv.Itoa(config.Limits.SilenceEntries))
 	isupport.Add("STATUSMSG", "~&@%+")
 	isupport.Add("TARGMAX", fmt.Sprintf("NAMES:1,LIST:1,KICK:1,WHOIS:1,USERHOST:10,PRIVMSG:%s,TAGMSG:%s,NOTICE:%s,MONITOR:%d", maxTargetsString, maxTargetsString, maxTargetsString, config.Limits.MonitorEntries))
 	isupport.Add("TOPICLEN", strconv.Itoa(config.Limits.TopicLen))
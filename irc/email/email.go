@@ -4,6 +4,7 @@
 package email
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"net"
@@ -12,6 +13,7 @@ import (
 	"time"
 
 	"github.com/ergochat/ergo/irc/smtp"
+	"github.com/ergochat/ergo/irc/utils"
 )
 
 var (
@@ -37,11 +39,13 @@ type MailtoConfig struct {
 	HeloDomain           string `yaml:"helo-domain"`
 	RequireTLS           bool   `yaml:"require-tls"`
 	VerifyMessageSubject string `yaml:"verify-message-subject"`
-	DKIM                 DKIMConfig
-	MTAReal              MTAConfig `yaml:"mta"`
-	BlacklistRegexes     []string  `yaml:"blacklist-regexes"`
-	blacklistRegexes     []*regexp.Regexp
-	Timeout              time.Duration
+	// send a notification to the old address when an account's address is changed:
+	NotifyOldAddress bool `yaml:"notify-old-address"`
+	DKIM             DKIMConfig
+	MTAReal          MTAConfig `yaml:"mta"`
+	BlacklistRegexes []string  `yaml:"blacklist-regexes"`
+	blacklistRegexes []*regexp.Regexp
+	Timeout          time.Duration
 }
 
 func (config *MailtoConfig) Postprocess(heloDomain string) (err error) {
@@ -75,6 +79,20 @@ func (config *MailtoConfig) Postprocess(heloDomain string) (err error) {
 	return config.DKIM.Postprocess()
 }
 
+// ComposeMail starts a message to `recipient`, writing its headers;
+// the caller writes the body.
+func ComposeMail(config MailtoConfig, recipient, subject string) (message bytes.Buffer) {
+	fmt.Fprintf(&message, "From: %s\r\n", config.Sender)
+	fmt.Fprintf(&message, "To: %s\r\n", recipient)
+	if config.DKIM.Domain != "" {
+		fmt.Fprintf(&message, "Message-ID: <%s@%s>\r\n", utils.GenerateSecretKey(), config.DKIM.Domain)
+	}
+	fmt.Fprintf(&message, "Date: %s\r\n", time.Now().UTC().Format(time.RFC1123Z))
+	fmt.Fprintf(&message, "Subject: %s\r\n", subject)
+	message.WriteString("\r\n") // blank line: end headers, begin message body
+	return
+}
+
 // are we sending email directly, as opposed to deferring to an MTA?
 func (config *MailtoConfig) DirectSendingEnabled() bool {
 	return config.MTAReal.Server == ""
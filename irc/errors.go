@@ -42,6 +42,17 @@ var (
 	errChannelNameInUse               = errors.New(`Channel name in use`)
 	errInvalidChannelName             = errors.New(`Invalid channel name`)
 	errMonitorLimitExceeded           = errors.New("Monitor limit exceeded")
+	errAcceptExists                   = errors.New("Nickname is already on the accept list")
+	errAcceptListFull                 = errors.New("Accept list is full")
+	errAcceptNotFound                 = errors.New("Nickname is not on the accept list")
+	errSilenceListFull                = errors.New("Silence list is full")
+	errMetadataLimitReached           = errors.New("Metadata limit reached")
+	errMetadataTooManySubs            = errors.New("Too many metadata subscriptions")
+	errMessageTooOld                  = errors.New("Message is too old")
+	errNoScramCredentials             = errors.New("No SCRAM credentials are stored for this account; log in once with PLAIN to create them")
+	errTOTPRequired                   = errors.New("This account requires a two-factor authentication code; log in with PLAIN, using your password followed by a colon and the code")
+	errInvalidTOTPCode                = errors.New("Invalid or missing two-factor authentication code")
+	errInvalidPasswordResetCode       = errors.New("Invalid or expired password reset code")
 	errNickMissing                    = errors.New("nick missing")
 	errNicknameInvalid                = errors.New("invalid nickname")
 	errNicknameInUse                  = errors.New("nickname in use")
@@ -73,6 +84,9 @@ var (
 	errRegisteredOnly                 = errors.New("Cannot join registered-only channel without an account")
 	errValidEmailRequired             = errors.New("A valid email address is required for account registration")
 	errInvalidAccountRename           = errors.New("Account renames can only change the casefolding of the account name")
+	errLinkNotConfigured              = errors.New("No link is configured for that server")
+	errAlreadyLinked                  = errors.New("That server is already linked, or a connection to it is in progress")
+	errNotDirectlyLinked              = errors.New("That server is not directly linked to this one")
 )
 
 // String Errors
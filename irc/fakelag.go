@@ -57,8 +57,9 @@ func (fl *Fakelag) Unsuspend() {
 	}
 }
 
-// register a new command, sleep if necessary to delay it
-func (fl *Fakelag) Touch() {
+// register a new command, sleep if necessary to delay it;
+// returns whether the command was delayed
+func (fl *Fakelag) Touch() (throttled bool) {
 	if !fl.config.Enabled {
 		return
 	}
@@ -108,6 +109,8 @@ func (fl *Fakelag) Touch() {
 			fl.sleepFunc(sleepDuration)
 			// the touch time should take into account the time we slept
 			fl.lastTouch = fl.nowFunc()
+			throttled = true
 		}
 	}
+	return
 }
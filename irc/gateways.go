@@ -78,7 +78,8 @@ func (client *Client) ApplyProxiedIP(session *Session, proxiedIP net.IP, tls boo
 	}
 	proxiedIP = proxiedIP.To16()
 
-	isBanned, requireSASL, banMsg := client.server.checkBans(client.server.Config(), proxiedIP, true)
+	listener := session.socket.conn.UnderlyingConn().Config.Addr
+	isBanned, requireSASL, banMsg := client.server.checkBans(client.server.Config(), proxiedIP, true, listener)
 	if isBanned {
 		return errBanned, banMsg
 	}
@@ -10,6 +10,7 @@ import (
 	"unsafe"
 
 	"github.com/ergochat/ergo/irc/caps"
+	"github.com/ergochat/ergo/irc/history"
 	"github.com/ergochat/ergo/irc/languages"
 	"github.com/ergochat/ergo/irc/modes"
 	"github.com/ergochat/ergo/irc/utils"
@@ -23,6 +24,18 @@ func (server *Server) SetConfig(config *Config) {
 	atomic.StorePointer(&server.config, unsafe.Pointer(config))
 }
 
+// HistoryDB returns the persistent history database, or nil if there is none.
+func (server *Server) HistoryDB() (historyDB history.Database) {
+	if ptr := (*history.Database)(atomic.LoadPointer(&server.historyDB)); ptr != nil {
+		historyDB = *ptr
+	}
+	return
+}
+
+func (server *Server) setHistoryDB(historyDB history.Database) {
+	atomic.StorePointer(&server.historyDB, unsafe.Pointer(&historyDB))
+}
+
 func (server *Server) ChannelRegistrationEnabled() bool {
 	return server.Config().Channels.Registration.Enabled
 }
@@ -297,6 +310,9 @@ func (client *Client) Login(account ClientAccount) {
 	client.account = account.NameCasefolded
 	client.accountName = account.Name
 	client.accountSettings = account.Settings
+	client.silence = newSilenceList(account.Silence)
+	client.readMarkers = account.ReadMarkers
+	client.metadata = account.Metadata
 	// mark always-on here: it will not be respected until the client is registered
 	client.alwaysOn = alwaysOn
 	client.accountRegDate = account.RegisteredAt
@@ -341,6 +357,17 @@ func (client *Client) Logout() {
 	client.alwaysOn = false
 	client.accountRegDate = time.Time{}
 	client.accountSettings = AccountSettings{}
+	// loaded from the account on login:
+	client.silence = nil
+	client.readMarkers = nil
+	client.metadata = nil
+	client.pendingOper = nil
+	client.stateMutex.Unlock()
+}
+
+func (client *Client) setPendingOper(oper *Oper) {
+	client.stateMutex.Lock()
+	client.pendingOper = oper
 	client.stateMutex.Unlock()
 }
 
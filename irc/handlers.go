@@ -31,6 +31,7 @@ import (
 	"github.com/ergochat/ergo/irc/history"
 	"github.com/ergochat/ergo/irc/jwt"
 	"github.com/ergochat/ergo/irc/modes"
+	"github.com/ergochat/ergo/irc/scram"
 	"github.com/ergochat/ergo/irc/sno"
 	"github.com/ergochat/ergo/irc/utils"
 )
@@ -118,6 +119,7 @@ func sendSuccessfulAccountAuth(service *ircService, client *Client, rb *Response
 			rb.Add(nil, details.nickMask, "ACCOUNT", details.accountName)
 		}
 		client.server.sendLoginSnomask(details.nickMask, details.accountName)
+		client.applyPendingOper(rb)
 	}
 
 	// #1479: for Tor clients, replace the hostname with the always-on cloak here
@@ -141,6 +143,49 @@ func (server *Server) sendLoginSnomask(nickMask, accountName string) {
 	server.snomasks.Send(sno.LocalAccounts, fmt.Sprintf(ircfmt.Unescape("Client $c[grey][$r%s$c[grey]] logged into account $c[grey][$r%s$c[grey]]"), nickMask, accountName))
 }
 
+// ACCEPT <nick>|-<nick>|*{,<nick>|-<nick>|*}
+func acceptHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
+	nick := client.Nick()
+	limit := server.Config().Limits.AcceptEntries
+	changed := false
+	for _, entry := range strings.Split(msg.Params[0], ",") {
+		if entry == "*" {
+			for _, accepted := range client.AcceptList() {
+				rb.Add(nil, server.name, RPL_ACCEPTLIST, nick, accepted)
+			}
+			rb.Add(nil, server.name, RPL_ENDOFACCEPT, nick, client.t("End of /ACCEPT list"))
+			continue
+		}
+
+		remove := strings.HasPrefix(entry, "-")
+		target := strings.TrimPrefix(entry, "-")
+		cftarget, err := CasefoldName(target)
+		if err != nil {
+			rb.Add(nil, server.name, ERR_NOSUCHNICK, nick, utils.SafeErrorParam(target), client.t("No such nick"))
+			continue
+		}
+		if remove {
+			err = client.removeAccept(cftarget)
+		} else {
+			err = client.addAccept(target, cftarget, limit)
+		}
+		switch err {
+		case nil:
+			changed = true
+		case errAcceptExists:
+			rb.Add(nil, server.name, ERR_ACCEPTEXIST, nick, target, client.t("is already on your accept list"))
+		case errAcceptNotFound:
+			rb.Add(nil, server.name, ERR_ACCEPTNOT, nick, target, client.t("is not on your accept list"))
+		case errAcceptListFull:
+			rb.Add(nil, server.name, ERR_ACCEPTFULL, nick, client.t("Accept list is full"))
+		}
+	}
+	if changed {
+		client.markDirty(IncludeUserModes)
+	}
+	return false
+}
+
 // AUTHENTICATE [<mechanism>|<data>|*]
 func authenticateHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
 	session := rb.session
@@ -171,14 +216,7 @@ func authenticateHandler(server *Server, client *Client, msg ircmsg.Message, rb
 
 		if mechanismIsEnabled {
 			session.sasl.mechanism = mechanism
-			if !config.Server.Compatibility.SendUnprefixedSasl {
-				// normal behavior
-				rb.Add(nil, server.name, "AUTHENTICATE", "+")
-			} else {
-				// gross hack: send a raw message to ensure no tags or prefix
-				rb.Flush(true)
-				rb.session.SendRawMessage(ircmsg.MakeMessage(nil, "", "AUTHENTICATE", "+"), true)
-			}
+			sendAuthenticate(rb, config, "+")
 		} else {
 			rb.Add(nil, server.name, ERR_SASLFAIL, details.nick, client.t("SASL authentication failed"))
 		}
@@ -195,7 +233,12 @@ func authenticateHandler(server *Server, client *Client, msg ircmsg.Message, rb
 		return false
 	} else if len(rawData) == 400 {
 		// allow 4 'continuation' lines before rejecting for length
-		if len(session.sasl.value) >= 400*4 {
+		maxContinuations := 4
+		if session.sasl.mechanism == "OAUTHBEARER" {
+			// bearer tokens are much longer than passphrases
+			maxContinuations = 16
+		}
+		if len(session.sasl.value) >= 400*maxContinuations {
 			rb.Add(nil, server.name, ERR_SASLFAIL, details.nick, client.t("SASL authentication failed: Passphrase too long"))
 			session.sasl.Clear()
 			return false
@@ -230,11 +273,43 @@ func authenticateHandler(server *Server, client *Client, msg ircmsg.Message, rb
 
 	// let the SASL handler do its thing
 	exiting := handler(server, client, session.sasl.mechanism, data, rb)
+	if session.sasl.scramConv != nil {
+		// the exchange continues with the client's next response
+		session.sasl.value = ""
+		return exiting
+	}
+	server.metrics.saslAttempt(session.sasl.mechanism, client.Account() != "")
 	session.sasl.Clear()
 
 	return exiting
 }
 
+// sendAuthenticate sends an AUTHENTICATE line (a continuation or a challenge)
+func sendAuthenticate(rb *ResponseBuffer, config *Config, param string) {
+	if !config.Server.Compatibility.SendUnprefixedSasl {
+		// normal behavior
+		rb.Add(nil, rb.target.server.name, "AUTHENTICATE", param)
+	} else {
+		// gross hack: send a raw message to ensure no tags or prefix
+		rb.Flush(true)
+		rb.session.SendRawMessage(ircmsg.MakeMessage(nil, "", "AUTHENTICATE", param), true)
+	}
+}
+
+// sendSaslChallenge sends binary SASL data to the client, split into
+// 400-byte AUTHENTICATE lines
+func sendSaslChallenge(rb *ResponseBuffer, config *Config, data []byte) {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) >= 400 {
+		sendAuthenticate(rb, config, encoded[:400])
+		encoded = encoded[400:]
+	}
+	if encoded == "" {
+		encoded = "+"
+	}
+	sendAuthenticate(rb, config, encoded)
+}
+
 // AUTHENTICATE PLAIN
 func authPlainHandler(server *Server, client *Client, mechanism string, value []byte, rb *ResponseBuffer) bool {
 	splitValue := bytes.Split(value, []byte{'\000'})
@@ -285,13 +360,77 @@ func authPlainHandler(server *Server, client *Client, mechanism string, value []
 	return false
 }
 
+// AUTHENTICATE SCRAM-SHA-256, SCRAM-SHA-512
+func authScramHandler(server *Server, client *Client, mechanism string, value []byte, rb *ResponseBuffer) bool {
+	session := rb.session
+	sasl := &session.sasl
+	config := server.Config()
+
+	if sasl.scramConv == nil {
+		hashFunc := scram.SHA256
+		if mechanism == "SCRAM-SHA-512" {
+			hashFunc = scram.SHA512
+		}
+		sasl.scramConv = scram.NewServerConversation(hashFunc, func(username string) (creds scram.Credentials, err error) {
+			// see #843: strip the device ID, as for PLAIN
+			if strudelIndex := strings.IndexByte(username, '@'); strudelIndex != -1 {
+				var deviceID string
+				username, deviceID = username[:strudelIndex], username[strudelIndex+1:]
+				if !client.registered {
+					session.deviceID = deviceID
+				}
+			}
+			sasl.scramAccount, creds, err = server.accounts.LoadScramCredentials(client, username, mechanism)
+			return
+		})
+	}
+
+	conv := sasl.scramConv
+	if conv.Done() {
+		// this is the client's (empty) response to our server-final-message
+		sasl.scramConv = nil
+		if len(value) != 0 {
+			rb.Add(nil, server.name, ERR_SASLFAIL, client.Nick(), client.t("SASL authentication failed: Invalid auth blob"))
+			return false
+		}
+		server.accounts.Login(client, sasl.scramAccount)
+		if !fixupNickEqualsAccount(client, rb, config, "") {
+			return false
+		}
+		sendSuccessfulAccountAuth(nil, client, rb, true)
+		return false
+	}
+
+	output, err := conv.Step(value)
+	if err == nil && conv.Done() && server.accounts.totpRequired(&sasl.scramAccount.Credentials) {
+		// the proof was verified, so it's safe to reveal that a code is needed
+		err = errTOTPRequired
+	}
+	if err != nil {
+		sasl.scramConv = nil
+		var msg string
+		switch err {
+		case scram.ErrInvalidMessage, scram.ErrChannelBindingNotSupported:
+			msg = "Invalid auth blob"
+		case scram.ErrInvalidProof:
+			msg = authErrorToMessage(server, errAccountInvalidCredentials)
+		default:
+			msg = authErrorToMessage(server, err)
+		}
+		rb.Add(nil, server.name, ERR_SASLFAIL, client.Nick(), fmt.Sprintf("%s: %s", client.t("SASL authentication failed"), client.t(msg)))
+		return false
+	}
+	sendSaslChallenge(rb, config, output)
+	return false
+}
+
 func authErrorToMessage(server *Server, err error) (msg string) {
 	if throttled, ok := err.(*ThrottleError); ok {
 		return throttled.Error()
 	}
 
 	switch err {
-	case errAccountDoesNotExist, errAccountUnverified, errAccountInvalidCredentials, errAuthzidAuthcidMismatch, errNickAccountMismatch, errAccountSuspended:
+	case errAccountDoesNotExist, errAccountUnverified, errAccountInvalidCredentials, errAuthzidAuthcidMismatch, errNickAccountMismatch, errAccountSuspended, errNoScramCredentials, errTOTPRequired, errInvalidTOTPCode:
 		return err.Error()
 	default:
 		// don't expose arbitrary error messages to the user
@@ -343,6 +482,63 @@ func authExternalHandler(server *Server, client *Client, mechanism string, value
 	return false
 }
 
+// AUTHENTICATE OAUTHBEARER
+func authOauthbearerHandler(server *Server, client *Client, mechanism string, value []byte, rb *ResponseBuffer) bool {
+	if !server.Config().Accounts.JWTAuth.Enabled {
+		rb.Add(nil, server.name, ERR_SASLFAIL, client.nick, client.t("SASL authentication failed"))
+		return false
+	}
+
+	// RFC 7628: gs2-header, then kvpairs separated by \x01:
+	// n,a=authzid,\x01auth=Bearer <token>\x01\x01
+	var authzid, token string
+	fields := strings.SplitN(string(value), ",", 3)
+	valid := len(fields) == 3 && (fields[0] == "n" || fields[0] == "y")
+	if valid && fields[1] != "" {
+		if strings.HasPrefix(fields[1], "a=") {
+			authzid = fields[1][2:]
+		} else {
+			valid = false
+		}
+	}
+	if valid {
+		for _, kvpair := range strings.Split(fields[2], "\x01") {
+			if strings.HasPrefix(kvpair, "auth=") {
+				authScheme := strings.SplitN(kvpair[5:], " ", 2)
+				if len(authScheme) == 2 && strings.ToLower(authScheme[0]) == "bearer" {
+					token = strings.TrimSpace(authScheme[1])
+				}
+				break
+			}
+		}
+	}
+	if token == "" {
+		rb.Add(nil, server.name, ERR_SASLFAIL, client.nick, client.t("SASL authentication failed: Invalid auth blob"))
+		return false
+	}
+
+	// see authExternalHandler
+	if strudelIndex := strings.IndexByte(authzid, '@'); strudelIndex != -1 {
+		var deviceID string
+		authzid, deviceID = authzid[:strudelIndex], authzid[strudelIndex+1:]
+		if !client.registered {
+			rb.session.deviceID = deviceID
+		}
+	}
+
+	err := server.accounts.AuthenticateByOAuthBearer(client, token, authzid)
+	if err != nil {
+		msg := authErrorToMessage(server, err)
+		rb.Add(nil, server.name, ERR_SASLFAIL, client.nick, fmt.Sprintf("%s: %s", client.t("SASL authentication failed"), client.t(msg)))
+		return false
+	} else if !fixupNickEqualsAccount(client, rb, server.Config(), "") {
+		return false
+	}
+
+	sendSuccessfulAccountAuth(nil, client, rb, true)
+	return false
+}
+
 // AWAY [<message>]
 func awayHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
 	var isAway bool
@@ -686,6 +882,21 @@ func chathistoryHandler(server *Server, client *Client, msg ircmsg.Message, rb *
 	return
 }
 
+// CONNECT <server>
+func connectHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
+	name := msg.Params[0]
+	switch err := server.links.Connect(name); err {
+	case nil:
+		rb.Notice(fmt.Sprintf(client.t("Connecting to server %s"), name))
+		server.snomasks.Send(sno.Links, fmt.Sprintf(ircfmt.Unescape("Operator $c[grey][$r%s$c[grey]]$r requested a link to %s"), client.Nick(), name))
+	case errLinkNotConfigured:
+		rb.Add(nil, server.name, ERR_NOSUCHSERVER, client.Nick(), utils.SafeErrorParam(name), client.t("No link is configured for that server"))
+	default:
+		rb.Add(nil, server.name, ERR_UNKNOWNERROR, client.Nick(), "CONNECT", client.t(err.Error()))
+	}
+	return false
+}
+
 // DEBUG <subcmd>
 func debugHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
 	param := strings.ToUpper(msg.Params[0])
@@ -1509,6 +1720,24 @@ func klineHandler(server *Server, client *Client, msg ircmsg.Message, rb *Respon
 	return killClient
 }
 
+// KNOCK <channel> [<reason>]
+func knockHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
+	channelName := msg.Params[0]
+	var reason string
+	if len(msg.Params) > 1 {
+		reason = ircutils.TruncateUTF8Safe(msg.Params[1], server.Config().Limits.KickLen)
+	}
+
+	channel := server.channels.Get(channelName)
+	if channel == nil {
+		rb.Add(nil, server.name, ERR_NOSUCHCHANNEL, client.Nick(), utils.SafeErrorParam(channelName), client.t("No such channel"))
+		return false
+	}
+
+	channel.Knock(client, reason, rb)
+	return false
+}
+
 // LANGUAGE <code>{ <code>}
 func languageHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
 	nick := client.Nick()
@@ -1563,6 +1792,16 @@ func languageHandler(server *Server, client *Client, msg ircmsg.Message, rb *Res
 	return false
 }
 
+// LINKS
+func linksHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
+	nick := client.Nick()
+	for _, linked := range server.links.Servers() {
+		rb.Add(nil, server.name, RPL_LINKS, nick, linked.name, linked.uplink, fmt.Sprintf("%d %s", linked.hops, linked.description))
+	}
+	rb.Add(nil, server.name, RPL_ENDOFLINKS, nick, "*", client.t("End of LINKS list"))
+	return false
+}
+
 // LIST [<channel>{,<channel>}] [<elistcond>{,<elistcond>}]
 func listHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
 	config := server.Config()
@@ -1657,6 +1896,227 @@ func lusersHandler(server *Server, client *Client, msg ircmsg.Message, rb *Respo
 	return false
 }
 
+// MAP
+func mapHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
+	nick := client.Nick()
+	for _, line := range mapLines(server.links.Servers()) {
+		rb.Add(nil, server.name, RPL_MAP, nick, line)
+	}
+	rb.Add(nil, server.name, RPL_MAPEND, nick, client.t("End of MAP"))
+	return false
+}
+
+// MARKREAD <target> [timestamp=YYYY-MM-DDThh:mm:ss.sssZ]
+func markReadHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
+	if len(msg.Params) == 0 {
+		rb.Add(nil, server.name, "FAIL", "MARKREAD", "NEED_MORE_PARAMS", client.t("Missing parameters"))
+		return false
+	}
+	target := msg.Params[0]
+	cftarget, err := casefoldTarget(target)
+	if err != nil {
+		rb.Add(nil, server.name, "FAIL", "MARKREAD", "INVALID_PARAMS", utils.SafeErrorParam(target), client.t("Invalid target"))
+		return false
+	}
+
+	if len(msg.Params) == 1 {
+		rb.Add(nil, server.name, "MARKREAD", target, formatReadMarker(client.ReadMarker(cftarget)))
+		return false
+	}
+
+	timestampStr := strings.TrimPrefix(msg.Params[1], "timestamp=")
+	timestamp, err := time.Parse(IRCv3TimestampFormat, timestampStr)
+	if timestampStr == msg.Params[1] || err != nil {
+		rb.Add(nil, server.name, "FAIL", "MARKREAD", "INVALID_PARAMS", utils.SafeErrorParam(msg.Params[1]), client.t("Invalid timestamp"))
+		return false
+	}
+	// if the marker was already later than the timestamp, this replies with its current value
+	marker, err := client.updateReadMarker(target, cftarget, timestamp.UTC(), rb.session)
+	if err != nil {
+		rb.Add(nil, server.name, "FAIL", "MARKREAD", "INTERNAL_ERROR", utils.SafeErrorParam(target), client.t("Could not update your read marker"))
+	} else {
+		rb.Add(nil, server.name, "MARKREAD", target, formatReadMarker(marker))
+	}
+	return false
+}
+
+// METADATA <target> GET <key> [<key>...]
+// METADATA <target> LIST
+// METADATA <target> SET <key> [:<value>]
+// METADATA <target> SYNC
+// METADATA * SUB|UNSUB <key> [<key>...]
+// METADATA * SUBS
+func metadataHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
+	config := server.Config()
+	if !config.Metadata.Enabled {
+		rb.Add(nil, server.name, "FAIL", "METADATA", "NOT_ENABLED", client.t("Metadata has been disabled"))
+		return false
+	}
+
+	nick := client.Nick()
+	target := msg.Params[0]
+	subcommand := strings.ToLower(msg.Params[1])
+	params := msg.Params[2:]
+
+	switch subcommand {
+	case "sub", "unsub":
+		if len(params) == 0 {
+			rb.Add(nil, server.name, "FAIL", "METADATA", "NEED_MORE_PARAMS", client.t("Missing parameters"))
+			return false
+		}
+		var changed []string
+		for _, key := range params {
+			if !metadataKeyIsValid(key) {
+				rb.Add(nil, server.name, "FAIL", "METADATA", "KEY_INVALID", utils.SafeErrorParam(key), client.t("Invalid key name"))
+				continue
+			}
+			if subcommand == "unsub" {
+				rb.session.unsubscribeMetadata(key)
+			} else if err := rb.session.subscribeMetadata(key, config.Metadata.MaxSubs); err != nil {
+				rb.Add(nil, server.name, "FAIL", "METADATA", "TOO_MANY_SUBS", key, client.t("Too many subscriptions"))
+				continue
+			}
+			changed = append(changed, key)
+		}
+		if len(changed) != 0 {
+			numeric := RPL_METADATASUBOK
+			if subcommand == "unsub" {
+				numeric = RPL_METADATAUNSUBOK
+			}
+			rb.Add(nil, server.name, numeric, append([]string{nick}, changed...)...)
+		}
+		return false
+	case "subs":
+		subs := rb.session.metadataSubscriptionList()
+		batchID := startMetadataBatch(rb)
+		defer rb.EndNestedBatch(batchID)
+		for i := 0; i < len(subs); i += maxMetadataSubsPerLine {
+			end := i + maxMetadataSubsPerLine
+			if end > len(subs) {
+				end = len(subs)
+			}
+			rb.Add(nil, server.name, RPL_METADATASUBS, append([]string{nick}, subs[i:end]...)...)
+		}
+		return false
+	case "get", "list", "set", "sync":
+		// handled below
+	default:
+		rb.Add(nil, server.name, "FAIL", "METADATA", "SUBCOMMAND_INVALID", utils.SafeErrorParam(msg.Params[1]), client.t("Invalid subcommand"))
+		return false
+	}
+
+	// resolve the target: `*` is the client itself
+	var targetClient *Client
+	var targetChannel *Channel
+	if target == "*" {
+		targetClient = client
+		target = nick
+	} else if strings.HasPrefix(target, "#") {
+		targetChannel = server.channels.Get(target)
+		if targetChannel == nil || !targetChannel.canSeeMetadata(client) {
+			targetChannel = nil
+		} else {
+			target = targetChannel.Name()
+		}
+	} else if targetClient = server.clients.Get(target); targetClient != nil {
+		target = targetClient.Nick()
+	}
+	if targetClient == nil && targetChannel == nil {
+		rb.Add(nil, server.name, "FAIL", "METADATA", "INVALID_TARGET", utils.SafeErrorParam(target), client.t("Invalid target"))
+		return false
+	}
+	var metadata map[string]string
+	if targetChannel != nil {
+		metadata = targetChannel.Metadata()
+	} else {
+		metadata = targetClient.Metadata()
+	}
+
+	switch subcommand {
+	case "get":
+		if len(params) == 0 {
+			rb.Add(nil, server.name, "FAIL", "METADATA", "NEED_MORE_PARAMS", client.t("Missing parameters"))
+			return false
+		}
+		batchID := startMetadataBatch(rb)
+		defer rb.EndNestedBatch(batchID)
+		for _, key := range params {
+			if !metadataKeyIsValid(key) {
+				rb.Add(nil, server.name, "FAIL", "METADATA", "KEY_INVALID", utils.SafeErrorParam(key), client.t("Invalid key name"))
+			} else if value, ok := metadata[key]; ok {
+				rb.Add(nil, server.name, RPL_KEYVALUE, nick, target, key, metadataVisibility, value)
+			} else {
+				rb.Add(nil, server.name, RPL_KEYNOTSET, nick, target, key, client.t("Key is not set"))
+			}
+		}
+	case "list":
+		batchID := startMetadataBatch(rb)
+		defer rb.EndNestedBatch(batchID)
+		for _, key := range sortedMetadataKeys(metadata) {
+			rb.Add(nil, server.name, RPL_KEYVALUE, nick, target, key, metadataVisibility, metadata[key])
+		}
+	case "sync":
+		if targetChannel != nil {
+			targetChannel.syncChannelMetadata(rb)
+		} else {
+			targetClient.syncClientMetadata(rb)
+		}
+	case "set":
+		if len(params) == 0 {
+			rb.Add(nil, server.name, "FAIL", "METADATA", "NEED_MORE_PARAMS", client.t("Missing parameters"))
+			return false
+		}
+		key := params[0]
+		if !metadataKeyIsValid(key) {
+			rb.Add(nil, server.name, "FAIL", "METADATA", "KEY_INVALID", utils.SafeErrorParam(key), client.t("Invalid key name"))
+			return false
+		}
+		if targetChannel != nil && !targetChannel.ClientIsAtLeast(client, modes.ChannelOperator) ||
+			targetClient != nil && targetClient != client {
+			rb.Add(nil, server.name, "FAIL", "METADATA", "KEY_NO_PERMISSION", target, key, client.t("You do not have permission to set this key"))
+			return false
+		}
+		set := len(params) > 1
+		var value string
+		if set {
+			value = params[1]
+			if !metadataValueIsValid(value, config.Metadata.MaxValueBytes) {
+				rb.Add(nil, server.name, "FAIL", "METADATA", "VALUE_INVALID", client.t("Invalid value"))
+				return false
+			}
+		}
+
+		source := client.NickMaskString()
+		var err error
+		if targetChannel != nil {
+			var changed bool
+			changed, err = targetChannel.modifyMetadata(key, value, set, config.Metadata.MaxKeys)
+			if changed {
+				targetChannel.notifyMetadataChanged(source, key, value, set, rb.session)
+			}
+		} else {
+			var changedClients []*Client
+			changedClients, err = client.modifyMetadata(key, value, set, config.Metadata.MaxKeys)
+			for _, changedClient := range changedClients {
+				changedClient.notifyMetadataChanged(source, key, value, set, rb.session)
+			}
+		}
+		switch err {
+		case nil:
+			if set {
+				rb.Add(nil, server.name, RPL_KEYVALUE, nick, target, key, metadataVisibility, value)
+			} else {
+				rb.Add(nil, server.name, RPL_KEYNOTSET, nick, target, key, client.t("Key deleted"))
+			}
+		case errMetadataLimitReached:
+			rb.Add(nil, server.name, "FAIL", "METADATA", "LIMIT_REACHED", target, client.t("Metadata limit reached"))
+		default:
+			rb.Add(nil, server.name, "FAIL", "METADATA", "INTERNAL_ERROR", target, client.t("Could not update metadata"))
+		}
+	}
+	return false
+}
+
 // MODE <target> [<modestring> [<mode arguments>...]]
 func modeHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
 	if 0 < len(msg.Params[0]) && msg.Params[0][0] == '#' {
@@ -1689,6 +2149,12 @@ func cmodeHandler(server *Server, client *Client, msg ircmsg.Message, rb *Respon
 			return false
 		}
 	}
+	if msg.Command != "SAMODE" && len(changes) != 0 {
+		changes = channel.filterMLockedChanges(client, changes, rb)
+		if len(changes) == 0 {
+			return false
+		}
+	}
 	// process mode changes, include list operations (an empty set of changes does a list)
 	applied := channel.ApplyChannelModeChanges(client, msg.Command == "SAMODE", changes, rb)
 	details := client.Details()
@@ -1707,10 +2173,16 @@ func announceCmodeChanges(channel *Channel, applied modes.ModeChanges, source, a
 			message.Split = append(message.Split, utils.MessagePair{Message: changeString})
 		}
 		args := append([]string{channel.name}, changeStrings...)
-		rb.AddFromClient(message.Time, message.Msgid, source, accountName, isBot, nil, "MODE", args...)
+		channel.server.links.ChannelModesChanged(channel, applied, source, message)
+		var rbSession *Session
+		if rb != nil {
+			// rb may be nil for changes made by the server itself, e.g. expiring bans
+			rb.AddFromClient(message.Time, message.Msgid, source, accountName, isBot, nil, "MODE", args...)
+			rbSession = rb.session
+		}
 		for _, member := range channel.Members() {
 			for _, session := range member.Sessions() {
-				if session != rb.session {
+				if session != rbSession {
 					session.sendFromClientInternal(false, message.Time, message.Msgid, source, accountName, isBot, nil, "MODE", args...)
 				}
 			}
@@ -2134,7 +2606,13 @@ func dispatchMessageToTarget(client *Client, tags map[string]string, histType hi
 
 	if len(target) == 0 {
 		return
-	} else if target[0] == '#' {
+	}
+	tags, ok := client.validateMessageEdit(histType, command, target, tags, rb)
+	if !ok {
+		return
+	}
+
+	if target[0] == '#' {
 		channel := server.channels.Get(target)
 		if channel == nil {
 			if histType != history.Notice {
@@ -2209,6 +2687,16 @@ func dispatchMessageToTarget(client *Client, tags map[string]string, histType hi
 			rb.Add(nil, server.name, ERR_NEEDREGGEDNICK, client.Nick(), tnick, client.t("You must be registered to send a direct message to this user"))
 			return
 		}
+		// and when +g is set
+		if !user.acceptsMessagesFrom(client) {
+			switch histType {
+			case history.Privmsg:
+				user.rejectCallerID(client, rb)
+			case history.Notice:
+				user.rejectCallerID(client, nil)
+			}
+			return
+		}
 		if !client.server.Config().Server.Compatibility.allowTruncation {
 			if !validateSplitMessageLen(histType, client.NickMaskString(), tnick, message) {
 				rb.Add(nil, server.name, ERR_INPUTTOOLONG, client.Nick(), client.t("Line too long to be relayed without truncation"))
@@ -2218,7 +2706,10 @@ func dispatchMessageToTarget(client *Client, tags map[string]string, histType hi
 		nickMaskString := details.nickMask
 		accountName := details.accountName
 		var deliverySessions []*Session
-		deliverySessions = append(deliverySessions, user.Sessions()...)
+		// messages from a silenced sender are dropped without telling them:
+		if !user.isSilenced(client) {
+			deliverySessions = append(deliverySessions, user.Sessions()...)
+		}
 		// all sessions of the sender, except the originating session, get a copy as well:
 		if client != user {
 			for _, session := range client.Sessions() {
@@ -2242,6 +2733,9 @@ func dispatchMessageToTarget(client *Client, tags map[string]string, histType hi
 				session.sendSplitMsgFromClientInternal(false, nickMaskString, accountName, isBot, tagsToSend, command, tnick, message)
 			}
 		}
+		if user.remote != nil {
+			server.links.DirectMessage(client, user, tags, command, message)
+		}
 
 		// the originating session may get an echo message:
 		rb.addEchoMessage(tags, nickMaskString, accountName, command, tnick, message)
@@ -2506,6 +3000,114 @@ func quitHandler(server *Server, client *Client, msg ircmsg.Message, rb *Respons
 	return true
 }
 
+// REDACT <target> <msgid> [<reason>]
+func redactHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
+	target, msgid := msg.Params[0], msg.Params[1]
+	reason := ""
+	if len(msg.Params) > 2 {
+		reason = msg.Params[2]
+	}
+
+	var channel *Channel
+	var user *Client
+	if strings.HasPrefix(target, "#") {
+		channel = server.channels.Get(target)
+		if channel == nil || !channel.hasClient(client) {
+			rb.Add(nil, server.name, "FAIL", "REDACT", "INVALID_TARGET", utils.SafeErrorParam(target), client.t("You cannot delete messages from that target"))
+			return false
+		}
+		target = channel.Name()
+	} else {
+		user = server.clients.Get(target)
+		if user == nil {
+			rb.Add(nil, server.name, "FAIL", "REDACT", "INVALID_TARGET", utils.SafeErrorParam(target), client.t("You cannot delete messages from that target"))
+			return false
+		}
+		target = user.Nick()
+	}
+
+	// operators, and channel operators for channel messages, can redact any message;
+	// other clients can redact their own messages, within the redact window
+	window := time.Duration(server.Config().History.Retention.RedactWindow)
+	accountName := "*"
+	var notBefore time.Time
+	if !(client.HasRoleCapabs("history") || (channel != nil && channel.ClientIsAtLeast(client, modes.ChannelOperator))) {
+		accountName = client.AccountName()
+		if accountName == "*" || window == 0 {
+			rb.Add(nil, server.name, "FAIL", "REDACT", "REDACT_FORBIDDEN", target, utils.SafeErrorParam(msgid), client.t("You are not authorised to delete this message"))
+			return false
+		}
+		notBefore = time.Now().UTC().Add(-window)
+	}
+
+	var err error
+	if channel != nil {
+		err = server.DeleteMessage(target, msgid, accountName, notBefore)
+	} else {
+		// a direct message is stored separately for the sender and the recipient;
+		// a remote recipient's server deletes its own copy
+		err = server.DeleteMessage(client.Nick(), msgid, accountName, notBefore)
+		if user != client && user.remote == nil {
+			if rErr := server.DeleteMessage(target, msgid, accountName, notBefore); rErr == nil || err == errNoop {
+				err = rErr
+			}
+		}
+	}
+	switch err {
+	case nil:
+	case errMessageTooOld:
+		rb.Add(nil, server.name, "FAIL", "REDACT", "REDACT_WINDOW_EXPIRED", target, utils.SafeErrorParam(msgid), strconv.Itoa(int(window.Seconds())), client.t("You can no longer delete this message"))
+		return false
+	case history.ErrDisallowed:
+		rb.Add(nil, server.name, "FAIL", "REDACT", "REDACT_FORBIDDEN", target, utils.SafeErrorParam(msgid), client.t("You are not authorised to delete this message"))
+		return false
+	default:
+		rb.Add(nil, server.name, "FAIL", "REDACT", "UNKNOWN_MSGID", target, utils.SafeErrorParam(msgid), client.t("This message does not exist or is too old"))
+		return false
+	}
+
+	message := utils.MakeMessage("")
+	recipients := []*Client{client}
+	if channel != nil {
+		recipients = channel.Members()
+	} else if user != client {
+		recipients = append(recipients, user)
+	}
+	server.deliverRedact(client, recipients, target, msgid, reason, message, rb)
+	server.links.Redact(client, channel, user, msgid, reason, message)
+	server.logger.Info("history", client.Nick(), "redacted message", msgid, "from", target)
+	return false
+}
+
+// deliverRedact sends a REDACT from `sender` (which may be a remote client) to
+// the local sessions of `recipients` that negotiated message redaction. If the
+// sender is local, its own session receives its copy via `rb`, if it has echo-message.
+func (server *Server) deliverRedact(sender *Client, recipients []*Client, target, msgid, reason string, message utils.SplitMessage, rb *ResponseBuffer) {
+	details := sender.Details()
+	isBot := sender.HasMode(modes.Bot)
+	params := []string{target, msgid}
+	if reason != "" {
+		params = append(params, reason)
+	}
+	for _, recipient := range recipients {
+		if recipient.remote != nil {
+			continue
+		}
+		for _, session := range recipient.Sessions() {
+			if !session.capabilities.Has(caps.MessageRedaction) {
+				continue
+			}
+			if rb != nil && session == rb.session {
+				if session.capabilities.Has(caps.EchoMessage) {
+					rb.AddFromClient(message.Time, message.Msgid, details.nickMask, details.accountName, isBot, nil, "REDACT", params...)
+				}
+			} else {
+				session.sendFromClientInternal(false, message.Time, message.Msgid, details.nickMask, details.accountName, isBot, nil, "REDACT", params...)
+			}
+		}
+	}
+}
+
 // REGISTER < account | * > < email | * > <password>
 func registerHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) (exiting bool) {
 	accountName := client.Nick()
@@ -2704,10 +3306,24 @@ func relaymsgHandler(server *Server, client *Client, msg ircmsg.Message, rb *Res
 	}
 	nuh := fmt.Sprintf("%s!%s@%s", nick, ident, hostname)
 
+	clientOnlyTags := msg.ClientOnlyTags()
+	if editMsgid, isEdit := clientOnlyTags[caps.MessageEditTagName]; isEdit {
+		// relayed users have no accounts; the relayed NUH identifies the author
+		chname := channel.Name()
+		err := server.checkMessageEdit(chname, chname, editMsgid, func(item *history.Item) bool {
+			return item.Nick == nuh
+		})
+		if err != nil {
+			sendMessageEditError(client, "RELAYMSG", chname, editMsgid, err, rb)
+			return false
+		}
+	}
+
 	channel.AddHistoryItem(history.Item{
 		Type:    history.Privmsg,
 		Message: message,
 		Nick:    nuh,
+		Tags:    clientOnlyTags,
 	}, "")
 
 	// 3 possibilities for tags:
@@ -2715,7 +3331,6 @@ func relaymsgHandler(server *Server, client *Client, msg ircmsg.Message, rb *Res
 	relayTag := map[string]string{
 		caps.RelaymsgTagName: details.nick,
 	}
-	clientOnlyTags := msg.ClientOnlyTags()
 	var fullTags map[string]string
 	if len(clientOnlyTags) == 0 {
 		fullTags = relayTag
@@ -2885,6 +3500,89 @@ func setnameHandler(server *Server, client *Client, msg ircmsg.Message, rb *Resp
 	return false
 }
 
+// SILENCE [{+|-}<mask>{,{+|-}<mask>}]
+func silenceHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
+	nick := client.Nick()
+	if len(msg.Params) == 0 || msg.Params[0] == "" {
+		for _, mask := range client.Silence() {
+			rb.Add(nil, server.name, RPL_SILELIST, nick, mask)
+		}
+		rb.Add(nil, server.name, RPL_ENDOFSILELIST, nick, client.t("End of Silence List"))
+		return false
+	}
+
+	limit := server.Config().Limits.SilenceEntries
+	for _, entry := range strings.Split(msg.Params[0], ",") {
+		add := !strings.HasPrefix(entry, "-")
+		entry = strings.TrimLeft(entry, "+-")
+		if entry == "" {
+			continue
+		}
+		mask, err := canonicalizeSilenceMask(entry)
+		if err != nil {
+			rb.Add(nil, server.name, "FAIL", "SILENCE", "INVALID_MASK", utils.SafeErrorParam(entry), client.t("Invalid silence mask"))
+			continue
+		}
+		changed, err := client.modifySilence(mask, add, limit)
+		switch err {
+		case nil:
+			if changed {
+				change := "+" + mask
+				if !add {
+					change = "-" + mask
+				}
+				rb.Add(nil, client.NickMaskString(), "SILENCE", change)
+			}
+		case errSilenceListFull:
+			rb.Add(nil, server.name, ERR_SILELISTFULL, nick, mask, client.t("Your silence list is full"))
+		default:
+			rb.Add(nil, server.name, "FAIL", "SILENCE", "UNKNOWN_ERROR", client.t("Could not update your silence list"))
+		}
+	}
+	return false
+}
+
+// SQUIT <server> [reason]
+func squitHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
+	name := msg.Params[0]
+	reason := fmt.Sprintf("Disconnected by %s", client.Nick())
+	if len(msg.Params) > 1 && msg.Params[1] != "" {
+		reason = msg.Params[1]
+	}
+
+	if server.links.getServer(name) == nil {
+		rb.Add(nil, server.name, ERR_NOSUCHSERVER, client.Nick(), utils.SafeErrorParam(name), client.t("No such server"))
+		return false
+	}
+	if err := server.links.Squit(name, reason); err != nil {
+		rb.Add(nil, server.name, ERR_UNKNOWNERROR, client.Nick(), "SQUIT", client.t("You can only SQUIT servers that are directly linked to this one"))
+		return false
+	}
+	rb.Notice(fmt.Sprintf(client.t("Closing link to %s"), name))
+	server.snomasks.Send(sno.Links, fmt.Sprintf(ircfmt.Unescape("Operator $c[grey][$r%s$c[grey]]$r closed the link to %s: %s"), client.Nick(), name, reason))
+	return false
+}
+
+// STATS <letter>
+func statsHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
+	letter := msg.Params[0]
+	details := client.Details()
+	server.snomasks.Send(sno.Stats, fmt.Sprintf(ircfmt.Unescape("Client $c[grey][$r%s$c[grey]] requested STATS %s"), details.nickMask, letter))
+
+	if len(letter) == 1 {
+		if report, ok := statsLetters[letter[0]]; ok {
+			oper := client.Oper()
+			if report.public || (oper != nil && (report.capab == "" || oper.HasRoleCapab(report.capab))) {
+				report.handler(server, client, rb)
+			} else {
+				rb.Add(nil, server.name, ERR_NOPRIVILEGES, details.nick, client.t("Permission Denied"))
+			}
+		}
+	}
+	rb.Add(nil, server.name, RPL_ENDOFSTATS, details.nick, letter, client.t("End of STATS report"))
+	return false
+}
+
 // SUMMON [parameters]
 func summonHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
 	rb.Add(nil, server.name, ERR_SUMMONDISABLED, client.Nick(), client.t("SUMMON has been disabled"))
@@ -3092,6 +3790,45 @@ func versionHandler(server *Server, client *Client, msg ircmsg.Message, rb *Resp
 	return false
 }
 
+// WALLOPS <text>
+func wallopsHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
+	text := msg.Params[0]
+	if text == "" {
+		rb.Add(nil, server.name, ERR_NEEDMOREPARAMS, client.Nick(), msg.Command, client.t("Not enough parameters"))
+		return false
+	}
+
+	message := utils.MakeMessage(text)
+	details := client.Details()
+	server.deliverWallops(client, message, rb)
+	server.links.Wallops(client, message)
+	server.snomasks.Send(sno.LocalWallops, fmt.Sprintf(ircfmt.Unescape("%s$r sent WALLOPS: %s"), details.nickMask, text))
+	server.logger.Info("opers", details.nick, "sent WALLOPS", text)
+	return false
+}
+
+// deliverWallops sends a WALLOPS from `sender` (which may be a remote client)
+// to all local sessions of clients with +w. If the sender is local, its own
+// session receives its copy via `rb`.
+func (server *Server) deliverWallops(sender *Client, message utils.SplitMessage, rb *ResponseBuffer) {
+	details := sender.Details()
+	isBot := sender.HasMode(modes.Bot)
+	for _, target := range server.clients.AllClients() {
+		if target.remote != nil || !target.HasMode(modes.WallOps) {
+			continue
+		}
+		for _, session := range target.Sessions() {
+			if rb != nil && session == rb.session {
+				rb.AddFromClient(message.Time, message.Msgid, details.nickMask, details.accountName, isBot, nil, "WALLOPS", message.Message)
+			} else {
+				trb := NewResponseBuffer(session)
+				trb.AddFromClient(message.Time, message.Msgid, details.nickMask, details.accountName, isBot, nil, "WALLOPS", message.Message)
+				trb.Send(true)
+			}
+		}
+	}
+}
+
 // WEBIRC <password> <gateway> <hostname> <ip> [:flag1 flag2=x flag3]
 func webircHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
 	// only allow unregistered clients to use this command
@@ -3202,7 +3939,7 @@ func (client *Client) rplWhoReply(channel *Channel, target *Client, rb *Response
 		params = append(params, details.hostname)
 	}
 	if fields.Has('s') {
-		params = append(params, target.server.name)
+		params = append(params, target.ServerName())
 	}
 	if fields.Has('n') {
 		params = append(params, details.nick)
@@ -3325,7 +4062,7 @@ func whoHandler(server *Server, client *Client, msg ircmsg.Message, rb *Response
 		channel := server.channels.Get(mask)
 		if channel != nil {
 			isJoined := channel.hasClient(client)
-			if !channel.flags.HasMode(modes.Secret) || isJoined || hasPrivs {
+			if isJoined || hasPrivs || channel.visibleToOutsider(client) {
 				var members []*Client
 				if hasPrivs {
 					members = channel.Members()
@@ -42,6 +42,10 @@ Ergo supports the following channel modes:
   +b  |  Client masks that are banned from the channel (e.g. *!*@127.0.0.1)
   +e  |  Client masks that are exempted from bans.
   +I  |  Client masks that are exempted from the invite-only flag.
+      |  Instead of a mask, +b/+e/+I accept the extbans $a (any logged-in user),
+      |  $a:account, $r:realname, $c:#channel (members of another channel),
+      |  $o (opers), and $z (TLS users). Prefix a mask or extban with m: to
+      |  mute matching users instead of banning them.
   +i  |  Invite-only mode, only invited clients can join the channel.
   +k  |  Key required when joining the channel.
   +l  |  Client join limit for the channel.
@@ -60,6 +64,7 @@ Ergo supports the following channel modes:
          from unvoiced clients.
   +U  |  Op-moderated mode: messages from unprivileged clients are sent
          only to channel operators.
+  +K  |  Clients cannot use /KNOCK to request an invite to the channel.
 
 = Prefixes =
 
@@ -80,7 +85,10 @@ Ergo supports the following user modes:
   +Z  |  User is connected via TLS.
   +B  |  User is a bot.
   +E  |  User can receive roleplaying commands.
-  +T  |  CTCP messages to the user are blocked.`
+  +T  |  CTCP messages to the user are blocked.
+  +w  |  User receives WALLOPS messages.
+  +g  |  Caller-ID: user only receives direct messages from users on their
+         accept list (see /HELP ACCEPT).`
 	snomaskHelpText = `== Server Notice Masks ==
 
 Ergo supports the following server notice masks for operators:
@@ -90,6 +98,7 @@ Ergo supports the following server notice masks for operators:
   d  |  Local client disconnects.
   j  |  Local channel actions.
   k  |  Local kills.
+  l  |  Server links and netsplits.
   n  |  Local nick changes.
   o  |  Local oper actions.
   q  |  Local quits.
@@ -97,6 +106,7 @@ Ergo supports the following server notice masks for operators:
   u  |  Local client account actions.
   x  |  Local X-lines (DLINE/KLINE/etc).
   v  |  Local vhost changes.
+  w  |  Local WALLOPS usage.
 
 To set a snomask, do this with your nickname:
 
@@ -110,6 +120,14 @@ For instance, this would set the kill, oper, account and xline snomasks on dan:
 // Help contains the help strings distributed with the IRCd.
 var Help = map[string]HelpEntry{
 	// Commands
+	"accept": {
+		text: `ACCEPT <nick>|-<nick>|*{,<nick>|-<nick>|*}
+
+Manages the accept list used by user mode +g (caller-ID). While +g is set,
+you only receive direct messages from users on your accept list. A nickname
+also matches users logged into an account of that name. "-<nick>" removes a
+nickname from the list, and "*" lists the current entries.`,
+	},
 	"ambiance": {
 		text: `AMBIANCE <target> <text to be sent>
 
@@ -146,6 +164,13 @@ http://ircv3.net/specs/core/capability-negotiation-3.2.html`,
 CHATHISTORY is a history replay command associated with the IRCv3
 specification draft/chathistory. See this document:
 https://github.com/ircv3/ircv3-specifications/pull/393`,
+	},
+	"connect": {
+		oper: true,
+		text: `CONNECT <server>
+
+Opens a link to the given server, which must be configured in the linking
+section of the config file.`,
 	},
 	"debug": {
 		oper: true,
@@ -301,11 +326,23 @@ ON <server> specifies that the ban is to be set on that specific server.
 If "KLINE LIST" is sent, the server sends back a list of our current KLINEs.
 
 To remove a KLINE, use the "UNKLINE" command.`,
+	},
+	"knock": {
+		text: `KNOCK <channel> [<reason>]
+
+Asks the operators of an invite-only channel to invite you. Knocking is
+rate-limited, and is not possible if you are banned from the channel or
+if the channel has mode +K set.`,
 	},
 	"language": {
 		text: `LANGUAGE <code>{ <code>}
 
 Sets your preferred languages to the given ones.`,
+	},
+	"links": {
+		text: `LINKS
+
+Shows the servers that make up the network.`,
 	},
 	"list": {
 		text: `LIST [<channel>{,<channel>}] [<elistcond>{,<elistcond>}]
@@ -320,6 +357,35 @@ channels). <elistcond>s modify how the channels are selected.`,
 Shows statistics about the size of the network. If <mask> is given, only
 returns stats for servers matching the given mask.  If <server> is given, the
 command is processed by that server.`,
+	},
+	"map": {
+		text: `MAP
+
+Shows the servers that make up the network as a tree, with the number of users
+connected to each.`,
+	},
+	"markread": {
+		text: `MARKREAD <target> [timestamp=<timestamp>]
+
+Gets or sets your read marker for <target>, a channel or nickname: the time
+of the last message you have read there. With a timestamp, the marker is
+moved forward to that time, and all your connected sessions are notified of
+the change. Requires the draft/read-marker capability to be useful.`,
+	},
+	"metadata": {
+		text: `METADATA <target> GET <key> [<key>...]
+METADATA <target> LIST
+METADATA <target> SET <key> [<value>]
+METADATA <target> SYNC
+METADATA * SUB|UNSUB <key> [<key>...]
+METADATA * SUBS
+
+Queries and modifies metadata (key-value data, such as an avatar or display
+name) of users and channels. <target> is a nickname, a channel, or * for
+yourself. You can set metadata on yourself, and on channels where you are a
+channel operator; SET without a value deletes the key. SUB and UNSUB manage
+the keys you will be notified about when they change. Requires the
+draft/metadata-2 capability to be useful.`,
 	},
 	"mode": {
 		text: `MODE <target> [<modestring> [<mode arguments>...]]
@@ -414,6 +480,14 @@ Replies to a PING. Used to check link connectivity.`,
 		text: `PRIVMSG <target>{,<target>} <text to be sent>
 
 Sends the text to the given targets as a PRIVMSG.`,
+	},
+	"redact": {
+		text: `REDACT <target> <msgid> [<reason>]
+
+Deletes a message from the history of a channel or a direct conversation, and
+tells clients supporting draft/message-redaction to hide it. You can redact
+your own messages for a limited time after sending them; channel operators
+can redact any message in their channel.`,
 	},
 	"relaymsg": {
 		text: `RELAYMSG <channel> <spoofed nick> :<message>
@@ -463,6 +537,38 @@ The SCENE command is used to send a scene notification to the given target.`,
 		text: `SETNAME <realname>
 
 The SETNAME command updates the realname to be the newly-given one.`,
+	},
+	"silence": {
+		text: `SILENCE [{+|-}<mask>{,{+|-}<mask>}]
+
+Manages your server-side ignore list. Direct messages, TAGMSGs and INVITEs
+from clients matching an entry are discarded, and kept out of your history.
+<mask> is a nickmask (e.g. dan!*@* or *@example.com), or $a:<account> to
+ignore every client logged into that account. If you're logged in, the list
+is saved with your account. With no parameters, lists the current entries.`,
+	},
+	"squit": {
+		oper: true,
+		text: `SQUIT <server> [reason]
+
+Closes the link to the given server, which must be directly linked to this one.`,
+	},
+	"stats": {
+		text: `STATS <letter>
+
+Shows server statistics. The available reports are:
+
+  u  |  Server uptime.
+
+Operators can also request:
+
+  d  |  D-Lines (requires the 'ban' capability).
+  k  |  K-Lines (requires the 'ban' capability).
+  l  |  Sendq and traffic for each session and server link.
+  m  |  Number of times each command has been used.
+  o  |  Configured operators and their classes.
+  P  |  Configured listeners.
+  y  |  Connection limiter classes.`,
 	},
 	"summon": {
 		text: `SUMMON [parameters]
@@ -565,6 +671,13 @@ Verifies an account in accordance with the draft/register capability.`,
 		text: `VERSION [server]
 
 Views the version of software and the RPL_ISUPPORT tokens for the given server.`,
+	},
+	"wallops": {
+		oper: true,
+		text: `WALLOPS <text>
+
+Sends a message to all users with user mode +w (usually operators and
+other staff). The message is relayed to users on linked servers.`,
 	},
 	"webirc": {
 		oper: true, // not really, but it's restricted anyways
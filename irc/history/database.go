@@ -0,0 +1,44 @@
+// Copyright (c) 2026 agent <agent@local>
+// released under the MIT license
+
+package history
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+var (
+	// returned by DeleteMsgid:
+	ErrDisallowed = errors.New("disallowed")
+	ErrExpired    = errors.New("expired")
+)
+
+// Database is a backend for persistent history (MySQL or PostgreSQL).
+// Channel messages are indexed by the casefolded channel name (the target);
+// direct messages are indexed once for each participant's account (the target),
+// together with the casefolded nickname of the other participant (the correspondent).
+type Database interface {
+	AddChannelItem(target string, item Item, account string) error
+	AddDirectMessage(sender, senderAccount, recipient, recipientAccount string, item Item) error
+	// MakeSequence returns a view of a channel's history (if correspondent is empty),
+	// or of a DM conversation; if target is an account and correspondent is empty,
+	// ListCorrespondents on the sequence lists that account's conversations.
+	MakeSequence(target, correspondent string, cutoff time.Time) Sequence
+	// ListChannels returns the time of the latest message in each of the channels.
+	ListChannels(cfchannels []string) ([]TargetListing, error)
+	// LookupMsgid returns a message, along with its targets: the casefolded name
+	// of the channel, for a channel message, or the accounts that have a copy
+	// of a direct message.
+	LookupMsgid(msgid string) (item Item, targets []string, err error)
+	// DeleteMsgid deletes a message; if accountName is anything other than "*",
+	// it must match the AccountName of the message (otherwise ErrDisallowed),
+	// and messages sent before notBefore cannot be deleted (ErrExpired).
+	DeleteMsgid(msgid, accountName string, notBefore time.Time) error
+	// Forget asynchronously deletes all messages sent by an account.
+	Forget(account string)
+	// Export writes all messages sent by an account, as JSON lines.
+	Export(account string, writer io.Writer)
+	Close()
+}
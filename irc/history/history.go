@@ -140,6 +140,14 @@ func (list *Buffer) Add(item Item) {
 	list.buffer[pos] = item
 }
 
+// Lookup returns the item with the given msgid, if it is still in the buffer.
+func (list *Buffer) Lookup(msgid string) (result Item, found bool) {
+	list.RLock()
+	defer list.RUnlock()
+
+	return list.lookup(msgid)
+}
+
 func (list *Buffer) lookup(msgid string) (result Item, found bool) {
 	predicate := func(item *Item) bool {
 		return item.HasMsgid(msgid)
@@ -381,6 +389,17 @@ func (list *Buffer) latest(limit int) (results []Item) {
 	return
 }
 
+// All returns all items in the buffer in ascending time order,
+// skipping any that were deleted.
+func (list *Buffer) All() (results []Item) {
+	list.RLock()
+	defer list.RUnlock()
+
+	return list.matchInternal(func(item *Item) bool {
+		return item.Type != uninitializedItem
+	}, true, 0)
+}
+
 // LastDiscarded returns the latest time of any entry that was evicted
 // from the ring buffer.
 func (list *Buffer) LastDiscarded() time.Time {
@@ -504,6 +523,13 @@ func (list *Buffer) resize(size int) {
 	list.buffer = newbuffer
 }
 
+// Occupancy returns the number of items in the buffer and its currently allocated size.
+func (list *Buffer) Occupancy() (length, capacity int) {
+	list.RLock()
+	defer list.RUnlock()
+	return list.length(), len(list.buffer)
+}
+
 func (hist *Buffer) length() int {
 	if hist.start == -1 {
 		return 0
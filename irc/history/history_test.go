@@ -241,6 +241,19 @@ func TestDisabledByResize(t *testing.T) {
 	assertEqual(len(items), 0, t)
 }
 
+func TestAll(t *testing.T) {
+	now := easyParse("2006-01-01 00:00:00Z")
+	buf := NewHistoryBuffer(3, 0)
+	for i := 0; i < 4; i++ {
+		item := autoItem(i, now.Add(time.Duration(i)*time.Second))
+		item.Type = Privmsg
+		buf.Add(item)
+	}
+	buf.Delete(func(item *Item) bool { return item.Nick == "2" })
+	// the first item was evicted and the third was deleted:
+	assertEqual(toNicks(buf.All()), []string{"1", "3"}, t)
+}
+
 func BenchmarkInsert(b *testing.B) {
 	buf := NewHistoryBuffer(1024, 0)
 	b.ResetTimer()
@@ -122,7 +122,7 @@ func histservDeleteHandler(service *ircService, server *Server, client *Client,
 		return
 	}
 
-	err := server.DeleteMessage(target, msgid, accountName)
+	err := server.DeleteMessage(target, msgid, accountName, time.Time{})
 	if err == nil {
 		service.Notice(rb, client.t("Successfully deleted message"))
 	} else {
@@ -167,7 +167,9 @@ func histservExportAndNotify(service *ircService, server *Server, cfAccount stri
 	writer := bufio.NewWriter(outfile)
 	defer writer.Flush()
 
-	server.historyDB.Export(cfAccount, writer)
+	if historyDB := server.HistoryDB(); historyDB != nil {
+		historyDB.Export(cfAccount, writer)
+	}
 
 	client := server.clients.Get(alertNick)
 	if client != nil && client.HasRoleCapabs("history") {
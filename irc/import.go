@@ -9,6 +9,8 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/tidwall/buntdb"
 
@@ -45,6 +47,18 @@ type channelImport struct {
 	Key          string
 	Limit        int
 	Forward      string
+	AKicks       []akickImport `json:"akicks"`
+}
+
+// akickImport is an AKICK entry, targeting either a mask or an account;
+// the reason may contain a private reason after a | character.
+type akickImport struct {
+	Mask      string
+	Account   string
+	Reason    string
+	SetBy     string `json:"setBy"`
+	SetAt     int64  `json:"setAt"`
+	ExpiresAt int64  `json:"expiresAt"`
 }
 
 type databaseImport struct {
@@ -71,6 +85,46 @@ func serializeAmodes(raw map[string]string, validCfUsernames utils.StringSet) (r
 	return
 }
 
+func serializeAKicks(raw []akickImport, now time.Time) (result []byte, err error) {
+	processed := make(map[string]MaskInfo, len(raw))
+	for _, akick := range raw {
+		var mask string
+		if akick.Account != "" {
+			cfname, err := CasefoldName(akick.Account)
+			if err != nil {
+				log.Printf("skipping invalid akick account %s\n", akick.Account)
+				continue
+			}
+			mask = extbanPrefix + "a:" + cfname
+		} else {
+			mask, err = canonicalizeListMask(akick.Mask)
+			if err != nil || strings.HasPrefix(mask, "m:") {
+				log.Printf("skipping invalid akick mask %s\n", akick.Mask)
+				continue
+			}
+		}
+		info := MaskInfo{
+			TimeCreated:     time.Unix(0, akick.SetAt).UTC(),
+			CreatorNickmask: akick.SetBy,
+		}
+		if akick.ExpiresAt != 0 {
+			if akick.ExpiresAt <= now.UnixNano() {
+				continue
+			}
+			info.Duration = time.Duration(akick.ExpiresAt - akick.SetAt)
+		}
+		reason := akick.Reason
+		if separator := strings.IndexByte(reason, '|'); separator != -1 {
+			info.PrivateReason = strings.TrimSpace(reason[separator+1:])
+			reason = reason[:separator]
+		}
+		info.Reason = strings.TrimSpace(reason)
+		processed[mask] = info
+	}
+	result, err = json.Marshal(processed)
+	return
+}
+
 func doImportDBGeneric(config *Config, dbImport databaseImport, credsType CredentialsVersion, tx *buntdb.Tx) (err error) {
 	requiredVersion := 1
 	if dbImport.Version != requiredVersion {
@@ -193,6 +247,14 @@ func doImportDBGeneric(config *Config, dbImport databaseImport, credsType Creden
 				tx.Set(fmt.Sprintf(keyChannelForward, cfchname), chInfo.Forward, nil)
 			}
 		}
+		if len(chInfo.AKicks) != 0 {
+			a, err := serializeAKicks(chInfo.AKicks, time.Now())
+			if err == nil {
+				tx.Set(fmt.Sprintf(keyChannelAKicks, cfchname), string(a), nil)
+			} else {
+				log.Printf("couldn't serialize akicks for %s: %v", chname, err)
+			}
+		}
 	}
 
 	if warnSkeletons {
@@ -0,0 +1,35 @@
+// Copyright (c) 2026 agent <agent@local>
+// released under the MIT license
+
+package irc
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestSerializeAKicks(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	raw := []akickImport{
+		{Account: "Evan", Reason: "spam|reported by dan", SetBy: "dan", SetAt: now.Add(-time.Hour).UnixNano()},
+		{Mask: "*!*@Bad.Example", SetAt: now.Add(-time.Hour).UnixNano(), ExpiresAt: now.Add(time.Hour).UnixNano()},
+		{Mask: "*!*@expired.example", SetAt: now.Add(-time.Hour).UnixNano(), ExpiresAt: now.Add(-time.Minute).UnixNano()},
+		{Mask: "m:*!*@muted.example"},
+	}
+	serialized, err := serializeAKicks(raw, now)
+	assertEqual(err, nil, t)
+	var akicks map[string]MaskInfo
+	assertEqual(json.Unmarshal(serialized, &akicks), nil, t)
+	assertEqual(len(akicks), 2, t)
+
+	info := akicks["$a:evan"]
+	assertEqual(info.Reason, "spam", t)
+	assertEqual(info.PrivateReason, "reported by dan", t)
+	assertEqual(info.CreatorNickmask, "dan", t)
+	assertEqual(info.Duration, time.Duration(0), t)
+
+	info = akicks["*!*@bad.example"]
+	assertEqual(info.Duration, 2*time.Hour, t)
+	assertEqual(info.Expiration().Equal(now.Add(time.Hour)), true, t)
+}
@@ -0,0 +1,248 @@
+// Copyright (c) 2026 agent <agent@local>
+// released under the MIT license
+
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+var (
+	ErrUnknownIssuer  = errors.New("JWT has an unknown issuer")
+	ErrUnknownKey     = errors.New("JWT was signed with an unknown key")
+	ErrInvalidClaims  = errors.New("JWT is expired, or has an invalid audience")
+	ErrNoAccountClaim = errors.New("JWT has no account name")
+)
+
+var (
+	// only asymmetric algorithms are accepted (in particular, never `none`
+	// or HMAC, which would let a public key be used as a shared secret)
+	validAuthMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+)
+
+// JwtAuthConfig controls logging into accounts with JWTs (via SASL OAUTHBEARER)
+// issued by trusted identity providers.
+type JwtAuthConfig struct {
+	Enabled    bool
+	Autocreate bool
+	Issuers    map[string]*JwtIssuerConfig
+}
+
+// JwtIssuerConfig describes an identity provider, keyed by its `iss` claim.
+type JwtIssuerConfig struct {
+	// a PEM-encoded RSA or ECDSA public key, or a JSON Web Key Set:
+	KeyFile  string `yaml:"key-file"`
+	JwksFile string `yaml:"jwks-file"`
+	// the required `aud` claim:
+	Audience string
+	// the claim containing the account name:
+	AccountClaim string `yaml:"account-claim"`
+	// public keys, by key ID (the key from a PEM file has the empty ID):
+	keys map[string]interface{}
+}
+
+func (t *JwtAuthConfig) Postprocess() (err error) {
+	if !t.Enabled {
+		return nil
+	}
+	if len(t.Issuers) == 0 {
+		return errors.New("no issuers configured for jwt-auth")
+	}
+	for issuer, issuerConfig := range t.Issuers {
+		if err = issuerConfig.postprocess(); err != nil {
+			return fmt.Errorf("invalid jwt-auth configuration for %s: %w", issuer, err)
+		}
+	}
+	return nil
+}
+
+func (t *JwtIssuerConfig) postprocess() (err error) {
+	if t.Audience == "" {
+		return errors.New("audience is required")
+	}
+	if t.AccountClaim == "" {
+		t.AccountClaim = "sub"
+	}
+	t.keys = make(map[string]interface{})
+	if t.KeyFile != "" {
+		keyBytes, err := os.ReadFile(t.KeyFile)
+		if err != nil {
+			return err
+		}
+		if key, err := jwt.ParseRSAPublicKeyFromPEM(keyBytes); err == nil {
+			t.keys[""] = key
+		} else if key, err := jwt.ParseECPublicKeyFromPEM(keyBytes); err == nil {
+			t.keys[""] = key
+		} else {
+			return fmt.Errorf("could not parse %s as an RSA or ECDSA public key", t.KeyFile)
+		}
+	}
+	if t.JwksFile != "" {
+		jwksBytes, err := os.ReadFile(t.JwksFile)
+		if err != nil {
+			return err
+		}
+		if err = parseJwks(jwksBytes, t.keys); err != nil {
+			return err
+		}
+	}
+	if len(t.keys) == 0 {
+		return errors.New("no keys configured")
+	}
+	return nil
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	// RSA:
+	N string `json:"n"`
+	E string `json:"e"`
+	// ECDSA:
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func decodeJwkInt(value string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("invalid integer in JWKS")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// parseJwks parses the RSA and ECDSA signing keys from a JSON Web Key Set
+// (RFC 7517); other keys are ignored.
+func parseJwks(jwksBytes []byte, keys map[string]interface{}) (err error) {
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err = json.Unmarshal(jwksBytes, &jwks); err != nil {
+		return err
+	}
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		switch jwk.Kty {
+		case "RSA":
+			n, err := decodeJwkInt(jwk.N)
+			if err != nil {
+				return err
+			}
+			e, err := decodeJwkInt(jwk.E)
+			if err != nil || !e.IsInt64() {
+				return errors.New("invalid RSA exponent in JWKS")
+			}
+			keys[jwk.Kid] = &rsa.PublicKey{N: n, E: int(e.Int64())}
+		case "EC":
+			var curve elliptic.Curve
+			switch jwk.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			case "P-521":
+				curve = elliptic.P521()
+			default:
+				continue
+			}
+			x, err := decodeJwkInt(jwk.X)
+			if err != nil {
+				return err
+			}
+			y, err := decodeJwkInt(jwk.Y)
+			if err != nil {
+				return err
+			}
+			if !curve.IsOnCurve(x, y) {
+				return errors.New("invalid ECDSA key in JWKS")
+			}
+			keys[jwk.Kid] = &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+		}
+	}
+	return nil
+}
+
+// Validate verifies a JWT from one of the configured issuers, returning
+// the (unvalidated) account name it contains.
+func (t *JwtAuthConfig) Validate(tokenString string) (accountName string, err error) {
+	var issuerConfig *JwtIssuerConfig
+	parser := jwt.Parser{ValidMethods: validAuthMethods}
+	token, err := parser.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		claims, _ := token.Claims.(jwt.MapClaims)
+		issuer, _ := claims["iss"].(string)
+		issuerConfig = t.Issuers[issuer]
+		if issuerConfig == nil {
+			return nil, ErrUnknownIssuer
+		}
+		return issuerConfig.selectKey(token)
+	})
+	if err != nil {
+		return
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !claims.VerifyExpiresAt(time.Now().Unix(), true) || !verifyAudience(claims["aud"], issuerConfig.Audience) {
+		return "", ErrInvalidClaims
+	}
+	accountName, _ = claims[issuerConfig.AccountClaim].(string)
+	if accountName == "" {
+		return "", ErrNoAccountClaim
+	}
+	return accountName, nil
+}
+
+// selectKey finds the key the token claims to be signed with, checking
+// that its type matches the signing algorithm.
+func (t *JwtIssuerConfig) selectKey(token *jwt.Token) (key interface{}, err error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := t.keys[kid]
+	if !ok && len(t.keys) == 1 {
+		for _, key = range t.keys {
+		}
+		ok = true
+	}
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	switch key.(type) {
+	case *rsa.PublicKey:
+		switch token.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+			return key, nil
+		}
+	case *ecdsa.PublicKey:
+		if _, isECDSA := token.Method.(*jwt.SigningMethodECDSA); isECDSA {
+			return key, nil
+		}
+	}
+	return nil, ErrUnknownKey
+}
+
+// the `aud` claim is either a string or an array of strings
+func verifyAudience(aud interface{}, audience string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, a := range aud {
+			if a, ok := a.(string); ok && a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,148 @@
+// Copyright (c) 2026 agent <agent@local>
+// released under the MIT license
+
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func writeFile(t *testing.T, name string, data []byte) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, key interface{}, kid string, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	result, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestJwtAuthPEM(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubBytes, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	keyFile := writeFile(t, "key.pem", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubBytes}))
+
+	config := JwtAuthConfig{
+		Enabled: true,
+		Issuers: map[string]*JwtIssuerConfig{
+			"https://idp.example": {KeyFile: keyFile, Audience: "irc.example", AccountClaim: "preferred_username"},
+		},
+	}
+	if err := config.Postprocess(); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := time.Now().Add(time.Hour).Unix()
+	claims := func() jwt.MapClaims {
+		return jwt.MapClaims{"iss": "https://idp.example", "aud": "irc.example", "exp": exp, "preferred_username": "alice"}
+	}
+
+	if account, err := config.Validate(sign(t, jwt.SigningMethodRS256, rsaKey, "", claims())); err != nil || account != "alice" {
+		t.Errorf("valid token rejected: %v", err)
+	}
+	c := claims()
+	c["aud"] = []interface{}{"other.example", "irc.example"}
+	if account, err := config.Validate(sign(t, jwt.SigningMethodRS256, rsaKey, "", c)); err != nil || account != "alice" {
+		t.Errorf("audience array should be accepted: %v", err)
+	}
+
+	c = claims()
+	c["aud"] = "other.example"
+	if _, err := config.Validate(sign(t, jwt.SigningMethodRS256, rsaKey, "", c)); err != ErrInvalidClaims {
+		t.Errorf("wrong audience should be rejected, got %v", err)
+	}
+	c = claims()
+	delete(c, "exp")
+	if _, err := config.Validate(sign(t, jwt.SigningMethodRS256, rsaKey, "", c)); err != ErrInvalidClaims {
+		t.Errorf("token without expiry should be rejected, got %v", err)
+	}
+	c = claims()
+	c["exp"] = time.Now().Add(-time.Minute).Unix()
+	if _, err := config.Validate(sign(t, jwt.SigningMethodRS256, rsaKey, "", c)); err == nil {
+		t.Errorf("expired token should be rejected")
+	}
+	c = claims()
+	c["iss"] = "https://evil.example"
+	if _, err := config.Validate(sign(t, jwt.SigningMethodRS256, rsaKey, "", c)); err == nil {
+		t.Errorf("unknown issuer should be rejected")
+	}
+	c = claims()
+	delete(c, "preferred_username")
+	if _, err := config.Validate(sign(t, jwt.SigningMethodRS256, rsaKey, "", c)); err != ErrNoAccountClaim {
+		t.Errorf("token without an account name should be rejected, got %v", err)
+	}
+
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	if _, err := config.Validate(sign(t, jwt.SigningMethodRS256, otherKey, "", claims())); err == nil {
+		t.Errorf("token signed with the wrong key should be rejected")
+	}
+	// the public key must not be usable as an HMAC secret
+	pemBytes, _ := os.ReadFile(keyFile)
+	if _, err := config.Validate(sign(t, jwt.SigningMethodHS256, pemBytes, "", claims())); err == nil {
+		t.Errorf("HMAC token should be rejected")
+	}
+}
+
+func TestJwtAuthJWKS(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	b64 := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	jwks, _ := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rsa1", "use": "sig", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{"kty": "EC", "kid": "ec1", "crv": "P-256", "x": b64(ecKey.X.Bytes()), "y": b64(ecKey.Y.Bytes())},
+			{"kty": "oct", "kid": "secret", "k": "c2VjcmV0"},
+		},
+	})
+
+	config := JwtAuthConfig{
+		Enabled: true,
+		Issuers: map[string]*JwtIssuerConfig{
+			"idp": {JwksFile: writeFile(t, "jwks.json", jwks), Audience: "irc"},
+		},
+	}
+	if err := config.Postprocess(); err != nil {
+		t.Fatal(err)
+	}
+
+	claims := jwt.MapClaims{"iss": "idp", "aud": "irc", "exp": time.Now().Add(time.Hour).Unix(), "sub": "bob"}
+	if account, err := config.Validate(sign(t, jwt.SigningMethodRS256, rsaKey, "rsa1", claims)); err != nil || account != "bob" {
+		t.Errorf("RSA token rejected: %v", err)
+	}
+	if account, err := config.Validate(sign(t, jwt.SigningMethodES256, ecKey, "ec1", claims)); err != nil || account != "bob" {
+		t.Errorf("ECDSA token rejected: %v", err)
+	}
+	if _, err := config.Validate(sign(t, jwt.SigningMethodES256, ecKey, "rsa1", claims)); err == nil {
+		t.Errorf("algorithm must match the key type")
+	}
+	if _, err := config.Validate(sign(t, jwt.SigningMethodRS256, rsaKey, "", claims)); err == nil {
+		t.Errorf("key ID is required when there are several keys")
+	}
+}
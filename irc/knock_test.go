@@ -0,0 +1,71 @@
+// Copyright (c) 2026 agent <agent@local>
+// released under the MIT license
+
+package irc
+
+import (
+	"strings"
+	"testing"
+)
+
+// knock sends a KNOCK and returns the numeric it gets in reply.
+func (c *testClient) knock(channel, reason string) (numeric string) {
+	c.t.Helper()
+	c.Send("KNOCK " + channel + " :" + reason)
+	return lineCommand(c.Expect(RPL_KNOCKDLVR, ERR_TOOMANYKNOCK, ERR_CHANOPEN, ERR_KNOCKONCHAN, ERR_CANNOTKNOCK, ERR_NOSUCHCHANNEL))
+}
+
+func TestKnock(t *testing.T) {
+	_, addr := newTestServer(t, nil)
+	alice := connectTestClient(t, addr, "alice", "")
+	bob := connectTestClient(t, addr, "bob", "")
+	carol := connectTestClient(t, addr, "carol", "")
+	dave := connectTestClient(t, addr, "dave", "")
+
+	for _, channel := range []string{"#open", "#secret", "#quiet", "#other"} {
+		alice.Send("JOIN " + channel)
+		alice.Expect(RPL_ENDOFNAMES)
+	}
+	alice.Send("MODE #secret +i")
+	alice.Send("MODE #quiet +iK")
+	alice.Send("MODE #other +ib dave!*@*")
+	alice.Sync()
+	// a member without channel operator status doesn't see KNOCKs:
+	alice.Send("INVITE carol #secret")
+	carol.Expect("INVITE")
+	carol.Send("JOIN #secret")
+	carol.Expect(RPL_ENDOFNAMES)
+	alice.Sync()
+	carol.Sync()
+
+	assertEqual(bob.knock("#nonexistent", "hi"), ERR_NOSUCHCHANNEL, t)
+	// KNOCK is only for invite-only channels:
+	assertEqual(bob.knock("#open", "hi"), ERR_CHANOPEN, t)
+	assertEqual(carol.knock("#secret", "hi"), ERR_KNOCKONCHAN, t)
+	// +K disables it:
+	assertEqual(bob.knock("#quiet", "hi"), ERR_CANNOTKNOCK, t)
+	// as do bans:
+	assertEqual(dave.knock("#other", "hi"), ERR_CANNOTKNOCK, t)
+
+	assertEqual(bob.knock("#secret", "let me in"), RPL_KNOCKDLVR, t)
+	notice := alice.Expect("NOTICE")
+	if !strings.HasPrefix(notice, ":ergo.test NOTICE @#secret :[Knock] by bob!") || !strings.HasSuffix(notice, " (let me in)") {
+		t.Errorf("unexpected KNOCK notice: %q", notice)
+	}
+	assertEqual(carol.SyncContains("[Knock]"), false, t)
+
+	// each client can only KNOCK once per client-delay:
+	assertEqual(bob.knock("#other", "hi"), ERR_TOOMANYKNOCK, t)
+	// and each channel only receives one KNOCK per channel-delay:
+	assertEqual(dave.knock("#secret", "hi"), ERR_TOOMANYKNOCK, t)
+	// a KNOCK that was refused by the channel's limit doesn't count against the client:
+	assertEqual(dave.knock("#open", "hi"), ERR_CHANOPEN, t)
+	alice.Send("MODE #open +i")
+	alice.Sync()
+	assertEqual(dave.knock("#open", ""), RPL_KNOCKDLVR, t)
+	notice = alice.Expect("NOTICE")
+	if !strings.HasSuffix(notice, " (no reason specified)") {
+		t.Errorf("unexpected KNOCK notice: %q", notice)
+	}
+	assertEqual(alice.SyncContains("[Knock]"), false, t)
+}
@@ -0,0 +1,331 @@
+// Copyright (c) 2026 agent <agent@local>
+// released under the MIT license
+
+// Package ldap authenticates users against an LDAP directory, either by
+// binding directly as the user or by searching for the user's entry and then
+// binding as it.
+package ldap
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+const (
+	defaultTimeout = 10 * time.Second
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrUserNotFound       = errors.New("user not found in the directory")
+	ErrAmbiguousUser      = errors.New("search filter matched more than one user")
+	ErrNotInGroup         = errors.New("user is not a member of any required group")
+)
+
+// ServerConfig controls authentication against an LDAP server.
+type ServerConfig struct {
+	Enabled    bool
+	Autocreate bool
+	// host:port of the server
+	Address            string
+	Timeout            time.Duration
+	UseTLS             bool   `yaml:"use-tls"`
+	StartTLS           bool   `yaml:"start-tls"`
+	InsecureSkipVerify bool   `yaml:"insecure-skip-verify"`
+	RootCACert         string `yaml:"root-ca-cert"`
+	tlsConfig          *tls.Config
+
+	// bind-as-user: the user's DN, with %s in place of the account name,
+	// e.g., uid=%s,ou=people,dc=example,dc=com
+	UserDN string `yaml:"user-dn"`
+	// search-then-bind: credentials for the search (empty for an anonymous
+	// search), and the filter to find the user, with %s in place of the account name
+	BindDN        string   `yaml:"bind-dn"`
+	BindPassword  string   `yaml:"bind-password"`
+	SearchBaseDNs []string `yaml:"search-base-dns"`
+	SearchFilter  string   `yaml:"search-filter"`
+
+	// groups are read from this attribute of the user's entry:
+	MemberOfAttribute string `yaml:"member-of-attribute"`
+	// and/or by searching for group entries, with %s in place of the user's DN
+	GroupSearchBaseDNs []string `yaml:"group-search-base-dns"`
+	GroupSearchFilter  string   `yaml:"group-search-filter"`
+	// if nonempty, the user must be a member of at least one of these groups:
+	RequireGroups []string `yaml:"require-groups"`
+	// group DN to the name of an operator class:
+	OperClasses map[string]string `yaml:"oper-classes"`
+}
+
+func (config *ServerConfig) Postprocess() (err error) {
+	if config.Address == "" {
+		return errors.New("LDAP server address is required")
+	}
+	host, _, err := net.SplitHostPort(config.Address)
+	if err != nil {
+		host = config.Address
+		port := "389"
+		if config.UseTLS {
+			port = "636"
+		}
+		config.Address = net.JoinHostPort(host, port)
+	}
+	if config.Timeout == 0 {
+		config.Timeout = defaultTimeout
+	}
+
+	if config.UseTLS && config.StartTLS {
+		return errors.New("LDAP use-tls and start-tls are mutually exclusive")
+	}
+	if config.UseTLS || config.StartTLS {
+		config.tlsConfig = &tls.Config{
+			ServerName:         host,
+			InsecureSkipVerify: config.InsecureSkipVerify,
+			MinVersion:         tls.VersionTLS12,
+		}
+		if config.RootCACert != "" {
+			caCert, err := os.ReadFile(config.RootCACert)
+			if err != nil {
+				return err
+			}
+			config.tlsConfig.RootCAs = x509.NewCertPool()
+			if !config.tlsConfig.RootCAs.AppendCertsFromPEM(caCert) {
+				return fmt.Errorf("no certificates found in LDAP root-ca-cert %s", config.RootCACert)
+			}
+		}
+	}
+
+	if config.UserDN != "" {
+		if strings.Count(config.UserDN, "%s") != 1 {
+			return errors.New("LDAP user-dn must contain %s exactly once")
+		}
+	} else {
+		if len(config.SearchBaseDNs) == 0 {
+			return errors.New("LDAP requires either user-dn or search-base-dns")
+		}
+		if config.SearchFilter == "" {
+			config.SearchFilter = "(uid=%s)"
+		}
+		if _, err := ldap.CompileFilter(interpolate(config.SearchFilter, "test")); err != nil {
+			return fmt.Errorf("invalid LDAP search-filter: %w", err)
+		}
+	}
+	if config.GroupSearchFilter != "" {
+		if len(config.GroupSearchBaseDNs) == 0 {
+			return errors.New("LDAP group-search-filter requires group-search-base-dns")
+		}
+		if _, err := ldap.CompileFilter(interpolate(config.GroupSearchFilter, "test")); err != nil {
+			return fmt.Errorf("invalid LDAP group-search-filter: %w", err)
+		}
+	}
+	if config.MemberOfAttribute == "" && config.GroupSearchFilter == "" {
+		config.MemberOfAttribute = "memberOf"
+	}
+
+	for i, group := range config.RequireGroups {
+		config.RequireGroups[i] = normalizeDN(group)
+	}
+	operClasses := make(map[string]string, len(config.OperClasses))
+	for group, class := range config.OperClasses {
+		operClasses[normalizeDN(group)] = class
+	}
+	config.OperClasses = operClasses
+	return nil
+}
+
+func interpolate(template, value string) string {
+	return strings.ReplaceAll(template, "%s", value)
+}
+
+// XXX this is a simplification of the comparison rules in RFC 4517;
+// it suffices for DNs that are written consistently
+func normalizeDN(dn string) string {
+	return strings.ToLower(strings.TrimSpace(dn))
+}
+
+// CheckLDAPPassphrase checks a user's passphrase against the directory.
+// On success, it returns the (normalized) DNs of the user's groups.
+func CheckLDAPPassphrase(config *ServerConfig, accountName, passphrase string) (groups []string, err error) {
+	// an empty password would be an "unauthenticated" bind (RFC 4513, 5.1.2),
+	// which many servers accept with any DN
+	if passphrase == "" {
+		return nil, ErrInvalidCredentials
+	}
+	c, err := dial(config)
+	if err != nil {
+		return
+	}
+	defer c.Close()
+
+	var userDN string
+	if config.UserDN != "" {
+		userDN = interpolate(config.UserDN, EscapeDN(accountName))
+		if err = bind(c, userDN, passphrase); err != nil {
+			return
+		}
+		if config.MemberOfAttribute != "" {
+			request := ldap.NewSearchRequest(userDN, ldap.ScopeBaseObject, ldap.NeverDerefAliases,
+				1, timeLimit(config), false, "(objectClass=*)", []string{config.MemberOfAttribute}, nil)
+			result, err := c.Search(request)
+			if err != nil {
+				return nil, err
+			}
+			if len(result.Entries) != 0 {
+				groups = result.Entries[0].GetEqualFoldAttributeValues(config.MemberOfAttribute)
+			}
+		}
+		groups, err = searchGroups(c, config, userDN, groups)
+		if err != nil {
+			return
+		}
+	} else {
+		if config.BindDN != "" {
+			if err = bind(c, config.BindDN, config.BindPassword); err != nil {
+				return
+			}
+		}
+		var user *ldap.Entry
+		user, err = searchUser(c, config, accountName)
+		if err != nil {
+			return
+		}
+		userDN = user.DN
+		if config.MemberOfAttribute != "" {
+			groups = user.GetEqualFoldAttributeValues(config.MemberOfAttribute)
+		}
+		groups, err = searchGroups(c, config, userDN, groups)
+		if err != nil {
+			return
+		}
+		if err = bind(c, userDN, passphrase); err != nil {
+			return
+		}
+	}
+
+	for i, group := range groups {
+		groups[i] = normalizeDN(group)
+	}
+	if len(config.RequireGroups) != 0 && !containsAny(groups, config.RequireGroups) {
+		return nil, ErrNotInGroup
+	}
+	return groups, nil
+}
+
+func dial(config *ServerConfig) (c *ldap.Conn, err error) {
+	dialer := &net.Dialer{Timeout: config.Timeout}
+	if config.UseTLS {
+		c, err = ldap.DialURL("ldaps://"+config.Address, ldap.DialWithDialer(dialer), ldap.DialWithTLSConfig(config.tlsConfig))
+	} else {
+		c, err = ldap.DialURL("ldap://"+config.Address, ldap.DialWithDialer(dialer))
+	}
+	if err != nil {
+		return
+	}
+	c.SetTimeout(config.Timeout)
+	if config.StartTLS {
+		if err = c.StartTLS(config.tlsConfig); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return
+}
+
+func bind(c *ldap.Conn, dn, password string) (err error) {
+	err = c.Bind(dn, password)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		err = ErrInvalidCredentials
+	}
+	return
+}
+
+// timeLimit is the server-side time limit for searches, in seconds
+func timeLimit(config *ServerConfig) int {
+	return int(config.Timeout / time.Second)
+}
+
+func searchUser(c *ldap.Conn, config *ServerConfig, accountName string) (result *ldap.Entry, err error) {
+	filter := interpolate(config.SearchFilter, ldap.EscapeFilter(accountName))
+	var attributes []string
+	if config.MemberOfAttribute != "" {
+		attributes = []string{config.MemberOfAttribute}
+	} else {
+		attributes = []string{"1.1"} // no attributes (RFC 4511, 4.5.1.8)
+	}
+	var found []*ldap.Entry
+	for _, baseDN := range config.SearchBaseDNs {
+		request := ldap.NewSearchRequest(baseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+			2, timeLimit(config), false, filter, attributes, nil)
+		sr, err := c.Search(request)
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, sr.Entries...)
+	}
+	switch len(found) {
+	case 0:
+		return nil, ErrUserNotFound
+	case 1:
+		return found[0], nil
+	default:
+		return nil, ErrAmbiguousUser
+	}
+}
+
+func searchGroups(c *ldap.Conn, config *ServerConfig, userDN string, groups []string) (result []string, err error) {
+	result = groups
+	if config.GroupSearchFilter == "" {
+		return
+	}
+	filter := interpolate(config.GroupSearchFilter, ldap.EscapeFilter(userDN))
+	for _, baseDN := range config.GroupSearchBaseDNs {
+		request := ldap.NewSearchRequest(baseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+			0, timeLimit(config), false, filter, []string{"1.1"}, nil)
+		sr, err := c.Search(request)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range sr.Entries {
+			result = append(result, e.DN)
+		}
+	}
+	return
+}
+
+func containsAny(groups, wanted []string) bool {
+	for _, group := range groups {
+		for _, w := range wanted {
+			if group == w {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// EscapeDN escapes a value for interpolation into a distinguished name (RFC 4514).
+func EscapeDN(value string) string {
+	var buf strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == 0:
+			buf.WriteString("\\00")
+		case strings.IndexByte(",+\"\\<>;=", c) != -1,
+			i == 0 && (c == ' ' || c == '#'),
+			i == len(value)-1 && c == ' ':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	return buf.String()
+}
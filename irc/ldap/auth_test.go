@@ -0,0 +1,328 @@
+// Copyright (c) 2026 agent <agent@local>
+// released under the MIT license
+
+package ldap
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// an in-process LDAP server, implementing just enough of the protocol to test against
+
+type testEntry struct {
+	dn         string
+	attributes map[string][]string
+}
+
+// values returns the values of an attribute (names are case-insensitive)
+func (e *testEntry) values(name string) []string {
+	for attr, values := range e.attributes {
+		if strings.EqualFold(attr, name) {
+			return values
+		}
+	}
+	return nil
+}
+
+type testServer struct {
+	listener  net.Listener
+	entries   []testEntry
+	tlsConfig *tls.Config
+}
+
+func newTestServer(t *testing.T, useTLS bool) (server *testServer, caFile string) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		IsCA:         true,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caFile = filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	server = &testServer{
+		tlsConfig: &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}},
+		entries: []testEntry{
+			{dn: "cn=search,dc=example,dc=com", attributes: map[string][]string{"userPassword": {"searchpw"}}},
+			{dn: "uid=alice,ou=people,dc=example,dc=com", attributes: map[string][]string{
+				"uid": {"alice"}, "userPassword": {"alicepw"}, "objectClass": {"person"},
+				"memberOf": {"cn=IRC,ou=groups,dc=example,dc=com", "cn=ircops,ou=groups,dc=example,dc=com"},
+			}},
+			{dn: "uid=bob,ou=people,dc=example,dc=com", attributes: map[string][]string{
+				"uid": {"bob"}, "userPassword": {"bobpw"}, "objectClass": {"person"},
+			}},
+			{dn: "cn=irc,ou=groups,dc=example,dc=com", attributes: map[string][]string{
+				"objectClass": {"groupOfNames"}, "member": {"uid=alice,ou=people,dc=example,dc=com"},
+			}},
+		},
+	}
+	server.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if useTLS {
+		server.listener = tls.NewListener(server.listener, server.tlsConfig)
+	}
+	t.Cleanup(func() { server.listener.Close() })
+	go func() {
+		for {
+			conn, err := server.listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.handle(conn)
+		}
+	}()
+	return
+}
+
+func octetString(value string) *ber.Packet {
+	return ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "")
+}
+
+func response(msgID int64, tag ber.Tag, children ...*ber.Packet) []byte {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+	for _, child := range children {
+		op.AppendChild(child)
+	}
+	msg := ber.NewSequence("")
+	msg.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, msgID, ""))
+	msg.AppendChild(op)
+	return msg.Bytes()
+}
+
+func result(code int64) []*ber.Packet {
+	return []*ber.Packet{
+		ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, ""),
+		octetString(""), octetString(""),
+	}
+}
+
+func (s *testServer) handle(conn net.Conn) {
+	defer conn.Close()
+	bound := false
+	for {
+		msg, err := ber.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		msgID, op := msg.Children[0].Value.(int64), msg.Children[1]
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			dn, password := op.Children[1].Data.String(), op.Children[2].Data.String()
+			code := int64(ldap.LDAPResultInvalidCredentials)
+			for _, e := range s.entries {
+				if strings.EqualFold(e.dn, dn) && password != "" && e.values("userPassword")[0] == password {
+					code = ldap.LDAPResultSuccess
+				}
+			}
+			bound = code == ldap.LDAPResultSuccess
+			conn.Write(response(msgID, ldap.ApplicationBindResponse, result(code)...))
+		case ldap.ApplicationSearchRequest:
+			if !bound {
+				conn.Write(response(msgID, ldap.ApplicationSearchResultDone, result(ldap.LDAPResultInsufficientAccessRights)...))
+				continue
+			}
+			base, scope, filter, attrs := op.Children[0].Data.String(), op.Children[1].Value.(int64), op.Children[6], op.Children[7]
+			for _, e := range s.entries {
+				inScope := strings.EqualFold(e.dn, base) ||
+					(scope == ldap.ScopeWholeSubtree && strings.HasSuffix(strings.ToLower(e.dn), ","+strings.ToLower(base)))
+				if !inScope || !matches(&e, filter) {
+					continue
+				}
+				attributes := ber.NewSequence("")
+				for _, attr := range attrs.Children {
+					if values := e.values(attr.Data.String()); values != nil {
+						vals := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+						for _, v := range values {
+							vals.AppendChild(octetString(v))
+						}
+						attribute := ber.NewSequence("")
+						attribute.AppendChild(octetString(attr.Data.String()))
+						attribute.AppendChild(vals)
+						attributes.AppendChild(attribute)
+					}
+				}
+				conn.Write(response(msgID, ldap.ApplicationSearchResultEntry, octetString(e.dn), attributes))
+			}
+			conn.Write(response(msgID, ldap.ApplicationSearchResultDone, result(ldap.LDAPResultSuccess)...))
+		case ldap.ApplicationExtendedRequest:
+			conn.Write(response(msgID, ldap.ApplicationExtendedResponse, result(ldap.LDAPResultSuccess)...))
+			conn = tls.Server(conn, s.tlsConfig)
+		case ldap.ApplicationUnbindRequest:
+			return
+		}
+	}
+}
+
+func matches(e *testEntry, filter *ber.Packet) bool {
+	switch filter.Tag {
+	case ldap.FilterAnd:
+		for _, child := range filter.Children {
+			if !matches(e, child) {
+				return false
+			}
+		}
+		return true
+	case ldap.FilterOr:
+		for _, child := range filter.Children {
+			if matches(e, child) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterNot:
+		return !matches(e, filter.Children[0])
+	case ldap.FilterPresent:
+		return e.values(filter.Data.String()) != nil
+	case ldap.FilterEqualityMatch:
+		for _, v := range e.values(filter.Children[0].Data.String()) {
+			if strings.EqualFold(v, filter.Children[1].Data.String()) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestEscaping(t *testing.T) {
+	if escaped := EscapeDN(" a,b+c=d "); escaped != `\ a\,b\+c\=d\ ` {
+		t.Errorf("unexpected DN escape: %s", escaped)
+	}
+	config := ServerConfig{
+		Address:       "localhost",
+		SearchBaseDNs: []string{"ou=people,dc=example,dc=com"},
+		SearchFilter:  "(&(objectClass=person)(uid=%s)",
+	}
+	if err := config.Postprocess(); err == nil {
+		t.Errorf("invalid search filter accepted")
+	}
+}
+
+func TestSearchThenBind(t *testing.T) {
+	server, _ := newTestServer(t, false)
+	config := ServerConfig{
+		Enabled:       true,
+		Address:       server.listener.Addr().String(),
+		BindDN:        "cn=search,dc=example,dc=com",
+		BindPassword:  "searchpw",
+		SearchBaseDNs: []string{"ou=people,dc=example,dc=com"},
+		SearchFilter:  "(&(objectClass=person)(uid=%s))",
+	}
+	if err := config.Postprocess(); err != nil {
+		t.Fatal(err)
+	}
+
+	groups, err := CheckLDAPPassphrase(&config, "alice", "alicepw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 2 || groups[0] != "cn=irc,ou=groups,dc=example,dc=com" {
+		t.Errorf("unexpected groups: %v", groups)
+	}
+	if _, err := CheckLDAPPassphrase(&config, "alice", "bobpw"); err != ErrInvalidCredentials {
+		t.Errorf("wrong password should be rejected, got %v", err)
+	}
+	if _, err := CheckLDAPPassphrase(&config, "alice", ""); err != ErrInvalidCredentials {
+		t.Errorf("empty password should be rejected, got %v", err)
+	}
+	if _, err := CheckLDAPPassphrase(&config, "carol", "carolpw"); err != ErrUserNotFound {
+		t.Errorf("unknown user should be rejected, got %v", err)
+	}
+	if _, err := CheckLDAPPassphrase(&config, "*", "alicepw"); err != ErrUserNotFound {
+		t.Errorf("wildcards in the account name must be escaped, got %v", err)
+	}
+
+	config.RequireGroups = []string{"CN=IRC,ou=groups,dc=example,dc=com"}
+	if err := config.Postprocess(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CheckLDAPPassphrase(&config, "alice", "alicepw"); err != nil {
+		t.Errorf("group member should be accepted, got %v", err)
+	}
+	if _, err := CheckLDAPPassphrase(&config, "bob", "bobpw"); err != ErrNotInGroup {
+		t.Errorf("non-member should be rejected, got %v", err)
+	}
+
+	config.BindDN = ""
+	if _, err := CheckLDAPPassphrase(&config, "alice", "alicepw"); err == nil {
+		t.Errorf("anonymous search should fail against this server")
+	}
+}
+
+func TestBindAsUserStartTLS(t *testing.T) {
+	server, caFile := newTestServer(t, false)
+	config := ServerConfig{
+		Enabled:            true,
+		Address:            server.listener.Addr().String(),
+		StartTLS:           true,
+		RootCACert:         caFile,
+		UserDN:             "uid=%s,ou=people,dc=example,dc=com",
+		GroupSearchBaseDNs: []string{"ou=groups,dc=example,dc=com"},
+		GroupSearchFilter:  "(&(objectClass=groupOfNames)(member=%s))",
+		RequireGroups:      []string{"cn=irc,ou=groups,dc=example,dc=com"},
+	}
+	if err := config.Postprocess(); err != nil {
+		t.Fatal(err)
+	}
+	groups, err := CheckLDAPPassphrase(&config, "alice", "alicepw")
+	if err != nil || len(groups) != 1 {
+		t.Errorf("bind as user failed: %v %v", groups, err)
+	}
+	if _, err := CheckLDAPPassphrase(&config, "alice", "wrong"); err != ErrInvalidCredentials {
+		t.Errorf("wrong password should be rejected, got %v", err)
+	}
+	if _, err := CheckLDAPPassphrase(&config, "bob", "bobpw"); err != ErrNotInGroup {
+		t.Errorf("non-member should be rejected, got %v", err)
+	}
+}
+
+func TestLDAPS(t *testing.T) {
+	server, caFile := newTestServer(t, true)
+	config := ServerConfig{
+		Enabled: true,
+		Address: server.listener.Addr().String(),
+		UseTLS:  true,
+		UserDN:  "uid=%s,ou=people,dc=example,dc=com",
+	}
+	if err := config.Postprocess(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CheckLDAPPassphrase(&config, "bob", "bobpw"); err == nil {
+		t.Errorf("untrusted certificate should be rejected")
+	}
+	config.RootCACert = caFile
+	if err := config.Postprocess(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CheckLDAPPassphrase(&config, "bob", "bobpw"); err != nil {
+		t.Errorf("bind over TLS failed: %v", err)
+	}
+}
@@ -0,0 +1,2180 @@
+// Copyright (c) 2026 agent <agent@local>
+// released under the MIT license
+
+package irc
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ergochat/irc-go/ircfmt"
+	"github.com/ergochat/irc-go/ircmsg"
+
+	"github.com/ergochat/ergo/irc/caps"
+	"github.com/ergochat/ergo/irc/history"
+	"github.com/ergochat/ergo/irc/modes"
+	"github.com/ergochat/ergo/irc/sno"
+	"github.com/ergochat/ergo/irc/utils"
+)
+
+// Server linking. Linked servers form a spanning tree; each server relays every
+// event it learns about to all of its links except the one the event arrived on.
+// Clients are identified on the links by an opaque network-wide ID (their linkID),
+// servers by their names. The protocol is spoken over TLS on a dedicated listener:
+//
+//	SERVER <name> <password> <protocol version> :<description>
+//	:<uplink> SID <name> <hops> :<description>
+//	:<server> UID <id> <nick> <signon ts> <username> <hostname> <raw hostname> <ip> <account name> <+umodes> :<realname>
+//	:<server> SJOIN <channel ts> <channel> <+modes> [mode args...] :<[member modes:]id> ...
+//	:<server> TB <channel> <topic ts> <setter> :<topic>
+//	:<server> BMASK <channel ts> <channel> <b|e|I> :<mask> ...
+//	:<server> EOB
+//	:<id> NICK <nick>
+//	:<id> QUIT :<reason>
+//	:<id> PART <channel> [:<reason>]
+//	:<id> KICK <channel> <id> :<comment>
+//	:<id> TOPIC <channel> :<topic>
+//	:<id|server> MODE <channel> <modes> [args...]
+//	:<id> PRIVMSG|NOTICE|TAGMSG <channel|id> [:<text>]
+//	:<id> MULTILINE <PRIVMSG|NOTICE> <channel|id> <line count>, followed by
+//	LINE <0|1> :<text> (the first parameter is the draft/multiline-concat flag)
+//	:<id> WALLOPS :<text>
+//	:<id> KNOCK <channel> :<reason>
+//	:<id> REDACT <channel|id> <msgid> :<reason>
+//	:<server> KILL <id> :<quit message>
+//	:<server> SQUIT <name> :<reason>
+//
+// Events carry their msgid and time as tags, so that all servers agree on them.
+
+const (
+	linkProtocolVersion   = "1"
+	linkHandshakeTimeout  = 30 * time.Second
+	linkPingInterval      = 90 * time.Second
+	linkReadTimeout       = 4 * time.Minute
+	linkReconnectInterval = 30 * time.Second
+	// maximum length of a line on a link; this has to accommodate a maximum-length
+	// client message, plus server tags and IDs:
+	linkMaxLineLen = 16384
+	// maximum number of members or masks in a single burst line:
+	linkBurstBatchSize = 48
+	// maximum number of lines in a relayed multiline message:
+	linkMaxMultilineLines = 4096
+)
+
+var (
+	// user modes that are relevant to other servers, e.g., because they are
+	// enforced by the sender's server:
+	linkUserModes = modes.Modes{
+		modes.Bot, modes.Invisible, modes.RegisteredOnly, modes.TLS, modes.UserNoCTCP,
+	}
+)
+
+// remoteServer is another server on the network.
+type remoteServer struct {
+	name        string
+	description string
+	hops        int
+	uplink      string      // name of the server it is linked to
+	link        *serverLink // our direct link in its direction
+	split       bool        // set while its clients are being removed
+}
+
+// remoteClient holds the link state of a client on another server.
+type remoteClient struct {
+	server *remoteServer
+	// these are protected by the client's stateMutex:
+	quitMessage string
+	propagated  bool // whether the rest of the network already knows about the quit
+}
+
+// linkMultiline is a MULTILINE message that is still receiving its lines.
+type linkMultiline struct {
+	source    *Client
+	tags      map[string]string
+	command   string
+	target    string
+	remaining int
+	message   utils.SplitMessage
+}
+
+// serverLink is a direct connection to another server.
+type serverLink struct {
+	manager      *LinkManager
+	conn         net.Conn
+	socket       *Socket
+	certfp       string
+	outgoing     bool
+	expectedName string // casefolded name we connected to, for outgoing links
+	pingTimer    *time.Timer
+	ctime        time.Time
+
+	closeMutex  sync.Mutex
+	closeReason string // first reason passed to close()
+
+	// these are only accessed by the link's own goroutine:
+	registered bool
+	multiline  *linkMultiline
+
+	// these are immutable after registration:
+	name           string
+	nameCasefolded string
+	description    string
+}
+
+// LinkManager manages our links to other servers, and the servers and clients
+// we have learned about over them.
+type LinkManager struct {
+	sync.RWMutex // tier 2
+	server       *Server
+	links        map[string]*serverLink   // casefolded name -> registered direct link
+	servers      map[string]*remoteServer // casefolded name -> every other server on the network
+	clients      map[string]*Client       // link ID -> introduced client, local or remote
+	connecting   utils.StringSet          // casefolded names of outgoing links in progress
+	listener     net.Listener
+	listenAddr   string
+}
+
+// Initialize initializes a LinkManager.
+func (lm *LinkManager) Initialize(server *Server) {
+	lm.server = server
+	lm.links = make(map[string]*serverLink)
+	lm.servers = make(map[string]*remoteServer)
+	lm.clients = make(map[string]*Client)
+	lm.connecting = make(utils.StringSet)
+}
+
+// applyConfig starts or stops the link listener, and drops links that are
+// no longer configured.
+func (lm *LinkManager) applyConfig(config *Config) {
+	listenAddr := ""
+	if config.Linking.Enabled {
+		listenAddr = config.Linking.Listener
+	}
+
+	var toClose []*serverLink
+	lm.Lock()
+	if lm.listener != nil && lm.listenAddr != listenAddr {
+		lm.server.logger.Info("linking", "Stopping link listener", lm.listenAddr)
+		lm.listener.Close()
+		lm.listener = nil
+	}
+	if listenAddr != "" && lm.listener == nil {
+		listener, err := net.Listen("tcp", listenAddr)
+		if err == nil {
+			lm.listener = listener
+			lm.listenAddr = listenAddr
+			go lm.acceptLoop(listener)
+			lm.server.logger.Info("linking", "Started link listener", listenAddr)
+		} else {
+			lm.server.logger.Error("linking", "Could not start link listener", listenAddr, err.Error())
+		}
+	}
+	for cfname, link := range lm.links {
+		if _, ok := config.Linking.links[cfname]; !ok {
+			toClose = append(toClose, link)
+		}
+	}
+	lm.Unlock()
+
+	for _, link := range toClose {
+		link.close("Link is no longer configured")
+	}
+}
+
+func (lm *LinkManager) acceptLoop(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			// the listener was closed
+			return
+		}
+		go lm.runIncoming(conn)
+	}
+}
+
+func (lm *LinkManager) runIncoming(conn net.Conn) {
+	config := lm.server.Config()
+	if !config.Linking.Enabled {
+		conn.Close()
+		return
+	}
+	tlsConn := tls.Server(conn, config.Linking.tlsConfig)
+	link, err := newServerLink(lm, tlsConn, config)
+	if err != nil {
+		lm.server.logger.Info("linking", "Incoming link failed TLS handshake", conn.RemoteAddr().String(), err.Error())
+		conn.Close()
+		return
+	}
+	link.run()
+}
+
+// Connect opens a link to a configured server.
+func (lm *LinkManager) Connect(name string) (err error) {
+	config := lm.server.Config()
+	cfname := strings.ToLower(name)
+	linkConfig, ok := config.Linking.links[cfname]
+	if !config.Linking.Enabled || !ok || linkConfig.Address == "" {
+		return errLinkNotConfigured
+	}
+	return lm.connect(cfname, linkConfig)
+}
+
+func (lm *LinkManager) connect(cfname string, linkConfig LinkConfig) (err error) {
+	lm.Lock()
+	if lm.links[cfname] != nil || lm.servers[cfname] != nil || lm.connecting.Has(cfname) {
+		err = errAlreadyLinked
+	} else {
+		lm.connecting.Add(cfname)
+	}
+	lm.Unlock()
+
+	if err == nil {
+		go lm.runOutgoing(cfname, linkConfig)
+	}
+	return
+}
+
+func (lm *LinkManager) runOutgoing(cfname string, linkConfig LinkConfig) {
+	config := lm.server.Config()
+	link, err := func() (*serverLink, error) {
+		defer func() {
+			lm.Lock()
+			delete(lm.connecting, cfname)
+			lm.Unlock()
+		}()
+
+		host, _, err := net.SplitHostPort(linkConfig.Address)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig := &tls.Config{
+			Certificates:       config.Linking.tlsConfig.Certificates,
+			ServerName:         host,
+			InsecureSkipVerify: linkConfig.InsecureSkipVerify || linkConfig.certfp != "",
+			MinVersion:         tls.VersionTLS12,
+		}
+		dialer := net.Dialer{Timeout: linkHandshakeTimeout}
+		conn, err := tls.DialWithDialer(&dialer, "tcp", linkConfig.Address, tlsConfig)
+		if err != nil {
+			return nil, err
+		}
+		link, err := newServerLink(lm, conn, config)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if linkConfig.certfp != "" && linkConfig.certfp != link.certfp {
+			conn.Close()
+			return nil, errors.New("Certificate fingerprint mismatch")
+		}
+		link.outgoing = true
+		link.expectedName = cfname
+		return link, nil
+	}()
+
+	if err != nil {
+		lm.server.logger.Warning("linking", "Could not connect to server", cfname, err.Error())
+		lm.server.snomasks.Send(sno.Links, fmt.Sprintf("Could not connect to server %s: %s", cfname, err.Error()))
+		return
+	}
+
+	link.sendServer(linkConfig.Password, config)
+	link.run()
+}
+
+// handleAutoconnect periodically (re)connects to the links that are configured
+// for autoconnect.
+func (lm *LinkManager) handleAutoconnect() {
+	defer func() {
+		if r := recover(); r != nil {
+			lm.server.logger.Error("internal",
+				fmt.Sprintf("Panic in link autoconnect: %v\n%s", r, debug.Stack()))
+		}
+		// either way, reschedule
+		time.AfterFunc(linkReconnectInterval, lm.handleAutoconnect)
+	}()
+
+	config := lm.server.Config()
+	if !config.Linking.Enabled {
+		return
+	}
+	for cfname, linkConfig := range config.Linking.links {
+		if linkConfig.Autoconnect {
+			lm.connect(cfname, linkConfig)
+		}
+	}
+}
+
+// Squit closes a direct link.
+func (lm *LinkManager) Squit(name, reason string) (err error) {
+	lm.RLock()
+	link := lm.links[strings.ToLower(name)]
+	lm.RUnlock()
+
+	if link == nil {
+		return errNotDirectlyLinked
+	}
+	link.close(reason)
+	return nil
+}
+
+func newServerLink(lm *LinkManager, conn *tls.Conn, config *Config) (*serverLink, error) {
+	certfp, _, err := utils.GetCertFP(conn, linkHandshakeTimeout)
+	if err != nil && err != utils.ErrNoPeerCerts {
+		return nil, err
+	}
+	ircConn := NewIRCStreamConn(&utils.WrappedConn{Conn: conn, Secure: true})
+	// links carry server tags and IDs in addition to client messages:
+	ircConn.reader.Initialize(conn, initialBufferSize, linkMaxLineLen)
+	return &serverLink{
+		manager: lm,
+		conn:    conn,
+		socket:  NewSocket(ircConn, config.Linking.maxSendQBytes),
+		certfp:  certfp,
+		ctime:   time.Now().UTC(),
+	}, nil
+}
+
+// run is the main loop of a link's goroutine.
+func (link *serverLink) run() {
+	lm := link.manager
+	reason := "Connection closed"
+
+	defer func() {
+		if r := recover(); r != nil {
+			lm.server.logger.Error("internal",
+				fmt.Sprintf("Server link caused panic: %v\n%s", r, debug.Stack()))
+			if !lm.server.Config().Debug.recoverFromErrors {
+				panic(r)
+			}
+			reason = "Internal error"
+		}
+		link.close(reason)
+		lm.linkClosed(link, reason)
+	}()
+
+	link.pingTimer = time.AfterFunc(linkPingInterval, link.ping)
+
+	for {
+		timeout := linkReadTimeout
+		if !link.registered {
+			timeout = linkHandshakeTimeout
+		}
+		link.conn.SetReadDeadline(time.Now().Add(timeout))
+
+		line, err := link.socket.Read()
+		if err != nil {
+			if closeReason := link.getCloseReason(); closeReason != "" {
+				// we closed it ourselves, e.g., due to SQUIT
+				reason = closeReason
+			} else {
+				reason = fmt.Sprintf("Read error: %s", err.Error())
+			}
+			return
+		}
+		msg, err := ircmsg.ParseLine(line)
+		if err == ircmsg.ErrorLineIsEmpty {
+			continue
+		} else if err != nil {
+			reason = "Protocol error: could not parse line"
+			return
+		}
+		if err := link.handle(msg); err != nil {
+			reason = err.Error()
+			return
+		}
+	}
+}
+
+func (link *serverLink) ping() {
+	if link.socket.IsClosed() {
+		return
+	}
+	link.send(nil, "", "PING", link.manager.server.name)
+	link.pingTimer.Reset(linkPingInterval)
+}
+
+// close closes the link, sending the reason to the other server.
+func (link *serverLink) close(reason string) {
+	link.closeMutex.Lock()
+	if link.closeReason == "" {
+		link.closeReason = reason
+	}
+	link.closeMutex.Unlock()
+
+	errorMsg := ircmsg.MakeMessage(nil, "", "ERROR", reason)
+	errorLine, _ := errorMsg.LineBytes()
+	link.socket.SetFinalData(errorLine)
+	link.socket.Close()
+}
+
+func (link *serverLink) getCloseReason() string {
+	link.closeMutex.Lock()
+	defer link.closeMutex.Unlock()
+	return link.closeReason
+}
+
+func (link *serverLink) write(line []byte) {
+	if err := link.socket.Write(line); err == errSendQExceeded {
+		link.manager.server.metrics.sendQExceeded.Inc()
+		link.manager.server.logger.Warning("linking", "Link exceeded sendq", link.name)
+	}
+}
+
+func (link *serverLink) sendMessage(msg *ircmsg.Message) {
+	line, err := msg.LineBytes()
+	if err != nil {
+		link.manager.server.logger.Error("linking", "Could not assemble line", msg.Command, err.Error())
+		return
+	}
+	link.write(line)
+}
+
+func (link *serverLink) send(tags map[string]string, prefix, command string, params ...string) {
+	msg := ircmsg.MakeMessage(tags, prefix, command, params...)
+	link.sendMessage(&msg)
+}
+
+func (link *serverLink) sendServer(password string, config *Config) {
+	link.send(nil, "", "SERVER", link.manager.server.name, password, linkProtocolVersion, config.Linking.Description)
+}
+
+type linkCommand struct {
+	handler      func(link *serverLink, msg ircmsg.Message) error
+	usablePreReg bool
+	minParams    int
+}
+
+// linkCommands holds the commands of the server-to-server protocol
+var linkCommands map[string]linkCommand
+
+func init() {
+	linkCommands = map[string]linkCommand{
+		"BMASK":     {handler: linkBmaskHandler, minParams: 4},
+		"EOB":       {handler: linkEobHandler},
+		"ERROR":     {handler: linkErrorHandler, usablePreReg: true},
+		"KICK":      {handler: linkKickHandler, minParams: 3},
+		"KILL":      {handler: linkKillHandler, minParams: 2},
+		"KNOCK":     {handler: linkKnockHandler, minParams: 2},
+		"LINE":      {handler: linkLineHandler, minParams: 2},
+		"MODE":      {handler: linkModeHandler, minParams: 2},
+		"MULTILINE": {handler: linkMultilineHandler, minParams: 3},
+		"NICK":      {handler: linkNickHandler, minParams: 1},
+		"NOTICE":    {handler: linkMessageHandler, minParams: 2},
+		"PART":      {handler: linkPartHandler, minParams: 1},
+		"PING":      {handler: linkPingHandler, usablePreReg: true, minParams: 1},
+		"PONG":      {handler: linkPongHandler, usablePreReg: true},
+		"PRIVMSG":   {handler: linkMessageHandler, minParams: 2},
+		"QUIT":      {handler: linkQuitHandler},
+		"REDACT":    {handler: linkRedactHandler, minParams: 3},
+		"SERVER":    {handler: linkServerHandler, usablePreReg: true, minParams: 4},
+		"SID":       {handler: linkSidHandler, minParams: 3},
+		"SJOIN":     {handler: linkSjoinHandler, minParams: 4},
+		"SQUIT":     {handler: linkSquitHandler, minParams: 1},
+		"TAGMSG":    {handler: linkMessageHandler, minParams: 1},
+		"TB":        {handler: linkTbHandler, minParams: 4},
+		"TOPIC":     {handler: linkTopicHandler, minParams: 2},
+		"UID":       {handler: linkUidHandler, minParams: 10},
+		"WALLOPS":   {handler: linkWallopsHandler, minParams: 1},
+	}
+}
+
+// handle processes a line received from the link; a non-nil error closes the link.
+func (link *serverLink) handle(msg ircmsg.Message) error {
+	cmd, ok := linkCommands[msg.Command]
+	if !ok {
+		link.manager.server.logger.Debug("linking", "Ignoring unknown link command", link.name, msg.Command)
+		return nil
+	}
+	if !link.registered && !cmd.usablePreReg {
+		return errors.New("Not registered")
+	}
+	if len(msg.Params) < cmd.minParams {
+		return fmt.Errorf("Not enough parameters for %s", msg.Command)
+	}
+	if link.multiline != nil && msg.Command != "LINE" {
+		return errors.New("Unterminated MULTILINE")
+	}
+	return cmd.handler(link, msg)
+}
+
+// addLink registers a link that completed the SERVER handshake.
+func (lm *LinkManager) addLink(link *serverLink) error {
+	lm.Lock()
+	defer lm.Unlock()
+
+	if link.nameCasefolded == lm.server.nameCasefolded || lm.links[link.nameCasefolded] != nil || lm.servers[link.nameCasefolded] != nil {
+		return fmt.Errorf("Server %s is already linked", link.name)
+	}
+	lm.links[link.nameCasefolded] = link
+	lm.servers[link.nameCasefolded] = &remoteServer{
+		name:        link.name,
+		description: link.description,
+		hops:        1,
+		uplink:      lm.server.name,
+		link:        link,
+	}
+	return nil
+}
+
+// linkClosed cleans up after a link's goroutine exits: the servers behind the
+// link split from the network, and their clients quit.
+func (lm *LinkManager) linkClosed(link *serverLink, reason string) {
+	if link.pingTimer != nil {
+		link.pingTimer.Stop()
+	}
+
+	lm.Lock()
+	registered := link.nameCasefolded != "" && lm.links[link.nameCasefolded] == link
+	var split utils.StringSet
+	if registered {
+		delete(lm.links, link.nameCasefolded)
+		split = lm.splitServersNoMutex(link.nameCasefolded)
+	}
+	lm.Unlock()
+
+	if !registered {
+		lm.server.logger.Info("linking", "Unregistered link closed", link.conn.RemoteAddr().String(), reason)
+		return
+	}
+
+	lm.server.logger.Info("linking", "Link closed", link.name, reason)
+	lm.server.snomasks.Send(sno.Links, fmt.Sprintf(ircfmt.Unescape("Link to $c[grey][$r%s$c[grey]]$r closed: %s"), link.name, reason))
+	lm.removeServerClients(split, fmt.Sprintf("%s %s", lm.server.name, link.name))
+	lm.broadcast(nil, nil, lm.server.name, "SQUIT", link.name, reason)
+}
+
+// splitServersNoMutex removes a server and everything behind it, returning
+// their casefolded names. Requires the write lock.
+func (lm *LinkManager) splitServersNoMutex(cfname string) (split utils.StringSet) {
+	split = computeSubtree(lm.servers, cfname)
+	for name := range split {
+		if server := lm.servers[name]; server != nil {
+			server.split = true
+			delete(lm.servers, name)
+		}
+	}
+	return
+}
+
+// computeSubtree returns the casefolded names of `root` and every server
+// that is linked to the network through it.
+func computeSubtree(servers map[string]*remoteServer, root string) (result utils.StringSet) {
+	result = make(utils.StringSet)
+	result.Add(root)
+	for {
+		added := false
+		for cfname, server := range servers {
+			if !result.Has(cfname) && result.Has(strings.ToLower(server.uplink)) {
+				result.Add(cfname)
+				added = true
+			}
+		}
+		if !added {
+			return
+		}
+	}
+}
+
+// removeServerClients removes the clients of servers that split from the network.
+func (lm *LinkManager) removeServerClients(split utils.StringSet, quitMessage string) {
+	var clients []*Client
+	lm.RLock()
+	for _, client := range lm.clients {
+		if client.remote != nil && split.Has(strings.ToLower(client.remote.server.name)) {
+			clients = append(clients, client)
+		}
+	}
+	lm.RUnlock()
+
+	for _, client := range clients {
+		lm.removeRemoteClient(client, quitMessage)
+	}
+}
+
+// removeRemoteClient removes a remote client on instructions from the network.
+func (lm *LinkManager) removeRemoteClient(client *Client, quitMessage string) {
+	client.stateMutex.Lock()
+	client.remote.quitMessage = quitMessage
+	client.remote.propagated = true
+	client.stateMutex.Unlock()
+	client.destroy(nil)
+}
+
+func (lm *LinkManager) allLinks() (result []*serverLink) {
+	lm.RLock()
+	defer lm.RUnlock()
+	result = make([]*serverLink, 0, len(lm.links))
+	for _, link := range lm.links {
+		result = append(result, link)
+	}
+	return
+}
+
+func (lm *LinkManager) hasLinks() bool {
+	lm.RLock()
+	defer lm.RUnlock()
+	return len(lm.links) != 0
+}
+
+func (lm *LinkManager) getServer(name string) *remoteServer {
+	lm.RLock()
+	defer lm.RUnlock()
+	return lm.servers[strings.ToLower(name)]
+}
+
+func (lm *LinkManager) getClient(id string) *Client {
+	lm.RLock()
+	defer lm.RUnlock()
+	return lm.clients[id]
+}
+
+// linkClient looks up the client that is the source of a line received from
+// `link`; clients are only allowed to act from the direction of their server.
+func (lm *LinkManager) linkClient(link *serverLink, id string) *Client {
+	client := lm.getClient(id)
+	if client == nil || client.remote == nil || client.remote.server.link != link {
+		return nil
+	}
+	return client
+}
+
+// broadcastData relays a raw line (or lines) to all links except `exclude`.
+func (lm *LinkManager) broadcastData(exclude *serverLink, data []byte) {
+	for _, link := range lm.allLinks() {
+		if link != exclude {
+			link.write(data)
+		}
+	}
+}
+
+func (lm *LinkManager) broadcastMessage(exclude *serverLink, msg *ircmsg.Message) {
+	if !lm.hasLinks() {
+		return
+	}
+	line, err := msg.LineBytes()
+	if err != nil {
+		lm.server.logger.Error("linking", "Could not assemble line", msg.Command, err.Error())
+		return
+	}
+	lm.broadcastData(exclude, line)
+}
+
+func (lm *LinkManager) broadcast(exclude *serverLink, tags map[string]string, prefix, command string, params ...string) {
+	msg := ircmsg.MakeMessage(tags, prefix, command, params...)
+	lm.broadcastMessage(exclude, &msg)
+}
+
+// LinkID returns the client's network-wide identifier, generating it if necessary.
+func (client *Client) LinkID() (result string) {
+	client.stateMutex.Lock()
+	if client.linkID == "" {
+		client.linkID = utils.GenerateSecretToken()
+	}
+	result = client.linkID
+	client.stateMutex.Unlock()
+	return
+}
+
+// ServerName returns the name of the server the client is connected to.
+func (client *Client) ServerName() string {
+	if client.remote != nil {
+		return client.remote.server.name
+	}
+	return client.server.name
+}
+
+// ServerDescription returns the description of the server the client is connected to.
+func (client *Client) ServerDescription() string {
+	if client.remote != nil {
+		return client.remote.server.description
+	}
+	return client.server.Config().Linking.Description
+}
+
+func linkTags(message utils.SplitMessage) map[string]string {
+	return map[string]string{
+		"msgid": message.Msgid,
+		"time":  message.Time.Format(IRCv3TimestampFormat),
+	}
+}
+
+// linkMessageFromTags reconstructs a message with the msgid and time assigned
+// by the server where it originated.
+func linkMessageFromTags(msg *ircmsg.Message, text string) (result utils.SplitMessage) {
+	result.Message = text
+	if present, msgid := msg.GetTag("msgid"); present && msgid != "" {
+		result.Msgid = msgid
+	} else {
+		result.Msgid = utils.GenerateSecretToken()
+	}
+	if present, timeTag := msg.GetTag("time"); present {
+		if serverTime, err := time.Parse(IRCv3TimestampFormat, timeTag); err == nil {
+			result.Time = serverTime.UTC()
+		}
+	}
+	if result.Time.IsZero() {
+		result.SetTime()
+	}
+	return
+}
+
+// encodeLinkMember encodes a channel member and their channel modes for SJOIN.
+func encodeLinkMember(id string, memberModes modes.Modes) string {
+	if len(memberModes) == 0 {
+		return id
+	}
+	return memberModes.String() + ":" + id
+}
+
+// parseLinkMember decodes a member token from SJOIN.
+func parseLinkMember(token string) (id string, memberModes modes.Modes) {
+	colon := strings.IndexByte(token, ':')
+	if colon == -1 {
+		return token, nil
+	}
+	for _, mode := range token[:colon] {
+		for _, userMode := range modes.ChannelUserModes {
+			if modes.Mode(mode) == userMode {
+				memberModes = append(memberModes, userMode)
+				break
+			}
+		}
+	}
+	return token[colon+1:], memberModes
+}
+
+// uidMessage builds the UID line introducing a client.
+func (lm *LinkManager) uidMessage(client *Client) ircmsg.Message {
+	id := client.LinkID()
+	serverName := client.ServerName()
+	if client.remote == nil {
+		lm.Lock()
+		lm.clients[id] = client
+		lm.Unlock()
+	}
+
+	var umodes modes.Modes
+	for _, mode := range linkUserModes {
+		if client.HasMode(mode) {
+			umodes = append(umodes, mode)
+		}
+	}
+
+	client.stateMutex.RLock()
+	nick := client.nick
+	username := client.username
+	hostname := client.hostname
+	rawHostname := client.rawHostname
+	accountName := client.accountName
+	realname := client.realname
+	signon := client.ctime
+	client.stateMutex.RUnlock()
+
+	return ircmsg.MakeMessage(nil, serverName, "UID", id, nick, strconv.FormatInt(signon.Unix(), 10),
+		username, hostname, rawHostname, client.IPString(), accountName, "+"+umodes.String(), realname)
+}
+
+// linkModeStrings returns all the channel's modes and their arguments,
+// including the key.
+func (channel *Channel) linkModeStrings() (result []string) {
+	channel.stateMutex.RLock()
+	defer channel.stateMutex.RUnlock()
+
+	var mods strings.Builder
+	mods.WriteRune('+')
+	var args []string
+	if channel.key != "" {
+		mods.WriteRune(rune(modes.Key))
+		args = append(args, channel.key)
+	}
+	if channel.userLimit > 0 {
+		mods.WriteRune(rune(modes.UserLimit))
+		args = append(args, strconv.Itoa(channel.userLimit))
+	}
+	if channel.forward != "" {
+		mods.WriteRune(rune(modes.Forward))
+		args = append(args, channel.forward)
+	}
+	for _, m := range channel.flags.AllModes() {
+		mods.WriteRune(rune(m))
+	}
+	return append([]string{mods.String()}, args...)
+}
+
+// sendBurst sends our view of the network to a newly registered link.
+func (link *serverLink) sendBurst() {
+	lm := link.manager
+	server := lm.server
+
+	// other servers, ordered by distance so that uplinks are introduced first:
+	lm.RLock()
+	var servers []*remoteServer
+	for _, remote := range lm.servers {
+		if remote.link != link {
+			servers = append(servers, remote)
+		}
+	}
+	lm.RUnlock()
+	sort.Slice(servers, func(i, j int) bool { return servers[i].hops < servers[j].hops })
+	for _, remote := range servers {
+		link.send(nil, remote.uplink, "SID", remote.name, strconv.Itoa(remote.hops), remote.description)
+	}
+
+	for _, client := range server.clients.AllClients() {
+		if client.remote != nil && client.remote.server.link == link {
+			continue
+		}
+		msg := lm.uidMessage(client)
+		link.sendMessage(&msg)
+	}
+
+	for _, channel := range server.channels.Channels() {
+		link.sendChannelBurst(channel)
+	}
+
+	link.send(nil, server.name, "EOB")
+}
+
+func (link *serverLink) sendChannelBurst(channel *Channel) {
+	server := link.manager.server
+
+	channel.stateMutex.RLock()
+	chname := channel.name
+	ts := strconv.FormatInt(channel.createdTime.Unix(), 10)
+	topic := channel.topic
+	topicSetBy := channel.topicSetBy
+	topicSetTime := channel.topicSetTime
+	members := make(map[*Client]modes.Modes, len(channel.members))
+	for member, data := range channel.members {
+		members[member] = data.modes.AllModes()
+	}
+	channel.stateMutex.RUnlock()
+
+	var tokens []string
+	for member, memberModes := range members {
+		if member.remote != nil && member.remote.server.link == link {
+			continue
+		}
+		tokens = append(tokens, encodeLinkMember(member.LinkID(), memberModes))
+	}
+	if len(tokens) == 0 {
+		return
+	}
+
+	modeStrings := channel.linkModeStrings()
+	for i := 0; i < len(tokens); i += linkBurstBatchSize {
+		end := i + linkBurstBatchSize
+		if len(tokens) < end {
+			end = len(tokens)
+		}
+		params := append([]string{ts, chname}, modeStrings...)
+		params = append(params, strings.Join(tokens[i:end], " "))
+		link.send(nil, server.name, "SJOIN", params...)
+	}
+
+	if topic != "" {
+		link.send(nil, server.name, "TB", chname, strconv.FormatInt(topicSetTime.Unix(), 10), topicSetBy, topic)
+	}
+
+	for _, mode := range []modes.Mode{modes.BanMask, modes.ExceptMask, modes.InviteMask} {
+		var masks []string
+		for mask := range channel.lists[mode].Masks() {
+			masks = append(masks, mask)
+		}
+		for i := 0; i < len(masks); i += linkBurstBatchSize {
+			end := i + linkBurstBatchSize
+			if len(masks) < end {
+				end = len(masks)
+			}
+			link.send(nil, server.name, "BMASK", ts, chname, mode.String(), strings.Join(masks[i:end], " "))
+		}
+	}
+}
+
+//
+// hooks for local events that have to be relayed to the network
+//
+
+// IntroduceClient announces a newly registered local client to the network.
+func (lm *LinkManager) IntroduceClient(client *Client) {
+	if !lm.hasLinks() {
+		return
+	}
+	msg := lm.uidMessage(client)
+	lm.broadcastMessage(nil, &msg)
+}
+
+// NickChanged relays a local client's nickname change.
+func (lm *LinkManager) NickChanged(client *Client, message utils.SplitMessage) {
+	// XXX SANICK of a remote client is not propagated
+	if client.remote != nil || !lm.hasLinks() {
+		return
+	}
+	lm.broadcast(nil, linkTags(message), client.LinkID(), "NICK", client.Nick())
+}
+
+// ClientExited is called when any client, local or remote, is destroyed.
+func (lm *LinkManager) ClientExited(client *Client, quitMessage string) {
+	client.stateMutex.RLock()
+	id := client.linkID
+	var propagated bool
+	if client.remote != nil {
+		propagated = client.remote.propagated
+	}
+	client.stateMutex.RUnlock()
+
+	if id == "" {
+		return // never introduced to the network
+	}
+
+	lm.Lock()
+	if lm.clients[id] == client {
+		delete(lm.clients, id)
+	}
+	var split bool
+	if client.remote != nil {
+		split = client.remote.server.split
+	}
+	lm.Unlock()
+
+	if client.remote == nil {
+		lm.broadcast(nil, nil, id, "QUIT", quitMessage)
+		return
+	}
+	if split {
+		// the other servers will remove it when they process the SQUIT
+		return
+	}
+	link := client.remote.server.link
+	if !propagated {
+		// we removed it on our own initiative (KILL, nick collision, etc.);
+		// make sure its own server finds out
+		link.send(nil, lm.server.name, "KILL", id, quitMessage)
+	}
+	lm.broadcast(link, nil, id, "QUIT", quitMessage)
+}
+
+// ChannelJoined relays a local join.
+func (lm *LinkManager) ChannelJoined(channel *Channel, client *Client, givenMode modes.Mode, message utils.SplitMessage) {
+	if !lm.hasLinks() {
+		return
+	}
+	var memberModes modes.Modes
+	if givenMode != 0 {
+		memberModes = modes.Modes{givenMode}
+	}
+	channel.stateMutex.RLock()
+	ts := strconv.FormatInt(channel.createdTime.Unix(), 10)
+	channel.stateMutex.RUnlock()
+	params := append([]string{ts, channel.Name()}, channel.linkModeStrings()...)
+	params = append(params, encodeLinkMember(client.LinkID(), memberModes))
+	lm.broadcast(nil, linkTags(message), client.ServerName(), "SJOIN", params...)
+}
+
+// ChannelParted relays a local part.
+func (lm *LinkManager) ChannelParted(channel *Channel, client *Client, reason string, message utils.SplitMessage) {
+	if !lm.hasLinks() {
+		return
+	}
+	lm.broadcast(nil, linkTags(message), client.LinkID(), "PART", channel.Name(), reason)
+}
+
+// ChannelKicked relays a local kick.
+func (lm *LinkManager) ChannelKicked(channel *Channel, client, target *Client, comment string, message utils.SplitMessage) {
+	if !lm.hasLinks() {
+		return
+	}
+	lm.broadcast(nil, linkTags(message), client.LinkID(), "KICK", channel.Name(), target.LinkID(), comment)
+}
+
+// TopicChanged relays a local topic change.
+func (lm *LinkManager) TopicChanged(channel *Channel, client *Client, topic string, message utils.SplitMessage) {
+	if !lm.hasLinks() {
+		return
+	}
+	lm.broadcast(nil, linkTags(message), client.LinkID(), "TOPIC", channel.Name(), topic)
+}
+
+// Wallops relays a WALLOPS sent by a local client.
+func (lm *LinkManager) Wallops(client *Client, message utils.SplitMessage) {
+	if !lm.hasLinks() {
+		return
+	}
+	lm.broadcast(nil, linkTags(message), client.LinkID(), "WALLOPS", message.Message)
+}
+
+// Knock relays a KNOCK sent by a local client; rate limits and bans have
+// already been checked by our server.
+func (lm *LinkManager) Knock(client *Client, channel *Channel, reason string) {
+	if !lm.hasLinks() {
+		return
+	}
+	lm.broadcast(nil, nil, client.LinkID(), "KNOCK", channel.Name(), reason)
+}
+
+// Redact relays a REDACT sent by a local client: to the whole network for
+// a channel message, otherwise toward the server of a remote recipient.
+// Permissions have already been checked by our server.
+func (lm *LinkManager) Redact(client *Client, channel *Channel, recipient *Client, msgid, reason string, message utils.SplitMessage) {
+	if !lm.hasLinks() {
+		return
+	}
+	if channel != nil {
+		lm.broadcast(nil, linkTags(message), client.LinkID(), "REDACT", channel.Name(), msgid, reason)
+	} else if recipient.remote != nil {
+		msg := ircmsg.MakeMessage(linkTags(message), client.LinkID(), "REDACT", recipient.LinkID(), msgid, reason)
+		if data, err := msg.LineBytes(); err == nil {
+			recipient.remote.server.link.write(data)
+		}
+	}
+}
+
+// ChannelModesChanged relays channel mode changes made locally; `source` is the
+// nickmask of the client that made them, or the name of a service or server.
+func (lm *LinkManager) ChannelModesChanged(channel *Channel, applied modes.ModeChanges, source string, message utils.SplitMessage) {
+	if !lm.hasLinks() {
+		return
+	}
+	prefix := lm.server.name
+	if strings.IndexByte(source, '!') != -1 {
+		if client := lm.server.clients.Get(NUHToNick(source)); client != nil {
+			prefix = client.LinkID()
+		}
+	}
+	changes := make(modes.ModeChanges, 0, len(applied))
+	for _, change := range applied {
+		switch change.Mode {
+		case modes.ChannelFounder, modes.ChannelAdmin, modes.ChannelOperator, modes.Halfop, modes.Voice:
+			target := lm.server.clients.Get(change.Arg)
+			if target == nil {
+				continue
+			}
+			change.Arg = target.LinkID()
+		}
+		changes = append(changes, change)
+	}
+	if len(changes) == 0 {
+		return
+	}
+	params := append([]string{channel.Name()}, changes.Strings()...)
+	lm.broadcast(nil, linkTags(message), prefix, "MODE", params...)
+}
+
+// ChannelMessage relays a PRIVMSG, NOTICE or TAGMSG sent by a local client to a
+// channel; `target` may include a STATUSMSG prefix.
+func (lm *LinkManager) ChannelMessage(client *Client, tags map[string]string, command, target string, message utils.SplitMessage) {
+	if !lm.hasLinks() {
+		return
+	}
+	data, err := linkMessageData(client.LinkID(), tags, command, target, message)
+	if err == nil {
+		lm.broadcastData(nil, data)
+	}
+}
+
+// DirectMessage relays a direct message to a client on another server.
+func (lm *LinkManager) DirectMessage(client, target *Client, tags map[string]string, command string, message utils.SplitMessage) {
+	if target.remote == nil {
+		return
+	}
+	data, err := linkMessageData(client.LinkID(), tags, command, target.LinkID(), message)
+	if err == nil {
+		target.remote.server.link.write(data)
+	}
+}
+
+// linkMessageData assembles the line or lines that relay a message.
+func linkMessageData(prefix string, clientOnlyTags map[string]string, command, target string, message utils.SplitMessage) (data []byte, err error) {
+	tags := linkTags(message)
+	for name, value := range clientOnlyTags {
+		tags[name] = value
+	}
+	if message.Is512() {
+		params := []string{target}
+		if command != "TAGMSG" {
+			params = append(params, message.Message)
+		}
+		msg := ircmsg.MakeMessage(tags, prefix, command, params...)
+		return msg.LineBytes()
+	}
+
+	header := ircmsg.MakeMessage(tags, prefix, "MULTILINE", command, target, strconv.Itoa(len(message.Split)))
+	data, err = header.LineBytes()
+	if err != nil {
+		return
+	}
+	for _, pair := range message.Split {
+		concat := "0"
+		if pair.Concat {
+			concat = "1"
+		}
+		line := ircmsg.MakeMessage(nil, "", "LINE", concat, pair.Message)
+		lineBytes, err := line.LineBytes()
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, lineBytes...)
+	}
+	return
+}
+
+//
+// application of remote events to local state
+//
+
+// claimRemoteNick assigns a nickname to a client from another server, resolving
+// any collision in favor of the client that connected first. It returns false
+// if `client` lost and was removed.
+func (lm *LinkManager) claimRemoteNick(client *Client, nick string) (success bool) {
+	for i := 0; i < 2; i++ {
+		holder, err := lm.server.clients.setRemoteNick(client, nick)
+		if err == nil {
+			return true
+		} else if holder == nil {
+			lm.killCollided(client, "Invalid nickname")
+			return false
+		}
+
+		if holder.ctime.Before(client.ctime) {
+			lm.killCollided(client, "Nick collision")
+			return false
+		} else if client.ctime.Before(holder.ctime) {
+			lm.killCollided(holder, "Nick collision")
+		} else {
+			lm.killCollided(holder, "Nick collision")
+			lm.killCollided(client, "Nick collision")
+			return false
+		}
+	}
+	return false
+}
+
+func (lm *LinkManager) killCollided(client *Client, reason string) {
+	lm.server.snomasks.Send(sno.Links, fmt.Sprintf(ircfmt.Unescape("Removing $c[grey][$r%s$c[grey]]$r from %s: %s"), client.Nick(), client.ServerName(), reason))
+	client.Quit(reason, nil)
+	client.destroy(nil)
+}
+
+func linkServerHandler(link *serverLink, msg ircmsg.Message) error {
+	lm := link.manager
+	server := lm.server
+	if link.registered {
+		return errors.New("Already registered")
+	}
+
+	config := server.Config()
+	name, password, version, description := msg.Params[0], msg.Params[1], msg.Params[2], msg.Params[3]
+	cfname := strings.ToLower(name)
+	linkConfig, ok := config.Linking.links[cfname]
+	if !config.Linking.Enabled || !ok {
+		return fmt.Errorf("No link is configured for %s", name)
+	}
+	if link.outgoing && cfname != link.expectedName {
+		return fmt.Errorf("Expected server %s, got %s", link.expectedName, name)
+	}
+	if !utils.SecretTokensMatch(linkConfig.Password, password) {
+		return errors.New("Password incorrect")
+	}
+	if version != linkProtocolVersion {
+		return fmt.Errorf("Unsupported protocol version %s", version)
+	}
+	if linkConfig.certfp != "" && linkConfig.certfp != link.certfp {
+		return errors.New("Certificate fingerprint mismatch")
+	}
+
+	link.name = name
+	link.nameCasefolded = cfname
+	link.description = description
+	if !link.outgoing {
+		link.sendServer(linkConfig.Password, config)
+	}
+	if err := lm.addLink(link); err != nil {
+		return err
+	}
+	link.registered = true
+
+	server.logger.Info("linking", "Linked to server", name)
+	server.snomasks.Send(sno.Links, fmt.Sprintf(ircfmt.Unescape("Linked to server $c[grey][$r%s$c[grey]]$r (%s)"), name, description))
+	lm.broadcast(link, nil, server.name, "SID", name, "1", description)
+	link.sendBurst()
+	return nil
+}
+
+func linkErrorHandler(link *serverLink, msg ircmsg.Message) error {
+	reason := "Unknown error"
+	if len(msg.Params) != 0 {
+		reason = msg.Params[0]
+	}
+	return fmt.Errorf("Remote error: %s", reason)
+}
+
+func linkPingHandler(link *serverLink, msg ircmsg.Message) error {
+	link.send(nil, "", "PONG", msg.Params[0])
+	return nil
+}
+
+func linkPongHandler(link *serverLink, msg ircmsg.Message) error {
+	// the read deadline takes care of timeouts
+	return nil
+}
+
+func linkEobHandler(link *serverLink, msg ircmsg.Message) error {
+	lm := link.manager
+	lm.server.logger.Debug("linking", "End of burst", msg.Prefix)
+	lm.broadcastMessage(link, &msg)
+	return nil
+}
+
+// :<uplink> SID <name> <hops> :<description>
+func linkSidHandler(link *serverLink, msg ircmsg.Message) error {
+	lm := link.manager
+	uplink := lm.getServer(msg.Prefix)
+	if uplink == nil || uplink.link != link {
+		return fmt.Errorf("SID from unknown server %s", msg.Prefix)
+	}
+	name, description := msg.Params[0], msg.Params[2]
+	if !utils.IsServerName(name) {
+		return fmt.Errorf("Invalid server name %s", name)
+	}
+	hops, err := strconv.Atoi(msg.Params[1])
+	if err != nil {
+		return errors.New("Invalid hop count")
+	}
+	remote := &remoteServer{
+		name:        name,
+		description: description,
+		hops:        hops + 1,
+		uplink:      uplink.name,
+		link:        link,
+	}
+
+	cfname := strings.ToLower(name)
+	lm.Lock()
+	exists := cfname == lm.server.nameCasefolded || lm.servers[cfname] != nil
+	if !exists {
+		lm.servers[cfname] = remote
+	}
+	lm.Unlock()
+	if exists {
+		// a loop in the network: refuse it by dropping the newer link
+		return fmt.Errorf("Server %s already exists", name)
+	}
+
+	lm.server.snomasks.Send(sno.Links, fmt.Sprintf(ircfmt.Unescape("Server $c[grey][$r%s$c[grey]]$r joined the network via %s"), name, uplink.name))
+	lm.broadcast(link, nil, uplink.name, "SID", name, strconv.Itoa(remote.hops), description)
+	return nil
+}
+
+// :<server> SQUIT <name> :<reason>
+func linkSquitHandler(link *serverLink, msg ircmsg.Message) error {
+	lm := link.manager
+	reason := ""
+	if len(msg.Params) > 1 {
+		reason = msg.Params[1]
+	}
+
+	cfname := strings.ToLower(msg.Params[0])
+	lm.Lock()
+	remote := lm.servers[cfname]
+	var split utils.StringSet
+	if remote != nil && remote.link == link && remote.hops > 1 {
+		split = lm.splitServersNoMutex(cfname)
+	}
+	lm.Unlock()
+	if split == nil {
+		return nil
+	}
+
+	lm.server.snomasks.Send(sno.Links, fmt.Sprintf(ircfmt.Unescape("Server $c[grey][$r%s$c[grey]]$r split from %s: %s"), remote.name, remote.uplink, reason))
+	lm.removeServerClients(split, fmt.Sprintf("%s %s", remote.uplink, remote.name))
+	lm.broadcastMessage(link, &msg)
+	return nil
+}
+
+// :<server> UID <id> <nick> <signon ts> <username> <hostname> <raw hostname> <ip> <account name> <+umodes> :<realname>
+func linkUidHandler(link *serverLink, msg ircmsg.Message) error {
+	lm := link.manager
+	server := lm.server
+	origin := lm.getServer(msg.Prefix)
+	if origin == nil || origin.link != link {
+		return fmt.Errorf("UID from unknown server %s", msg.Prefix)
+	}
+	id, nick := msg.Params[0], msg.Params[1]
+	if lm.getClient(id) != nil {
+		// duplicate introduction, e.g., a registration that raced with the burst
+		return nil
+	}
+	signon, err := strconv.ParseInt(msg.Params[2], 10, 64)
+	if err != nil {
+		return errors.New("Invalid signon time")
+	}
+	ip := net.ParseIP(msg.Params[6])
+	if ip == nil {
+		ip = utils.IPv4LoopbackAddress
+	}
+
+	client := &Client{
+		lastActive: time.Now().UTC(),
+		channels:   make(ChannelSet),
+		ctime:      time.Unix(signon, 0).UTC(),
+		languages:  server.Languages().Default(),
+		server:     server,
+
+		username:    msg.Params[3],
+		rawHostname: msg.Params[5],
+		realIP:      ip,
+		realname:    msg.Params[9],
+		// accounts are not shared between servers, so remote clients are never
+		// logged into a local account; the account name is for display only
+		accountName: msg.Params[7],
+
+		linkID: id,
+		remote: &remoteClient{server: origin},
+
+		writerSemaphore: utils.NewSemaphore(1),
+	}
+	if msg.Params[4] != msg.Params[5] {
+		client.vhost = msg.Params[4]
+	}
+	for _, mode := range strings.TrimPrefix(msg.Params[8], "+") {
+		for _, linkMode := range linkUserModes {
+			if modes.Mode(mode) == linkMode {
+				client.SetMode(linkMode, true)
+			}
+		}
+	}
+	client.history.Initialize(0, 0)
+
+	lm.Lock()
+	lm.clients[id] = client
+	lm.Unlock()
+
+	if !lm.claimRemoteNick(client, nick) {
+		return nil
+	}
+
+	server.monitorManager.AlertAbout(client.Nick(), client.NickCasefolded(), true)
+	lm.broadcastMessage(link, &msg)
+	return nil
+}
+
+// :<id> NICK <nick>
+func linkNickHandler(link *serverLink, msg ircmsg.Message) error {
+	lm := link.manager
+	server := lm.server
+	client := lm.linkClient(link, msg.Prefix)
+	if client == nil {
+		return nil
+	}
+
+	details := client.Details()
+	if !lm.claimRemoteNick(client, msg.Params[0]) {
+		return nil
+	}
+	newNick := client.Nick()
+	newCfnick := client.NickCasefolded()
+
+	message := linkMessageFromTags(&msg, "")
+	isBot := client.HasMode(modes.Bot)
+	server.whoWas.Append(details.WhoWas)
+	for session := range client.Friends() {
+		session.sendFromClientInternal(false, message.Time, message.Msgid, details.nickMask, details.accountName, isBot, nil, "NICK", newNick)
+	}
+
+	histItem := history.Item{
+		Type:        history.Nick,
+		Nick:        details.nickMask,
+		AccountName: details.accountName,
+		Message:     message,
+		IsBot:       isBot,
+	}
+	histItem.Params[0] = newNick
+	for _, channel := range client.Channels() {
+		channel.AddHistoryItem(histItem, details.account)
+	}
+
+	if newCfnick != details.nickCasefolded {
+		server.monitorManager.AlertAbout(details.nick, details.nickCasefolded, false)
+		server.monitorManager.AlertAbout(newNick, newCfnick, true)
+	}
+
+	lm.broadcastMessage(link, &msg)
+	return nil
+}
+
+// :<id> QUIT :<reason>
+func linkQuitHandler(link *serverLink, msg ircmsg.Message) error {
+	lm := link.manager
+	client := lm.linkClient(link, msg.Prefix)
+	if client == nil {
+		return nil
+	}
+	reason := ""
+	if len(msg.Params) != 0 {
+		reason = msg.Params[0]
+	}
+	// ClientExited will relay the QUIT
+	lm.removeRemoteClient(client, reason)
+	return nil
+}
+
+// :<server> KILL <id> :<quit message>
+func linkKillHandler(link *serverLink, msg ircmsg.Message) error {
+	lm := link.manager
+	target := lm.getClient(msg.Params[0])
+	if target == nil {
+		return nil
+	}
+	reason := msg.Params[1]
+	if target.remote != nil && target.remote.server.link == link {
+		// the KILL came from the target's own direction, so it's already known there
+		lm.removeRemoteClient(target, reason)
+		return nil
+	}
+	if target.remote == nil {
+		lm.server.snomasks.Send(sno.LocalKills, fmt.Sprintf(ircfmt.Unescape("%s$r was killed by %s $c[grey][$r%s$c[grey]]"), target.Nick(), msg.Prefix, reason))
+	}
+	// ClientExited will send the KILL on toward a remote target, and the QUIT
+	// everywhere else
+	target.Quit(reason, nil)
+	target.destroy(nil)
+	return nil
+}
+
+// :<id> WALLOPS :<text>
+func linkWallopsHandler(link *serverLink, msg ircmsg.Message) error {
+	lm := link.manager
+	client := lm.linkClient(link, msg.Prefix)
+	if client == nil {
+		return nil
+	}
+	lm.server.deliverWallops(client, linkMessageFromTags(&msg, msg.Params[0]), nil)
+	lm.broadcastMessage(link, &msg)
+	return nil
+}
+
+// :<id> KNOCK <channel> :<reason>
+func linkKnockHandler(link *serverLink, msg ircmsg.Message) error {
+	lm := link.manager
+	client := lm.linkClient(link, msg.Prefix)
+	if client == nil {
+		return nil
+	}
+	if channel := lm.server.channels.Get(msg.Params[0]); channel != nil {
+		channel.notifyKnock(client.NickMaskString(), msg.Params[1])
+	}
+	lm.broadcastMessage(link, &msg)
+	return nil
+}
+
+// :<id> REDACT <channel|id> <msgid> :<reason>
+func linkRedactHandler(link *serverLink, msg ircmsg.Message) error {
+	lm := link.manager
+	server := lm.server
+	client := lm.linkClient(link, msg.Prefix)
+	if client == nil {
+		return nil
+	}
+	msgid, reason := msg.Params[1], msg.Params[2]
+	message := linkMessageFromTags(&msg, "")
+
+	if strings.HasPrefix(msg.Params[0], "#") {
+		if channel := server.channels.Get(msg.Params[0]); channel != nil {
+			chname := channel.Name()
+			server.DeleteMessage(chname, msgid, "*", time.Time{})
+			server.deliverRedact(client, channel.Members(), chname, msgid, reason, message, nil)
+		}
+		lm.broadcastMessage(link, &msg)
+		return nil
+	}
+
+	user := lm.getClient(msg.Params[0])
+	if user == nil {
+		return nil
+	}
+	if user.remote != nil {
+		// route it toward the recipient's server
+		if user.remote.server.link != link {
+			if data, err := msg.LineBytes(); err == nil {
+				user.remote.server.link.write(data)
+			}
+		}
+		return nil
+	}
+	nick := user.Nick()
+	server.DeleteMessage(nick, msgid, "*", time.Time{})
+	server.deliverRedact(client, []*Client{user}, nick, msgid, reason, message, nil)
+	return nil
+}
+
+// :<server> SJOIN <channel ts> <channel> <+modes> [mode args...] :<members>
+func linkSjoinHandler(link *serverLink, msg ircmsg.Message) error {
+	lm := link.manager
+	server := lm.server
+	ts, err := strconv.ParseInt(msg.Params[0], 10, 64)
+	if err != nil {
+		return errors.New("Invalid channel timestamp")
+	}
+	chname := msg.Params[1]
+	modeParams := msg.Params[2 : len(msg.Params)-1]
+	members := strings.Fields(msg.Params[len(msg.Params)-1])
+
+	// SJOIN for a single member is a live join, and carries the msgid:
+	var message utils.SplitMessage
+	if len(members) == 1 {
+		message = linkMessageFromTags(&msg, "")
+	}
+	for _, token := range members {
+		id, memberModes := parseLinkMember(token)
+		client := lm.linkClient(link, id)
+		if client == nil {
+			continue
+		}
+		if len(members) != 1 {
+			message = utils.MakeMessage("")
+		}
+		lm.remoteJoin(client, chname, memberModes, message)
+	}
+
+	if channel := server.channels.Get(chname); channel != nil {
+		channel.stateMutex.Lock()
+		if ts < channel.createdTime.Unix() {
+			channel.createdTime = time.Unix(ts, 0).UTC()
+		}
+		channel.stateMutex.Unlock()
+		changes, _ := modes.ParseChannelModeChanges(modeParams...)
+		lm.applyRemoteModes(channel, changes, msg.Prefix, "*", false, utils.MakeMessage(""))
+	}
+
+	lm.broadcastMessage(link, &msg)
+	return nil
+}
+
+// remoteJoin joins a remote client to a channel, creating it if necessary.
+func (lm *LinkManager) remoteJoin(client *Client, chname string, memberModes modes.Modes, message utils.SplitMessage) {
+	server := lm.server
+	existing := server.channels.Get(chname)
+	wasMember := existing != nil && existing.hasClient(client)
+
+	// XXX isSajoin=true: the other server already checked whether the join was allowed
+	err, _ := server.channels.Join(client, chname, "", true, nil)
+	channel := server.channels.Get(chname)
+	if err != nil || channel == nil {
+		server.logger.Warning("linking", "Could not join remote client to channel", client.Nick(), chname)
+		return
+	}
+
+	// the member modes from the other server are authoritative; in particular,
+	// Join may have given the client ops for creating the channel
+	channel.stateMutex.Lock()
+	data, present := channel.members[client]
+	if present {
+		if !wasMember {
+			data.modes = modes.NewModeSet()
+		}
+		for _, mode := range memberModes {
+			data.modes.SetMode(mode, true)
+		}
+		channel.members[client] = data
+	}
+	channel.stateMutex.Unlock()
+	if !present || wasMember {
+		return
+	}
+
+	details := client.Details()
+	isBot := client.HasMode(modes.Bot)
+	chname = channel.Name()
+	respectAuditorium := len(memberModes) == 0 && channel.flags.HasMode(modes.Auditorium)
+
+	var modeParams []string
+	if len(memberModes) != 0 {
+		modeParams = []string{chname, "+" + memberModes.String()}
+		for range memberModes {
+			modeParams = append(modeParams, details.nick)
+		}
+	}
+
+	var cache MessageCache
+	cache.Initialize(server, message.Time, message.Msgid, details.nickMask, details.accountName, isBot, nil, "JOIN", chname)
+	for _, member := range channel.Members() {
+		if respectAuditorium && !channel.ClientIsAtLeast(member, modes.Voice) {
+			continue
+		}
+		for _, session := range member.Sessions() {
+			if session.capabilities.Has(caps.ExtendedJoin) {
+				session.sendFromClientInternal(false, message.Time, message.Msgid, details.nickMask, details.accountName, isBot, nil, "JOIN", chname, details.accountName, details.realname)
+			} else {
+				cache.Send(session)
+			}
+			if modeParams != nil {
+				session.Send(nil, client.ServerName(), "MODE", modeParams...)
+			}
+		}
+	}
+
+	if !respectAuditorium {
+		histItem := history.Item{
+			Type:        history.Join,
+			Nick:        details.nickMask,
+			AccountName: details.accountName,
+			Message:     message,
+			IsBot:       isBot,
+		}
+		histItem.Params[0] = details.realname
+		channel.AddHistoryItem(histItem, details.account)
+	}
+}
+
+// :<id> PART <channel> [:<reason>]
+func linkPartHandler(link *serverLink, msg ircmsg.Message) error {
+	lm := link.manager
+	client := lm.linkClient(link, msg.Prefix)
+	if client == nil {
+		return nil
+	}
+	reason := ""
+	if len(msg.Params) > 1 {
+		reason = msg.Params[1]
+	}
+	if channel := lm.server.channels.Get(msg.Params[0]); channel != nil {
+		channel.remotePart(client, reason, linkMessageFromTags(&msg, reason))
+	}
+	lm.broadcastMessage(link, &msg)
+	return nil
+}
+
+func (channel *Channel) remotePart(client *Client, reason string, message utils.SplitMessage) {
+	channel.stateMutex.RLock()
+	chname := channel.name
+	clientData, ok := channel.members[client]
+	channel.stateMutex.RUnlock()
+	if !ok {
+		return
+	}
+
+	channel.Quit(client)
+
+	details := client.Details()
+	isBot := client.HasMode(modes.Bot)
+	params := []string{chname}
+	if reason != "" {
+		params = append(params, reason)
+	}
+	respectAuditorium := channel.flags.HasMode(modes.Auditorium) &&
+		clientData.modes.HighestChannelUserMode() == modes.Mode(0)
+	var cache MessageCache
+	cache.Initialize(channel.server, message.Time, message.Msgid, details.nickMask, details.accountName, isBot, nil, "PART", params...)
+	for _, member := range channel.Members() {
+		if respectAuditorium && !channel.ClientIsAtLeast(member, modes.Voice) {
+			continue
+		}
+		for _, session := range member.Sessions() {
+			cache.Send(session)
+		}
+	}
+
+	if !respectAuditorium {
+		channel.AddHistoryItem(history.Item{
+			Type:        history.Part,
+			Nick:        details.nickMask,
+			AccountName: details.accountName,
+			Message:     message,
+			IsBot:       isBot,
+		}, details.account)
+	}
+}
+
+// :<id> KICK <channel> <id> :<comment>
+func linkKickHandler(link *serverLink, msg ircmsg.Message) error {
+	lm := link.manager
+	client := lm.linkClient(link, msg.Prefix)
+	target := lm.getClient(msg.Params[1])
+	if client == nil || target == nil {
+		return nil
+	}
+	comment := msg.Params[2]
+	if channel := lm.server.channels.Get(msg.Params[0]); channel != nil && channel.hasClient(target) {
+		message := linkMessageFromTags(&msg, comment)
+		details := client.Details()
+		isBot := client.HasMode(modes.Bot)
+		chname := channel.Name()
+		targetNick := target.Nick()
+		for _, member := range channel.Members() {
+			for _, session := range member.Sessions() {
+				session.sendFromClientInternal(false, message.Time, message.Msgid, details.nickMask, details.accountName, isBot, nil, "KICK", chname, targetNick, comment)
+			}
+		}
+		histItem := history.Item{
+			Type:        history.Kick,
+			Nick:        details.nickMask,
+			AccountName: details.accountName,
+			Message:     message,
+			IsBot:       isBot,
+		}
+		histItem.Params[0] = targetNick
+		channel.AddHistoryItem(histItem, details.account)
+		channel.Quit(target)
+	}
+	lm.broadcastMessage(link, &msg)
+	return nil
+}
+
+// :<id> TOPIC <channel> :<topic>
+func linkTopicHandler(link *serverLink, msg ircmsg.Message) error {
+	lm := link.manager
+	client := lm.linkClient(link, msg.Prefix)
+	if client == nil {
+		return nil
+	}
+	if channel := lm.server.channels.Get(msg.Params[0]); channel != nil {
+		details := client.Details()
+		message := linkMessageFromTags(&msg, msg.Params[1])
+		channel.setRemoteTopic(msg.Params[1], details.nickMask, message.Time, details.accountName, client.HasMode(modes.Bot), message)
+		channel.AddHistoryItem(history.Item{
+			Type:        history.Topic,
+			Nick:        details.nickMask,
+			AccountName: details.accountName,
+			Message:     message,
+			IsBot:       client.HasMode(modes.Bot),
+		}, details.account)
+	}
+	lm.broadcastMessage(link, &msg)
+	return nil
+}
+
+// :<server> TB <channel> <topic ts> <setter> :<topic>
+func linkTbHandler(link *serverLink, msg ircmsg.Message) error {
+	lm := link.manager
+	ts, err := strconv.ParseInt(msg.Params[1], 10, 64)
+	if err != nil {
+		return errors.New("Invalid topic timestamp")
+	}
+	if channel := lm.server.channels.Get(msg.Params[0]); channel != nil {
+		setTime := time.Unix(ts, 0).UTC()
+		channel.stateMutex.RLock()
+		newer := channel.topic == "" || channel.topicSetTime.Before(setTime)
+		channel.stateMutex.RUnlock()
+		// when both sides of a netjoin have a topic, the newer one wins
+		if newer {
+			channel.setRemoteTopic(msg.Params[3], msg.Params[2], setTime, "*", false, utils.MakeMessage(msg.Params[3]))
+		}
+	}
+	lm.broadcastMessage(link, &msg)
+	return nil
+}
+
+// setRemoteTopic sets a topic that was changed on another server, and announces
+// it to local members.
+func (channel *Channel) setRemoteTopic(topic, setBy string, setTime time.Time, accountName string, isBot bool, message utils.SplitMessage) {
+	channel.stateMutex.Lock()
+	chname := channel.name
+	changed := channel.topic != topic
+	channel.topic = topic
+	channel.topicSetBy = setBy
+	channel.topicSetTime = setTime
+	channel.stateMutex.Unlock()
+
+	if changed {
+		for _, member := range channel.Members() {
+			for _, session := range member.Sessions() {
+				session.sendFromClientInternal(false, message.Time, message.Msgid, setBy, accountName, isBot, nil, "TOPIC", chname, topic)
+			}
+		}
+	}
+	channel.MarkDirty(IncludeTopic)
+}
+
+// :<server> BMASK <channel ts> <channel> <b|e|I> :<masks>
+func linkBmaskHandler(link *serverLink, msg ircmsg.Message) error {
+	lm := link.manager
+	if channel := lm.server.channels.Get(msg.Params[1]); channel != nil {
+		var changes modes.ModeChanges
+		for _, mask := range strings.Fields(msg.Params[3]) {
+			changes = append(changes, modes.ModeChange{Mode: modes.Mode(msg.Params[2][0]), Op: modes.Add, Arg: mask})
+		}
+		lm.applyRemoteModes(channel, changes, msg.Prefix, "*", false, utils.MakeMessage(""))
+	}
+	lm.broadcastMessage(link, &msg)
+	return nil
+}
+
+// :<id|server> MODE <channel> <modes> [args...]
+func linkModeHandler(link *serverLink, msg ircmsg.Message) error {
+	lm := link.manager
+	source, accountName, isBot := msg.Prefix, "*", false
+	if client := lm.linkClient(link, msg.Prefix); client != nil {
+		details := client.Details()
+		source, accountName, isBot = details.nickMask, details.accountName, client.HasMode(modes.Bot)
+	} else if lm.getServer(msg.Prefix) == nil {
+		return nil
+	}
+	if channel := lm.server.channels.Get(msg.Params[0]); channel != nil {
+		changes, _ := modes.ParseChannelModeChanges(msg.Params[1:]...)
+		lm.applyRemoteModes(channel, changes, source, accountName, isBot, linkMessageFromTags(&msg, ""))
+	}
+	lm.broadcastMessage(link, &msg)
+	return nil
+}
+
+// applyRemoteModes applies channel mode changes made on another server, and
+// announces the ones that changed anything to local members. Member modes
+// refer to their targets by link ID.
+func (lm *LinkManager) applyRemoteModes(channel *Channel, changes modes.ModeChanges, source, accountName string, isBot bool, message utils.SplitMessage) {
+	var applied modes.ModeChanges
+	for _, change := range changes {
+		if change.Op == modes.List {
+			continue
+		}
+		switch change.Mode {
+		case modes.BanMask, modes.ExceptMask, modes.InviteMask:
+			var result string
+			if change.Op == modes.Add {
+				result, _ = channel.lists[change.Mode].Add(change.Arg, source, accountName)
+			} else {
+				result, _ = channel.lists[change.Mode].Remove(change.Arg)
+			}
+			if result != "" {
+				change.Arg = result
+				applied = append(applied, change)
+			}
+		case modes.UserLimit:
+			limit := 0
+			if change.Op == modes.Add {
+				limit, _ = strconv.Atoi(change.Arg)
+			}
+			channel.setUserLimit(limit)
+			applied = append(applied, change)
+		case modes.Forward:
+			if change.Op == modes.Add {
+				channel.setForward(change.Arg)
+			} else {
+				channel.setForward("")
+			}
+			applied = append(applied, change)
+		case modes.Key:
+			if change.Op == modes.Add {
+				channel.setKey(change.Arg)
+			} else {
+				channel.setKey("")
+			}
+			applied = append(applied, change)
+		case modes.ChannelFounder, modes.ChannelAdmin, modes.ChannelOperator, modes.Halfop, modes.Voice:
+			target := lm.getClient(change.Arg)
+			if target == nil {
+				continue
+			}
+			channel.stateMutex.Lock()
+			data, present := channel.members[target]
+			changed := present && data.modes.SetMode(change.Mode, change.Op == modes.Add)
+			channel.stateMutex.Unlock()
+			if changed {
+				change.Arg = target.Nick()
+				applied = append(applied, change)
+				target.markDirty(IncludeChannels)
+			}
+		default:
+			if channel.flags.SetMode(change.Mode, change.Op == modes.Add) {
+				applied = append(applied, change)
+			}
+		}
+	}
+
+	if len(applied) == 0 {
+		return
+	}
+
+	var includeFlags uint
+	for _, change := range applied {
+		switch change.Mode {
+		case modes.BanMask, modes.ExceptMask, modes.InviteMask:
+			includeFlags |= IncludeLists
+		case modes.ChannelFounder, modes.ChannelAdmin, modes.ChannelOperator, modes.Halfop, modes.Voice:
+		default:
+			includeFlags |= IncludeModes
+		}
+	}
+	if includeFlags != 0 {
+		channel.MarkDirty(includeFlags)
+	}
+
+	changeStrings := applied.Strings()
+	for _, changeString := range changeStrings {
+		message.Split = append(message.Split, utils.MessagePair{Message: changeString})
+	}
+	params := append([]string{channel.Name()}, changeStrings...)
+	for _, member := range channel.Members() {
+		for _, session := range member.Sessions() {
+			session.sendFromClientInternal(false, message.Time, message.Msgid, source, accountName, isBot, nil, "MODE", params...)
+		}
+	}
+	channel.AddHistoryItem(history.Item{
+		Type:        history.Mode,
+		Nick:        source,
+		AccountName: accountName,
+		Message:     message,
+		IsBot:       isBot,
+	}, "")
+}
+
+// :<id> PRIVMSG|NOTICE|TAGMSG <channel|id> [:<text>]
+func linkMessageHandler(link *serverLink, msg ircmsg.Message) error {
+	lm := link.manager
+	client := lm.linkClient(link, msg.Prefix)
+	if client == nil {
+		return nil
+	}
+	text := ""
+	if msg.Command != "TAGMSG" {
+		text = msg.Params[1]
+	}
+	lm.deliverRemoteMessage(link, client, msg.ClientOnlyTags(), msg.Command, msg.Params[0], linkMessageFromTags(&msg, text))
+	return nil
+}
+
+// :<id> MULTILINE <PRIVMSG|NOTICE> <channel|id> <line count>
+func linkMultilineHandler(link *serverLink, msg ircmsg.Message) error {
+	lm := link.manager
+	count, err := strconv.Atoi(msg.Params[2])
+	if err != nil || count <= 0 || linkMaxMultilineLines < count {
+		return errors.New("Invalid MULTILINE line count")
+	}
+	message := linkMessageFromTags(&msg, "")
+	link.multiline = &linkMultiline{
+		source:    lm.linkClient(link, msg.Prefix),
+		tags:      msg.ClientOnlyTags(),
+		command:   msg.Params[0],
+		target:    msg.Params[1],
+		remaining: count,
+		message:   message,
+	}
+	return nil
+}
+
+// LINE <0|1> :<text>
+func linkLineHandler(link *serverLink, msg ircmsg.Message) error {
+	multiline := link.multiline
+	if multiline == nil {
+		return errors.New("LINE outside of MULTILINE")
+	}
+	multiline.message.Append(msg.Params[1], msg.Params[0] == "1")
+	multiline.remaining--
+	if multiline.remaining == 0 {
+		link.multiline = nil
+		if multiline.source != nil {
+			link.manager.deliverRemoteMessage(link, multiline.source, multiline.tags, multiline.command, multiline.target, multiline.message)
+		}
+	}
+	return nil
+}
+
+// deliverRemoteMessage delivers a message from a remote client to local
+// recipients, and relays it onward.
+func (lm *LinkManager) deliverRemoteMessage(link *serverLink, client *Client, tags map[string]string, command, target string, message utils.SplitMessage) {
+	histType, err := msgCommandToHistType(command)
+	if err != nil {
+		return
+	}
+	server := lm.server
+	details := client.Details()
+	isBot := client.HasMode(modes.Bot)
+
+	prefixes, chname := modes.SplitChannelMembershipPrefixes(target)
+	if strings.HasPrefix(chname, "#") {
+		if channel := server.channels.Get(chname); channel != nil {
+			minPrefixMode := modes.GetLowestChannelModePrefix(prefixes)
+			isCTCP := message.IsRestrictedCTCPMessage()
+			var cache MessageCache
+			cache.InitializeSplitMessage(server, details.nickMask, details.accountName, isBot, tags, command, target, message)
+			for _, member := range channel.Members() {
+				if minPrefixMode != modes.Mode(0) && !channel.ClientIsAtLeast(member, minPrefixMode) {
+					continue
+				}
+				for _, session := range member.Sessions() {
+					if isCTCP && session.isTor {
+						continue // #753
+					}
+					cache.Send(session)
+				}
+			}
+			if minPrefixMode == modes.Mode(0) {
+				channel.AddHistoryItem(history.Item{
+					Type:        histType,
+					Message:     message,
+					Nick:        details.nickMask,
+					AccountName: details.accountName,
+					Tags:        tags,
+					IsBot:       isBot,
+				}, details.account)
+			}
+		}
+		if data, err := linkMessageData(client.LinkID(), tags, command, target, message); err == nil {
+			lm.broadcastData(link, data)
+		}
+		return
+	}
+
+	user := lm.getClient(target)
+	if user == nil {
+		return
+	}
+	if user.remote != nil {
+		// route it toward the target's server
+		if user.remote.server.link != link {
+			lm.DirectMessage(client, user, tags, command, message)
+		}
+		return
+	}
+
+	if user.HasMode(modes.UserNoCTCP) && message.IsRestrictedCTCPMessage() {
+		return
+	}
+	if user.isSilenced(client) {
+		return
+	}
+	if !user.acceptsMessagesFrom(client) {
+		// the sender's server can't relay our numerics, but the target is still notified
+		if histType != history.Tagmsg {
+			user.rejectCallerID(client, nil)
+		}
+		return
+	}
+	tDetails := user.Details()
+	for _, session := range user.Sessions() {
+		hasTagsCap := session.capabilities.Has(caps.MessageTags)
+		if histType == history.Tagmsg && hasTagsCap {
+			session.sendFromClientInternal(false, message.Time, message.Msgid, details.nickMask, details.accountName, isBot, tags, command, tDetails.nick)
+		} else if histType != history.Tagmsg && !(session.isTor && message.IsRestrictedCTCPMessage()) {
+			tagsToSend := tags
+			if !hasTagsCap {
+				tagsToSend = nil
+			}
+			session.sendSplitMsgFromClientInternal(false, details.nickMask, details.accountName, isBot, tagsToSend, command, tDetails.nick, message)
+		}
+	}
+
+	config := server.Config()
+	if config.History.Enabled {
+		item := history.Item{
+			Type:    histType,
+			Message: message,
+			Tags:    tags,
+		}
+		client.addHistoryItem(user, item, &details, &tDetails, config)
+	}
+}
+
+//
+// LINKS and MAP
+//
+
+// linkedServer is a snapshot of a server on the network, for LINKS and MAP.
+type linkedServer struct {
+	name        string
+	description string
+	hops        int
+	uplink      string
+	users       int
+}
+
+// Servers returns every server on the network, starting with this one.
+func (lm *LinkManager) Servers() (result []linkedServer) {
+	config := lm.server.Config()
+	users := make(map[*remoteServer]int)
+	localUsers := 0
+	for _, client := range lm.server.clients.AllClients() {
+		if client.remote == nil {
+			localUsers++
+		} else {
+			users[client.remote.server]++
+		}
+	}
+
+	result = append(result, linkedServer{
+		name:        lm.server.name,
+		description: config.Linking.Description,
+		uplink:      lm.server.name,
+		users:       localUsers,
+	})
+
+	lm.RLock()
+	for _, remote := range lm.servers {
+		result = append(result, linkedServer{
+			name:        remote.name,
+			description: remote.description,
+			hops:        remote.hops,
+			uplink:      remote.uplink,
+			users:       users[remote],
+		})
+	}
+	lm.RUnlock()
+
+	sort.SliceStable(result[1:], func(i, j int) bool {
+		a, b := result[1+i], result[1+j]
+		if a.hops != b.hops {
+			return a.hops < b.hops
+		}
+		return a.name < b.name
+	})
+	return
+}
+
+// LinkCounts returns the number of other servers on the network, the number
+// of direct links, and the number and invisible number of remote clients.
+func (lm *LinkManager) LinkCounts() (servers, links, remoteClients, remoteInvisible int) {
+	lm.RLock()
+	servers = len(lm.servers)
+	links = len(lm.links)
+	var remote []*Client
+	for _, client := range lm.clients {
+		if client.remote != nil {
+			remote = append(remote, client)
+		}
+	}
+	lm.RUnlock()
+
+	remoteClients = len(remote)
+	for _, client := range remote {
+		if client.HasMode(modes.Invisible) {
+			remoteInvisible++
+		}
+	}
+	return
+}
+
+// mapLines renders the network as a tree, for MAP.
+func mapLines(servers []linkedServer) (lines []string) {
+	children := make(map[string][]linkedServer)
+	for _, server := range servers[1:] {
+		uplink := strings.ToLower(server.uplink)
+		children[uplink] = append(children[uplink], server)
+	}
+
+	var render func(server linkedServer, indent string, last bool, root bool)
+	render = func(server linkedServer, indent string, last bool, root bool) {
+		prefix, childIndent := "", ""
+		if !root {
+			if last {
+				prefix, childIndent = indent+"`-", indent+"  "
+			} else {
+				prefix, childIndent = indent+"|-", indent+"| "
+			}
+		}
+		lines = append(lines, fmt.Sprintf("%s%s [%d users]", prefix, server.name, server.users))
+		serverChildren := children[strings.ToLower(server.name)]
+		for i, child := range serverChildren {
+			render(child, childIndent, i == len(serverChildren)-1, false)
+		}
+	}
+	render(servers[0], "", true, true)
+	return
+}
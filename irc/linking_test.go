@@ -0,0 +1,242 @@
+// Copyright (c) 2026 agent <agent@local>
+// released under the MIT license
+
+package irc
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ergochat/ergo/irc/mkcerts"
+	"github.com/ergochat/ergo/irc/modes"
+	"github.com/ergochat/ergo/irc/utils"
+)
+
+func TestLinkMemberEncoding(t *testing.T) {
+	token := encodeLinkMember("abcdef", modes.Modes{modes.ChannelOperator, modes.Voice})
+	if token != "ov:abcdef" {
+		t.Errorf("unexpected member token %s", token)
+	}
+	id, memberModes := parseLinkMember(token)
+	if id != "abcdef" || !reflect.DeepEqual(memberModes, modes.Modes{modes.ChannelOperator, modes.Voice}) {
+		t.Errorf("unexpected decoding %s %v", id, memberModes)
+	}
+
+	token = encodeLinkMember("abcdef", nil)
+	if token != "abcdef" {
+		t.Errorf("unexpected member token %s", token)
+	}
+	id, memberModes = parseLinkMember(token)
+	if id != "abcdef" || len(memberModes) != 0 {
+		t.Errorf("unexpected decoding %s %v", id, memberModes)
+	}
+
+	// unknown channel user modes are ignored:
+	id, memberModes = parseLinkMember("xh:abcdef")
+	if id != "abcdef" || !reflect.DeepEqual(memberModes, modes.Modes{modes.Halfop}) {
+		t.Errorf("unexpected decoding %s %v", id, memberModes)
+	}
+}
+
+func TestComputeSubtree(t *testing.T) {
+	// a.test (us) -- b.test -- c.test -- d.test
+	//                       \- e.test
+	// a.test -- f.test
+	servers := map[string]*remoteServer{
+		"b.test": {name: "b.test", uplink: "a.test"},
+		"c.test": {name: "c.test", uplink: "B.test"},
+		"d.test": {name: "d.test", uplink: "c.test"},
+		"e.test": {name: "e.test", uplink: "b.test"},
+		"f.test": {name: "f.test", uplink: "a.test"},
+	}
+
+	assertSubtree := func(root string, expected ...string) {
+		expectedSet := make(utils.StringSet)
+		for _, name := range expected {
+			expectedSet.Add(name)
+		}
+		if result := computeSubtree(servers, root); !reflect.DeepEqual(result, expectedSet) {
+			t.Errorf("unexpected subtree of %s: %v", root, result)
+		}
+	}
+
+	assertSubtree("b.test", "b.test", "c.test", "d.test", "e.test")
+	assertSubtree("c.test", "c.test", "d.test")
+	assertSubtree("d.test", "d.test")
+	assertSubtree("f.test", "f.test")
+}
+
+func TestMapLines(t *testing.T) {
+	lines := mapLines([]linkedServer{
+		{name: "a.test", uplink: "a.test", users: 3},
+		{name: "b.test", uplink: "a.test", hops: 1, users: 2},
+		{name: "f.test", uplink: "a.test", hops: 1},
+		{name: "c.test", uplink: "b.test", hops: 2, users: 1},
+	})
+	expected := []string{
+		"a.test [3 users]",
+		"|-b.test [2 users]",
+		"| `-c.test [1 users]",
+		"`-f.test [0 users]",
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("unexpected map %#v", lines)
+	}
+}
+
+// freeLocalAddr returns a loopback address with a port that is currently free.
+func freeLocalAddr(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+// waitForListener waits until a TCP connection to addr succeeds.
+func waitForListener(t *testing.T, addr string) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		conn, err := net.DialTimeout("tcp", addr, time.Second)
+		if err == nil {
+			conn.Close()
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("nothing listening on %s: %v", addr, err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// startLinkedServer runs the ergo binary as a separate process, with linking
+// enabled on linkAddr, and returns the address of its client listener.
+func startLinkedServer(t *testing.T, binary, name, linkAddr string, links map[interface{}]interface{}) (addr string) {
+	dir := t.TempDir()
+	cert, key := filepath.Join(dir, "link.pem"), filepath.Join(dir, "link.key")
+	if err := mkcerts.CreateCert("Ergo", name, cert, key); err != nil {
+		t.Fatal(err)
+	}
+	addr = freeLocalAddr(t)
+	config := writeTestConfig(t, dir, map[string]interface{}{
+		"server.name":         name,
+		"server.listeners":    map[interface{}]interface{}{addr: nil},
+		"linking.enabled":     true,
+		"linking.description": name,
+		"linking.listener":    linkAddr,
+		"linking.tls":         map[interface{}]interface{}{"cert": cert, "key": key},
+		"linking.links":       links,
+		// the link events are logged in case the test fails:
+		"logging": []interface{}{map[interface{}]interface{}{"method": "stderr", "type": "linking", "level": "info"}},
+	})
+
+	if output, err := exec.Command(binary, "initdb", "--conf", config, "--quiet").CombinedOutput(); err != nil {
+		t.Fatalf("initdb failed: %v\n%s", err, output)
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command(binary, "run", "--conf", config, "--quiet")
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+		if t.Failed() && stderr.Len() != 0 {
+			t.Logf("%s output:\n%s", name, stderr.String())
+		}
+	})
+	waitForListener(t, addr)
+	return
+}
+
+// TestLinkedProcesses links two ergo processes on localhost, and checks that
+// their clients can see and message each other.
+func TestLinkedProcesses(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds and runs the ergo binary")
+	}
+	goBinary, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("no go toolchain available")
+	}
+	binary := filepath.Join(t.TempDir(), "ergo")
+	if output, err := exec.Command(goBinary, "build", "-o", binary, "..").CombinedOutput(); err != nil {
+		t.Fatalf("could not build ergo: %v\n%s", err, output)
+	}
+
+	const password = "BQ8y3hZtRMgdkOHSyIdLmoC3ugBpmYGD"
+	hubLinkAddr, leafLinkAddr := freeLocalAddr(t), freeLocalAddr(t)
+	hubAddr := startLinkedServer(t, binary, "hub.test", hubLinkAddr, map[interface{}]interface{}{
+		"leaf.test": map[interface{}]interface{}{"password": password},
+	})
+	waitForListener(t, hubLinkAddr)
+	// the leaf connects to the hub as soon as it starts:
+	leafAddr := startLinkedServer(t, binary, "leaf.test", leafLinkAddr, map[interface{}]interface{}{
+		"hub.test": map[interface{}]interface{}{
+			"password":             password,
+			"address":              hubLinkAddr,
+			"insecure-skip-verify": true,
+			"autoconnect":          true,
+		},
+	})
+
+	alice := connectTestClient(t, hubAddr, "alice", "")
+	bob := connectTestClient(t, leafAddr, "bob", "")
+
+	// wait for the link to come up and for alice to be introduced to the leaf:
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		bob.Send("ISON alice")
+		if fields := strings.Fields(bob.Expect(RPL_ISON)); strings.TrimPrefix(fields[len(fields)-1], ":") == "alice" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("alice never became visible on the leaf")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	alice.Send("JOIN #link")
+	alice.Expect(RPL_ENDOFNAMES)
+	bob.Send("JOIN #link")
+	bob.Expect(RPL_ENDOFNAMES)
+	if line := alice.Expect("JOIN"); !strings.HasPrefix(line, ":bob!") {
+		t.Fatalf("unexpected JOIN on the hub: %q", line)
+	}
+
+	alice.Send("PRIVMSG #link :hello from the hub")
+	if line := bob.Expect("PRIVMSG"); !strings.HasPrefix(line, ":alice!") || !strings.HasSuffix(line, "#link :hello from the hub") {
+		t.Fatalf("unexpected channel message on the leaf: %q", line)
+	}
+	bob.Send("PRIVMSG alice :hello from the leaf")
+	if line := alice.Expect("PRIVMSG"); !strings.HasPrefix(line, ":bob!") || !strings.HasSuffix(line, "alice :hello from the leaf") {
+		t.Fatalf("unexpected direct message on the hub: %q", line)
+	}
+
+	bob.Send("LINKS")
+	var links []string
+	for {
+		line := bob.Expect(RPL_LINKS, RPL_ENDOFLINKS)
+		if lineCommand(line) == RPL_ENDOFLINKS {
+			break
+		}
+		links = append(links, strings.Fields(line)[3])
+	}
+	assertEqual(fmt.Sprint(links), fmt.Sprint([]string{"leaf.test", "hub.test"}), t)
+
+	// quits propagate across the link:
+	bob.Send("QUIT :bye")
+	if line := alice.Expect("QUIT"); !strings.HasPrefix(line, ":bob!") {
+		t.Fatalf("unexpected QUIT on the hub: %q", line)
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright (c) 2026 agent <agent@local>
+// released under the MIT license
+
+package irc
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ergochat/ergo/irc/caps"
+	"github.com/ergochat/ergo/irc/history"
+	"github.com/ergochat/ergo/irc/utils"
+)
+
+// Message editing (draft/message-edit): a PRIVMSG carrying the client-only tag
+// +draft/edit=<msgid> replaces the text of an earlier PRIVMSG to the same target.
+// The server checks that the original was sent by the same author within the
+// edit window; the edit is then relayed and stored like any other message, as a
+// new revision that refers to the original via the tag. When history is replayed,
+// sessions that negotiated the cap see the edited form of the original, and
+// other sessions see the original followed by the edit.
+
+// checkMessageEdit checks that `msgid` is a PRIVMSG to `target` that was sent
+// within the edit window by the author `isAuthor` accepts. `histTarget` is
+// the buffer to look for it in, as for (*Server).DeleteMessage.
+func (server *Server) checkMessageEdit(histTarget, target, msgid string, isAuthor func(item *history.Item) bool) (err error) {
+	window := time.Duration(server.Config().History.Retention.EditWindow)
+	if window == 0 {
+		return errInsufficientPrivs
+	}
+	// the lookup only finds messages stored for histTarget: a channel's own
+	// messages, or the direct messages of a client
+	item, err := server.LookupMessage(histTarget, msgid)
+	if err != nil {
+		return errNoop
+	}
+	if item.Type != history.Privmsg {
+		return errNoop
+	}
+	// Params[0] is the recipient of a direct message, and empty for a channel message
+	if strings.HasPrefix(target, "#") {
+		cftarget, _ := CasefoldChannel(target)
+		if cfhistTarget, _ := CasefoldChannel(histTarget); item.Params[0] != "" || cfhistTarget != cftarget {
+			return errNoop
+		}
+	} else {
+		cftarget, _ := CasefoldName(target)
+		if cfrecipient, _ := CasefoldName(item.Params[0]); cfrecipient == "" || cfrecipient != cftarget {
+			return errNoop
+		}
+	}
+	if !isAuthor(&item) {
+		return errInsufficientPrivs
+	}
+	if item.Message.Time.Before(time.Now().UTC().Add(-window)) {
+		return errMessageTooOld
+	}
+	return nil
+}
+
+// validateMessageEdit checks the edit tag (if any) of a message the client
+// is sending to `target`, sending a FAIL if the edit is not allowed.
+// Edit tags on anything other than PRIVMSG are removed.
+func (client *Client) validateMessageEdit(histType history.ItemType, command, target string, tags map[string]string, rb *ResponseBuffer) (result map[string]string, ok bool) {
+	msgid, isEdit := tags[caps.MessageEditTagName]
+	if !isEdit {
+		return tags, true
+	}
+	if histType != history.Privmsg {
+		result = make(map[string]string, len(tags))
+		for name, value := range tags {
+			if name != caps.MessageEditTagName {
+				result[name] = value
+			}
+		}
+		return result, true
+	}
+
+	accountName := client.AccountName()
+	histTarget := target
+	if !strings.HasPrefix(target, "#") {
+		// look in our own copy of the conversation
+		histTarget = client.Nick()
+	}
+	err := client.server.checkMessageEdit(histTarget, target, msgid, func(item *history.Item) bool {
+		return accountName != "*" && item.AccountName == accountName
+	})
+	if err != nil {
+		sendMessageEditError(client, command, target, msgid, err, rb)
+		return nil, false
+	}
+	return tags, true
+}
+
+func sendMessageEditError(client *Client, command, target, msgid string, err error, rb *ResponseBuffer) {
+	server := client.server
+	switch err {
+	case errMessageTooOld:
+		window := time.Duration(server.Config().History.Retention.EditWindow)
+		rb.Add(nil, server.name, "FAIL", command, "EDIT_WINDOW_EXPIRED", utils.SafeErrorParam(target), utils.SafeErrorParam(msgid), strconv.Itoa(int(window.Seconds())), client.t("You can no longer edit this message"))
+	case errInsufficientPrivs:
+		rb.Add(nil, server.name, "FAIL", command, "EDIT_FORBIDDEN", utils.SafeErrorParam(target), utils.SafeErrorParam(msgid), client.t("You are not authorised to edit this message"))
+	default:
+		rb.Add(nil, server.name, "FAIL", command, "UNKNOWN_MSGID", utils.SafeErrorParam(target), utils.SafeErrorParam(msgid), client.t("This message does not exist or is too old"))
+	}
+}
+
+// applyMessageEdits collapses the revisions in a (chronologically ordered) list
+// of history items into the originals they edit, for sessions that negotiated
+// the cap. The originals keep their msgids and times; revisions of messages
+// that aren't in the list are left as they are, for the client to apply.
+func applyMessageEdits(items []history.Item) (result []history.Item) {
+	originals := make(map[string]int)
+	result = make([]history.Item, 0, len(items))
+	for _, item := range items {
+		if item.Type == history.Privmsg {
+			if msgid := item.Tags[caps.MessageEditTagName]; msgid != "" {
+				if i, ok := originals[msgid]; ok {
+					edited := item.Message
+					edited.Msgid, edited.Time = result[i].Message.Msgid, result[i].Message.Time
+					result[i].Message = edited
+					continue
+				}
+			} else {
+				originals[item.Message.Msgid] = len(result)
+			}
+		}
+		result = append(result, item)
+	}
+	return
+}
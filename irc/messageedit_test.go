@@ -0,0 +1,108 @@
+// Copyright (c) 2026 agent <agent@local>
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ergochat/ergo/irc/caps"
+	"github.com/ergochat/ergo/irc/history"
+	"github.com/ergochat/ergo/irc/utils"
+)
+
+func TestApplyMessageEdits(t *testing.T) {
+	now := time.Now().UTC()
+	privmsg := func(msgid, text string, offset int, edits string) (item history.Item) {
+		item.Type = history.Privmsg
+		item.Message = utils.SplitMessage{Msgid: msgid, Message: text, Time: now.Add(time.Duration(offset) * time.Second)}
+		if edits != "" {
+			item.Tags = map[string]string{caps.MessageEditTagName: edits}
+		}
+		return
+	}
+	items := []history.Item{
+		privmsg("a", "helo", 0, ""),
+		privmsg("b", "world", 1, ""),
+		privmsg("c", "hello", 2, "a"),
+		privmsg("d", "hello!", 3, "a"),
+		privmsg("e", "fixed", 4, "z"),
+	}
+
+	result := applyMessageEdits(items)
+	if len(result) != 3 {
+		t.Fatalf("expected 3 items, got %d", len(result))
+	}
+	if result[0].Message.Msgid != "a" || result[0].Message.Message != "hello!" || !result[0].Message.Time.Equal(now) {
+		t.Errorf("original should show the latest revision, with its own msgid and time: %#v", result[0].Message)
+	}
+	if result[1].Message.Msgid != "b" || result[1].Message.Message != "world" {
+		t.Errorf("unedited message should be unchanged: %#v", result[1].Message)
+	}
+	if result[2].Message.Msgid != "e" {
+		t.Errorf("revision of a message outside the list should be kept: %#v", result[2].Message)
+	}
+	if items[0].Message.Message != "helo" {
+		t.Errorf("input should not be modified")
+	}
+}
+
+// sendEdit sends an edit of msgid to target and returns the FAIL code, or "" if it was relayed.
+func (c *testClient) sendEdit(target, msgid, text string) (code string) {
+	c.t.Helper()
+	c.Send(fmt.Sprintf("@%s=%s PRIVMSG %s :%s", caps.MessageEditTagName, msgid, target, text))
+	for _, line := range c.Sync() {
+		fields := strings.Fields(line)
+		for i := 0; i+2 < len(fields); i++ {
+			if fields[i] == "FAIL" && fields[i+1] == "PRIVMSG" {
+				return fields[i+2]
+			}
+		}
+		if lineCommand(line) == "PRIVMSG" && strings.HasSuffix(line, " :"+text) {
+			return ""
+		}
+	}
+	c.t.Fatalf("%s: no response to edit", c.nick)
+	return
+}
+
+func testMessageEditTargets(t *testing.T, overrides map[string]interface{}) {
+	server, addr := newTestServer(t, overrides)
+	registerTestAccount(t, server, "alice", "pw")
+	registerTestAccount(t, server, "bob", "pw")
+	capabilities := []string{"echo-message", "message-tags", "draft/message-edit"}
+	alice := connectTestClient(t, addr, "alice", "pw", capabilities...)
+	connectTestClient(t, addr, "bob", "pw")
+	connectTestClient(t, addr, "carol", "")
+	for _, channel := range []string{"#chan", "#other"} {
+		alice.Send("JOIN " + channel)
+		alice.Expect(RPL_ENDOFNAMES)
+	}
+
+	inChan := alice.sendMessage("#chan", "helo")
+	direct := alice.sendMessage("bob", "helo")
+
+	// an edit must go to the same target as the original:
+	assertEqual(alice.sendEdit("#other", inChan, "hello"), "UNKNOWN_MSGID", t)
+	assertEqual(alice.sendEdit("bob", inChan, "hello"), "UNKNOWN_MSGID", t)
+	assertEqual(alice.sendEdit("#chan", direct, "hello"), "UNKNOWN_MSGID", t)
+	assertEqual(alice.sendEdit("carol", direct, "hello"), "UNKNOWN_MSGID", t)
+
+	assertEqual(alice.sendEdit("#chan", inChan, "hello"), "", t)
+	assertEqual(alice.sendEdit("bob", direct, "hello"), "", t)
+}
+
+func TestMessageEditTargetsEphemeral(t *testing.T) {
+	testMessageEditTargets(t, nil)
+}
+
+func TestMessageEditTargetsPersistent(t *testing.T) {
+	testMessageEditTargets(t, map[string]interface{}{
+		"datastore.sqlite.enabled":                 true,
+		"history.persistent.enabled":               true,
+		"history.persistent.unregistered-channels": true,
+	})
+}
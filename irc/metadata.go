@@ -0,0 +1,296 @@
+// Copyright (c) 2026 agent <agent@local>
+// released under the MIT license
+
+package irc
+
+import (
+	"sort"
+	"unicode/utf8"
+
+	"github.com/ergochat/ergo/irc/caps"
+	"github.com/ergochat/ergo/irc/modes"
+	"github.com/ergochat/ergo/irc/utils"
+)
+
+// IRCv3 metadata (draft/metadata-2): key-value data attached to users and
+// channels, e.g., avatars and display names. The metadata of a logged-in client
+// is stored with its account, and that of a registered channel with the channel
+// registration; otherwise it lasts as long as the client or channel does.
+// Sessions subscribe to the keys they're interested in, and are notified when
+// those keys change on users they share a channel with, or on channels they're in.
+// All metadata is currently public, so the visibility of every key is `*`.
+
+const (
+	metadataVisibility = "*"
+	// number of keys per RPL_METADATASUBS line:
+	maxMetadataSubsPerLine = 10
+)
+
+// metadataKeyIsValid checks a key name against the spec's allowed characters.
+func metadataKeyIsValid(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, r := range key {
+		if !(('a' <= r && r <= 'z') || ('0' <= r && r <= '9') || r == '_' || r == '.' || r == '/' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+func metadataValueIsValid(value string, maxBytes int) bool {
+	return len(value) <= maxBytes && utf8.ValidString(value)
+}
+
+// updateMetadata applies a SET (or, if `set` is false, a deletion) to an immutable
+// metadata map, returning a new map if anything changed.
+func updateMetadata(metadata map[string]string, key, value string, set bool, maxKeys int) (result map[string]string, changed bool, err error) {
+	current, exists := metadata[key]
+	if set {
+		if exists && current == value {
+			return metadata, false, nil
+		}
+		if !exists && len(metadata) >= maxKeys {
+			return metadata, false, errMetadataLimitReached
+		}
+	} else if !exists {
+		return metadata, false, nil
+	}
+
+	result = make(map[string]string, len(metadata)+1)
+	for k, v := range metadata {
+		result[k] = v
+	}
+	if set {
+		result[key] = value
+	} else {
+		delete(result, key)
+	}
+	return result, true, nil
+}
+
+// sortedMetadataKeys returns the keys of a metadata map, for deterministic output.
+func sortedMetadataKeys(metadata map[string]string) (result []string) {
+	result = make([]string, 0, len(metadata))
+	for key := range metadata {
+		result = append(result, key)
+	}
+	sort.Strings(result)
+	return
+}
+
+// Metadata returns the client's metadata; the map MUST NOT be modified.
+func (client *Client) Metadata() (result map[string]string) {
+	client.stateMutex.RLock()
+	result = client.metadata
+	client.stateMutex.RUnlock()
+	return
+}
+
+func (client *Client) setMetadata(metadata map[string]string) {
+	client.stateMutex.Lock()
+	client.metadata = metadata
+	client.stateMutex.Unlock()
+}
+
+// modifyMetadata sets or deletes a metadata key on the client, persisting the
+// change if it is logged in. It returns the clients whose metadata changed
+// (all the clients of the account, if it is logged in).
+func (client *Client) modifyMetadata(key, value string, set bool, maxKeys int) (clients []*Client, err error) {
+	if account := client.Account(); account != "" {
+		var changed bool
+		clients, changed, err = client.server.accounts.saveMetadata(account, key, value, set, maxKeys)
+		if !changed {
+			clients = nil
+		}
+		return
+	}
+
+	client.stateMutex.Lock()
+	defer client.stateMutex.Unlock()
+	metadata, changed, err := updateMetadata(client.metadata, key, value, set, maxKeys)
+	if changed {
+		client.metadata = metadata
+		clients = []*Client{client}
+	}
+	return
+}
+
+// Metadata returns the channel's metadata; the map MUST NOT be modified.
+func (channel *Channel) Metadata() (result map[string]string) {
+	channel.stateMutex.RLock()
+	result = channel.metadata
+	channel.stateMutex.RUnlock()
+	return
+}
+
+// modifyMetadata sets or deletes a metadata key on the channel.
+func (channel *Channel) modifyMetadata(key, value string, set bool, maxKeys int) (changed bool, err error) {
+	channel.stateMutex.Lock()
+	metadata, changed, err := updateMetadata(channel.metadata, key, value, set, maxKeys)
+	if changed {
+		channel.metadata = metadata
+	}
+	channel.stateMutex.Unlock()
+
+	if changed {
+		channel.MarkDirty(IncludeMetadata)
+	}
+	return
+}
+
+// MetadataSubscriptions returns the keys the session is subscribed to;
+// the set MUST NOT be modified.
+func (session *Session) MetadataSubscriptions() (result utils.StringSet) {
+	session.client.stateMutex.RLock()
+	result = session.metadataSubscriptions
+	session.client.stateMutex.RUnlock()
+	return
+}
+
+// subscribeMetadata adds `key` to the session's subscriptions (without an
+// error if it is already present).
+func (session *Session) subscribeMetadata(key string, limit int) (err error) {
+	session.client.stateMutex.Lock()
+	defer session.client.stateMutex.Unlock()
+	if session.metadataSubscriptions.Has(key) {
+		return nil
+	}
+	if len(session.metadataSubscriptions) >= limit {
+		return errMetadataTooManySubs
+	}
+	subs := make(utils.StringSet, len(session.metadataSubscriptions)+1)
+	for existing := range session.metadataSubscriptions {
+		subs.Add(existing)
+	}
+	subs.Add(key)
+	session.metadataSubscriptions = subs
+	return nil
+}
+
+// metadataSubscriptionList returns the keys the session is subscribed to, sorted.
+func (session *Session) metadataSubscriptionList() (result []string) {
+	subs := session.MetadataSubscriptions()
+	result = make([]string, 0, len(subs))
+	for key := range subs {
+		result = append(result, key)
+	}
+	sort.Strings(result)
+	return
+}
+
+func (session *Session) unsubscribeMetadata(key string) {
+	session.client.stateMutex.Lock()
+	defer session.client.stateMutex.Unlock()
+	if !session.metadataSubscriptions.Has(key) {
+		return
+	}
+	subs := make(utils.StringSet, len(session.metadataSubscriptions))
+	for existing := range session.metadataSubscriptions {
+		if existing != key {
+			subs.Add(existing)
+		}
+	}
+	session.metadataSubscriptions = subs
+}
+
+// sendMetadata sends a METADATA notification to the session, if it is subscribed
+// to the key; a deletion is indicated by omitting the value.
+func (session *Session) sendMetadata(source, target, key, value string, set bool) {
+	if !session.capabilities.Has(caps.Metadata) || !session.MetadataSubscriptions().Has(key) {
+		return
+	}
+	if set {
+		session.Send(nil, source, "METADATA", target, key, metadataVisibility, value)
+	} else {
+		session.Send(nil, source, "METADATA", target, key, metadataVisibility)
+	}
+}
+
+// notifyMetadataChanged notifies subscribed sessions of a change to the client's
+// metadata: that is, its own sessions, and the sessions of everyone sharing
+// a channel with it. `exclude` is the session that made the change.
+func (client *Client) notifyMetadataChanged(source, key, value string, set bool, exclude *Session) {
+	nick := client.Nick()
+	for session := range client.Friends(caps.Metadata) {
+		if session != exclude {
+			session.sendMetadata(source, nick, key, value, set)
+		}
+	}
+}
+
+// notifyMetadataChanged notifies subscribed members of a change to the
+// channel's metadata. `exclude` is the session that made the change.
+func (channel *Channel) notifyMetadataChanged(source, key, value string, set bool, exclude *Session) {
+	chname := channel.Name()
+	for _, member := range channel.Members() {
+		for _, session := range member.Sessions() {
+			if session != exclude {
+				session.sendMetadata(source, chname, key, value, set)
+			}
+		}
+	}
+}
+
+// addMetadataLines adds METADATA lines for the subscribed keys of `metadata`
+// to the response buffer.
+func addMetadataLines(rb *ResponseBuffer, subs utils.StringSet, target string, metadata map[string]string) {
+	for _, key := range sortedMetadataKeys(metadata) {
+		if subs.Has(key) {
+			rb.Add(nil, rb.target.server.name, "METADATA", target, key, metadataVisibility, metadata[key])
+		}
+	}
+}
+
+// startMetadataBatch starts a `metadata` batch, if the session supports batches.
+func startMetadataBatch(rb *ResponseBuffer) (batchID string) {
+	if rb.session.capabilities.Has(caps.Batch) {
+		batchID = rb.StartNestedBatch("metadata")
+	}
+	return
+}
+
+// syncChannelMetadata sends the subscribed metadata of a channel and of all
+// its members, e.g., after a JOIN or in response to METADATA SYNC.
+func (channel *Channel) syncChannelMetadata(rb *ResponseBuffer) {
+	subs := rb.session.MetadataSubscriptions()
+	if len(subs) == 0 {
+		return
+	}
+	batchID := startMetadataBatch(rb)
+	defer rb.EndNestedBatch(batchID)
+	addMetadataLines(rb, subs, channel.Name(), channel.Metadata())
+	for _, member := range channel.Members() {
+		addMetadataLines(rb, subs, member.Nick(), member.Metadata())
+	}
+}
+
+// syncClientMetadata sends the subscribed metadata of the client.
+func (client *Client) syncClientMetadata(rb *ResponseBuffer) {
+	subs := rb.session.MetadataSubscriptions()
+	if len(subs) == 0 {
+		return
+	}
+	batchID := startMetadataBatch(rb)
+	defer rb.EndNestedBatch(batchID)
+	addMetadataLines(rb, subs, client.Nick(), client.Metadata())
+}
+
+// sendJoinMetadata tells the session about the subscribed metadata of
+// a client that just joined one of its channels.
+func (session *Session) sendJoinMetadata(nick string, metadata map[string]string) {
+	if len(metadata) == 0 {
+		return
+	}
+	servername := session.client.server.name
+	for _, key := range sortedMetadataKeys(metadata) {
+		session.sendMetadata(servername, nick, key, metadata[key], true)
+	}
+}
+
+// canSeeMetadata returns whether the client may query a channel's metadata:
+// the metadata of a secret channel is only visible to its members.
+func (channel *Channel) canSeeMetadata(client *Client) bool {
+	return !channel.flags.HasMode(modes.Secret) || channel.hasClient(client) || client.HasRoleCapabs("sajoin")
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2026 agent <agent@local>
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+)
+
+func TestMetadataKeyIsValid(t *testing.T) {
+	for _, key := range []string{"avatar", "display-name", "ergo.chat/pronouns", "x_1"} {
+		if !metadataKeyIsValid(key) {
+			t.Errorf("key %s should be valid", key)
+		}
+	}
+	for _, key := range []string{"", "Avatar", "display name", "a:b", "ü"} {
+		if metadataKeyIsValid(key) {
+			t.Errorf("key %s should be invalid", key)
+		}
+	}
+
+	if !metadataValueIsValid("https://example.com/a.png", 32) {
+		t.Errorf("value should be valid")
+	}
+	if metadataValueIsValid("\xff", 32) {
+		t.Errorf("invalid UTF-8 should be rejected")
+	}
+	if metadataValueIsValid("abcd", 3) {
+		t.Errorf("overlong value should be rejected")
+	}
+}
+
+func TestUpdateMetadata(t *testing.T) {
+	var metadata map[string]string
+
+	result, changed, err := updateMetadata(metadata, "avatar", "a.png", true, 2)
+	if err != nil || !changed {
+		t.Fatalf("set failed: %v", err)
+	}
+	if metadata != nil {
+		t.Errorf("original map should not be modified")
+	}
+	metadata = result
+	assertEqual(metadata, map[string]string{"avatar": "a.png"}, t)
+
+	if _, changed, _ = updateMetadata(metadata, "avatar", "a.png", true, 2); changed {
+		t.Errorf("setting the same value should not be a change")
+	}
+	metadata, _, _ = updateMetadata(metadata, "color", "red", true, 2)
+	if _, _, err = updateMetadata(metadata, "pronouns", "they", true, 2); err != errMetadataLimitReached {
+		t.Errorf("expected errMetadataLimitReached, got %v", err)
+	}
+	if _, changed, err = updateMetadata(metadata, "avatar", "b.png", true, 2); !changed || err != nil {
+		t.Errorf("existing keys should be modifiable at the limit")
+	}
+
+	result, changed, _ = updateMetadata(metadata, "color", "", false, 2)
+	if !changed {
+		t.Errorf("deletion should be a change")
+	}
+	assertEqual(result, map[string]string{"avatar": "a.png"}, t)
+	assertEqual(sortedMetadataKeys(metadata), []string{"avatar", "color"}, t)
+
+	if _, changed, _ = updateMetadata(result, "color", "", false, 2); changed {
+		t.Errorf("deleting a missing key should not be a change")
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright (c) 2026 agent <agent@local>
+// released under the MIT license
+
+package irc
+
+import (
+	"bufio"
+	"net/http"
+	"time"
+
+	"github.com/ergochat/ergo/irc/metrics"
+	"github.com/ergochat/ergo/irc/mysql"
+	"github.com/ergochat/ergo/irc/postgresql"
+	"github.com/ergochat/ergo/irc/sqlite"
+)
+
+// Prometheus-format metrics, served over HTTP on `metrics.listener`.
+// Counters are updated inline as events occur; gauges (client counts,
+// history buffer occupancy) are computed when the endpoint is scraped.
+
+const (
+	metricsTimeout = 30 * time.Second
+)
+
+// reasons for rejecting a connection, used as the `reason` label:
+const (
+	rejectDefcon        = "defcon"
+	rejectDline         = "dline"
+	rejectLimit         = "limit"
+	rejectThrottle      = "throttle"
+	rejectIPCheckScript = "ip-check-script"
+	rejectTorLimit      = "tor-limit"
+	rejectTorThrottle   = "tor-throttle"
+)
+
+type serverMetrics struct {
+	connectionsAccepted *metrics.CounterVec // listener
+	connectionsRejected *metrics.CounterVec // listener, reason
+	saslAttempts        *metrics.CounterVec // mechanism, result
+	commands            *metrics.CounterVec // command
+	// counters for each entry in `Commands`, so that the dispatch loop
+	// can look them up without taking a lock; read-only after Initialize
+	commandCounters  map[string]*metrics.Counter
+	unknownCommands  *metrics.Counter
+	sendQExceeded    metrics.Counter
+	fakelagThrottles metrics.Counter
+}
+
+func (m *serverMetrics) Initialize() {
+	m.connectionsAccepted = metrics.NewCounterVec("listener")
+	m.connectionsRejected = metrics.NewCounterVec("listener", "reason")
+	m.saslAttempts = metrics.NewCounterVec("mechanism", "result")
+	m.commands = metrics.NewCounterVec("command")
+	m.commandCounters = make(map[string]*metrics.Counter, len(Commands))
+	for command := range Commands {
+		m.commandCounters[command] = m.commands.With(command)
+	}
+	m.unknownCommands = m.commands.With("unknown")
+}
+
+func (m *serverMetrics) acceptConnection(listener string) {
+	m.connectionsAccepted.With(listener).Inc()
+}
+
+func (m *serverMetrics) rejectConnection(listener, reason string) {
+	m.connectionsRejected.With(listener, reason).Inc()
+}
+
+func (m *serverMetrics) saslAttempt(mechanism string, success bool) {
+	result := "failure"
+	if success {
+		result = "success"
+	}
+	m.saslAttempts.With(mechanism, result).Inc()
+}
+
+func (m *serverMetrics) command(command string) {
+	if counter, ok := m.commandCounters[command]; ok {
+		counter.Inc()
+	} else {
+		m.unknownCommands.Inc()
+	}
+}
+
+func (server *Server) setupMetricsListener(config *Config) {
+	listener := ""
+	if config.Metrics.Enabled {
+		listener = config.Metrics.Listener
+	}
+	if server.metricsServer != nil {
+		if listener == "" || listener != server.metricsServer.Addr {
+			server.logger.Info("server", "Stopping metrics listener", server.metricsServer.Addr)
+			server.metricsServer.Close()
+			server.metricsServer = nil
+		}
+	}
+	if listener != "" && server.metricsServer == nil {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/metrics", server.serveMetrics)
+		ms := http.Server{
+			Addr:         listener,
+			Handler:      mux,
+			ReadTimeout:  metricsTimeout,
+			WriteTimeout: metricsTimeout,
+		}
+		go func() {
+			if err := ms.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				server.logger.Error("server", "metrics listener failed", err.Error())
+			}
+		}()
+		server.metricsServer = &ms
+		server.logger.Info("server", "Started metrics listener", server.metricsServer.Addr)
+	}
+}
+
+func (server *Server) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	buf := bufio.NewWriter(w)
+	mw := metrics.NewWriter(buf)
+	server.writeMetrics(mw)
+	if mw.Err() == nil {
+		buf.Flush()
+	}
+}
+
+func (server *Server) writeMetrics(mw *metrics.Writer) {
+	stats := server.stats.GetValues()
+	mw.GaugeVec("ergo_clients", "Number of connected clients", "state", map[string]float64{
+		"unregistered": float64(stats.Unknown),
+		"registered":   float64(stats.Total),
+		"invisible":    float64(stats.Invisible),
+		"operators":    float64(stats.Operators),
+	})
+	mw.Gauge("ergo_clients_registered_max", "High-water mark of registered clients", float64(stats.Max))
+	mw.Gauge("ergo_channels", "Number of channels", float64(server.channels.Len()))
+
+	m := &server.metrics
+	mw.CounterVec("ergo_connections_accepted_total", "Connections accepted, by listener", m.connectionsAccepted)
+	mw.CounterVec("ergo_connections_rejected_total", "Connections rejected by bans or connection limits, by listener and reason", m.connectionsRejected)
+	mw.CounterVec("ergo_sasl_attempts_total", "SASL authentication attempts, by mechanism and result", m.saslAttempts)
+	mw.CounterVec("ergo_commands_total", "Commands received from clients, by command", m.commands)
+	mw.Counter("ergo_sendq_exceeded_total", "Connections closed for exceeding their sendq", m.sendQExceeded.Value())
+	mw.Counter("ergo_fakelag_throttles_total", "Commands delayed by fakelag", m.fakelagThrottles.Value())
+
+	items, capacity := server.historyOccupancy()
+	mw.GaugeVec("ergo_history_items", "Messages held in in-memory history buffers", "type", items)
+	mw.GaugeVec("ergo_history_capacity", "Currently allocated size of in-memory history buffers", "type", capacity)
+
+	switch historyDB := server.HistoryDB().(type) {
+	case *mysql.MySQL:
+		mw.HistogramVec("ergo_mysql_operation_duration_seconds", "Latency of MySQL history operations, by operation", historyDB.QueryLatency())
+	case *postgresql.PostgreSQL:
+		mw.HistogramVec("ergo_postgresql_operation_duration_seconds", "Latency of PostgreSQL history operations, by operation", historyDB.QueryLatency())
+	case *sqlite.SQLite:
+		mw.HistogramVec("ergo_sqlite_operation_duration_seconds", "Latency of SQLite history operations, by operation", historyDB.QueryLatency())
+	}
+}
+
+func (server *Server) historyOccupancy() (items, capacity map[string]float64) {
+	var channelItems, channelCapacity, clientItems, clientCapacity int
+	for _, channel := range server.channels.Channels() {
+		length, size := channel.history.Occupancy()
+		channelItems += length
+		channelCapacity += size
+	}
+	for _, client := range server.clients.AllClients() {
+		length, size := client.history.Occupancy()
+		clientItems += length
+		clientCapacity += size
+	}
+	items = map[string]float64{
+		"channel": float64(channelItems),
+		"client":  float64(clientItems),
+	}
+	capacity = map[string]float64{
+		"channel": float64(channelCapacity),
+		"client":  float64(clientCapacity),
+	}
+	return
+}
@@ -0,0 +1,312 @@
+// Copyright (c) 2026 agent <agent@local>
+// released under the MIT license
+
+// Package metrics implements a minimal set of Prometheus-style metric types,
+// and serialization of them in the Prometheus text exposition format:
+// https://prometheus.io/docs/instrumenting/exposition_formats/
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// DefaultLatencyBuckets are histogram buckets (in seconds) suitable
+	// for database queries and other network round trips.
+	DefaultLatencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+)
+
+// Counter is a monotonically increasing value; it is safe for concurrent use.
+type Counter struct {
+	value uint64
+}
+
+func (c *Counter) Inc() {
+	atomic.AddUint64(&c.value, 1)
+}
+
+func (c *Counter) Add(n uint64) {
+	atomic.AddUint64(&c.value, n)
+}
+
+func (c *Counter) Value() uint64 {
+	return atomic.LoadUint64(&c.value)
+}
+
+// CounterVec is a family of counters, distinguished by the values of one or more labels.
+type CounterVec struct {
+	sync.Mutex
+	labels   []string
+	counters map[string]*Counter
+}
+
+func NewCounterVec(labels ...string) *CounterVec {
+	return &CounterVec{
+		labels:   labels,
+		counters: make(map[string]*Counter),
+	}
+}
+
+// With returns the counter for the given label values, which must be supplied
+// in the same order as the label names passed to NewCounterVec.
+func (v *CounterVec) With(values ...string) *Counter {
+	key := joinLabelValues(values)
+	v.Lock()
+	defer v.Unlock()
+	counter, ok := v.counters[key]
+	if !ok {
+		counter = new(Counter)
+		v.counters[key] = counter
+	}
+	return counter
+}
+
+// Histogram counts observations in cumulative buckets; it is safe for concurrent use.
+type Histogram struct {
+	sync.Mutex
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+func NewHistogram(buckets []float64) *Histogram {
+	return &Histogram{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+}
+
+func (h *Histogram) Observe(value float64) {
+	h.Lock()
+	defer h.Unlock()
+	for i, bound := range h.buckets {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+// ObserveSince records the time elapsed since `start`, in seconds.
+func (h *Histogram) ObserveSince(start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
+
+func (h *Histogram) snapshot() (counts []uint64, count uint64, sum float64) {
+	h.Lock()
+	defer h.Unlock()
+	counts = make([]uint64, len(h.counts))
+	copy(counts, h.counts)
+	return counts, h.count, h.sum
+}
+
+// HistogramVec is a family of histograms, distinguished by the values of one or more labels.
+type HistogramVec struct {
+	sync.Mutex
+	labels     []string
+	buckets    []float64
+	histograms map[string]*Histogram
+}
+
+func NewHistogramVec(buckets []float64, labels ...string) *HistogramVec {
+	return &HistogramVec{
+		labels:     labels,
+		buckets:    buckets,
+		histograms: make(map[string]*Histogram),
+	}
+}
+
+func (v *HistogramVec) With(values ...string) *Histogram {
+	key := joinLabelValues(values)
+	v.Lock()
+	defer v.Unlock()
+	histogram, ok := v.histograms[key]
+	if !ok {
+		histogram = NewHistogram(v.buckets)
+		v.histograms[key] = histogram
+	}
+	return histogram
+}
+
+// label values are stored internally as a single string, joined with
+// a separator that cannot appear in valid UTF-8:
+const labelSeparator = "\xff"
+
+func joinLabelValues(values []string) string {
+	return strings.Join(values, labelSeparator)
+}
+
+func splitLabelValues(key string, numLabels int) []string {
+	if numLabels == 0 {
+		return nil
+	}
+	return strings.SplitN(key, labelSeparator, numLabels)
+}
+
+// Writer serializes metrics in the Prometheus text exposition format.
+// The first write error is retained and returned by Err(); subsequent
+// writes are no-ops.
+type Writer struct {
+	w   io.Writer
+	err error
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+func (mw *Writer) Err() error {
+	return mw.err
+}
+
+func (mw *Writer) printf(format string, args ...interface{}) {
+	if mw.err == nil {
+		_, mw.err = fmt.Fprintf(mw.w, format, args...)
+	}
+}
+
+func (mw *Writer) header(name, help, metricType string) {
+	mw.printf("# HELP %s %s\n", name, escapeHelp(help))
+	mw.printf("# TYPE %s %s\n", name, metricType)
+}
+
+func (mw *Writer) Counter(name, help string, value uint64) {
+	mw.header(name, help, "counter")
+	mw.printf("%s %d\n", name, value)
+}
+
+func (mw *Writer) Gauge(name, help string, value float64) {
+	mw.header(name, help, "gauge")
+	mw.printf("%s %s\n", name, formatFloat(value))
+}
+
+// GaugeVec writes a gauge family from a map of label values to values;
+// this is for gauges that are computed at scrape time.
+func (mw *Writer) GaugeVec(name, help, label string, values map[string]float64) {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+
+	mw.header(name, help, "gauge")
+	for _, key := range sortStrings(keys) {
+		mw.printf("%s%s %s\n", name, formatLabels([]string{label}, []string{key}, "", ""), formatFloat(values[key]))
+	}
+}
+
+func (mw *Writer) CounterVec(name, help string, vec *CounterVec) {
+	vec.Lock()
+	keys := make([]string, 0, len(vec.counters))
+	values := make(map[string]uint64, len(vec.counters))
+	for key, counter := range vec.counters {
+		keys = append(keys, key)
+		values[key] = counter.Value()
+	}
+	vec.Unlock()
+
+	mw.header(name, help, "counter")
+	for _, key := range sortStrings(keys) {
+		labels := formatLabels(vec.labels, splitLabelValues(key, len(vec.labels)), "", "")
+		mw.printf("%s%s %d\n", name, labels, values[key])
+	}
+}
+
+func (mw *Writer) Histogram(name, help string, h *Histogram) {
+	mw.header(name, help, "histogram")
+	mw.histogramSeries(name, nil, nil, h)
+}
+
+func (mw *Writer) HistogramVec(name, help string, vec *HistogramVec) {
+	vec.Lock()
+	keys := make([]string, 0, len(vec.histograms))
+	histograms := make(map[string]*Histogram, len(vec.histograms))
+	for key, histogram := range vec.histograms {
+		keys = append(keys, key)
+		histograms[key] = histogram
+	}
+	vec.Unlock()
+
+	mw.header(name, help, "histogram")
+	for _, key := range sortStrings(keys) {
+		mw.histogramSeries(name, vec.labels, splitLabelValues(key, len(vec.labels)), histograms[key])
+	}
+}
+
+func (mw *Writer) histogramSeries(name string, labels, values []string, h *Histogram) {
+	counts, count, sum := h.snapshot()
+	for i, bound := range h.buckets {
+		mw.printf("%s_bucket%s %d\n", name, formatLabels(labels, values, "le", formatFloat(bound)), counts[i])
+	}
+	mw.printf("%s_bucket%s %d\n", name, formatLabels(labels, values, "le", "+Inf"), count)
+	mw.printf("%s_sum%s %s\n", name, formatLabels(labels, values, "", ""), formatFloat(sum))
+	mw.printf("%s_count%s %d\n", name, formatLabels(labels, values, "", ""), count)
+}
+
+// formatLabels produces a label set like {listener=":6697",reason="dline"},
+// optionally with an extra label (used for the `le` label of histogram buckets).
+func formatLabels(labels, values []string, extraLabel, extraValue string) string {
+	if len(labels) == 0 && extraLabel == "" {
+		return ""
+	}
+	var buf strings.Builder
+	buf.WriteByte('{')
+	for i, label := range labels {
+		if i != 0 {
+			buf.WriteByte(',')
+		}
+		var value string
+		if i < len(values) {
+			value = values[i]
+		}
+		fmt.Fprintf(&buf, "%s=\"%s\"", label, escapeLabelValue(value))
+	}
+	if extraLabel != "" {
+		if len(labels) != 0 {
+			buf.WriteByte(',')
+		}
+		fmt.Fprintf(&buf, "%s=\"%s\"", extraLabel, escapeLabelValue(extraValue))
+	}
+	buf.WriteByte('}')
+	return buf.String()
+}
+
+var (
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+}
+
+func sortStrings(keys []string) []string {
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2026 agent <agent@local>
+// released under the MIT license
+
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExposition(t *testing.T) {
+	var counter Counter
+	counter.Inc()
+	counter.Add(2)
+
+	vec := NewCounterVec("listener", "reason")
+	vec.With(":6697", "dline").Inc()
+	vec.With(":6667", "limit").Add(4)
+	vec.With(":6667", "limit").Inc()
+	vec.With("\"quoted\"\n", "x").Inc()
+
+	histogram := NewHistogram([]float64{0.1, 1})
+	histogram.Observe(0.05)
+	histogram.Observe(0.5)
+	histogram.Observe(5)
+
+	var buf strings.Builder
+	w := NewWriter(&buf)
+	w.Counter("ergo_test_total", "A test\ncounter", counter.Value())
+	w.CounterVec("ergo_rejected_total", "Rejections", vec)
+	w.Gauge("ergo_gauge", "A gauge", 1.5)
+	w.GaugeVec("ergo_items", "Items", "type", map[string]float64{"client": 2, "channel": 1})
+	w.Histogram("ergo_latency_seconds", "Latency", histogram)
+	if w.Err() != nil {
+		t.Fatal(w.Err())
+	}
+
+	expected := `# HELP ergo_test_total A test\ncounter
+# TYPE ergo_test_total counter
+ergo_test_total 3
+# HELP ergo_rejected_total Rejections
+# TYPE ergo_rejected_total counter
+ergo_rejected_total{listener="\"quoted\"\n",reason="x"} 1
+ergo_rejected_total{listener=":6667",reason="limit"} 5
+ergo_rejected_total{listener=":6697",reason="dline"} 1
+# HELP ergo_gauge A gauge
+# TYPE ergo_gauge gauge
+ergo_gauge 1.5
+# HELP ergo_items Items
+# TYPE ergo_items gauge
+ergo_items{type="channel"} 1
+ergo_items{type="client"} 2
+# HELP ergo_latency_seconds Latency
+# TYPE ergo_latency_seconds histogram
+ergo_latency_seconds_bucket{le="0.1"} 1
+ergo_latency_seconds_bucket{le="1"} 2
+ergo_latency_seconds_bucket{le="+Inf"} 3
+ergo_latency_seconds_sum 5.55
+ergo_latency_seconds_count 3
+`
+	if buf.String() != expected {
+		t.Errorf("unexpected exposition:\n%s", buf.String())
+	}
+}
+
+func TestHistogramVec(t *testing.T) {
+	vec := NewHistogramVec([]float64{1}, "operation")
+	vec.With("select").Observe(0.5)
+	vec.With("insert").Observe(2)
+
+	var buf strings.Builder
+	NewWriter(&buf).HistogramVec("ergo_query_seconds", "Query latency", vec)
+	expected := `# HELP ergo_query_seconds Query latency
+# TYPE ergo_query_seconds histogram
+ergo_query_seconds_bucket{operation="insert",le="1"} 0
+ergo_query_seconds_bucket{operation="insert",le="+Inf"} 1
+ergo_query_seconds_sum{operation="insert"} 2
+ergo_query_seconds_count{operation="insert"} 1
+ergo_query_seconds_bucket{operation="select",le="1"} 1
+ergo_query_seconds_bucket{operation="select",le="+Inf"} 1
+ergo_query_seconds_sum{operation="select"} 0.5
+ergo_query_seconds_count{operation="select"} 1
+`
+	if buf.String() != expected {
+		t.Errorf("unexpected exposition:\n%s", buf.String())
+	}
+}
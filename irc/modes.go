@@ -7,6 +7,7 @@ package irc
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -189,7 +190,10 @@ func (channel *Channel) ApplyChannelModeChanges(client *Client, isSamode bool, c
 			return channelUserModeHasPrivsOver(channel.HighestUserMode(client), change.Mode)
 		case modes.InviteMask, modes.ExceptMask:
 			// listing these requires privileges
-			return channel.ClientIsAtLeast(client, modes.ChannelOperator)
+			return channel.ClientIsAtLeast(client, modes.ChannelOperator) || channel.ClientHasAccess(client, ChanAccessBans)
+		case modes.BanMask:
+			// #163: allow unprivileged users to list ban masks
+			return change.Op == modes.List || channel.ClientIsAtLeast(client, modes.ChannelOperator) || channel.ClientHasAccess(client, ChanAccessBans)
 		default:
 			// #163: allow unprivileged users to list ban masks, and any other modes
 			return change.Op == modes.List || channel.ClientIsAtLeast(client, modes.ChannelOperator)
@@ -308,6 +312,12 @@ func (channel *Channel) ApplyChannelModeChanges(client *Client, isSamode bool, c
 				rb.Add(nil, client.server.name, ERR_NEEDMOREPARAMS, client.Nick(), "MODE", client.t("Not enough parameters"))
 				continue
 			}
+			if change.Op == modes.Add && !isSamode {
+				if target := client.server.clients.Get(nick); target != nil && channel.secureOpsForbids(target) {
+					rb.Add(nil, client.server.name, ERR_CHANOPRIVSNEEDED, details.nick, chname, fmt.Sprintf(client.t("%s can't receive channel privileges while SECUREOPS is enabled"), target.Nick()))
+					continue
+				}
+			}
 
 			success, change := channel.applyModeToMember(client, change, rb)
 			if success {
@@ -387,8 +397,8 @@ func (channel *Channel) ProcessAccountToUmodeChange(client *Client, change modes
 
 	// server operators and founders can do anything:
 	hasPrivs := isOperChange || (account != "" && account == channel.registeredFounder)
-	// halfop and up can list:
-	if change.Op == modes.List && (clientMode == modes.Halfop || umodeGreaterThan(clientMode, modes.Halfop)) {
+	// halfop and up, and holders of the access-list flag, can list:
+	if change.Op == modes.List && (clientMode == modes.Halfop || umodeGreaterThan(clientMode, modes.Halfop) || channel.accountToFlags[account]&ChanAccessList != 0) {
 		hasPrivs = true
 		// you can do adds or removes at levels you have "privileges over":
 	} else if channelUserModeHasPrivsOver(clientMode, targetModeNow) && channelUserModeHasPrivsOver(clientMode, targetModeAfter) {
@@ -433,3 +443,150 @@ func (channel *Channel) ProcessAccountToUmodeChange(client *Client, change modes
 		return nil, errInvalidCharacter
 	}
 }
+
+// parseMLock parses a CS SET MLOCK value such as "+nt-ik" into the modes
+// that are locked on and off. List and prefix modes can't be locked, and
+// modes that take a parameter can only be locked off.
+func parseMLock(value string) (on, off modes.Modes, err error) {
+	if value == "" || (value[0] != '+' && value[0] != '-') {
+		return nil, nil, errInvalidParams
+	}
+	add := true
+	for _, char := range value {
+		switch char {
+		case '+':
+			add = true
+			continue
+		case '-':
+			add = false
+			continue
+		}
+		mode := modes.Mode(char)
+		switch mode {
+		case modes.BanMask, modes.ExceptMask, modes.InviteMask:
+			return nil, nil, errInvalidParams
+		case modes.Key, modes.UserLimit, modes.Forward:
+			if add {
+				return nil, nil, errInvalidParams
+			}
+		}
+		if !modeInList(modes.SupportedChannelModes, mode) {
+			return nil, nil, errInvalidParams
+		}
+		// a later occurrence of the mode overrides an earlier one
+		on = removeMode(on, mode)
+		off = removeMode(off, mode)
+		if add {
+			on = append(on, mode)
+		} else {
+			off = append(off, mode)
+		}
+	}
+	return
+}
+
+// formatMLock returns the canonical (sorted) form of a mode lock.
+func formatMLock(on, off modes.Modes) string {
+	sortModes := func(ms modes.Modes) string {
+		result := []rune(ms.String())
+		sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+		return string(result)
+	}
+	var result string
+	if len(on) != 0 {
+		result += "+" + sortModes(on)
+	}
+	if len(off) != 0 {
+		result += "-" + sortModes(off)
+	}
+	return result
+}
+
+func modeInList(list modes.Modes, mode modes.Mode) bool {
+	for _, m := range list {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+func removeMode(list modes.Modes, mode modes.Mode) modes.Modes {
+	for i, m := range list {
+		if m == mode {
+			return append(list[:i], list[i+1:]...)
+		}
+	}
+	return list
+}
+
+// filterMLockedChanges removes the mode changes that would violate the
+// channel's MLOCK setting, which binds everyone but the founder.
+func (channel *Channel) filterMLockedChanges(client *Client, changes modes.ModeChanges, rb *ResponseBuffer) (result modes.ModeChanges) {
+	channel.stateMutex.RLock()
+	founder := channel.registeredFounder
+	mlock := channel.settings.MLock
+	channel.stateMutex.RUnlock()
+
+	if founder == "" || mlock == "" || client.Account() == founder {
+		return changes
+	}
+	on, off, err := parseMLock(mlock)
+	if err != nil {
+		return changes
+	}
+	result = make(modes.ModeChanges, 0, len(changes))
+	for _, change := range changes {
+		if (change.Op == modes.Add && modeInList(off, change.Mode)) || (change.Op == modes.Remove && modeInList(on, change.Mode)) {
+			rb.Add(nil, client.server.name, ERR_MLOCKRESTRICTED, client.Nick(), channel.Name(), string(change.Mode), mlock, client.t("MODE cannot be set due to channel having an active MLOCK restriction policy"))
+			continue
+		}
+		result = append(result, change)
+	}
+	return
+}
+
+// enforceMLock brings the channel's modes into line with its MLOCK setting,
+// returning the changes that were made.
+func (channel *Channel) enforceMLock() (applied modes.ModeChanges) {
+	on, off, err := parseMLock(channel.Settings().MLock)
+	if err != nil {
+		return
+	}
+	for _, mode := range on {
+		if channel.flags.SetMode(mode, true) {
+			applied = append(applied, modes.ModeChange{Mode: mode, Op: modes.Add})
+		}
+	}
+	channel.stateMutex.Lock()
+	for _, mode := range off {
+		change := modes.ModeChange{Mode: mode, Op: modes.Remove}
+		switch mode {
+		case modes.Key:
+			if channel.key != "" {
+				channel.key = ""
+				change.Arg = "*"
+				applied = append(applied, change)
+			}
+		case modes.UserLimit:
+			if channel.userLimit != 0 {
+				channel.userLimit = 0
+				applied = append(applied, change)
+			}
+		case modes.Forward:
+			if channel.forward != "" {
+				channel.forward = ""
+				applied = append(applied, change)
+			}
+		default:
+			if channel.flags.SetMode(mode, false) {
+				applied = append(applied, change)
+			}
+		}
+	}
+	channel.stateMutex.Unlock()
+	if len(applied) != 0 {
+		channel.MarkDirty(IncludeModes)
+	}
+	return
+}
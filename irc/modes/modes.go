@@ -17,14 +17,14 @@ var (
 	// SupportedUserModes are the user modes that we actually support (modifying).
 	SupportedUserModes = Modes{
 		Bot, Invisible, Operator, RegisteredOnly, ServerNotice, UserRoleplaying,
-		UserNoCTCP,
+		UserNoCTCP, WallOps, CallerID,
 	}
 
 	// SupportedChannelModes are the channel modes that we support.
 	SupportedChannelModes = Modes{
 		BanMask, ChanRoleplaying, ExceptMask, InviteMask, InviteOnly, Key,
 		Moderated, NoOutside, OpOnlyTopic, RegisteredOnly, RegisteredOnlySpeak,
-		Secret, UserLimit, NoCTCP, Auditorium, OpModerated, Forward,
+		Secret, UserLimit, NoCTCP, Auditorium, OpModerated, Forward, NoKnock,
 	}
 )
 
@@ -100,6 +100,7 @@ func (modes Modes) String() string {
 // User Modes
 const (
 	Bot             Mode = 'B'
+	CallerID        Mode = 'g'
 	Invisible       Mode = 'i'
 	Operator        Mode = 'o'
 	Restricted      Mode = 'r'
@@ -130,6 +131,7 @@ const (
 	NoCTCP              Mode = 'C' // flag
 	OpModerated         Mode = 'U' // flag
 	Forward             Mode = 'f' // flag arg
+	NoKnock             Mode = 'K' // flag
 )
 
 var (
@@ -459,7 +461,7 @@ func ChanmodesToken() (result string) {
 	// type C: modes that take a parameter only when set, never when unset
 	C := Modes{UserLimit, Forward}
 	// type D: modes without parameters
-	D := Modes{InviteOnly, Moderated, NoOutside, OpOnlyTopic, ChanRoleplaying, Secret, NoCTCP, RegisteredOnly, RegisteredOnlySpeak, Auditorium, OpModerated}
+	D := Modes{InviteOnly, Moderated, NoOutside, OpOnlyTopic, ChanRoleplaying, Secret, NoCTCP, RegisteredOnly, RegisteredOnlySpeak, Auditorium, OpModerated, NoKnock}
 
 	sort.Sort(ByCodepoint(A))
 	sort.Sort(ByCodepoint(B))
@@ -92,3 +92,22 @@ func TestChannelUserModeHasPrivsOver(t *testing.T) {
 	assertEqual(channelUserModeHasPrivsOver(modes.ChannelFounder, modes.ChannelAdmin), true, t)
 	assertEqual(channelUserModeHasPrivsOver(modes.ChannelOperator, modes.ChannelOperator), true, t)
 }
+
+func TestParseMLock(t *testing.T) {
+	on, off, err := parseMLock("+tn-ik")
+	assertEqual(err, nil, t)
+	assertEqual(on, modes.Modes{modes.OpOnlyTopic, modes.NoOutside}, t)
+	assertEqual(off, modes.Modes{modes.InviteOnly, modes.Key}, t)
+	assertEqual(formatMLock(on, off), "+nt-ik", t)
+
+	// later occurrences override earlier ones:
+	on, off, err = parseMLock("+is-i")
+	assertEqual(err, nil, t)
+	assertEqual(formatMLock(on, off), "+s-i", t)
+
+	for _, invalid := range []string{"", "nt", "+b", "+k", "+l", "+o", "+x"} {
+		if _, _, err := parseMLock(invalid); err == nil {
+			t.Errorf("expected %#v to be rejected", invalid)
+		}
+	}
+}
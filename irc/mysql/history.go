@@ -7,7 +7,6 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
 	"runtime/debug"
@@ -18,12 +17,14 @@ import (
 
 	"github.com/ergochat/ergo/irc/history"
 	"github.com/ergochat/ergo/irc/logger"
+	"github.com/ergochat/ergo/irc/metrics"
 	"github.com/ergochat/ergo/irc/utils"
 	_ "github.com/go-sql-driver/mysql"
 )
 
 var (
-	ErrDisallowed = errors.New("disallowed")
+	ErrDisallowed = history.ErrDisallowed
+	ErrExpired    = history.ErrExpired
 )
 
 const (
@@ -60,10 +61,14 @@ type MySQL struct {
 	config     Config
 
 	wakeForgetter chan e
+
+	// latency of the user-facing operations, by operation name
+	queryLatency *metrics.HistogramVec
 }
 
 func (mysql *MySQL) Initialize(logger *logger.Manager, config Config) {
 	mysql.logger = logger
+	mysql.queryLatency = metrics.NewHistogramVec(metrics.DefaultLatencyBuckets, "operation")
 	mysql.wakeForgetter = make(chan e, 1)
 	mysql.SetConfig(config)
 }
@@ -80,6 +85,15 @@ func (mysql *MySQL) SetConfig(config Config) {
 	mysql.stateMutex.Unlock()
 }
 
+// QueryLatency returns the latency histograms for database operations.
+func (mysql *MySQL) QueryLatency() *metrics.HistogramVec {
+	return mysql.queryLatency
+}
+
+func (mysql *MySQL) observeLatency(operation string, start time.Time) {
+	mysql.queryLatency.With(operation).ObserveSince(start)
+}
+
 func (mysql *MySQL) getExpireTime() (expireTime time.Duration) {
 	mysql.stateMutex.Lock()
 	expireTime = mysql.config.ExpireTime
@@ -576,6 +590,7 @@ func (mysql *MySQL) Forget(account string) {
 
 	ctx, cancel := context.WithTimeout(context.Background(), mysql.getTimeout())
 	defer cancel()
+	defer mysql.observeLatency("forget", time.Now())
 
 	_, err := mysql.db.ExecContext(ctx, `INSERT INTO forget (account) VALUES (?);`, account)
 	if mysql.logError("can't insert into forget table", err) {
@@ -600,6 +615,7 @@ func (mysql *MySQL) AddChannelItem(target string, item history.Item, account str
 
 	ctx, cancel := context.WithTimeout(context.Background(), mysql.getTimeout())
 	defer cancel()
+	defer mysql.observeLatency("add_channel_item", time.Now())
 
 	id, err := mysql.insertBase(ctx, item)
 	if err != nil {
@@ -684,6 +700,7 @@ func (mysql *MySQL) AddDirectMessage(sender, senderAccount, recipient, recipient
 
 	ctx, cancel := context.WithTimeout(context.Background(), mysql.getTimeout())
 	defer cancel()
+	defer mysql.observeLatency("add_direct_message", time.Now())
 
 	id, err := mysql.insertBase(ctx, item)
 	if err != nil {
@@ -722,16 +739,18 @@ func (mysql *MySQL) AddDirectMessage(sender, senderAccount, recipient, recipient
 	return
 }
 
-// note that accountName is the unfolded name
-func (mysql *MySQL) DeleteMsgid(msgid, accountName string) (err error) {
+// note that accountName is the unfolded name; if notBefore is nonzero,
+// messages sent before it cannot be deleted
+func (mysql *MySQL) DeleteMsgid(msgid, accountName string, notBefore time.Time) (err error) {
 	if mysql.db == nil {
 		return nil
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), mysql.getTimeout())
 	defer cancel()
+	defer mysql.observeLatency("delete_msgid", time.Now())
 
-	_, id, data, err := mysql.lookupMsgid(ctx, msgid, true)
+	msgtime, id, data, err := mysql.lookupMsgid(ctx, msgid, true)
 	if err != nil {
 		return
 	}
@@ -745,11 +764,60 @@ func (mysql *MySQL) DeleteMsgid(msgid, accountName string) (err error) {
 		}
 	}
 
+	if msgtime.Before(notBefore) {
+		return ErrExpired
+	}
+
 	err = mysql.deleteHistoryIDs(ctx, []uint64{id})
 	mysql.logError("couldn't delete msgid", err)
 	return
 }
 
+// LookupMsgid returns the history item with the given msgid, and its targets.
+func (mysql *MySQL) LookupMsgid(msgid string) (item history.Item, targets []string, err error) {
+	if mysql.db == nil {
+		err = sql.ErrNoRows
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), mysql.getTimeout())
+	defer cancel()
+	defer mysql.observeLatency("lookup_msgid", time.Now())
+
+	_, id, data, err := mysql.lookupMsgid(ctx, msgid, true)
+	if err != nil {
+		return
+	}
+	err = unmarshalItem(data, &item)
+	if err != nil {
+		return
+	}
+	targets, err = mysql.lookupTargets(ctx, id)
+	return
+}
+
+// lookupTargets returns the channel a message was sent to (from sequence),
+// or the accounts with a copy of a direct message (from conversations).
+func (mysql *MySQL) lookupTargets(ctx context.Context, id uint64) (targets []string, err error) {
+	rows, err := mysql.db.QueryContext(ctx, `
+		SELECT target FROM sequence WHERE history_id = ?
+		UNION ALL
+		SELECT target FROM conversations WHERE history_id = ?;`, id, id)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var target string
+		if err = rows.Scan(&target); err != nil {
+			return
+		}
+		targets = append(targets, target)
+	}
+	err = rows.Err()
+	return
+}
+
 func (mysql *MySQL) Export(account string, writer io.Writer) {
 	if mysql.db == nil {
 		return
@@ -981,6 +1049,7 @@ func (mysql *MySQL) ListChannels(cfchannels []string) (results []history.TargetL
 
 	ctx, cancel := context.WithTimeout(context.Background(), mysql.getTimeout())
 	defer cancel()
+	defer mysql.observeLatency("list_channels", time.Now())
 
 	var queryBuf strings.Builder
 	args := make([]interface{}, 0, len(results))
@@ -1038,6 +1107,7 @@ type mySQLHistorySequence struct {
 func (s *mySQLHistorySequence) Between(start, end history.Selector, limit int) (results []history.Item, err error) {
 	ctx, cancel := context.WithTimeout(context.Background(), s.mysql.getTimeout())
 	defer cancel()
+	defer s.mysql.observeLatency("between", time.Now())
 
 	startTime := start.Time
 	if start.Msgid != "" {
@@ -1065,6 +1135,7 @@ func (s *mySQLHistorySequence) Around(start history.Selector, limit int) (result
 func (seq *mySQLHistorySequence) ListCorrespondents(start, end history.Selector, limit int) (results []history.TargetListing, err error) {
 	ctx, cancel := context.WithTimeout(context.Background(), seq.mysql.getTimeout())
 	defer cancel()
+	defer seq.mysql.observeLatency("list_correspondents", time.Now())
 
 	// TODO accept msgids here?
 	startTime := start.Time
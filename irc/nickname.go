@@ -107,6 +107,7 @@ func performNickChange(server *Server, client *Client, target *Client, session *
 			target.server.snomasks.Send(sno.LocalNicks, fmt.Sprintf(ircfmt.Unescape("Operator %s changed nickname of $%s$r to %s"), client.Nick(), details.nick, assignedNickname))
 		}
 		target.server.whoWas.Append(details.WhoWas)
+		target.server.links.NickChanged(target, message)
 		rb.AddFromClient(message.Time, message.Msgid, origNickMask, details.accountName, isBot, nil, "NICK", assignedNickname)
 		for session := range target.Friends() {
 			if session != rb.session {
@@ -16,6 +16,7 @@ import (
 	"github.com/ergochat/ergo/irc/custime"
 	"github.com/ergochat/ergo/irc/passwd"
 	"github.com/ergochat/ergo/irc/sno"
+	"github.com/ergochat/ergo/irc/totp"
 	"github.com/ergochat/ergo/irc/utils"
 )
 
@@ -36,6 +37,10 @@ func servCmdRequiresBouncerEnabled(config *Config) bool {
 	return config.Accounts.Multiclient.Enabled
 }
 
+func servCmdRequiresPasswordReset(config *Config) bool {
+	return config.Accounts.AuthenticationEnabled && config.Accounts.PasswordReset.Enabled
+}
+
 const nickservHelp = `NickServ lets you register, log in to, and manage an account.`
 
 var (
@@ -99,10 +104,12 @@ will not be able to use it.`,
 		},
 		"identify": {
 			handler: nsIdentifyHandler,
-			help: `Syntax: $bIDENTIFY <username> [password]$b
+			help: `Syntax: $bIDENTIFY <username> [password] [code]$b
 
 IDENTIFY lets you login to the given username using either password auth, or
-certfp (your client certificate) if a password is not given.`,
+certfp (your client certificate) if a password is not given. If your account
+has two-factor authentication enabled, give a code from your authenticator
+app (or a backup code) after the password.`,
 			helpShort: `$bIDENTIFY$b lets you login to your account.`,
 			enabled:   servCmdRequiresAuthEnabled,
 			minParams: 1,
@@ -141,6 +148,19 @@ it instead of a password to log in, send * as the password.`,
 			minParams: 1,
 			maxParams: 2,
 		},
+		"resetpass": {
+			handler: nsResetpassHandler,
+			help: `Syntax: $bRESETPASS <username>$b
+        $bRESETPASS <username> <code> <newpassword>$b
+
+RESETPASS resets the password of an account whose password you've forgotten.
+$bRESETPASS <username>$b emails a code to the account's verified email address;
+then $bRESETPASS <username> <code> <newpassword>$b sets the new password.
+Each code can only be used once, and expires after a while.`,
+			helpShort: `$bRESETPASS$b resets a forgotten password by email.`,
+			enabled:   servCmdRequiresPasswordReset,
+			minParams: 1,
+		},
 		"sadrop": {
 			handler: nsDropHandler,
 			help: `Syntax: $bSADROP <nickname>$b
@@ -208,6 +228,17 @@ or other verification.`,
 			enabled:   servCmdRequiresAccreg,
 			minParams: 2,
 		},
+		"verify-email": {
+			handler: nsVerifyEmailHandler,
+			help: `Syntax: $bVERIFY-EMAIL <code>$b
+
+VERIFY-EMAIL confirms a change of your email address (made with SET EMAIL),
+using the code that was sent to the new address.`,
+			helpShort:    `$bVERIFY-EMAIL$b confirms a change of your email address.`,
+			enabled:      servCmdRequiresAuthEnabled,
+			authRequired: true,
+			minParams:    1,
+		},
 		"passwd": {
 			handler: nsPasswdHandler,
 			help: `Syntax: $bPASSWD <current> <new> <new_again>$b
@@ -302,6 +333,10 @@ how the history of your direct messages is stored. Your options are:
 'auto-away' is only effective for always-on clients. If enabled, you will
 automatically be marked away when all your sessions are disconnected, and
 automatically return from away when you connect again.`,
+				`$bEMAIL$b
+'email' changes the email address of your account (or adds one, if it
+doesn't have one). A verification code is sent to the new address, and the
+change takes effect when you confirm it with $bVERIFY-EMAIL <code>$b.`,
 			},
 			authRequired: true,
 			enabled:      servCmdRequiresAuthEnabled,
@@ -332,6 +367,28 @@ example with $bCERT ADD <account> <fingerprint>$b.`,
 			enabled:   servCmdRequiresAuthEnabled,
 			minParams: 1,
 		},
+		"2fa": {
+			handler: ns2FAHandler,
+			help: `Syntax: $b2FA <ENABLE | VERIFY | DISABLE | RESET> [code/account]$b
+
+2FA manages two-factor authentication for your account, using codes from an
+authenticator app (TOTP). $b2FA ENABLE$b generates a secret key for the app;
+then $b2FA VERIFY <code>$b, using a code from the app, turns on two-factor
+authentication and shows you a set of single-use backup codes.
+
+Once it's on, log in with your password followed by a colon and a code (or a
+backup code), e.g., $bhunter2:123456$b. SCRAM logins are not possible, since
+they can't include a code; logins with a client certificate may also require
+a code, depending on the server configuration.
+
+$b2FA DISABLE <code>$b turns two-factor authentication off. If you're an IRC
+operator with the correct permissions, $b2FA RESET <account>$b turns it off
+for another user's account.`,
+			helpShort:    `$b2FA$b manages two-factor authentication for your account.`,
+			enabled:      servCmdRequiresAuthEnabled,
+			authRequired: true,
+			minParams:    1,
+		},
 		"suspend": {
 			handler: nsSuspendHandler,
 			help: `Syntax: $bSUSPEND ADD <nickname> [DURATION duration] [reason]$b
@@ -490,6 +547,9 @@ func nsSetHandler(service *ircService, server *Server, client *Client, command s
 	case "pass", "password":
 		service.Notice(rb, client.t("To change a password, use the PASSWD command. For details, /msg NickServ HELP PASSWD"))
 		return
+	case "email":
+		nsSetEmail(service, server, client, command, account, params[1], rb)
+		return
 	case "enforce":
 		var method NickEnforcementMethod
 		method, err = nickReservationFromString(params[1])
@@ -625,6 +685,63 @@ func nsSetHandler(service *ircService, server *Server, client *Client, command s
 	}
 }
 
+func nsSetEmail(service *ircService, server *Server, client *Client, command, account, address string, rb *ResponseBuffer) {
+	config := server.Config()
+	// see parseCallback
+	address = strings.TrimPrefix(strings.ToLower(address), "mailto:")
+	if strings.IndexByte(address, '@') < 1 {
+		service.Notice(rb, client.t("Invalid email address"))
+		return
+	}
+
+	var err error
+	if command == "saset" {
+		// operators can set the address without verifying it
+		err = server.accounts.SetEmail(account, address)
+		if err == nil {
+			service.Notice(rb, fmt.Sprintf(client.t("Email address for account %[1]s set to %[2]s"), account, address))
+			server.snomasks.Send(sno.LocalAccounts, fmt.Sprintf(ircfmt.Unescape("Operator $c[grey][$r%s$c[grey]] set the email address of account $c[grey][$r%s$c[grey]]"), client.Oper().Name, account))
+		} else if err == errAccountDoesNotExist {
+			service.Notice(rb, client.t(err.Error()))
+		} else {
+			service.Notice(rb, client.t("An error occurred"))
+		}
+		return
+	}
+
+	err = server.accounts.StartEmailChange(client, account, address)
+	if throttled, ok := err.(*ThrottleError); ok {
+		service.Notice(rb, throttled.Error())
+		return
+	}
+	switch err {
+	case nil:
+		service.Notice(rb, fmt.Sprintf(client.t("A verification code was sent to %s; confirm the new address with /msg NickServ VERIFY-EMAIL <code>"), address))
+	case errFeatureDisabled, errAccountUnverified:
+		service.Notice(rb, client.t(err.Error()))
+	case errLimitExceeded:
+		service.Notice(rb, client.t("A verification code was sent recently; please wait before changing your email address again"))
+	default:
+		if emailError := registrationCallbackErrorText(config, client, err); emailError != "" {
+			service.Notice(rb, emailError)
+		} else {
+			service.Notice(rb, client.t("An error occurred"))
+		}
+	}
+}
+
+func nsVerifyEmailHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	address, err := server.accounts.VerifyEmailChange(client, client.Account(), params[0])
+	switch err {
+	case nil:
+		service.Notice(rb, fmt.Sprintf(client.t("Your email address is now %s"), address))
+	case errAccountVerificationInvalidCode:
+		service.Notice(rb, client.t(err.Error()))
+	default:
+		service.Notice(rb, client.t("An error occurred"))
+	}
+}
+
 func nsDropHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
 	sadrop := command == "sadrop"
 	var nick string
@@ -720,6 +837,10 @@ func nsIdentifyHandler(service *ircService, server *Server, client *Client, comm
 	} else {
 		username = params[0]
 		passphrase = params[1]
+		if len(params) > 2 {
+			// two-factor authentication code, see AuthenticateByPassphrase
+			passphrase = passphrase + ":" + params[2]
+		}
 	}
 
 	// try passphrase
@@ -750,6 +871,42 @@ func nsIdentifyHandler(service *ircService, server *Server, client *Client, comm
 	}
 }
 
+func nsResetpassHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	var err error
+	switch len(params) {
+	case 1:
+		err = server.accounts.SendPasswordReset(client, params[0])
+		if err == nil {
+			// don't reveal whether the account exists or has an email address
+			service.Notice(rb, client.t("If that account exists and has a verified email address, a password reset code was sent to it"))
+			return
+		}
+	case 3:
+		err = server.accounts.ResetPassword(client, params[0], params[1], params[2])
+		if err == nil {
+			service.Notice(rb, client.t("Password reset; you can now log in with your new password"))
+			return
+		}
+	default:
+		service.Notice(rb, client.t("Invalid parameters"))
+		return
+	}
+
+	if throttled, ok := err.(*ThrottleError); ok {
+		service.Notice(rb, throttled.Error())
+		return
+	}
+	switch err {
+	case errAccountBadPassphrase:
+		service.Notice(rb, client.t("Invalid password"))
+	case errInvalidPasswordResetCode, errAccountSuspended:
+		service.Notice(rb, client.t(err.Error()))
+	default:
+		server.logger.Error("internal", "could not reset password", err.Error())
+		service.Notice(rb, client.t("An error occurred"))
+	}
+}
+
 func nsListHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
 	if !client.HasRoleCapabs("accreg") {
 		service.Notice(rb, client.t("Insufficient privileges"))
@@ -1221,6 +1378,87 @@ func nsClientsLogoutHandler(service *ircService, server *Server, client *Client,
 	}
 }
 
+func ns2FAHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	verb := strings.ToLower(params[0])
+	params = params[1:]
+	config := server.Config()
+	accountName := client.AccountName()
+
+	switch verb {
+	case "enable":
+		if !config.Accounts.TOTP.Enabled {
+			service.Notice(rb, client.t("Two-factor authentication is disabled on this server"))
+			return
+		}
+		secret, err := server.accounts.StartTOTPEnrolment(accountName)
+		switch err {
+		case nil:
+			service.Notice(rb, client.t("Add your account to your authenticator app, using this URI (e.g., as a QR code) or secret key:"))
+			service.Notice(rb, totp.ProvisioningURI(config.Network.Name, accountName, secret))
+			service.Notice(rb, fmt.Sprintf(client.t("Secret key: %s"), totp.EncodeSecret(secret)))
+			service.Notice(rb, ircfmt.Unescape(client.t("Then turn on two-factor authentication with $b/NS 2FA VERIFY <code>$b, using a code from the app")))
+		case errNoop:
+			service.Notice(rb, client.t("Two-factor authentication is already enabled for your account"))
+		default:
+			server.logger.Error("internal", "could not start 2FA enrolment", accountName, err.Error())
+			service.Notice(rb, client.t("An error occurred"))
+		}
+	case "verify":
+		if len(params) == 0 {
+			service.Notice(rb, client.t("Invalid parameters"))
+			return
+		}
+		backupCodes, err := server.accounts.ConfirmTOTPEnrolment(accountName, params[0])
+		switch err {
+		case nil:
+			service.Notice(rb, client.t("Two-factor authentication is now enabled. Log in with your password followed by a colon and a code from your authenticator app"))
+			service.Notice(rb, client.t("If you lose your authenticator app, you can log in with one of these backup codes instead (once each); keep them somewhere safe:"))
+			service.Notice(rb, strings.Join(backupCodes, " "))
+		case errNoop:
+			service.Notice(rb, ircfmt.Unescape(client.t("You don't have a two-factor enrolment in progress; start one with $b/NS 2FA ENABLE$b")))
+		case errInvalidTOTPCode:
+			service.Notice(rb, client.t("Invalid code"))
+		default:
+			server.logger.Error("internal", "could not confirm 2FA enrolment", accountName, err.Error())
+			service.Notice(rb, client.t("An error occurred"))
+		}
+	case "disable", "reset":
+		var code string
+		hasPrivs := verb == "reset"
+		if hasPrivs {
+			if !client.HasRoleCapabs("accreg") {
+				service.Notice(rb, client.t("Insufficient privileges"))
+				return
+			} else if len(params) == 0 {
+				service.Notice(rb, client.t("Invalid parameters"))
+				return
+			}
+			accountName = params[0]
+		} else if len(params) > 0 {
+			code = params[0]
+		}
+		err := server.accounts.DisableTOTP(accountName, code, hasPrivs)
+		switch err {
+		case nil:
+			service.Notice(rb, client.t("Two-factor authentication is now disabled"))
+			if hasPrivs {
+				server.snomasks.Send(sno.LocalAccounts, fmt.Sprintf(ircfmt.Unescape("Operator $c[grey][$r%s$c[grey]] disabled two-factor authentication for account $c[grey][$r%s$c[grey]]"), client.Oper().Name, accountName))
+			}
+		case errNoop:
+			service.Notice(rb, client.t("Two-factor authentication is not enabled"))
+		case errInvalidTOTPCode:
+			service.Notice(rb, client.t("Invalid code"))
+		case errAccountDoesNotExist:
+			service.Notice(rb, client.t("Account does not exist"))
+		default:
+			server.logger.Error("internal", "could not disable 2FA", accountName, err.Error())
+			service.Notice(rb, client.t("An error occurred"))
+		}
+	default:
+		service.Notice(rb, client.t("Invalid parameters"))
+	}
+}
+
 func nsCertHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
 	verb := strings.ToLower(params[0])
 	params = params[1:]
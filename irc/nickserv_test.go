@@ -0,0 +1,214 @@
+// Copyright (c) 2026 agent <agent@local>
+// released under the MIT license
+
+package irc
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tidwall/buntdb"
+)
+
+// mailCode returns the code following prefix in an email body.
+func mailCode(t *testing.T, message, prefix string) string {
+	t.Helper()
+	idx := strings.Index(message, prefix)
+	if idx == -1 {
+		t.Fatalf("no %q in email: %q", prefix, message)
+	}
+	return strings.Fields(message[idx+len(prefix):])[0]
+}
+
+// expectNoMail checks that the test MTA doesn't receive a message for a while.
+func expectNoMail(t *testing.T, messages chan string) {
+	t.Helper()
+	select {
+	case message := <-messages:
+		t.Fatalf("unexpected email: %q", message)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestEmailChangeCooldown(t *testing.T) {
+	port, messages := newTestMTA(t)
+	overrides := testMailOverrides(port)
+	overrides["accounts.email-change.cooldown"] = "1h"
+	overrides["accounts.email-change.ip-throttling.enabled"] = false
+	server, addr := newTestServer(t, overrides)
+	registerTestAccount(t, server, "alice", "pw")
+	alice := connectTestClient(t, addr, "alice", "pw")
+
+	reply := alice.serviceCommand("NickServ", "SET EMAIL alice@example.com")
+	if !strings.HasPrefix(reply, "A verification code was sent") {
+		t.Fatalf("unexpected reply: %q", reply)
+	}
+	code := mailCode(t, expectMail(t, messages), "VERIFY-EMAIL ")
+
+	// a second change is refused within the cooldown, without sending email:
+	reply = alice.serviceCommand("NickServ", "SET EMAIL mallory@example.com")
+	if !strings.HasPrefix(reply, "A verification code was sent recently") {
+		t.Fatalf("unexpected reply: %q", reply)
+	}
+	assertEqual(len(messages), 0, t)
+
+	// the pending code still works:
+	reply = alice.serviceCommand("NickServ", "VERIFY-EMAIL "+code)
+	assertEqual(reply, "Your email address is now alice@example.com", t)
+	account, err := server.accounts.LoadAccount("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(account.Email, "alice@example.com", t)
+
+	// verifying doesn't reset the cooldown, and the code is single-use:
+	reply = alice.serviceCommand("NickServ", "SET EMAIL mallory@example.com")
+	if !strings.HasPrefix(reply, "A verification code was sent recently") {
+		t.Fatalf("unexpected reply: %q", reply)
+	}
+	reply = alice.serviceCommand("NickServ", "VERIFY-EMAIL "+code)
+	assertEqual(reply, errAccountVerificationInvalidCode.Error(), t)
+}
+
+func TestEmailChangeIPThrottle(t *testing.T) {
+	port, messages := newTestMTA(t)
+	overrides := testMailOverrides(port)
+	overrides["accounts.email-change.cooldown"] = "0s"
+	overrides["accounts.email-change.ip-throttling.max-attempts"] = 2
+	server, addr := newTestServer(t, overrides)
+	registerTestAccount(t, server, "alice", "pw")
+	registerTestAccount(t, server, "bob", "pw")
+	alice := connectTestClient(t, addr, "alice", "pw")
+	bob := connectTestClient(t, addr, "bob", "pw")
+
+	for i := 0; i < 2; i++ {
+		reply := alice.serviceCommand("NickServ", "SET EMAIL alice@example.com")
+		if !strings.HasPrefix(reply, "A verification code was sent") {
+			t.Fatalf("unexpected reply: %q", reply)
+		}
+		expectMail(t, messages)
+	}
+	// the throttle is per IP, not per account:
+	reply := bob.serviceCommand("NickServ", "SET EMAIL bob@example.com")
+	if !strings.HasPrefix(reply, "Please wait") {
+		t.Fatalf("unexpected reply: %q", reply)
+	}
+	assertEqual(len(messages), 0, t)
+}
+
+func newPasswordResetTestServer(t *testing.T, overrides map[string]interface{}) (server *Server, addr string, messages chan string) {
+	port, messages := newTestMTA(t)
+	config := testMailOverrides(port)
+	config["accounts.password-reset.enabled"] = true
+	config["accounts.login-throttling.enabled"] = false
+	for key, value := range overrides {
+		config[key] = value
+	}
+	server, addr = newTestServer(t, config)
+	registerTestAccount(t, server, "alice", "oldpassword")
+	if err := server.accounts.SetEmail("alice", "alice@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+const passwordResetSent = "If that account exists and has a verified email address, a password reset code was sent to it"
+
+func TestPasswordReset(t *testing.T) {
+	server, addr, messages := newPasswordResetTestServer(t, map[string]interface{}{
+		"accounts.password-reset.ip-throttling.enabled": false,
+	})
+	alice := connectTestClient(t, addr, "alice", "oldpassword")
+	bob := connectTestClient(t, addr, "bob", "")
+
+	// the reply doesn't reveal whether the account exists:
+	assertEqual(bob.serviceCommand("NickServ", "RESETPASS mallory"), passwordResetSent, t)
+	expectNoMail(t, messages)
+	assertEqual(bob.serviceCommand("NickServ", "RESETPASS alice"), passwordResetSent, t)
+	message := expectMail(t, messages)
+	if !strings.Contains(message, "To: alice@example.com\n") {
+		t.Fatalf("reset code sent to the wrong address: %q", message)
+	}
+	code := mailCode(t, message, "RESETPASS alice ")
+
+	// a second code can't be requested during the cooldown:
+	assertEqual(bob.serviceCommand("NickServ", "RESETPASS alice"), passwordResetSent, t)
+	expectNoMail(t, messages)
+
+	assertEqual(bob.serviceCommand("NickServ", "RESETPASS alice "+code+"x newpassword"), errInvalidPasswordResetCode.Error(), t)
+	assertEqual(bob.serviceCommand("NickServ", "RESETPASS mallory "+code+" newpassword"), errInvalidPasswordResetCode.Error(), t)
+	// a wrong code consumes nothing, but a right one is single-use:
+	assertEqual(bob.serviceCommand("NickServ", "RESETPASS alice "+code+" newpassword"), "Password reset; you can now log in with your new password", t)
+	assertEqual(bob.serviceCommand("NickServ", "RESETPASS alice "+code+" otherpassword"), errInvalidPasswordResetCode.Error(), t)
+
+	// the account's sessions were logged out:
+	alice.Expect("ERROR")
+	_, err := server.accounts.checkPassphrase("alice", "oldpassword")
+	assertEqual(err, errAccountInvalidCredentials, t)
+	connectTestClient(t, addr, "alice", "newpassword")
+
+	// the cooldown still applies after the code is used:
+	assertEqual(bob.serviceCommand("NickServ", "RESETPASS alice"), passwordResetSent, t)
+	expectNoMail(t, messages)
+}
+
+func TestPasswordResetExpiry(t *testing.T) {
+	server, addr, messages := newPasswordResetTestServer(t, map[string]interface{}{
+		"accounts.password-reset.cooldown":              "0s",
+		"accounts.password-reset.timeout":               "1h",
+		"accounts.password-reset.ip-throttling.enabled": false,
+	})
+	bob := connectTestClient(t, addr, "bob", "")
+
+	assertEqual(bob.serviceCommand("NickServ", "RESETPASS alice"), passwordResetSent, t)
+	code := mailCode(t, expectMail(t, messages), "RESETPASS alice ")
+	// move the code's creation time back past the timeout:
+	key := fmt.Sprintf(keyAccountPwReset, "alice")
+	err := server.store.Update(func(tx *buntdb.Tx) error {
+		recordStr, err := tx.Get(key)
+		if err != nil {
+			return err
+		}
+		var record PasswordResetRecord
+		if err := json.Unmarshal([]byte(recordStr), &record); err != nil {
+			return err
+		}
+		record.CreatedAt = record.CreatedAt.Add(-2 * time.Hour)
+		recordBytes, _ := json.Marshal(record)
+		_, _, err = tx.Set(key, string(recordBytes), nil)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(bob.serviceCommand("NickServ", "RESETPASS alice "+code+" newpassword"), errInvalidPasswordResetCode.Error(), t)
+
+	// without a cooldown, a new code can be requested right away, replacing the old one:
+	assertEqual(bob.serviceCommand("NickServ", "RESETPASS alice"), passwordResetSent, t)
+	newCode := mailCode(t, expectMail(t, messages), "RESETPASS alice ")
+	assertEqual(bob.serviceCommand("NickServ", "RESETPASS alice"), passwordResetSent, t)
+	newerCode := mailCode(t, expectMail(t, messages), "RESETPASS alice ")
+	assertEqual(bob.serviceCommand("NickServ", "RESETPASS alice "+newCode+" newpassword"), errInvalidPasswordResetCode.Error(), t)
+	assertEqual(bob.serviceCommand("NickServ", "RESETPASS alice "+newerCode+" newpassword"), "Password reset; you can now log in with your new password", t)
+}
+
+func TestPasswordResetIPThrottle(t *testing.T) {
+	_, addr, messages := newPasswordResetTestServer(t, map[string]interface{}{
+		"accounts.password-reset.ip-throttling.max-attempts": 2,
+	})
+	bob := connectTestClient(t, addr, "bob", "")
+	carol := connectTestClient(t, addr, "carol", "")
+
+	assertEqual(bob.serviceCommand("NickServ", "RESETPASS alice"), passwordResetSent, t)
+	expectMail(t, messages)
+	assertEqual(bob.serviceCommand("NickServ", "RESETPASS mallory"), passwordResetSent, t)
+	// the throttle is per IP, and counts requests for any account:
+	reply := carol.serviceCommand("NickServ", "RESETPASS alice")
+	if !strings.HasPrefix(reply, "Please wait") {
+		t.Fatalf("unexpected reply: %q", reply)
+	}
+	expectNoMail(t, messages)
+}
@@ -19,6 +19,8 @@ const (
 	RPL_ISUPPORT                  = "005"
 	RPL_SNOMASKIS                 = "008"
 	RPL_BOUNCE                    = "010"
+	RPL_MAP                       = "015"
+	RPL_MAPEND                    = "017"
 	RPL_TRACELINK                 = "200"
 	RPL_TRACECONNECTING           = "201"
 	RPL_TRACEHANDSHAKE            = "202"
@@ -32,8 +34,12 @@ const (
 	RPL_TRACERECONNECT            = "210"
 	RPL_STATSLINKINFO             = "211"
 	RPL_STATSCOMMANDS             = "212"
+	RPL_STATSKLINE                = "216"
+	RPL_STATSYLINE                = "218"
 	RPL_ENDOFSTATS                = "219"
+	RPL_STATSPLINE                = "220"
 	RPL_UMODEIS                   = "221"
+	RPL_STATSDLINE                = "225"
 	RPL_SERVLIST                  = "234"
 	RPL_SERVLISTEND               = "235"
 	RPL_STATSUPTIME               = "242"
@@ -52,6 +58,10 @@ const (
 	RPL_TRYAGAIN                  = "263"
 	RPL_LOCALUSERS                = "265"
 	RPL_GLOBALUSERS               = "266"
+	RPL_SILELIST                  = "271"
+	RPL_ENDOFSILELIST             = "272"
+	RPL_ACCEPTLIST                = "281"
+	RPL_ENDOFACCEPT               = "282"
 	RPL_WHOISCERTFP               = "276"
 	RPL_AWAY                      = "301"
 	RPL_USERHOST                  = "302"
@@ -141,6 +151,9 @@ const (
 	ERR_SUMMONDISABLED            = "445"
 	ERR_USERSDISABLED             = "446"
 	ERR_NOTREGISTERED             = "451"
+	ERR_ACCEPTFULL                = "456"
+	ERR_ACCEPTEXIST               = "457"
+	ERR_ACCEPTNOT                 = "458"
 	ERR_NEEDMOREPARAMS            = "461"
 	ERR_ALREADYREGISTRED          = "462"
 	ERR_NOPERMFORHOST             = "463"
@@ -158,6 +171,7 @@ const (
 	ERR_BADCHANMASK               = "476"
 	ERR_NEEDREGGEDNICK            = "477" // conflicted with ERR_NOCHANMODES; see #936
 	ERR_BANLISTFULL               = "478"
+	ERR_CANNOTKNOCK               = "480"
 	ERR_NOPRIVILEGES              = "481"
 	ERR_CHANOPRIVSNEEDED          = "482"
 	ERR_CANTKILLSERVER            = "483"
@@ -166,6 +180,7 @@ const (
 	ERR_NOOPERHOST                = "491"
 	ERR_UMODEUNKNOWNFLAG          = "501"
 	ERR_USERSDONTMATCH            = "502"
+	ERR_SILELISTFULL              = "511"
 	ERR_HELPNOTFOUND              = "524"
 	ERR_CANNOTSENDRP              = "573"
 	RPL_WHOWASIP                  = "652"
@@ -177,12 +192,25 @@ const (
 	RPL_HELPSTART                 = "704"
 	RPL_HELPTXT                   = "705"
 	RPL_ENDOFHELP                 = "706"
+	RPL_KNOCKDLVR                 = "711"
+	ERR_TOOMANYKNOCK              = "712"
+	ERR_CHANOPEN                  = "713"
+	ERR_KNOCKONCHAN               = "714"
+	ERR_TARGUMODEG                = "716"
+	RPL_TARGNOTIFY                = "717"
+	RPL_UMODEGMSG                 = "718"
 	ERR_NOPRIVS                   = "723"
 	RPL_MONONLINE                 = "730"
 	RPL_MONOFFLINE                = "731"
 	RPL_MONLIST                   = "732"
 	RPL_ENDOFMONLIST              = "733"
 	ERR_MONLISTFULL               = "734"
+	ERR_MLOCKRESTRICTED           = "742"
+	RPL_KEYVALUE                  = "761"
+	RPL_KEYNOTSET                 = "766"
+	RPL_METADATASUBOK             = "770"
+	RPL_METADATAUNSUBOK           = "771"
+	RPL_METADATASUBS              = "772"
 	RPL_LOGGEDIN                  = "900"
 	RPL_LOGGEDOUT                 = "901"
 	ERR_NICKLOCKED                = "902"
@@ -0,0 +1,29 @@
+// Copyright (c) 2026 agent <agent@local>
+// released under the MIT license
+
+package postgresql
+
+import (
+	"time"
+)
+
+type Config struct {
+	// these are intended to be written directly into the config file:
+	Enabled bool
+	Host    string
+	Port    int
+	// directory containing the server's Unix socket, e.g., /var/run/postgresql
+	SocketPath      string `yaml:"socket-path"`
+	User            string
+	Password        string
+	HistoryDatabase string `yaml:"history-database"`
+	// disable, require, verify-ca, or verify-full
+	SSLMode         string `yaml:"ssl-mode"`
+	Timeout         time.Duration
+	MaxConns        int           `yaml:"max-conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn-max-lifetime"`
+
+	// XXX these are copied from elsewhere in the config:
+	ExpireTime           time.Duration
+	TrackAccountMessages bool
+}
@@ -0,0 +1,1124 @@
+// Copyright (c) 2026 agent <agent@local>
+// released under the MIT license
+
+package postgresql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ergochat/ergo/irc/history"
+	"github.com/ergochat/ergo/irc/logger"
+	"github.com/ergochat/ergo/irc/metrics"
+	"github.com/ergochat/ergo/irc/utils"
+	_ "github.com/lib/pq"
+)
+
+const (
+	// maximum length in bytes of any message target (nickname or channel name) in its
+	// canonicalized (i.e., casefolded) state:
+	MaxTargetLength = 64
+
+	// latest schema of the db
+	latestDbSchema   = "1"
+	keySchemaVersion = "db.version"
+	cleanupRowLimit  = 50
+	cleanupPauseTime = 10 * time.Minute
+)
+
+type e struct{}
+
+// PostgreSQL is a persistent history backend; it uses the same tables
+// and queries as the MySQL backend, adapted to PostgreSQL's dialect.
+type PostgreSQL struct {
+	timeout              int64
+	trackAccountMessages uint32
+	db                   *sql.DB
+	logger               *logger.Manager
+
+	insertHistory        *sql.Stmt
+	insertSequence       *sql.Stmt
+	insertConversation   *sql.Stmt
+	insertCorrespondent  *sql.Stmt
+	insertAccountMessage *sql.Stmt
+
+	stateMutex sync.Mutex
+	config     Config
+
+	wakeForgetter chan e
+
+	// latency of the user-facing operations, by operation name
+	queryLatency *metrics.HistogramVec
+}
+
+func (pg *PostgreSQL) Initialize(logger *logger.Manager, config Config) {
+	pg.logger = logger
+	pg.queryLatency = metrics.NewHistogramVec(metrics.DefaultLatencyBuckets, "operation")
+	pg.wakeForgetter = make(chan e, 1)
+	pg.SetConfig(config)
+}
+
+func (pg *PostgreSQL) SetConfig(config Config) {
+	atomic.StoreInt64(&pg.timeout, int64(config.Timeout))
+	var trackAccountMessages uint32
+	if config.TrackAccountMessages {
+		trackAccountMessages = 1
+	}
+	atomic.StoreUint32(&pg.trackAccountMessages, trackAccountMessages)
+	pg.stateMutex.Lock()
+	pg.config = config
+	pg.stateMutex.Unlock()
+}
+
+// QueryLatency returns the latency histograms for database operations.
+func (pg *PostgreSQL) QueryLatency() *metrics.HistogramVec {
+	return pg.queryLatency
+}
+
+func (pg *PostgreSQL) observeLatency(operation string, start time.Time) {
+	pg.queryLatency.With(operation).ObserveSince(start)
+}
+
+func (pg *PostgreSQL) getExpireTime() (expireTime time.Duration) {
+	pg.stateMutex.Lock()
+	expireTime = pg.config.ExpireTime
+	pg.stateMutex.Unlock()
+	return
+}
+
+// quote a value for a key/value connection string
+func quoteConnParam(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `'`, `\'`)
+	return "'" + value + "'"
+}
+
+func (config *Config) connString() string {
+	params := make([]string, 0, 8)
+	if config.SocketPath != "" {
+		params = append(params, "host="+quoteConnParam(config.SocketPath))
+	} else if config.Host != "" {
+		params = append(params, "host="+quoteConnParam(config.Host))
+	}
+	if config.Port != 0 {
+		params = append(params, fmt.Sprintf("port=%d", config.Port))
+	}
+	params = append(params, "user="+quoteConnParam(config.User))
+	if config.Password != "" {
+		params = append(params, "password="+quoteConnParam(config.Password))
+	}
+	params = append(params, "dbname="+quoteConnParam(config.HistoryDatabase))
+	if config.SSLMode != "" {
+		params = append(params, "sslmode="+quoteConnParam(config.SSLMode))
+	}
+	if config.Timeout != 0 {
+		// connect_timeout is in seconds, with a minimum of 2
+		seconds := int(config.Timeout / time.Second)
+		if seconds < 2 {
+			seconds = 2
+		}
+		params = append(params, fmt.Sprintf("connect_timeout=%d", seconds))
+	}
+	return strings.Join(params, " ")
+}
+
+func (pg *PostgreSQL) Open() (err error) {
+	return pg.open(pg.config.connString())
+}
+
+func (pg *PostgreSQL) open(connString string) (err error) {
+	pg.db, err = sql.Open("postgres", connString)
+	if err != nil {
+		return err
+	}
+
+	if pg.config.MaxConns != 0 {
+		pg.db.SetMaxOpenConns(pg.config.MaxConns)
+		pg.db.SetMaxIdleConns(pg.config.MaxConns)
+	}
+	if pg.config.ConnMaxLifetime != 0 {
+		pg.db.SetConnMaxLifetime(pg.config.ConnMaxLifetime)
+	}
+
+	err = pg.fixSchemas()
+	if err != nil {
+		return err
+	}
+
+	err = pg.prepareStatements()
+	if err != nil {
+		return err
+	}
+
+	go pg.cleanupLoop()
+	go pg.forgetLoop()
+
+	return nil
+}
+
+func (pg *PostgreSQL) fixSchemas() (err error) {
+	_, err = pg.db.Exec(`CREATE TABLE IF NOT EXISTS metadata (
+		key_name VARCHAR(32) PRIMARY KEY,
+		value VARCHAR(32) NOT NULL
+	);`)
+	if err != nil {
+		return err
+	}
+
+	var schema string
+	err = pg.db.QueryRow(`SELECT value FROM metadata WHERE key_name = $1;`, keySchemaVersion).Scan(&schema)
+	if err == sql.ErrNoRows {
+		return pg.createTables()
+	} else if err == nil && schema != latestDbSchema {
+		// TODO figure out what to do about schema changes
+		return fmt.Errorf("incompatible schema: got %s, expected %s", schema, latestDbSchema)
+	}
+	return err
+}
+
+func (pg *PostgreSQL) createTables() (err error) {
+	// DDL is transactional in PostgreSQL, so a failure here leaves no partial schema
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	statements := []string{
+		`CREATE TABLE history (
+			id BIGSERIAL PRIMARY KEY,
+			data BYTEA NOT NULL,
+			msgid BYTEA NOT NULL
+		);`,
+		`CREATE INDEX history_msgid ON history (msgid);`,
+
+		fmt.Sprintf(`CREATE TABLE sequence (
+			history_id BIGINT PRIMARY KEY,
+			target VARCHAR(%[1]d) COLLATE "C" NOT NULL,
+			nanotime BIGINT NOT NULL
+		);`, MaxTargetLength),
+		`CREATE INDEX sequence_target_nanotime ON sequence (target, nanotime);`,
+
+		fmt.Sprintf(`CREATE TABLE conversations (
+			id BIGSERIAL PRIMARY KEY,
+			target VARCHAR(%[1]d) COLLATE "C" NOT NULL,
+			correspondent VARCHAR(%[1]d) COLLATE "C" NOT NULL,
+			nanotime BIGINT NOT NULL,
+			history_id BIGINT NOT NULL
+		);`, MaxTargetLength),
+		`CREATE INDEX conversations_target_correspondent_nanotime ON conversations (target, correspondent, nanotime);`,
+		`CREATE INDEX conversations_history_id ON conversations (history_id);`,
+
+		fmt.Sprintf(`CREATE TABLE correspondents (
+			id BIGSERIAL PRIMARY KEY,
+			target VARCHAR(%[1]d) COLLATE "C" NOT NULL,
+			correspondent VARCHAR(%[1]d) COLLATE "C" NOT NULL,
+			nanotime BIGINT NOT NULL,
+			UNIQUE (target, correspondent)
+		);`, MaxTargetLength),
+		`CREATE INDEX correspondents_target_nanotime ON correspondents (target, nanotime);`,
+		`CREATE INDEX correspondents_nanotime ON correspondents (nanotime);`,
+
+		fmt.Sprintf(`CREATE TABLE account_messages (
+			history_id BIGINT PRIMARY KEY,
+			account VARCHAR(%[1]d) COLLATE "C" NOT NULL
+		);`, MaxTargetLength),
+		`CREATE INDEX account_messages_account_history_id ON account_messages (account, history_id);`,
+
+		fmt.Sprintf(`CREATE TABLE forget (
+			id BIGSERIAL PRIMARY KEY,
+			account VARCHAR(%[1]d) COLLATE "C" NOT NULL
+		);`, MaxTargetLength),
+	}
+	for _, statement := range statements {
+		_, err = tx.Exec(statement)
+		if err != nil {
+			return
+		}
+	}
+
+	_, err = tx.Exec(`INSERT INTO metadata (key_name, value) VALUES ($1, $2);`, keySchemaVersion, latestDbSchema)
+	if err != nil {
+		return
+	}
+	return tx.Commit()
+}
+
+func (pg *PostgreSQL) cleanupLoop() {
+	defer func() {
+		if r := recover(); r != nil {
+			pg.logger.Error("postgresql",
+				fmt.Sprintf("Panic in cleanup routine: %v\n%s", r, debug.Stack()))
+			time.Sleep(cleanupPauseTime)
+			go pg.cleanupLoop()
+		}
+	}()
+
+	for {
+		expireTime := pg.getExpireTime()
+		if expireTime != 0 {
+			for {
+				startTime := time.Now()
+				rowsDeleted, err := pg.doCleanup(expireTime)
+				elapsed := time.Now().Sub(startTime)
+				pg.logError("error during row cleanup", err)
+				// keep going as long as we're accomplishing significant work
+				// (don't busy-wait on small numbers of rows expiring):
+				if rowsDeleted < (cleanupRowLimit / 10) {
+					break
+				}
+				// crude backpressure mechanism: if the database is slow,
+				// give it time to process other queries
+				time.Sleep(elapsed)
+			}
+		}
+		time.Sleep(cleanupPauseTime)
+	}
+}
+
+func (pg *PostgreSQL) doCleanup(age time.Duration) (count int, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupPauseTime)
+	defer cancel()
+
+	ids, maxNanotime, err := pg.selectCleanupIDs(ctx, age)
+	if len(ids) == 0 {
+		pg.logger.Debug("postgresql", "found no rows to clean up")
+		return
+	}
+
+	pg.logger.Debug("postgresql", fmt.Sprintf("deleting %d history rows, max age %s", len(ids), utils.NanoToTimestamp(maxNanotime)))
+
+	if maxNanotime != 0 {
+		pg.deleteCorrespondents(ctx, maxNanotime)
+	}
+
+	return len(ids), pg.deleteHistoryIDs(ctx, ids)
+}
+
+func (pg *PostgreSQL) deleteHistoryIDs(ctx context.Context, ids []uint64) (err error) {
+	// can't use $n binding for a variable number of arguments, build the IN clause manually
+	var inBuf strings.Builder
+	inBuf.WriteByte('(')
+	for i, id := range ids {
+		if i != 0 {
+			inBuf.WriteRune(',')
+		}
+		fmt.Fprintf(&inBuf, "%d", id)
+	}
+	inBuf.WriteRune(')')
+	inClause := inBuf.String()
+
+	_, err = pg.db.ExecContext(ctx, fmt.Sprintf(`DELETE FROM conversations WHERE history_id IN %s;`, inClause))
+	if err != nil {
+		return
+	}
+	_, err = pg.db.ExecContext(ctx, fmt.Sprintf(`DELETE FROM sequence WHERE history_id IN %s;`, inClause))
+	if err != nil {
+		return
+	}
+	if pg.isTrackingAccountMessages() {
+		_, err = pg.db.ExecContext(ctx, fmt.Sprintf(`DELETE FROM account_messages WHERE history_id IN %s;`, inClause))
+		if err != nil {
+			return
+		}
+	}
+	_, err = pg.db.ExecContext(ctx, fmt.Sprintf(`DELETE FROM history WHERE id IN %s;`, inClause))
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func (pg *PostgreSQL) selectCleanupIDs(ctx context.Context, age time.Duration) (ids []uint64, maxNanotime int64, err error) {
+	rows, err := pg.db.QueryContext(ctx, `
+		SELECT history.id, sequence.nanotime, conversations.nanotime
+		FROM history
+		LEFT JOIN sequence ON history.id = sequence.history_id
+		LEFT JOIN conversations ON history.id = conversations.history_id
+		ORDER BY history.id LIMIT $1;`, cleanupRowLimit)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	idset := make(map[uint64]struct{}, cleanupRowLimit)
+	threshold := time.Now().Add(-age).UnixNano()
+	for rows.Next() {
+		var id uint64
+		var seqNano, convNano sql.NullInt64
+		err = rows.Scan(&id, &seqNano, &convNano)
+		if err != nil {
+			return
+		}
+		nanotime := extractNanotime(seqNano, convNano)
+		// returns 0 if not found; in that case the data is inconsistent
+		// and we should delete the entry
+		if nanotime < threshold {
+			idset[id] = struct{}{}
+			if nanotime > maxNanotime {
+				maxNanotime = nanotime
+			}
+		}
+	}
+	ids = make([]uint64, len(idset))
+	i := 0
+	for id := range idset {
+		ids[i] = id
+		i++
+	}
+	return
+}
+
+func (pg *PostgreSQL) deleteCorrespondents(ctx context.Context, threshold int64) {
+	result, err := pg.db.ExecContext(ctx, `DELETE FROM correspondents WHERE nanotime <= $1;`, threshold)
+	if err != nil {
+		pg.logError("error deleting correspondents", err)
+	} else {
+		count, err := result.RowsAffected()
+		if !pg.logError("error deleting correspondents", err) {
+			pg.logger.Debug("postgresql", fmt.Sprintf("deleted %d correspondents entries", count))
+		}
+	}
+}
+
+// wait for forget queue items and process them one by one
+func (pg *PostgreSQL) forgetLoop() {
+	defer func() {
+		if r := recover(); r != nil {
+			pg.logger.Error("postgresql",
+				fmt.Sprintf("Panic in forget routine: %v\n%s", r, debug.Stack()))
+			time.Sleep(cleanupPauseTime)
+			go pg.forgetLoop()
+		}
+	}()
+
+	for {
+		for {
+			found, err := pg.doForget()
+			pg.logError("error processing forget", err)
+			if err != nil {
+				time.Sleep(cleanupPauseTime)
+			}
+			if !found {
+				break
+			}
+		}
+
+		<-pg.wakeForgetter
+	}
+}
+
+// dequeue an item from the forget queue and process it
+func (pg *PostgreSQL) doForget() (found bool, err error) {
+	id, account, err := func() (id int64, account string, err error) {
+		ctx, cancel := context.WithTimeout(context.Background(), cleanupPauseTime)
+		defer cancel()
+
+		row := pg.db.QueryRowContext(ctx,
+			`SELECT forget.id, forget.account FROM forget LIMIT 1;`)
+		err = row.Scan(&id, &account)
+		if err == sql.ErrNoRows {
+			return 0, "", nil
+		}
+		return
+	}()
+
+	if err != nil || account == "" {
+		return false, err
+	}
+
+	found = true
+
+	var count int
+	for {
+		start := time.Now()
+		count, err = pg.doForgetIteration(account)
+		elapsed := time.Since(start)
+		if err != nil {
+			return true, err
+		}
+		if count == 0 {
+			break
+		}
+		time.Sleep(elapsed)
+	}
+
+	pg.logger.Debug("postgresql", "forget complete for account", account)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupPauseTime)
+	defer cancel()
+	_, err = pg.db.ExecContext(ctx, `DELETE FROM forget WHERE id = $1;`, id)
+	return
+}
+
+func (pg *PostgreSQL) doForgetIteration(account string) (count int, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupPauseTime)
+	defer cancel()
+
+	rows, err := pg.db.QueryContext(ctx, `
+		SELECT account_messages.history_id
+		FROM account_messages
+		WHERE account_messages.account = $1
+		LIMIT $2;`, account, cleanupRowLimit)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	var ids []uint64
+	for rows.Next() {
+		var id uint64
+		err = rows.Scan(&id)
+		if err != nil {
+			return
+		}
+		ids = append(ids, id)
+	}
+
+	if len(ids) == 0 {
+		return
+	}
+
+	pg.logger.Debug("postgresql", fmt.Sprintf("deleting %d history rows from account %s", len(ids), account))
+	err = pg.deleteHistoryIDs(ctx, ids)
+	return len(ids), err
+}
+
+func (pg *PostgreSQL) prepareStatements() (err error) {
+	// lib/pq doesn't support LastInsertId(), use RETURNING instead:
+	pg.insertHistory, err = pg.db.Prepare(`INSERT INTO history
+		(data, msgid) VALUES ($1, $2) RETURNING id;`)
+	if err != nil {
+		return
+	}
+	pg.insertSequence, err = pg.db.Prepare(`INSERT INTO sequence
+		(target, nanotime, history_id) VALUES ($1, $2, $3);`)
+	if err != nil {
+		return
+	}
+	pg.insertConversation, err = pg.db.Prepare(`INSERT INTO conversations
+		(target, correspondent, nanotime, history_id) VALUES ($1, $2, $3, $4);`)
+	if err != nil {
+		return
+	}
+	pg.insertCorrespondent, err = pg.db.Prepare(`INSERT INTO correspondents
+		(target, correspondent, nanotime) VALUES ($1, $2, $3)
+		ON CONFLICT (target, correspondent)
+		DO UPDATE SET nanotime = GREATEST(correspondents.nanotime, EXCLUDED.nanotime);`)
+	if err != nil {
+		return
+	}
+	pg.insertAccountMessage, err = pg.db.Prepare(`INSERT INTO account_messages
+		(history_id, account) VALUES ($1, $2);`)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func (pg *PostgreSQL) getTimeout() time.Duration {
+	return time.Duration(atomic.LoadInt64(&pg.timeout))
+}
+
+func (pg *PostgreSQL) isTrackingAccountMessages() bool {
+	return atomic.LoadUint32(&pg.trackAccountMessages) != 0
+}
+
+func (pg *PostgreSQL) logError(context string, err error) (quit bool) {
+	if err != nil {
+		pg.logger.Error("postgresql", context, err.Error())
+		return true
+	}
+	return false
+}
+
+func (pg *PostgreSQL) Forget(account string) {
+	if pg.db == nil || account == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), pg.getTimeout())
+	defer cancel()
+	defer pg.observeLatency("forget", time.Now())
+
+	_, err := pg.db.ExecContext(ctx, `INSERT INTO forget (account) VALUES ($1);`, account)
+	if pg.logError("can't insert into forget table", err) {
+		return
+	}
+
+	// wake up the forget goroutine if it's blocked:
+	select {
+	case pg.wakeForgetter <- e{}:
+	default:
+	}
+}
+
+func (pg *PostgreSQL) AddChannelItem(target string, item history.Item, account string) (err error) {
+	if pg.db == nil {
+		return
+	}
+
+	if target == "" {
+		return utils.ErrInvalidParams
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), pg.getTimeout())
+	defer cancel()
+	defer pg.observeLatency("add_channel_item", time.Now())
+
+	id, err := pg.insertBase(ctx, item)
+	if err != nil {
+		return
+	}
+
+	err = pg.insertSequenceEntry(ctx, target, item.Message.Time.UnixNano(), id)
+	if err != nil {
+		return
+	}
+
+	err = pg.insertAccountMessageEntry(ctx, id, account)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func (pg *PostgreSQL) insertSequenceEntry(ctx context.Context, target string, messageTime int64, id int64) (err error) {
+	_, err = pg.insertSequence.ExecContext(ctx, target, messageTime, id)
+	pg.logError("could not insert sequence entry", err)
+	return
+}
+
+func (pg *PostgreSQL) insertConversationEntry(ctx context.Context, target, correspondent string, messageTime int64, id int64) (err error) {
+	_, err = pg.insertConversation.ExecContext(ctx, target, correspondent, messageTime, id)
+	pg.logError("could not insert conversations entry", err)
+	return
+}
+
+func (pg *PostgreSQL) insertCorrespondentsEntry(ctx context.Context, target, correspondent string, messageTime int64) (err error) {
+	_, err = pg.insertCorrespondent.ExecContext(ctx, target, correspondent, messageTime)
+	pg.logError("could not insert correspondents entry", err)
+	return
+}
+
+func (pg *PostgreSQL) insertBase(ctx context.Context, item history.Item) (id int64, err error) {
+	value, err := marshalItem(&item)
+	if pg.logError("could not marshal item", err) {
+		return
+	}
+
+	msgidBytes, err := decodeMsgid(item.Message.Msgid)
+	if pg.logError("could not decode msgid", err) {
+		return
+	}
+
+	err = pg.insertHistory.QueryRowContext(ctx, value, msgidBytes).Scan(&id)
+	pg.logError("could not insert item", err)
+	return
+}
+
+func (pg *PostgreSQL) insertAccountMessageEntry(ctx context.Context, id int64, account string) (err error) {
+	if account == "" || !pg.isTrackingAccountMessages() {
+		return
+	}
+	_, err = pg.insertAccountMessage.ExecContext(ctx, id, account)
+	pg.logError("could not insert account-message entry", err)
+	return
+}
+
+func (pg *PostgreSQL) AddDirectMessage(sender, senderAccount, recipient, recipientAccount string, item history.Item) (err error) {
+	if pg.db == nil {
+		return
+	}
+
+	if senderAccount == "" && recipientAccount == "" {
+		return
+	}
+
+	if sender == "" || recipient == "" {
+		return utils.ErrInvalidParams
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), pg.getTimeout())
+	defer cancel()
+	defer pg.observeLatency("add_direct_message", time.Now())
+
+	id, err := pg.insertBase(ctx, item)
+	if err != nil {
+		return
+	}
+
+	nanotime := item.Message.Time.UnixNano()
+
+	if senderAccount != "" {
+		err = pg.insertConversationEntry(ctx, senderAccount, recipient, nanotime, id)
+		if err != nil {
+			return
+		}
+		err = pg.insertCorrespondentsEntry(ctx, senderAccount, recipient, nanotime)
+		if err != nil {
+			return
+		}
+	}
+
+	if recipientAccount != "" && sender != recipient {
+		err = pg.insertConversationEntry(ctx, recipientAccount, sender, nanotime, id)
+		if err != nil {
+			return
+		}
+		err = pg.insertCorrespondentsEntry(ctx, recipientAccount, sender, nanotime)
+		if err != nil {
+			return
+		}
+	}
+
+	err = pg.insertAccountMessageEntry(ctx, id, senderAccount)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+// note that accountName is the unfolded name; if notBefore is nonzero,
+// messages sent before it cannot be deleted
+func (pg *PostgreSQL) DeleteMsgid(msgid, accountName string, notBefore time.Time) (err error) {
+	if pg.db == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), pg.getTimeout())
+	defer cancel()
+	defer pg.observeLatency("delete_msgid", time.Now())
+
+	msgtime, id, data, err := pg.lookupMsgid(ctx, msgid, true)
+	if err != nil {
+		return
+	}
+
+	if accountName != "*" {
+		var item history.Item
+		err = unmarshalItem(data, &item)
+		// delete if the entry is corrupt
+		if err == nil && item.AccountName != accountName {
+			return history.ErrDisallowed
+		}
+	}
+
+	if msgtime.Before(notBefore) {
+		return history.ErrExpired
+	}
+
+	err = pg.deleteHistoryIDs(ctx, []uint64{id})
+	pg.logError("couldn't delete msgid", err)
+	return
+}
+
+// LookupMsgid returns the history item with the given msgid, and its targets.
+func (pg *PostgreSQL) LookupMsgid(msgid string) (item history.Item, targets []string, err error) {
+	if pg.db == nil {
+		err = sql.ErrNoRows
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), pg.getTimeout())
+	defer cancel()
+	defer pg.observeLatency("lookup_msgid", time.Now())
+
+	_, id, data, err := pg.lookupMsgid(ctx, msgid, true)
+	if err != nil {
+		return
+	}
+	err = unmarshalItem(data, &item)
+	if err != nil {
+		return
+	}
+	targets, err = pg.lookupTargets(ctx, id)
+	return
+}
+
+// lookupTargets returns the channel a message was sent to (from sequence),
+// or the accounts with a copy of a direct message (from conversations).
+func (pg *PostgreSQL) lookupTargets(ctx context.Context, id uint64) (targets []string, err error) {
+	rows, err := pg.db.QueryContext(ctx, `
+		SELECT target FROM sequence WHERE history_id = $1
+		UNION ALL
+		SELECT target FROM conversations WHERE history_id = $1;`, id)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var target string
+		if err = rows.Scan(&target); err != nil {
+			return
+		}
+		targets = append(targets, target)
+	}
+	err = rows.Err()
+	return
+}
+
+func (pg *PostgreSQL) Export(account string, writer io.Writer) {
+	if pg.db == nil {
+		return
+	}
+
+	var err error
+	var lastSeen uint64
+	for {
+		rows := func() (count int) {
+			ctx, cancel := context.WithTimeout(context.Background(), cleanupPauseTime)
+			defer cancel()
+
+			rows, rowsErr := pg.db.QueryContext(ctx, `
+				SELECT account_messages.history_id, history.data, sequence.target FROM account_messages
+				INNER JOIN history ON history.id = account_messages.history_id
+				INNER JOIN sequence ON account_messages.history_id = sequence.history_id
+				WHERE account_messages.account = $1 AND account_messages.history_id > $2
+				ORDER BY account_messages.history_id
+				LIMIT $3`, account, lastSeen, cleanupRowLimit)
+			if rowsErr != nil {
+				err = rowsErr
+				return
+			}
+			defer rows.Close()
+			for rows.Next() {
+				var id uint64
+				var blob, jsonBlob []byte
+				var target string
+				var item history.Item
+				err = rows.Scan(&id, &blob, &target)
+				if err != nil {
+					return
+				}
+				err = unmarshalItem(blob, &item)
+				if err != nil {
+					return
+				}
+				item.CfCorrespondent = target
+				jsonBlob, err = json.Marshal(item)
+				if err != nil {
+					return
+				}
+				count++
+				if lastSeen < id {
+					lastSeen = id
+				}
+				writer.Write(jsonBlob)
+				writer.Write([]byte{'\n'})
+			}
+			return
+		}()
+		if rows == 0 || err != nil {
+			break
+		}
+	}
+
+	pg.logError("could not export history", err)
+	return
+}
+
+func (pg *PostgreSQL) lookupMsgid(ctx context.Context, msgid string, includeData bool) (result time.Time, id uint64, data []byte, err error) {
+	decoded, err := decodeMsgid(msgid)
+	if err != nil {
+		return
+	}
+	cols := `sequence.nanotime, conversations.nanotime`
+	if includeData {
+		cols = `sequence.nanotime, conversations.nanotime, history.id, history.data`
+	}
+	row := pg.db.QueryRowContext(ctx, fmt.Sprintf(`
+		SELECT %s FROM history
+		LEFT JOIN sequence ON history.id = sequence.history_id
+		LEFT JOIN conversations ON history.id = conversations.history_id
+		WHERE history.msgid = $1 LIMIT 1;`, cols), decoded)
+	var nanoSeq, nanoConv sql.NullInt64
+	if !includeData {
+		err = row.Scan(&nanoSeq, &nanoConv)
+	} else {
+		err = row.Scan(&nanoSeq, &nanoConv, &id, &data)
+	}
+	if err != sql.ErrNoRows {
+		pg.logError("could not resolve msgid to time", err)
+	}
+	if err != nil {
+		return
+	}
+	nanotime := extractNanotime(nanoSeq, nanoConv)
+	if nanotime == 0 {
+		err = sql.ErrNoRows
+		return
+	}
+	result = time.Unix(0, nanotime).UTC()
+	return
+}
+
+func extractNanotime(seq, conv sql.NullInt64) (result int64) {
+	if seq.Valid {
+		return seq.Int64
+	} else if conv.Valid {
+		return conv.Int64
+	}
+	return
+}
+
+// queryBuilder numbers positional parameters ($1, $2, ...) as they are added
+type queryBuilder struct {
+	strings.Builder
+	args []interface{}
+}
+
+func (q *queryBuilder) arg(value interface{}) string {
+	q.args = append(q.args, value)
+	return fmt.Sprintf("$%d", len(q.args))
+}
+
+func (pg *PostgreSQL) selectItems(ctx context.Context, query string, args ...interface{}) (results []history.Item, err error) {
+	rows, err := pg.db.QueryContext(ctx, query, args...)
+	if pg.logError("could not select history items", err) {
+		return
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var blob []byte
+		var item history.Item
+		err = rows.Scan(&blob)
+		if pg.logError("could not scan history item", err) {
+			return
+		}
+		err = unmarshalItem(blob, &item)
+		if pg.logError("could not unmarshal history item", err) {
+			return
+		}
+		results = append(results, item)
+	}
+	return
+}
+
+func (pg *PostgreSQL) betweenTimestamps(ctx context.Context, target, correspondent string, after, before, cutoff time.Time, limit int) (results []history.Item, err error) {
+	after, before, ascending := history.MinMaxAsc(after, before, cutoff)
+	query := betweenTimestampsQuery(target, correspondent, after, before, ascending, limit)
+	results, err = pg.selectItems(ctx, query.String(), query.args...)
+	if err == nil && !ascending {
+		history.Reverse(results)
+	}
+	return
+}
+
+// betweenTimestampsQuery selects a channel's messages from sequence,
+// or a DM conversation's from conversations
+func betweenTimestampsQuery(target, correspondent string, after, before time.Time, ascending bool, limit int) (query *queryBuilder) {
+	useSequence := correspondent == ""
+	table := "sequence"
+	if !useSequence {
+		table = "conversations"
+	}
+
+	direction := "ASC"
+	if !ascending {
+		direction = "DESC"
+	}
+
+	query = new(queryBuilder)
+	fmt.Fprintf(query,
+		"SELECT history.data FROM history INNER JOIN %[1]s ON history.id = %[1]s.history_id WHERE", table)
+	if useSequence {
+		fmt.Fprintf(query, " sequence.target = %s", query.arg(target))
+	} else {
+		fmt.Fprintf(query, " conversations.target = %s AND conversations.correspondent = %s", query.arg(target), query.arg(correspondent))
+	}
+	if !after.IsZero() {
+		fmt.Fprintf(query, " AND %s.nanotime > %s", table, query.arg(after.UnixNano()))
+	}
+	if !before.IsZero() {
+		fmt.Fprintf(query, " AND %s.nanotime < %s", table, query.arg(before.UnixNano()))
+	}
+	fmt.Fprintf(query, " ORDER BY %[1]s.nanotime %[2]s LIMIT %[3]s;", table, direction, query.arg(limit))
+	return
+}
+
+func (pg *PostgreSQL) listCorrespondentsInternal(ctx context.Context, target string, after, before, cutoff time.Time, limit int) (results []history.TargetListing, err error) {
+	after, before, ascending := history.MinMaxAsc(after, before, cutoff)
+	query := listCorrespondentsQuery(target, after, before, ascending, limit)
+	rows, err := pg.db.QueryContext(ctx, query.String(), query.args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	var correspondent string
+	var nanotime int64
+	for rows.Next() {
+		err = rows.Scan(&correspondent, &nanotime)
+		if err != nil {
+			return
+		}
+		results = append(results, history.TargetListing{
+			CfName: correspondent,
+			Time:   time.Unix(0, nanotime),
+		})
+	}
+
+	if !ascending {
+		history.ReverseCorrespondents(results)
+	}
+
+	return
+}
+
+func listCorrespondentsQuery(target string, after, before time.Time, ascending bool, limit int) (query *queryBuilder) {
+	direction := "ASC"
+	if !ascending {
+		direction = "DESC"
+	}
+
+	query = new(queryBuilder)
+	fmt.Fprintf(query, `SELECT correspondents.correspondent, correspondents.nanotime FROM correspondents
+		WHERE target = %s`, query.arg(target))
+	if !after.IsZero() {
+		fmt.Fprintf(query, " AND correspondents.nanotime > %s", query.arg(after.UnixNano()))
+	}
+	if !before.IsZero() {
+		fmt.Fprintf(query, " AND correspondents.nanotime < %s", query.arg(before.UnixNano()))
+	}
+	fmt.Fprintf(query, " ORDER BY correspondents.nanotime %s LIMIT %s;", direction, query.arg(limit))
+	return
+}
+
+func (pg *PostgreSQL) ListChannels(cfchannels []string) (results []history.TargetListing, err error) {
+	if pg.db == nil {
+		return
+	}
+
+	if len(cfchannels) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), pg.getTimeout())
+	defer cancel()
+	defer pg.observeLatency("list_channels", time.Now())
+
+	var query queryBuilder
+	query.WriteString(`SELECT sequence.target, MAX(sequence.nanotime) FROM sequence
+		WHERE sequence.target IN (`)
+	for i, chname := range cfchannels {
+		if i != 0 {
+			query.WriteString(", ")
+		}
+		query.WriteString(query.arg(chname))
+	}
+	query.WriteString(") GROUP BY sequence.target;")
+
+	rows, err := pg.db.QueryContext(ctx, query.String(), query.args...)
+	if pg.logError("could not query channel listings", err) {
+		return
+	}
+	defer rows.Close()
+
+	var target string
+	var nanotime int64
+	for rows.Next() {
+		err = rows.Scan(&target, &nanotime)
+		if pg.logError("could not scan channel listings", err) {
+			return
+		}
+		results = append(results, history.TargetListing{
+			CfName: target,
+			Time:   time.Unix(0, nanotime),
+		})
+	}
+	return
+}
+
+func (pg *PostgreSQL) Close() {
+	// closing the database will close our prepared statements as well
+	if pg.db != nil {
+		pg.db.Close()
+	}
+	pg.db = nil
+}
+
+// implements history.Sequence, emulating a single history buffer (for a channel,
+// a single user's DMs, or a DM conversation)
+type postgreSQLHistorySequence struct {
+	pg            *PostgreSQL
+	target        string
+	correspondent string
+	cutoff        time.Time
+}
+
+func (s *postgreSQLHistorySequence) Between(start, end history.Selector, limit int) (results []history.Item, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.pg.getTimeout())
+	defer cancel()
+	defer s.pg.observeLatency("between", time.Now())
+
+	startTime := start.Time
+	if start.Msgid != "" {
+		startTime, _, _, err = s.pg.lookupMsgid(ctx, start.Msgid, false)
+		if err != nil {
+			return nil, err
+		}
+	}
+	endTime := end.Time
+	if end.Msgid != "" {
+		endTime, _, _, err = s.pg.lookupMsgid(ctx, end.Msgid, false)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	results, err = s.pg.betweenTimestamps(ctx, s.target, s.correspondent, startTime, endTime, s.cutoff, limit)
+	return results, err
+}
+
+func (s *postgreSQLHistorySequence) Around(start history.Selector, limit int) (results []history.Item, err error) {
+	return history.GenericAround(s, start, limit)
+}
+
+func (seq *postgreSQLHistorySequence) ListCorrespondents(start, end history.Selector, limit int) (results []history.TargetListing, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), seq.pg.getTimeout())
+	defer cancel()
+	defer seq.pg.observeLatency("list_correspondents", time.Now())
+
+	// TODO accept msgids here?
+	startTime := start.Time
+	endTime := end.Time
+
+	results, err = seq.pg.listCorrespondentsInternal(ctx, seq.target, startTime, endTime, seq.cutoff, limit)
+	seq.pg.logError("could not read correspondents", err)
+	return
+}
+
+func (seq *postgreSQLHistorySequence) Cutoff() time.Time {
+	return seq.cutoff
+}
+
+func (seq *postgreSQLHistorySequence) Ephemeral() bool {
+	return false
+}
+
+func (pg *PostgreSQL) MakeSequence(target, correspondent string, cutoff time.Time) history.Sequence {
+	return &postgreSQLHistorySequence{
+		target:        target,
+		correspondent: correspondent,
+		pg:            pg,
+		cutoff:        cutoff,
+	}
+}
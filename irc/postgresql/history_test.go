@@ -0,0 +1,302 @@
+// Copyright (c) 2026 agent <agent@local>
+// released under the MIT license
+
+package postgresql
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ergochat/ergo/irc/history"
+	"github.com/ergochat/ergo/irc/logger"
+	"github.com/ergochat/ergo/irc/utils"
+)
+
+// the integration tests run against the server in this key/value
+// connection string, e.g. "host=/run/postgresql dbname=ergo_test";
+// each test creates (and drops) its own schema there
+const testDSNVar = "ERGO_TEST_POSTGRESQL_DSN"
+
+func assertEqual(supplied, expected interface{}, t *testing.T) {
+	t.Helper()
+	if !reflect.DeepEqual(supplied, expected) {
+		t.Errorf("expected %v but got %v", expected, supplied)
+	}
+}
+
+func TestConnString(t *testing.T) {
+	config := Config{
+		Host:            "db.example.com",
+		Port:            5433,
+		User:            "ergo",
+		Password:        `it's a \secret`,
+		HistoryDatabase: "ergo history",
+		SSLMode:         "verify-full",
+		Timeout:         10 * time.Second,
+	}
+	assertEqual(config.connString(), `host='db.example.com' port=5433 user='ergo' password='it\'s a \\secret' dbname='ergo history' sslmode='verify-full' connect_timeout=10`, t)
+
+	// the socket path takes precedence over the host, and optional
+	// parameters are omitted:
+	config = Config{
+		Host:            "db.example.com",
+		SocketPath:      "/run/postgresql",
+		User:            "ergo",
+		HistoryDatabase: "ergo",
+	}
+	assertEqual(config.connString(), `host='/run/postgresql' user='ergo' dbname='ergo'`, t)
+
+	// connect_timeout has a minimum of 2 seconds:
+	config.Timeout = 500 * time.Millisecond
+	assertEqual(config.connString(), `host='/run/postgresql' user='ergo' dbname='ergo' connect_timeout=2`, t)
+}
+
+func TestQueryBuilder(t *testing.T) {
+	var query queryBuilder
+	fmt.Fprintf(&query, "SELECT * FROM t WHERE a = %s AND b IN (%s, %s);", query.arg("x"), query.arg(2), query.arg(int64(3)))
+	assertEqual(query.String(), "SELECT * FROM t WHERE a = $1 AND b IN ($2, $3);", t)
+	assertEqual(query.args, []interface{}{"x", 2, int64(3)}, t)
+}
+
+func TestBetweenTimestampsQuery(t *testing.T) {
+	after := time.Unix(0, 1000)
+	before := time.Unix(0, 2000)
+
+	query := betweenTimestampsQuery("#ergo", "", time.Time{}, time.Time{}, false, 10)
+	assertEqual(query.String(), "SELECT history.data FROM history INNER JOIN sequence ON history.id = sequence.history_id WHERE sequence.target = $1 ORDER BY sequence.nanotime DESC LIMIT $2;", t)
+	assertEqual(query.args, []interface{}{"#ergo", 10}, t)
+
+	query = betweenTimestampsQuery("#ergo", "", after, before, true, 10)
+	assertEqual(query.String(), "SELECT history.data FROM history INNER JOIN sequence ON history.id = sequence.history_id WHERE sequence.target = $1 AND sequence.nanotime > $2 AND sequence.nanotime < $3 ORDER BY sequence.nanotime ASC LIMIT $4;", t)
+	assertEqual(query.args, []interface{}{"#ergo", int64(1000), int64(2000), 10}, t)
+
+	query = betweenTimestampsQuery("alice", "bob", time.Time{}, before, false, 5)
+	assertEqual(query.String(), "SELECT history.data FROM history INNER JOIN conversations ON history.id = conversations.history_id WHERE conversations.target = $1 AND conversations.correspondent = $2 AND conversations.nanotime < $3 ORDER BY conversations.nanotime DESC LIMIT $4;", t)
+	assertEqual(query.args, []interface{}{"alice", "bob", int64(2000), 5}, t)
+}
+
+func TestListCorrespondentsQuery(t *testing.T) {
+	query := listCorrespondentsQuery("alice", time.Unix(0, 1000), time.Time{}, true, 10)
+	assertEqual(strings.Join(strings.Fields(query.String()), " "), "SELECT correspondents.correspondent, correspondents.nanotime FROM correspondents WHERE target = $1 AND correspondents.nanotime > $2 ORDER BY correspondents.nanotime ASC LIMIT $3;", t)
+	assertEqual(query.args, []interface{}{"alice", int64(1000), 10}, t)
+}
+
+// openTestDB opens a PostgreSQL backend in a new, empty schema
+func openTestDB(t *testing.T) *PostgreSQL {
+	t.Helper()
+	dsn := os.Getenv(testDSNVar)
+	if dsn == "" {
+		t.Skipf("set %s to run the PostgreSQL integration tests", testDSNVar)
+	}
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Close() })
+	schema := fmt.Sprintf("ergo_test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec("CREATE SCHEMA " + schema + ";"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.Exec("DROP SCHEMA " + schema + " CASCADE;"); err != nil {
+			t.Error(err)
+		}
+	})
+
+	logger, err := logger.NewManager(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pg := new(PostgreSQL)
+	pg.Initialize(logger, Config{
+		Enabled:              true,
+		Timeout:              3 * time.Second,
+		TrackAccountMessages: true,
+	})
+	connString := dsn + " search_path=" + schema
+	if err := pg.open(connString); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pg.Close)
+	return pg
+}
+
+var baseTime = time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+
+func makeItem(nick, account, message string, minutes int) (item history.Item) {
+	item.Type = history.Privmsg
+	item.Nick = nick + "!u@h"
+	item.AccountName = account
+	item.Message = utils.MakeMessage(message)
+	item.Message.Time = baseTime.Add(time.Duration(minutes) * time.Minute)
+	return
+}
+
+func toMessages(items []history.Item) (result []string) {
+	result = make([]string, len(items))
+	for i, item := range items {
+		result[i] = item.Message.Message
+	}
+	return
+}
+
+func TestSchema(t *testing.T) {
+	pg := openTestDB(t)
+
+	var version string
+	assertEqual(pg.db.QueryRow(`SELECT value FROM metadata WHERE key_name = $1;`, keySchemaVersion).Scan(&version), nil, t)
+	assertEqual(version, latestDbSchema, t)
+	for _, table := range []string{"history", "sequence", "conversations", "correspondents", "account_messages", "forget"} {
+		var count int
+		if err := pg.db.QueryRow(`SELECT COUNT(*) FROM ` + table + `;`).Scan(&count); err != nil {
+			t.Errorf("table %s: %v", table, err)
+		}
+	}
+
+	// the existing schema is reused:
+	assertEqual(pg.AddChannelItem("#ergo", makeItem("alice", "alice", "hello", 0), "alice"), nil, t)
+	assertEqual(pg.fixSchemas(), nil, t)
+	results, _ := pg.MakeSequence("#ergo", "", time.Time{}).Between(history.Selector{}, history.Selector{}, 10)
+	assertEqual(toMessages(results), []string{"hello"}, t)
+
+	// a newer schema is refused:
+	pg.db.Exec(`UPDATE metadata SET value = '2' WHERE key_name = $1;`, keySchemaVersion)
+	if pg.fixSchemas() == nil {
+		t.Errorf("unknown schema version should be an error")
+	}
+}
+
+func TestChannelHistory(t *testing.T) {
+	pg := openTestDB(t)
+
+	var items []history.Item
+	for i, message := range []string{"a", "b", "c", "d", "e"} {
+		item := makeItem("alice", "alice", message, i)
+		items = append(items, item)
+		if err := pg.AddChannelItem("#ergo", item, "alice"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pg.AddChannelItem("#other", makeItem("bob", "bob", "x", 10), "bob")
+
+	seq := pg.MakeSequence("#ergo", "", time.Time{})
+	results, err := seq.Between(history.Selector{}, history.Selector{}, 3)
+	assertEqual(err, nil, t)
+	// with no bounds, we get the latest messages:
+	assertEqual(toMessages(results), []string{"c", "d", "e"}, t)
+
+	results, _ = seq.Between(history.Selector{Msgid: items[0].Message.Msgid}, history.Selector{Msgid: items[4].Message.Msgid}, 10)
+	assertEqual(toMessages(results), []string{"b", "c", "d"}, t)
+
+	results, _ = seq.Around(history.Selector{Msgid: items[2].Message.Msgid}, 3)
+	assertEqual(toMessages(results), []string{"b", "c", "d"}, t)
+
+	// the cutoff excludes earlier messages:
+	seq = pg.MakeSequence("#ergo", "", baseTime.Add(90*time.Second))
+	results, _ = seq.Between(history.Selector{}, history.Selector{}, 10)
+	assertEqual(toMessages(results), []string{"c", "d", "e"}, t)
+
+	listings, err := pg.ListChannels([]string{"#ergo", "#other", "#empty"})
+	assertEqual(err, nil, t)
+	assertEqual(len(listings), 2, t)
+
+	item, targets, err := pg.LookupMsgid(items[1].Message.Msgid)
+	assertEqual(err, nil, t)
+	assertEqual(item.Message.Message, "b", t)
+	assertEqual(targets, []string{"#ergo"}, t)
+
+	assertEqual(pg.DeleteMsgid(items[1].Message.Msgid, "bob", time.Time{}), history.ErrDisallowed, t)
+	assertEqual(pg.DeleteMsgid(items[1].Message.Msgid, "alice", baseTime.Add(time.Hour)), history.ErrExpired, t)
+	assertEqual(pg.DeleteMsgid(items[1].Message.Msgid, "alice", time.Time{}), nil, t)
+	_, _, err = pg.LookupMsgid(items[1].Message.Msgid)
+	if err == nil {
+		t.Errorf("deleted message should not be found")
+	}
+}
+
+func TestDirectMessages(t *testing.T) {
+	pg := openTestDB(t)
+
+	dm := makeItem("alice", "alice", "hi bob", 0)
+	pg.AddDirectMessage("alice", "alice", "bob", "bob", dm)
+	pg.AddDirectMessage("bob", "bob", "alice", "alice", makeItem("bob", "bob", "hi alice", 1))
+	pg.AddDirectMessage("carol", "", "alice", "alice", makeItem("carol", "*", "hi from carol", 2))
+	// neither party is logged in, this isn't stored:
+	pg.AddDirectMessage("carol", "", "dave", "", makeItem("carol", "*", "hi dave", 3))
+
+	results, _ := pg.MakeSequence("alice", "bob", time.Time{}).Between(history.Selector{}, history.Selector{}, 10)
+	assertEqual(toMessages(results), []string{"hi bob", "hi alice"}, t)
+	results, _ = pg.MakeSequence("bob", "alice", time.Time{}).Between(history.Selector{}, history.Selector{}, 10)
+	assertEqual(toMessages(results), []string{"hi bob", "hi alice"}, t)
+
+	listings, err := pg.MakeSequence("alice", "", time.Time{}).ListCorrespondents(history.Selector{}, history.Selector{}, 10)
+	assertEqual(err, nil, t)
+	assertEqual(len(listings), 2, t)
+	assertEqual(listings[0].CfName, "bob", t)
+	assertEqual(listings[0].Time.UnixNano(), baseTime.Add(time.Minute).UnixNano(), t)
+	assertEqual(listings[1].CfName, "carol", t)
+
+	// each participant's account holds a copy:
+	_, targets, err := pg.LookupMsgid(dm.Message.Msgid)
+	assertEqual(err, nil, t)
+	sort.Strings(targets)
+	assertEqual(targets, []string{"alice", "bob"}, t)
+}
+
+func TestExportAndForget(t *testing.T) {
+	pg := openTestDB(t)
+
+	pg.AddChannelItem("#ergo", makeItem("alice", "alice", "first", 0), "alice")
+	pg.AddChannelItem("#ergo", makeItem("bob", "bob", "second", 1), "bob")
+	pg.AddChannelItem("#ergo", makeItem("alice", "alice", "third", 2), "alice")
+
+	var buf bytes.Buffer
+	pg.Export("alice", &buf)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assertEqual(len(lines), 2, t)
+	var item history.Item
+	assertEqual(json.Unmarshal([]byte(lines[1]), &item), nil, t)
+	assertEqual(item.Message.Message, "third", t)
+	assertEqual(item.CfCorrespondent, "#ergo", t)
+
+	pg.Forget("alice")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		results, _ := pg.MakeSequence("#ergo", "", time.Time{}).Between(history.Selector{}, history.Selector{}, 10)
+		if len(results) == 1 {
+			assertEqual(toMessages(results), []string{"second"}, t)
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("forget did not complete, got %v", toMessages(results))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	pg := openTestDB(t)
+
+	now := time.Now().UTC()
+	old := makeItem("alice", "alice", "old", 0)
+	old.Message.Time = now.Add(-2 * time.Hour)
+	recent := makeItem("alice", "alice", "recent", 0)
+	recent.Message.Time = now
+	pg.AddChannelItem("#ergo", old, "alice")
+	pg.AddChannelItem("#ergo", recent, "alice")
+
+	count, err := pg.doCleanup(time.Hour)
+	assertEqual(err, nil, t)
+	assertEqual(count, 1, t)
+
+	results, _ := pg.MakeSequence("#ergo", "", time.Time{}).Between(history.Selector{}, history.Selector{}, 10)
+	assertEqual(toMessages(results), []string{"recent"}, t)
+}
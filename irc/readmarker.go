@@ -0,0 +1,129 @@
+// Copyright (c) 2026 agent <agent@local>
+// released under the MIT license
+
+package irc
+
+import (
+	"strings"
+	"time"
+
+	"github.com/ergochat/ergo/irc/caps"
+	"github.com/ergochat/ergo/irc/history"
+)
+
+// Read markers (draft/read-marker): a client can record, per target, the
+// timestamp of the last message the user has read. For logged-in clients,
+// the markers are stored with the account and synchronized to every session
+// of every client of the account. Markers only ever move forward.
+
+// casefoldTarget casefolds a channel name or nickname.
+func casefoldTarget(target string) (string, error) {
+	if strings.HasPrefix(target, "#") {
+		return CasefoldChannel(target)
+	}
+	return CasefoldName(target)
+}
+
+// ReadMarker returns the client's read marker for the casefolded target,
+// or the zero time if there is none.
+func (client *Client) ReadMarker(cftarget string) (result time.Time) {
+	client.stateMutex.RLock()
+	result = client.readMarkers[cftarget]
+	client.stateMutex.RUnlock()
+	return
+}
+
+// setReadMarker advances the marker for `cftarget`; `changed` is false
+// if the existing marker was already at or after `timestamp`.
+func (client *Client) setReadMarker(cftarget string, timestamp time.Time) (result time.Time, changed bool) {
+	client.stateMutex.Lock()
+	defer client.stateMutex.Unlock()
+	result = client.readMarkers[cftarget]
+	if !timestamp.After(result) {
+		return
+	}
+	if client.readMarkers == nil {
+		client.readMarkers = make(map[string]time.Time)
+	}
+	client.readMarkers[cftarget] = timestamp
+	return timestamp, true
+}
+
+// updateReadMarker processes a MARKREAD from the client, persisting the new
+// marker if the client is logged in, and notifying all affected sessions
+// (other than `origin`, which is answered directly) of the change.
+// It returns the (possibly unchanged) marker.
+func (client *Client) updateReadMarker(target, cftarget string, timestamp time.Time, origin *Session) (result time.Time, err error) {
+	var changed bool
+	clients := []*Client{client}
+	if account := client.Account(); account != "" {
+		result, changed, err = client.server.accounts.saveReadMarker(account, cftarget, timestamp)
+		if err != nil {
+			return
+		}
+		clients = client.server.accounts.AccountToClients(account)
+	} else {
+		result, changed = client.setReadMarker(cftarget, timestamp)
+	}
+	if !changed {
+		return
+	}
+	for _, c := range clients {
+		for _, session := range c.Sessions() {
+			if session != origin {
+				sendReadMarker(session, target, result)
+			}
+		}
+	}
+	return
+}
+
+// sendReadMarker sends a MARKREAD line to the session, if it negotiated
+// the capability; the zero time is sent as `*`.
+func sendReadMarker(session *Session, target string, marker time.Time) {
+	if !session.capabilities.Has(caps.ReadMarker) {
+		return
+	}
+	session.Send(nil, session.client.server.name, "MARKREAD", target, formatReadMarker(marker))
+}
+
+// addReadMarker adds the client's marker for the target to the response
+// buffer, if its session negotiated the capability (e.g., upon JOIN).
+func (client *Client) addReadMarker(rb *ResponseBuffer, target, cftarget string) {
+	if rb.session.capabilities.Has(caps.ReadMarker) {
+		rb.Add(nil, client.server.name, "MARKREAD", target, formatReadMarker(client.ReadMarker(cftarget)))
+	}
+}
+
+func formatReadMarker(marker time.Time) string {
+	if marker.IsZero() {
+		return "*"
+	}
+	return "timestamp=" + marker.Format(IRCv3TimestampFormat)
+}
+
+// readMarkerSince returns the time after which history for `cftarget` should
+// be replayed: the read marker, if it is after `since`, otherwise `since`.
+func (client *Client) readMarkerSince(cftarget string, since time.Time) time.Time {
+	if marker := client.ReadMarker(cftarget); marker.After(since) {
+		return marker
+	}
+	return since
+}
+
+// filterReadPrivmsgs removes direct messages the user has already read,
+// according to the read marker of each correspondent.
+func (client *Client) filterReadPrivmsgs(items []history.Item) (result []history.Item) {
+	client.stateMutex.RLock()
+	defer client.stateMutex.RUnlock()
+	if len(client.readMarkers) == 0 {
+		return items
+	}
+	result = items[:0]
+	for _, item := range items {
+		if item.Message.Time.After(client.readMarkers[item.CfCorrespondent]) {
+			result = append(result, item)
+		}
+	}
+	return
+}
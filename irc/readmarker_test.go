@@ -0,0 +1,52 @@
+// Copyright (c) 2026 agent <agent@local>
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ergochat/ergo/irc/history"
+	"github.com/ergochat/ergo/irc/utils"
+)
+
+func TestReadMarkers(t *testing.T) {
+	client := new(Client)
+	now := time.Now().UTC()
+	earlier := now.Add(-time.Hour)
+
+	if !client.ReadMarker("#ergo").IsZero() {
+		t.Errorf("new client should have no read markers")
+	}
+	if formatReadMarker(time.Time{}) != "*" {
+		t.Errorf("zero marker should be formatted as *")
+	}
+
+	result, changed := client.setReadMarker("#ergo", now)
+	if !changed || !result.Equal(now) {
+		t.Errorf("marker should have been set")
+	}
+	result, changed = client.setReadMarker("#ergo", earlier)
+	if changed || !result.Equal(now) {
+		t.Errorf("marker should not move backwards")
+	}
+	if !client.readMarkerSince("#ergo", earlier).Equal(now) {
+		t.Errorf("replay should start from the read marker")
+	}
+	if !client.readMarkerSince("#other", earlier).Equal(earlier) {
+		t.Errorf("replay should start from the given time when there is no marker")
+	}
+
+	client.setReadMarker("alice", earlier)
+	items := []history.Item{
+		{CfCorrespondent: "alice", Message: utils.SplitMessage{Msgid: "a", Time: earlier.Add(-time.Minute)}},
+		{CfCorrespondent: "alice", Message: utils.SplitMessage{Msgid: "b", Time: earlier.Add(time.Minute)}},
+		{CfCorrespondent: "bob", Message: utils.SplitMessage{Msgid: "c", Time: earlier.Add(-time.Minute)}},
+	}
+	var msgids []string
+	for _, item := range client.filterReadPrivmsgs(items) {
+		msgids = append(msgids, item.Message.Msgid)
+	}
+	assertEqual(msgids, []string{"b", "c"}, t)
+}
@@ -0,0 +1,266 @@
+// Copyright (c) 2026 agent <agent@local>
+// released under the MIT license
+
+// Package scram implements the server side of the SCRAM SASL mechanisms
+// (RFC 5802, RFC 7677), without channel binding.
+package scram
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	// DefaultIterations is the PBKDF2 iteration count for new credentials,
+	// the minimum recommended by RFC 7677.
+	DefaultIterations = 4096
+
+	saltLen  = 16
+	nonceLen = 18
+)
+
+var (
+	ErrInvalidMessage             = errors.New("invalid SCRAM message")
+	ErrChannelBindingNotSupported = errors.New("SCRAM channel binding is not supported")
+	ErrInvalidProof               = errors.New("invalid SCRAM proof")
+)
+
+// HashFunc is the hash function of a SCRAM mechanism.
+type HashFunc func() hash.Hash
+
+var (
+	SHA256 HashFunc = sha256.New
+	SHA512 HashFunc = sha512.New
+)
+
+// Credentials are the salted credentials a server stores for a SCRAM mechanism;
+// they can verify a password, but cannot be used to impersonate the user.
+type Credentials struct {
+	Salt       []byte
+	Iterations int
+	StoredKey  []byte
+	ServerKey  []byte
+}
+
+// NewCredentials generates credentials for a password, with a random salt.
+// Note that the password is not normalized with SASLprep; clients normalize
+// passwords before hashing them, so non-ASCII passwords may not match.
+func NewCredentials(hashFunc HashFunc, password string, iterations int) (result Credentials, err error) {
+	salt := make([]byte, saltLen)
+	if _, err = rand.Read(salt); err != nil {
+		return
+	}
+	return credentialsFromSalt(hashFunc, password, salt, iterations), nil
+}
+
+func credentialsFromSalt(hashFunc HashFunc, password string, salt []byte, iterations int) (result Credentials) {
+	saltedPassword := pbkdf2.Key([]byte(password), salt, iterations, hashFunc().Size(), hashFunc)
+	clientKey := computeHMAC(hashFunc, saltedPassword, []byte("Client Key"))
+	storedKey := hashFunc()
+	storedKey.Write(clientKey)
+	return Credentials{
+		Salt:       salt,
+		Iterations: iterations,
+		StoredKey:  storedKey.Sum(nil),
+		ServerKey:  computeHMAC(hashFunc, saltedPassword, []byte("Server Key")),
+	}
+}
+
+func computeHMAC(hashFunc HashFunc, key, data []byte) []byte {
+	mac := hmac.New(hashFunc, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+type conversationState uint
+
+const (
+	stateInitial conversationState = iota
+	stateServerFirstSent
+	stateServerFinalSent
+)
+
+// ServerConversation is the server side of a single SCRAM exchange.
+type ServerConversation struct {
+	hashFunc HashFunc
+	// lookup returns the stored credentials of a user (or an error, ending the exchange)
+	lookup func(username string) (Credentials, error)
+	state  conversationState
+
+	username        string
+	serverNonce     string
+	nonce           string
+	gs2Header       string
+	clientFirstBare string
+	serverFirst     string
+	credentials     Credentials
+}
+
+// NewServerConversation starts a new exchange for the mechanism using `hashFunc`.
+func NewServerConversation(hashFunc HashFunc, lookup func(username string) (Credentials, error)) *ServerConversation {
+	nonce := make([]byte, nonceLen)
+	rand.Read(nonce)
+	return &ServerConversation{
+		hashFunc:    hashFunc,
+		lookup:      lookup,
+		serverNonce: base64.RawURLEncoding.EncodeToString(nonce),
+	}
+}
+
+// Username returns the username the client is authenticating as.
+func (c *ServerConversation) Username() string {
+	return c.username
+}
+
+// Done returns whether the client's proof was verified and the server's
+// final message sent; the client is then authenticated.
+func (c *ServerConversation) Done() bool {
+	return c.state == stateServerFinalSent
+}
+
+// Step processes a message from the client, returning the server's response.
+// Any error ends the exchange unsuccessfully.
+func (c *ServerConversation) Step(input []byte) (output []byte, err error) {
+	switch c.state {
+	case stateInitial:
+		output, err = c.handleClientFirst(string(input))
+		if err == nil {
+			c.state = stateServerFirstSent
+		}
+	case stateServerFirstSent:
+		output, err = c.handleClientFinal(string(input))
+		if err == nil {
+			c.state = stateServerFinalSent
+		}
+	default:
+		err = ErrInvalidMessage
+	}
+	return
+}
+
+// client-first-message = gs2-header client-first-message-bare
+// gs2-header = gs2-cbind-flag "," [ authzid ] ","
+// client-first-message-bare = [reserved-mext ","] username "," nonce ["," extensions]
+func (c *ServerConversation) handleClientFirst(message string) (output []byte, err error) {
+	fields := strings.SplitN(message, ",", 3)
+	if len(fields) != 3 {
+		return nil, ErrInvalidMessage
+	}
+	switch {
+	case fields[0] == "n" || fields[0] == "y":
+	case strings.HasPrefix(fields[0], "p="):
+		return nil, ErrChannelBindingNotSupported
+	default:
+		return nil, ErrInvalidMessage
+	}
+	var authzid string
+	if fields[1] != "" {
+		if !strings.HasPrefix(fields[1], "a=") {
+			return nil, ErrInvalidMessage
+		}
+		if authzid, err = decodeName(fields[1][2:]); err != nil {
+			return nil, err
+		}
+	}
+	c.gs2Header = fields[0] + "," + fields[1] + ","
+	c.clientFirstBare = fields[2]
+
+	attrs := strings.Split(c.clientFirstBare, ",")
+	if len(attrs) < 2 || !strings.HasPrefix(attrs[0], "n=") || !strings.HasPrefix(attrs[1], "r=") || len(attrs[1]) == 2 {
+		// this also rejects the reserved m= extension, which we don't support
+		return nil, ErrInvalidMessage
+	}
+	if c.username, err = decodeName(attrs[0][2:]); err != nil {
+		return nil, err
+	}
+	if authzid != "" && authzid != c.username {
+		return nil, ErrInvalidMessage
+	}
+	c.nonce = attrs[1][2:] + c.serverNonce
+
+	if c.credentials, err = c.lookup(c.username); err != nil {
+		return nil, err
+	}
+	c.serverFirst = fmt.Sprintf("r=%s,s=%s,i=%d", c.nonce, base64.StdEncoding.EncodeToString(c.credentials.Salt), c.credentials.Iterations)
+	return []byte(c.serverFirst), nil
+}
+
+// client-final-message = channel-binding "," nonce ["," extensions] "," proof
+func (c *ServerConversation) handleClientFinal(message string) (output []byte, err error) {
+	proofIndex := strings.LastIndex(message, ",p=")
+	if proofIndex == -1 {
+		return nil, ErrInvalidMessage
+	}
+	withoutProof := message[:proofIndex]
+	proof, err := base64.StdEncoding.DecodeString(message[proofIndex+3:])
+	if err != nil {
+		return nil, ErrInvalidMessage
+	}
+
+	attrs := strings.Split(withoutProof, ",")
+	if len(attrs) < 2 || !strings.HasPrefix(attrs[0], "c=") || !strings.HasPrefix(attrs[1], "r=") {
+		return nil, ErrInvalidMessage
+	}
+	if cbind, err := base64.StdEncoding.DecodeString(attrs[0][2:]); err != nil || string(cbind) != c.gs2Header {
+		return nil, ErrInvalidMessage
+	}
+	if attrs[1][2:] != c.nonce {
+		return nil, ErrInvalidMessage
+	}
+
+	authMessage := []byte(c.clientFirstBare + "," + c.serverFirst + "," + withoutProof)
+	clientSignature := computeHMAC(c.hashFunc, c.credentials.StoredKey, authMessage)
+	if len(proof) != len(clientSignature) {
+		return nil, ErrInvalidProof
+	}
+	clientKey := make([]byte, len(proof))
+	for i := range proof {
+		clientKey[i] = proof[i] ^ clientSignature[i]
+	}
+	storedKey := c.hashFunc()
+	storedKey.Write(clientKey)
+	if subtle.ConstantTimeCompare(storedKey.Sum(nil), c.credentials.StoredKey) != 1 {
+		return nil, ErrInvalidProof
+	}
+
+	serverSignature := computeHMAC(c.hashFunc, c.credentials.ServerKey, authMessage)
+	return []byte("v=" + base64.StdEncoding.EncodeToString(serverSignature)), nil
+}
+
+// decodeName decodes a saslname, in which ',' and '=' are escaped as =2C and =3D
+func decodeName(name string) (result string, err error) {
+	if strings.IndexByte(name, '=') == -1 {
+		return name, nil
+	}
+	var buf bytes.Buffer
+	for i := 0; i < len(name); i++ {
+		if name[i] != '=' {
+			buf.WriteByte(name[i])
+			continue
+		}
+		if i+2 >= len(name) {
+			return "", ErrInvalidMessage
+		}
+		switch name[i+1 : i+3] {
+		case "2C":
+			buf.WriteByte(',')
+		case "3D":
+			buf.WriteByte('=')
+		default:
+			return "", ErrInvalidMessage
+		}
+		i += 2
+	}
+	return buf.String(), nil
+}
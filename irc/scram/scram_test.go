@@ -0,0 +1,110 @@
+// Copyright (c) 2026 agent <agent@local>
+// released under the MIT license
+
+package scram
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+func TestRFC7677Example(t *testing.T) {
+	salt, _ := base64.StdEncoding.DecodeString("W22ZaJ0SNY7soEsUEjb6gQ==")
+	creds := credentialsFromSalt(SHA256, "pencil", salt, 4096)
+	conv := NewServerConversation(SHA256, func(username string) (Credentials, error) {
+		if username != "user" {
+			t.Errorf("unexpected username %s", username)
+		}
+		return creds, nil
+	})
+	conv.serverNonce = "%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0"
+
+	output, err := conv.Step([]byte("n,,n=user,r=rOprNGfwEbeRWgbNEkqO"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(output) != "r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096" {
+		t.Errorf("unexpected server-first-message: %s", output)
+	}
+	output, err = conv.Step([]byte("c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ="))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(output) != "v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=" {
+		t.Errorf("unexpected server-final-message: %s", output)
+	}
+	if !conv.Done() || conv.Username() != "user" {
+		t.Errorf("exchange should be complete")
+	}
+	if _, err = conv.Step(nil); err == nil {
+		t.Errorf("completed exchange should not accept more messages")
+	}
+}
+
+// clientFinal computes a client-final-message the way a client would
+func clientFinal(hashFunc HashFunc, password, gs2Header, clientFirstBare, serverFirst string, salt []byte, iterations int, nonce string) string {
+	saltedPassword := pbkdf2.Key([]byte(password), salt, iterations, hashFunc().Size(), hashFunc)
+	clientKey := computeHMAC(hashFunc, saltedPassword, []byte("Client Key"))
+	h := hashFunc()
+	h.Write(clientKey)
+	withoutProof := "c=" + base64.StdEncoding.EncodeToString([]byte(gs2Header)) + ",r=" + nonce
+	clientSignature := computeHMAC(hashFunc, h.Sum(nil), []byte(clientFirstBare+","+serverFirst+","+withoutProof))
+	for i := range clientKey {
+		clientKey[i] ^= clientSignature[i]
+	}
+	return withoutProof + ",p=" + base64.StdEncoding.EncodeToString(clientKey)
+}
+
+func TestSHA512(t *testing.T) {
+	creds, err := NewCredentials(SHA512, "hunter2", DefaultIterations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, password := range []string{"hunter2", "hunter3"} {
+		conv := NewServerConversation(SHA512, func(string) (Credentials, error) { return creds, nil })
+		serverFirst, err := conv.Step([]byte("n,a=al=2Cice,n=al=2Cice,r=abcdef"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if conv.Username() != "al,ice" {
+			t.Errorf("username was not decoded: %s", conv.Username())
+		}
+		nonce := "abcdef" + conv.serverNonce
+		_, err = conv.Step([]byte(clientFinal(SHA512, password, "n,a=al=2Cice,", "n=al=2Cice,r=abcdef", string(serverFirst), creds.Salt, creds.Iterations, nonce)))
+		if password == "hunter2" && (err != nil || !conv.Done()) {
+			t.Errorf("correct password should be accepted: %v", err)
+		} else if password == "hunter3" && err != ErrInvalidProof {
+			t.Errorf("incorrect password should be rejected: %v", err)
+		}
+	}
+}
+
+func TestInvalidMessages(t *testing.T) {
+	lookup := func(string) (Credentials, error) { return credentialsFromSalt(SHA256, "pw", []byte("salt"), 1), nil }
+	for _, message := range []string{
+		"",
+		"n,,r=abc",
+		"n,,n=user",
+		"x,,n=user,r=abc",
+		"n,,m=ext,n=user,r=abc",
+		"n,,n=us=2er,r=abc",
+		"n,a=other,n=user,r=abc",
+	} {
+		if _, err := NewServerConversation(SHA256, lookup).Step([]byte(message)); err != ErrInvalidMessage {
+			t.Errorf("%q should be invalid, got %v", message, err)
+		}
+	}
+	if _, err := NewServerConversation(SHA256, lookup).Step([]byte("p=tls-unique,,n=user,r=abc")); err != ErrChannelBindingNotSupported {
+		t.Errorf("channel binding should be rejected, got %v", err)
+	}
+
+	conv := NewServerConversation(SHA256, lookup)
+	if _, err := conv.Step([]byte("n,,n=user,r=abc")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conv.Step([]byte("c=biws,r=wrongnonce,p=AAAA")); err != ErrInvalidMessage {
+		t.Errorf("mismatched nonce should be rejected, got %v", err)
+	}
+}
@@ -30,7 +30,9 @@ import (
 	"github.com/ergochat/ergo/irc/logger"
 	"github.com/ergochat/ergo/irc/modes"
 	"github.com/ergochat/ergo/irc/mysql"
+	"github.com/ergochat/ergo/irc/postgresql"
 	"github.com/ergochat/ergo/irc/sno"
+	"github.com/ergochat/ergo/irc/sqlite"
 	"github.com/ergochat/ergo/irc/utils"
 	"github.com/tidwall/buntdb"
 )
@@ -73,6 +75,8 @@ type Server struct {
 	dlines            *DLineManager
 	helpIndexManager  HelpIndexManager
 	klines            *KLineManager
+	links             LinkManager
+	metrics           serverMetrics
 	listeners         map[string]IRCListener
 	logger            *logger.Manager
 	monitorManager    MonitorManager
@@ -81,10 +85,12 @@ type Server struct {
 	rehashMutex       sync.Mutex // tier 4
 	rehashSignal      chan os.Signal
 	pprofServer       *http.Server
+	apiServer         *http.Server
+	metricsServer     *http.Server
 	exitSignals       chan os.Signal
 	snomasks          SnoManager
 	store             *buntdb.DB
-	historyDB         mysql.MySQL
+	historyDB         unsafe.Pointer // *history.Database; nil unless persistent history is configured
 	torLimiter        connection_limits.TorLimiter
 	whoWas            WhoWasList
 	stats             Stats
@@ -109,6 +115,8 @@ func NewServer(config *Config, logger *logger.Manager) (*Server, error) {
 	server.whoWas.Initialize(config.Limits.WhowasEntries)
 	server.monitorManager.Initialize()
 	server.snomasks.Initialize()
+	server.links.Initialize(server)
+	server.metrics.Initialize()
 
 	if err := server.applyConfig(config); err != nil {
 		return nil, err
@@ -119,6 +127,7 @@ func NewServer(config *Config, logger *logger.Manager) (*Server, error) {
 	signal.Notify(server.rehashSignal, syscall.SIGHUP)
 
 	time.AfterFunc(alwaysOnExpirationPollPeriod, server.handleAlwaysOnExpirations)
+	go server.links.handleAutoconnect()
 
 	return server, nil
 }
@@ -140,7 +149,9 @@ func (server *Server) Shutdown() {
 		server.logger.Error("shutdown", fmt.Sprintln("Could not close datastore:", err))
 	}
 
-	server.historyDB.Close()
+	if historyDB := server.HistoryDB(); historyDB != nil {
+		historyDB.Close()
+	}
 	server.logger.Info("server", fmt.Sprintf("%s exiting", Ver))
 }
 
@@ -159,7 +170,9 @@ func (server *Server) Run() {
 	}
 }
 
-func (server *Server) checkBans(config *Config, ipaddr net.IP, checkScripts bool) (banned bool, requireSASL bool, message string) {
+// checkBans checks a new connection (or a new proxied IP) against D-Lines, connection
+// limits, and the IP check script; rejections are recorded in the metrics for `listener`.
+func (server *Server) checkBans(config *Config, ipaddr net.IP, checkScripts bool, listener string) (banned bool, requireSASL bool, message string) {
 	// #671: do not enforce bans against loopback, as a failsafe
 	// note that this function is not used for Tor connections (checkTorLimits is used instead)
 	if ipaddr.IsLoopback() {
@@ -168,6 +181,7 @@ func (server *Server) checkBans(config *Config, ipaddr net.IP, checkScripts bool
 
 	if server.Defcon() == 1 {
 		if !utils.IPInNets(ipaddr, server.Config().Server.secureNets) {
+			server.metrics.rejectConnection(listener, rejectDefcon)
 			return true, false, "New connections to this server are temporarily restricted"
 		}
 	}
@@ -182,6 +196,7 @@ func (server *Server) checkBans(config *Config, ipaddr net.IP, checkScripts bool
 			return false, true, info.BanMessage("You must authenticate with SASL to connect from this IP (%s)")
 		} else {
 			server.logger.Info("connect-ip", "Client rejected by d-line", ipaddr.String())
+			server.metrics.rejectConnection(listener, rejectDline)
 			return true, false, info.BanMessage("You are banned from this server (%s)")
 		}
 	}
@@ -191,9 +206,11 @@ func (server *Server) checkBans(config *Config, ipaddr net.IP, checkScripts bool
 	if err == connection_limits.ErrLimitExceeded {
 		// too many connections from one client, tell the client and close the connection
 		server.logger.Info("connect-ip", "Client rejected for connection limit", ipaddr.String())
+		server.metrics.rejectConnection(listener, rejectLimit)
 		return true, false, "Too many clients from your network"
 	} else if err == connection_limits.ErrThrottleExceeded {
 		server.logger.Info("connect-ip", "Client exceeded connection throttle", ipaddr.String())
+		server.metrics.rejectConnection(listener, rejectThrottle)
 		return true, false, throttleMessage
 	} else if err != nil {
 		server.logger.Warning("internal", "unexpected ban result", err.Error())
@@ -222,6 +239,7 @@ func (server *Server) checkBans(config *Config, ipaddr net.IP, checkScripts bool
 			// XXX roll back IP connection/throttling addition for the IP
 			server.connectionLimiter.RemoveClient(flat)
 			server.logger.Info("connect-ip", "Rejected client due to ip-check-script", ipaddr.String())
+			server.metrics.rejectConnection(listener, rejectIPCheckScript)
 			return true, false, output.BanMessage
 		} else if output.Result == IPRequireSASL {
 			server.logger.Info("connect-ip", "Requiring SASL from client due to ip-check-script", ipaddr.String())
@@ -232,11 +250,13 @@ func (server *Server) checkBans(config *Config, ipaddr net.IP, checkScripts bool
 	return false, false, ""
 }
 
-func (server *Server) checkTorLimits() (banned bool, message string) {
+func (server *Server) checkTorLimits(listener string) (banned bool, message string) {
 	switch server.torLimiter.AddClient() {
 	case connection_limits.ErrLimitExceeded:
+		server.metrics.rejectConnection(listener, rejectTorLimit)
 		return true, "Too many clients from the Tor network"
 	case connection_limits.ErrThrottleExceeded:
+		server.metrics.rejectConnection(listener, rejectTorThrottle)
 		return true, "Exceeded connection throttle for the Tor network"
 	default:
 		return false, ""
@@ -352,6 +372,7 @@ func (server *Server) tryRegister(c *Client, session *Session) (exiting bool) {
 	}
 
 	server.playRegistrationBurst(session)
+	server.links.IntroduceClient(c)
 	return false
 }
 
@@ -398,6 +419,10 @@ func (server *Server) playRegistrationBurst(session *Session) {
 		session.Send(nil, server.name, RPL_UMODEIS, d.nick, modestring)
 	}
 
+	rb = NewResponseBuffer(session)
+	c.applyPendingOper(rb)
+	rb.Send(true)
+
 	c.attemptAutoOper(session)
 
 	if server.logger.IsLoggingRawIO() {
@@ -423,16 +448,24 @@ func (server *Server) RplISupport(client *Client, rb *ResponseBuffer) {
 func (server *Server) Lusers(client *Client, rb *ResponseBuffer) {
 	nick := client.Nick()
 	stats := server.stats.GetValues()
+	remoteServers, links, remoteTotal, remoteInvisible := server.links.LinkCounts()
+	globalTotal := stats.Total + remoteTotal
+	globalInvisible := stats.Invisible + remoteInvisible
 
-	rb.Add(nil, server.name, RPL_LUSERCLIENT, nick, fmt.Sprintf(client.t("There are %[1]d users and %[2]d invisible on %[3]d server(s)"), stats.Total-stats.Invisible, stats.Invisible, 1))
+	rb.Add(nil, server.name, RPL_LUSERCLIENT, nick, fmt.Sprintf(client.t("There are %[1]d users and %[2]d invisible on %[3]d server(s)"), globalTotal-globalInvisible, globalInvisible, 1+remoteServers))
 	rb.Add(nil, server.name, RPL_LUSEROP, nick, strconv.Itoa(stats.Operators), client.t("IRC Operators online"))
 	rb.Add(nil, server.name, RPL_LUSERUNKNOWN, nick, strconv.Itoa(stats.Unknown), client.t("unregistered connections"))
 	rb.Add(nil, server.name, RPL_LUSERCHANNELS, nick, strconv.Itoa(server.channels.Len()), client.t("channels formed"))
-	rb.Add(nil, server.name, RPL_LUSERME, nick, fmt.Sprintf(client.t("I have %[1]d clients and %[2]d servers"), stats.Total, 0))
+	rb.Add(nil, server.name, RPL_LUSERME, nick, fmt.Sprintf(client.t("I have %[1]d clients and %[2]d servers"), stats.Total, links))
 	total := strconv.Itoa(stats.Total)
 	max := strconv.Itoa(stats.Max)
 	rb.Add(nil, server.name, RPL_LOCALUSERS, nick, total, max, fmt.Sprintf(client.t("Current local users %[1]s, max %[2]s"), total, max))
-	rb.Add(nil, server.name, RPL_GLOBALUSERS, nick, total, max, fmt.Sprintf(client.t("Current global users %[1]s, max %[2]s"), total, max))
+	global := strconv.Itoa(globalTotal)
+	globalMax := strconv.Itoa(stats.Max)
+	if stats.Max < globalTotal {
+		globalMax = global
+	}
+	rb.Add(nil, server.name, RPL_GLOBALUSERS, nick, global, globalMax, fmt.Sprintf(client.t("Current global users %[1]s, max %[2]s"), global, globalMax))
 }
 
 // MOTD serves the Message of the Day.
@@ -475,6 +508,9 @@ func (client *Client) getWhoisOf(target *Client, hasPrivs bool, rb *ResponseBuff
 	if whoischannels != nil {
 		rb.Add(nil, client.server.name, RPL_WHOISCHANNELS, cnick, tnick, strings.Join(whoischannels, " "))
 	}
+	if target.remote != nil {
+		rb.Add(nil, client.server.name, RPL_WHOISSERVER, cnick, tnick, target.ServerName(), target.ServerDescription())
+	}
 	if target.HasMode(modes.Operator) && operStatusVisible(client, target, oper != nil) {
 		tOper := target.Oper()
 		if tOper != nil {
@@ -633,28 +669,49 @@ func (server *Server) applyConfig(config *Config) (err error) {
 		if !oldConfig.Channels.Registration.Enabled {
 			server.channels.loadRegisteredChannels(config)
 		}
-		// resize history buffers as needed
-		if config.historyChangedFrom(oldConfig) {
-			for _, channel := range server.channels.Channels() {
-				channel.resizeHistory(config)
-			}
-			for _, client := range server.clients.AllClients() {
-				client.resizeHistory(config)
-			}
-		}
 		if oldConfig.Accounts.Registration.Throttling != config.Accounts.Registration.Throttling {
 			server.accounts.resetRegisterThrottle(config)
 		}
 	}
 
 	server.logger.Info("server", "Using datastore", config.Datastore.Path)
+	// set if the in-memory history buffers should be copied into the database
+	var migrateHistoryDB *sqlite.SQLite
 	if initial {
 		if err := server.loadDatastore(config); err != nil {
 			return err
 		}
 	} else {
-		if config.Datastore.MySQL.Enabled && config.Datastore.MySQL != oldConfig.Datastore.MySQL {
-			server.historyDB.SetConfig(config.Datastore.MySQL)
+		switch historyDB := server.HistoryDB().(type) {
+		case *mysql.MySQL:
+			if config.Datastore.MySQL.Enabled && config.Datastore.MySQL != oldConfig.Datastore.MySQL {
+				historyDB.SetConfig(config.Datastore.MySQL)
+			}
+		case *postgresql.PostgreSQL:
+			if config.Datastore.PostgreSQL.Enabled && config.Datastore.PostgreSQL != oldConfig.Datastore.PostgreSQL {
+				historyDB.SetConfig(config.Datastore.PostgreSQL)
+			}
+		case *sqlite.SQLite:
+			if config.Datastore.SQLite.Enabled && config.Datastore.SQLite != oldConfig.Datastore.SQLite {
+				historyDB.SetConfig(config.Datastore.SQLite)
+			}
+			if config.History.Persistent.Enabled && !oldConfig.History.Persistent.Enabled {
+				migrateHistoryDB = historyDB
+			}
+		case nil:
+			if config.Datastore.MySQL.Enabled || config.Datastore.PostgreSQL.Enabled {
+				return fmt.Errorf("Enabling a history database requires a restart")
+			} else if config.Datastore.SQLite.Enabled {
+				// SQLite needs no external service, so we can start it up right away;
+				// it must be available before the new config is, since the new config
+				// may make history persistent
+				historyDB, err := server.loadSQLiteHistory(config)
+				if err != nil {
+					return err
+				}
+				server.setHistoryDB(historyDB)
+				migrateHistoryDB = historyDB
+			}
 		}
 	}
 
@@ -666,6 +723,23 @@ func (server *Server) applyConfig(config *Config) (err error) {
 	// activate the new config
 	server.SetConfig(config)
 
+	// now that persistent history is authoritative, nothing more is added to
+	// the buffers of persistent channels and clients; copy over their contents
+	// before they are discarded by the resize
+	if migrateHistoryDB != nil && config.History.Persistent.Enabled {
+		server.migrateEphemeralHistory(migrateHistoryDB, config)
+	}
+
+	// resize history buffers as needed
+	if oldConfig != nil && config.historyChangedFrom(oldConfig) {
+		for _, channel := range server.channels.Channels() {
+			channel.resizeHistory(config)
+		}
+		for _, client := range server.clients.AllClients() {
+			client.resizeHistory(config)
+		}
+	}
+
 	// load [dk]-lines, registered users and channels, etc.
 	if initial {
 		if err := server.loadFromDatastore(config); err != nil {
@@ -703,6 +777,9 @@ func (server *Server) applyConfig(config *Config) (err error) {
 	}
 
 	server.setupPprofListener(config)
+	server.setupAPIListener(config)
+	server.setupMetricsListener(config)
+	server.links.applyConfig(config)
 
 	// set RPL_ISUPPORT
 	var newISupportReplies [][]string
@@ -802,17 +879,119 @@ func (server *Server) loadFromDatastore(config *Config) (err error) {
 	server.accounts.Initialize(server)
 
 	if config.Datastore.MySQL.Enabled {
-		server.historyDB.Initialize(server.logger, config.Datastore.MySQL)
-		err = server.historyDB.Open()
+		historyDB := new(mysql.MySQL)
+		historyDB.Initialize(server.logger, config.Datastore.MySQL)
+		err = historyDB.Open()
 		if err != nil {
 			server.logger.Error("internal", "could not connect to mysql", err.Error())
 			return err
 		}
+		server.setHistoryDB(historyDB)
+	} else if config.Datastore.PostgreSQL.Enabled {
+		historyDB := new(postgresql.PostgreSQL)
+		historyDB.Initialize(server.logger, config.Datastore.PostgreSQL)
+		err = historyDB.Open()
+		if err != nil {
+			server.logger.Error("internal", "could not connect to postgresql", err.Error())
+			return err
+		}
+		server.setHistoryDB(historyDB)
+	} else if config.Datastore.SQLite.Enabled {
+		historyDB, err := server.loadSQLiteHistory(config)
+		if err != nil {
+			return err
+		}
+		server.setHistoryDB(historyDB)
 	}
 
 	return nil
 }
 
+func (server *Server) loadSQLiteHistory(config *Config) (historyDB *sqlite.SQLite, err error) {
+	historyDB = new(sqlite.SQLite)
+	historyDB.Initialize(server.logger, config.Datastore.SQLite)
+	err = historyDB.Open()
+	if err != nil {
+		server.logger.Error("internal", "could not open sqlite database", err.Error())
+		return nil, err
+	}
+	return historyDB, nil
+}
+
+// migrateEphemeralHistory copies the in-memory history of every channel and client
+// whose history is now persistent into a newly enabled SQLite database. The buffers
+// are read right away, but the database is written to in the background.
+func (server *Server) migrateEphemeralHistory(historyDB *sqlite.SQLite, config *Config) {
+	type bufferContents struct {
+		target string
+		nick   string // for clients only
+		items  []history.Item
+	}
+	var channels, clients []bufferContents
+	for _, channel := range server.channels.Channels() {
+		if status, target, _ := channel.historyStatus(config); status == HistoryPersistent {
+			if items := channel.history.All(); len(items) != 0 {
+				channels = append(channels, bufferContents{target: target, items: items})
+			}
+		}
+	}
+	for _, client := range server.clients.AllClients() {
+		if status, target := client.historyStatus(config); status == HistoryPersistent {
+			if items := client.history.All(); len(items) != 0 {
+				clients = append(clients, bufferContents{target: target, nick: client.Nick(), items: items})
+			}
+		}
+	}
+
+	// the account of the sender, casefolded, or "" for none
+	senderAccount := func(item *history.Item) (account string) {
+		if item.AccountName != "*" {
+			account, _ = CasefoldName(item.AccountName)
+		}
+		return
+	}
+
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				server.logger.Error("history",
+					fmt.Sprintf("Panic in history migration: %v\n%s", r, debug.Stack()))
+			}
+		}()
+
+		var itemCount int
+		for _, channel := range channels {
+			for _, item := range channel.items {
+				if historyDB.ImportChannelItem(channel.target, item, senderAccount(&item)) == nil {
+					itemCount++
+				}
+			}
+		}
+		for _, client := range clients {
+			for _, item := range client.items {
+				correspondent := item.CfCorrespondent
+				if correspondent == "" {
+					continue
+				}
+				// the persistent copy of a DM has the recipient's nick in Params[0],
+				// and no correspondent (see client.addHistoryItem):
+				var account string
+				if item.Params[0] == "" {
+					item.Params[0] = client.nick
+					account = senderAccount(&item)
+				} else {
+					account = client.target
+				}
+				item.CfCorrespondent = ""
+				if historyDB.ImportDirectMessage(client.target, correspondent, item, account) == nil {
+					itemCount++
+				}
+			}
+		}
+		server.logger.Info("history", fmt.Sprintf("Migrated %d in-memory history items (from %d channels and %d clients) to sqlite", itemCount, len(channels), len(clients)))
+	}()
+}
+
 func (server *Server) setupListeners(config *Config) (err error) {
 	logListener := func(addr string, config utils.ListenerConfig) {
 		server.logger.Info("listeners",
@@ -956,7 +1135,7 @@ func (server *Server) GetHistorySequence(providedChannel *Channel, client *Clien
 	if hist != nil {
 		sequence = hist.MakeSequence(correspondent, cutoff)
 	} else if target != "" {
-		sequence = server.historyDB.MakeSequence(target, correspondent, cutoff)
+		sequence = server.HistoryDB().MakeSequence(target, correspondent, cutoff)
 	}
 	return
 }
@@ -972,8 +1151,10 @@ func (server *Server) ForgetHistory(accountName string) {
 		return
 	}
 
-	if cfAccount, err := CasefoldName(accountName); err == nil {
-		server.historyDB.Forget(cfAccount)
+	if historyDB := server.HistoryDB(); historyDB != nil {
+		if cfAccount, err := CasefoldName(accountName); err == nil {
+			historyDB.Forget(cfAccount)
+		}
 	}
 
 	persistent := config.History.Persistent
@@ -992,38 +1173,114 @@ func (server *Server) ForgetHistory(accountName string) {
 	}
 }
 
-// deletes a message. target is a hint about what buffer it's in (not required for
-// persistent history, where all the msgids are indexed together). if accountName
-// is anything other than "*", it must match the recorded AccountName of the message
-func (server *Server) DeleteMessage(target, msgid, accountName string) (err error) {
-	config := server.Config()
-	var hist *history.Buffer
-
-	if target != "" {
-		if target[0] == '#' {
-			channel := server.channels.Get(target)
-			if channel != nil {
-				if status, _, _ := channel.historyStatus(config); status == HistoryEphemeral {
-					hist = &channel.history
-				}
+// ephemeralHistory returns the in-memory history buffer of a channel or client,
+// or nil if its history is not stored in memory.
+func (server *Server) ephemeralHistory(target string, config *Config) (hist *history.Buffer) {
+	if target == "" {
+		return nil
+	}
+	if target[0] == '#' {
+		channel := server.channels.Get(target)
+		if channel != nil {
+			if status, _, _ := channel.historyStatus(config); status == HistoryEphemeral {
+				hist = &channel.history
 			}
-		} else {
-			client := server.clients.Get(target)
-			if client != nil {
-				if status, _ := client.historyStatus(config); status == HistoryEphemeral {
-					hist = &client.history
-				}
+		}
+	} else {
+		client := server.clients.Get(target)
+		if client != nil {
+			if status, _ := client.historyStatus(config); status == HistoryEphemeral {
+				hist = &client.history
 			}
 		}
 	}
+	return
+}
+
+// looks up a message in history; target is as for DeleteMessage
+func (server *Server) LookupMessage(target, msgid string) (item history.Item, err error) {
+	config := server.Config()
+	if hist := server.ephemeralHistory(target, config); hist != nil {
+		item, found := hist.Lookup(msgid)
+		if !found {
+			err = errNoop
+		}
+		return item, err
+	}
+	if !config.History.Persistent.Enabled {
+		return item, errNoop
+	}
+	item, targets, err := server.HistoryDB().LookupMsgid(msgid)
+	if err != nil {
+		return
+	}
+	if target != "" && !server.persistentTargetMatches(target, targets) {
+		return history.Item{}, errNoop
+	}
+	return
+}
+
+// persistentTargetMatches checks whether a message stored in persistent history
+// belongs to `target`: a channel it was sent to, or a client whose account holds
+// a copy of the direct message.
+func (server *Server) persistentTargetMatches(target string, targets []string) bool {
+	var cftarget string
+	if strings.HasPrefix(target, "#") {
+		cftarget, _ = CasefoldChannel(target)
+	} else if client := server.clients.Get(target); client != nil {
+		cftarget = client.Account()
+	}
+	if cftarget == "" {
+		return false
+	}
+	for _, t := range targets {
+		if t == cftarget {
+			return true
+		}
+	}
+	return false
+}
+
+// deletes a message. target is the channel or client whose history contains it;
+// it may be empty for persistent history (where all the msgids are indexed together)
+// to delete the message regardless of where it was sent. if accountName
+// is anything other than "*", it must match the recorded AccountName of the message.
+// if notBefore is nonzero, messages sent before it are not deleted (errMessageTooOld).
+func (server *Server) DeleteMessage(target, msgid, accountName string, notBefore time.Time) (err error) {
+	config := server.Config()
+	hist := server.ephemeralHistory(target, config)
 
 	if hist == nil {
-		err = server.historyDB.DeleteMsgid(msgid, accountName)
+		if !config.History.Persistent.Enabled {
+			// nothing to delete (and no way to verify the message existed)
+			return errNoop
+		}
+		if target != "" {
+			// don't let a channel operator or conversation participant
+			// delete messages sent somewhere else
+			if _, err = server.LookupMessage(target, msgid); err != nil {
+				return errNoop
+			}
+		}
+		err = server.HistoryDB().DeleteMsgid(msgid, accountName, notBefore)
+		if err == history.ErrExpired {
+			err = errMessageTooOld
+		}
 	} else {
+		tooOld := false
 		count := hist.Delete(func(item *history.Item) bool {
-			return item.Message.Msgid == msgid && (accountName == "*" || item.AccountName == accountName)
+			if item.Message.Msgid != msgid || !(accountName == "*" || item.AccountName == accountName) {
+				return false
+			}
+			if item.Message.Time.Before(notBefore) {
+				tooOld = true
+				return false
+			}
+			return true
 		})
-		if count == 0 {
+		if tooOld {
+			err = errMessageTooOld
+		} else if count == 0 {
 			err = errNoop
 		}
 	}
@@ -0,0 +1,471 @@
+// Copyright (c) 2026 agent <agent@local>
+// released under the MIT license
+
+package irc
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/ergochat/ergo/irc/history"
+	"github.com/ergochat/ergo/irc/logger"
+	"github.com/ergochat/ergo/irc/sqlite"
+	"github.com/ergochat/ergo/irc/utils"
+)
+
+// these tests run a real server in-process, configured from default.yaml,
+// with its datastore in a temporary directory and a single plaintext
+// listener on a random loopback port.
+
+const testListenAddr = "127.0.0.1:0"
+
+// setConfigKey sets a value in a config parsed generically from YAML,
+// creating intermediate maps as needed; path is dot-separated.
+func setConfigKey(config map[interface{}]interface{}, path string, value interface{}) {
+	keys := strings.Split(path, ".")
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := config[key].(map[interface{}]interface{})
+		if !ok {
+			next = make(map[interface{}]interface{})
+			config[key] = next
+		}
+		config = next
+	}
+	config[keys[len(keys)-1]] = value
+}
+
+// writeTestConfig writes a config file derived from default.yaml to dir;
+// overrides maps dot-separated keys to their values.
+func writeTestConfig(t *testing.T, dir string, overrides map[string]interface{}) (filename string) {
+	data, err := os.ReadFile("../default.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config map[interface{}]interface{}
+	if err = yaml.Unmarshal(data, &config); err != nil {
+		t.Fatal(err)
+	}
+	setConfigKey(config, "server.listeners", map[interface{}]interface{}{testListenAddr: nil})
+	setConfigKey(config, "server.motd", "")
+	setConfigKey(config, "server.lookup-hostnames", false)
+	setConfigKey(config, "languages.enabled", false)
+	setConfigKey(config, "fakelag.enabled", false)
+	setConfigKey(config, "logging", []interface{}{
+		map[interface{}]interface{}{"method": "stderr", "type": "* -userinput -useroutput", "level": "error"},
+	})
+	setConfigKey(config, "datastore.path", filepath.Join(dir, "ircd.db"))
+	setConfigKey(config, "datastore.sqlite.database-path", filepath.Join(dir, "history.db"))
+	for key, value := range overrides {
+		setConfigKey(config, key, value)
+	}
+	if data, err = yaml.Marshal(config); err != nil {
+		t.Fatal(err)
+	}
+	filename = filepath.Join(dir, "ircd.yaml")
+	if err = os.WriteFile(filename, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+// newTestServer starts a server for the duration of the test.
+func newTestServer(t *testing.T, overrides map[string]interface{}) (server *Server, addr string) {
+	if enabled, _ := overrides["datastore.sqlite.enabled"].(bool); enabled && !sqlite.Supported {
+		t.Skip("SQLite requires cgo")
+	}
+	filename := writeTestConfig(t, t.TempDir(), overrides)
+	config, err := LoadConfig(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logman, err := logger.NewManager(config.Logging)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err = NewServer(config, logman)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		for _, listener := range server.listeners {
+			listener.Stop()
+		}
+		if server.apiServer != nil {
+			server.apiServer.Close()
+		}
+		for _, client := range server.clients.AllClients() {
+			client.destroy(nil)
+		}
+		if historyDB := server.HistoryDB(); historyDB != nil {
+			historyDB.Close()
+		}
+		server.store.Close()
+	})
+	addr = server.listeners[testListenAddr].(*NetListener).listener.Addr().String()
+	return
+}
+
+// testClient is a minimal line-based IRC client.
+type testClient struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+	nick   string
+}
+
+func newTestClient(t *testing.T, addr, nick string) *testClient {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testClient{t: t, conn: conn, reader: bufio.NewReader(conn), nick: nick}
+}
+
+// connectTestClient connects and registers a client, requesting the given
+// capabilities and, if password is nonempty, logging in with SASL PLAIN
+// to the account named after the nick.
+func connectTestClient(t *testing.T, addr, nick, password string, capabilities ...string) *testClient {
+	c := newTestClient(t, addr, nick)
+	if password != "" {
+		capabilities = append(capabilities, "sasl")
+	}
+	if len(capabilities) != 0 {
+		c.Send("CAP REQ :" + strings.Join(capabilities, " "))
+		c.Expect("CAP")
+	}
+	if password != "" {
+		c.Send("AUTHENTICATE PLAIN")
+		c.Expect("AUTHENTICATE")
+		c.Send("AUTHENTICATE " + saslPlain(nick, password))
+		c.Expect(RPL_SASLSUCCESS)
+	}
+	if len(capabilities) != 0 {
+		c.Send("CAP END")
+	}
+	c.Send("NICK " + nick)
+	c.Send("USER u 0 * :" + nick)
+	c.Expect(RPL_ENDOFMOTD, ERR_NOMOTD)
+	return c
+}
+
+func (c *testClient) Send(line string) {
+	c.t.Helper()
+	c.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	if _, err := c.conn.Write([]byte(line + "\r\n")); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// ReadLine returns the next line from the server, answering any PING.
+func (c *testClient) ReadLine() (line string, ok bool) {
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			return "", false
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if strings.HasPrefix(line, "PING ") {
+			c.Send("PONG " + line[5:])
+			continue
+		}
+		return line, true
+	}
+}
+
+// lineCommand returns the command of a raw line, skipping tags and source.
+func lineCommand(line string) string {
+	fields := strings.Fields(line)
+	for len(fields) != 0 && (fields[0][0] == '@' || fields[0][0] == ':') {
+		fields = fields[1:]
+	}
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
+// Expect reads lines until one of the commands (or numerics) arrives, and returns it.
+func (c *testClient) Expect(commands ...string) string {
+	c.t.Helper()
+	var seen []string
+	for {
+		line, ok := c.ReadLine()
+		if !ok {
+			c.t.Fatalf("%s: expected %v, got %q", c.nick, commands, seen)
+		}
+		command := lineCommand(line)
+		for _, expected := range commands {
+			if command == expected {
+				return line
+			}
+		}
+		seen = append(seen, line)
+	}
+}
+
+// Sync sends a PING and returns the lines received before its PONG.
+func (c *testClient) Sync() (lines []string) {
+	c.t.Helper()
+	token := fmt.Sprintf("sync%d", time.Now().UnixNano())
+	c.Send("PING " + token)
+	for {
+		line, ok := c.ReadLine()
+		if !ok {
+			c.t.Fatalf("%s: connection lost waiting for PONG", c.nick)
+		}
+		if lineCommand(line) == "PONG" && strings.HasSuffix(line, token) {
+			return
+		}
+		lines = append(lines, line)
+	}
+}
+
+// SyncContains sends a PING and reports whether any line before the PONG contains substr.
+func (c *testClient) SyncContains(substr string) bool {
+	c.t.Helper()
+	for _, line := range c.Sync() {
+		if strings.Contains(line, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+func saslPlain(account, password string) string {
+	return base64.StdEncoding.EncodeToString([]byte(account + "\x00" + account + "\x00" + password))
+}
+
+// registerTestAccount registers an account directly, as SAREGISTER does.
+func registerTestAccount(t *testing.T, server *Server, account, password string) {
+	t.Helper()
+	if err := server.accounts.SARegister(account, password); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// serviceCommand sends a command to a service and returns the text of its first NOTICE in reply.
+func (c *testClient) serviceCommand(service, command string) (reply string) {
+	c.t.Helper()
+	c.Send(fmt.Sprintf("PRIVMSG %s :%s", service, command))
+	for {
+		line := c.Expect("NOTICE")
+		if strings.HasPrefix(line, ":"+service+"!") {
+			return line[strings.Index(line[1:], " :")+3:]
+		}
+	}
+}
+
+// newTestMTA runs a minimal SMTP server on a random loopback port, for the
+// duration of the test; it sends the body of each message it receives on messages.
+func newTestMTA(t *testing.T) (port int, messages chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	messages = make(chan string, 16)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveTestSMTP(conn, messages)
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port, messages
+}
+
+func serveTestSMTP(conn net.Conn, messages chan string) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	text := textproto.NewConn(conn)
+	text.PrintfLine("220 localhost ESMTP")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		switch verb := strings.ToUpper(strings.Fields(line + " x")[0]); verb {
+		case "DATA":
+			text.PrintfLine("354 go ahead")
+			body, err := text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			messages <- string(body)
+			text.PrintfLine("250 OK")
+		case "QUIT":
+			text.PrintfLine("221 bye")
+			return
+		default:
+			text.PrintfLine("250 OK")
+		}
+	}
+}
+
+// testMailOverrides are config overrides that send email to a test MTA.
+func testMailOverrides(port int) map[string]interface{} {
+	return map[string]interface{}{
+		"accounts.registration.email-verification.enabled":     true,
+		"accounts.registration.email-verification.require-tls": false,
+		"accounts.registration.email-verification.mta": map[interface{}]interface{}{
+			"server": "127.0.0.1",
+			"port":   port,
+		},
+	}
+}
+
+// expectMail waits for a message from the test MTA.
+func expectMail(t *testing.T, messages chan string) string {
+	t.Helper()
+	select {
+	case message := <-messages:
+		return message
+	case <-time.After(5 * time.Second):
+		t.Fatal("no email was sent")
+		return ""
+	}
+}
+
+// lineMsgid returns the msgid tag of a raw line.
+func lineMsgid(line string) string {
+	if !strings.HasPrefix(line, "@") {
+		return ""
+	}
+	for _, tag := range strings.Split(strings.Fields(line)[0][1:], ";") {
+		if strings.HasPrefix(tag, "msgid=") {
+			return strings.TrimPrefix(tag, "msgid=")
+		}
+	}
+	return ""
+}
+
+// sendMessage sends a PRIVMSG and returns its msgid, from the echo.
+func (c *testClient) sendMessage(target, text string) (msgid string) {
+	c.t.Helper()
+	c.Send(fmt.Sprintf("PRIVMSG %s :%s", target, text))
+	msgid = lineMsgid(c.Expect("PRIVMSG"))
+	if msgid == "" {
+		c.t.Fatalf("%s: no msgid on echoed message", c.nick)
+	}
+	return
+}
+
+// redact sends a REDACT and returns the FAIL code, or "" if it succeeded.
+func (c *testClient) redact(target, msgid string) (code string) {
+	c.t.Helper()
+	c.Send(fmt.Sprintf("REDACT %s %s", target, msgid))
+	for _, line := range c.Sync() {
+		fields := strings.Fields(line)
+		for i := 0; i+2 < len(fields); i++ {
+			if fields[i] == "FAIL" && fields[i+1] == "REDACT" {
+				return fields[i+2]
+			}
+		}
+		if lineCommand(line) == "REDACT" && strings.Contains(line, ":"+c.nick+"!") && strings.HasSuffix(line, " "+msgid) {
+			return ""
+		}
+	}
+	c.t.Fatalf("%s: no response to REDACT", c.nick)
+	return
+}
+
+func testRedaction(t *testing.T, overrides map[string]interface{}) {
+	server, addr := newTestServer(t, overrides)
+	for _, account := range []string{"alice", "bob", "carol", "dave"} {
+		registerTestAccount(t, server, account, "pw")
+	}
+	capabilities := []string{"echo-message", "message-tags", "draft/message-redaction"}
+	alice := connectTestClient(t, addr, "alice", "pw", capabilities...)
+	bob := connectTestClient(t, addr, "bob", "pw", capabilities...)
+	carol := connectTestClient(t, addr, "carol", "pw", capabilities...)
+	dave := connectTestClient(t, addr, "dave", "pw", capabilities...)
+
+	alice.Send("JOIN #chan")
+	alice.Expect(RPL_ENDOFNAMES)
+	bob.Send("JOIN #chan")
+	bob.Expect(RPL_ENDOFNAMES)
+	carol.Send("JOIN #other")
+	carol.Expect(RPL_ENDOFNAMES)
+	alice.Sync()
+
+	inChan := bob.sendMessage("#chan", "hi")
+	inChan2 := bob.sendMessage("#chan", "hi again")
+	inOther := carol.sendMessage("#other", "hello")
+	direct := bob.sendMessage("dave", "psst")
+	direct2 := bob.sendMessage("dave", "psst again")
+	elsewhere := carol.sendMessage("dave", "hey")
+
+	exists := func(target, msgid string) bool {
+		_, err := server.LookupMessage(target, msgid)
+		return err == nil
+	}
+
+	// a channel operator can redact any message in their channel:
+	assertEqual(alice.redact("#chan", inChan), "", t)
+	assertEqual(exists("#chan", inChan), false, t)
+	// but not messages sent to other channels, or direct messages:
+	assertEqual(alice.redact("#chan", inOther), "UNKNOWN_MSGID", t)
+	assertEqual(exists("#other", inOther), true, t)
+	assertEqual(alice.redact("#chan", direct), "UNKNOWN_MSGID", t)
+	assertEqual(exists("dave", direct), true, t)
+
+	// a non-operator can only redact their own messages:
+	assertEqual(bob.redact("#chan", inOther), "UNKNOWN_MSGID", t)
+	assertEqual(exists("#other", inOther), true, t)
+	assertEqual(dave.redact("bob", direct) != "", true, t)
+	assertEqual(exists("dave", direct), true, t)
+	// including direct messages they weren't part of:
+	assertEqual(bob.redact("dave", elsewhere) != "", true, t)
+	assertEqual(exists("dave", elsewhere), true, t)
+	// the author can redact within the window, from either side of a conversation:
+	assertEqual(bob.redact("#chan", inChan2), "", t)
+	assertEqual(exists("#chan", inChan2), false, t)
+	assertEqual(bob.redact("dave", direct), "", t)
+	assertEqual(exists("bob", direct), false, t)
+	assertEqual(exists("dave", direct), false, t)
+	assertEqual(exists("dave", direct2), true, t)
+
+	// messages older than the window can only be redacted by operators:
+	old := history.Item{
+		Type:        history.Privmsg,
+		Nick:        "bob!u@localhost",
+		AccountName: "bob",
+		Message:     utils.MakeMessage("old news"),
+		Params:      [1]string{""},
+	}
+	old.Message.Time = time.Now().UTC().Add(-time.Hour)
+	if err := server.channels.Get("#chan").AddHistoryItem(old, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(exists("#chan", old.Message.Msgid), true, t)
+	assertEqual(bob.redact("#chan", old.Message.Msgid), "REDACT_WINDOW_EXPIRED", t)
+	assertEqual(exists("#chan", old.Message.Msgid), true, t)
+	assertEqual(alice.redact("#chan", old.Message.Msgid), "", t)
+	assertEqual(exists("#chan", old.Message.Msgid), false, t)
+}
+
+func TestRedactEphemeral(t *testing.T) {
+	testRedaction(t, nil)
+}
+
+func TestRedactPersistent(t *testing.T) {
+	testRedaction(t, map[string]interface{}{
+		"datastore.sqlite.enabled":                 true,
+		"history.persistent.enabled":               true,
+		"history.persistent.unregistered-channels": true,
+	})
+}
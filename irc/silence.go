@@ -0,0 +1,139 @@
+// Copyright (c) 2026 agent <agent@local>
+// released under the MIT license
+
+package irc
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/ergochat/ergo/irc/utils"
+)
+
+// Server-side ignore lists (SILENCE). An entry is either a nickmask, which is
+// canonicalized in the same way as a channel ban, or `$a:account`, which matches
+// any client logged into that account. For logged-in clients, the list is stored
+// with the account, so it applies to every client of the account (including
+// always-on clients).
+
+const silenceAccountPrefix = "$a:"
+
+// silenceList is a compiled silence list; it is immutable, and a client's
+// list is replaced wholesale whenever it changes.
+type silenceList struct {
+	masks    []string       // canonicalized entries, in the order they were added
+	regexp   *regexp.Regexp // matches the nickmask entries
+	accounts utils.StringSet
+}
+
+func newSilenceList(masks []string) (result *silenceList) {
+	if len(masks) == 0 {
+		return nil
+	}
+	result = &silenceList{
+		masks:    masks,
+		accounts: make(utils.StringSet),
+	}
+	var nickmasks []string
+	for _, mask := range masks {
+		if strings.HasPrefix(mask, silenceAccountPrefix) {
+			result.accounts.Add(strings.TrimPrefix(mask, silenceAccountPrefix))
+		} else {
+			nickmasks = append(nickmasks, mask)
+		}
+	}
+	if len(nickmasks) != 0 {
+		result.regexp, _ = utils.CompileMasks(nickmasks)
+	}
+	return
+}
+
+func (sl *silenceList) match(nickMaskCasefolded, account string) bool {
+	if sl == nil {
+		return false
+	}
+	if account != "" && sl.accounts.Has(account) {
+		return true
+	}
+	return sl.regexp != nil && sl.regexp.MatchString(nickMaskCasefolded)
+}
+
+// canonicalizeSilenceMask validates and casefolds a silence entry.
+func canonicalizeSilenceMask(mask string) (result string, err error) {
+	if strings.HasPrefix(mask, silenceAccountPrefix) {
+		account, err := CasefoldName(strings.TrimPrefix(mask, silenceAccountPrefix))
+		if err != nil {
+			return "", errInvalidParams
+		}
+		return silenceAccountPrefix + account, nil
+	}
+	return CanonicalizeMaskWildcard(mask)
+}
+
+// Silence returns the entries of the client's silence list.
+func (client *Client) Silence() (result []string) {
+	client.stateMutex.RLock()
+	silence := client.silence
+	client.stateMutex.RUnlock()
+	if silence != nil {
+		result = silence.masks
+	}
+	return
+}
+
+func (client *Client) setSilence(silence *silenceList) {
+	client.stateMutex.Lock()
+	client.silence = silence
+	client.stateMutex.Unlock()
+}
+
+// isSilenced returns whether the client is ignoring messages from `sender`.
+func (client *Client) isSilenced(sender *Client) bool {
+	client.stateMutex.RLock()
+	silence := client.silence
+	client.stateMutex.RUnlock()
+	if silence == nil {
+		return false
+	}
+	return silence.match(sender.NickMaskCasefolded(), sender.Account())
+}
+
+// modifySilence adds or removes a (canonicalized) silence entry; `changed`
+// is false if the entry was already present (or already absent).
+func (client *Client) modifySilence(mask string, add bool, limit int) (changed bool, err error) {
+	current := client.Silence()
+	index := -1
+	for i, existing := range current {
+		if existing == mask {
+			index = i
+			break
+		}
+	}
+
+	var masks []string
+	if add {
+		if index != -1 {
+			return false, nil
+		}
+		if len(current) >= limit {
+			return false, errSilenceListFull
+		}
+		masks = make([]string, len(current), len(current)+1)
+		copy(masks, current)
+		masks = append(masks, mask)
+	} else {
+		if index == -1 {
+			return false, nil
+		}
+		masks = make([]string, 0, len(current)-1)
+		masks = append(masks, current[:index]...)
+		masks = append(masks, current[index+1:]...)
+	}
+
+	if account := client.Account(); account != "" {
+		err = client.server.accounts.saveSilence(account, masks)
+	} else {
+		client.setSilence(newSilenceList(masks))
+	}
+	return err == nil, err
+}
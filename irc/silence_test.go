@@ -0,0 +1,43 @@
+// Copyright (c) 2026 agent <agent@local>
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+)
+
+func TestSilenceList(t *testing.T) {
+	var empty *silenceList
+	if empty.match("horse!~evan@tor-network.onion", "evan") {
+		t.Errorf("empty list should not match anything")
+	}
+
+	var masks []string
+	for _, entry := range []string{"Horse", "*@*.example.com", "$a:Evan"} {
+		mask, err := canonicalizeSilenceMask(entry)
+		if err != nil {
+			t.Fatalf("could not canonicalize %s: %v", entry, err)
+		}
+		masks = append(masks, mask)
+	}
+	assertEqual(masks, []string{"horse!*@*", "*!*@*.example.com", "$a:evan"}, t)
+
+	if _, err := canonicalizeSilenceMask("$a:"); err == nil {
+		t.Errorf("empty account entry should be rejected")
+	}
+
+	s := newSilenceList(masks)
+	if !s.match("horse!~u@tor-network.onion", "") {
+		t.Errorf("expected nickmask match failed")
+	}
+	if !s.match("pony!~u@irc.example.com", "") {
+		t.Errorf("expected host match failed")
+	}
+	if !s.match("pony!~u@tor-network.onion", "evan") {
+		t.Errorf("expected account match failed")
+	}
+	if s.match("pony!~u@tor-network.onion", "shivaram") {
+		t.Errorf("unexpected match succeeded")
+	}
+}
@@ -16,12 +16,14 @@ const (
 	LocalDisconnects   Mask = 'd'
 	LocalChannels      Mask = 'j'
 	LocalKills         Mask = 'k'
+	Links              Mask = 'l'
 	LocalNicks         Mask = 'n'
 	LocalOpers         Mask = 'o'
 	LocalQuits         Mask = 'q'
 	Stats              Mask = 't'
 	LocalAccounts      Mask = 'u'
 	LocalVhosts        Mask = 'v'
+	LocalWallops       Mask = 'w'
 	LocalXline         Mask = 'x'
 )
 
@@ -33,6 +35,7 @@ var (
 		LocalDisconnects:   "DISCONNECT",
 		LocalChannels:      "CHANNEL",
 		LocalKills:         "KILL",
+		Links:              "LINK",
 		LocalNicks:         "NICK",
 		LocalOpers:         "OPER",
 		LocalQuits:         "QUIT",
@@ -40,6 +43,7 @@ var (
 		LocalAccounts:      "ACCOUNT",
 		LocalXline:         "XLINE",
 		LocalVhosts:        "VHOST",
+		LocalWallops:       "WALLOPS",
 	}
 
 	// ValidMasks contains the snomasks that we support.
@@ -49,12 +53,14 @@ var (
 		LocalDisconnects,
 		LocalChannels,
 		LocalKills,
+		Links,
 		LocalNicks,
 		LocalOpers,
 		LocalQuits,
 		Stats,
 		LocalAccounts,
 		LocalVhosts,
+		LocalWallops,
 		LocalXline,
 	}
 )
@@ -35,6 +35,21 @@ type Socket struct {
 	sendQExceeded bool
 	finalData     []byte // what to send when we die
 	finalized     bool
+
+	// traffic counters, for STATS l:
+	sentLines     uint64
+	sentBytes     uint64
+	receivedLines uint64
+	receivedBytes uint64
+}
+
+// SocketStats is a snapshot of a socket's sendq and traffic counters.
+type SocketStats struct {
+	SendQBytes    int
+	SentLines     uint64
+	SentBytes     uint64
+	ReceivedLines uint64
+	ReceivedBytes uint64
 }
 
 // NewSocket returns a new Socket.
@@ -67,6 +82,13 @@ func (socket *Socket) Read() (string, error) {
 	lineBytes, err := socket.conn.ReadLine()
 	line := string(lineBytes)
 
+	if len(lineBytes) != 0 {
+		socket.Lock()
+		socket.receivedLines++
+		socket.receivedBytes += uint64(len(lineBytes))
+		socket.Unlock()
+	}
+
 	if err == io.EOF {
 		socket.Close()
 	}
@@ -96,6 +118,8 @@ func (socket *Socket) Write(data []byte) (err error) {
 		} else {
 			socket.buffers = append(socket.buffers, data)
 			socket.totalLength = prospectiveLen
+			socket.sentLines++
+			socket.sentBytes += uint64(len(data))
 		}
 	}
 	socket.Unlock()
@@ -136,6 +160,11 @@ func (socket *Socket) BlockingWrite(data []byte) (err error) {
 		return io.EOF
 	}
 
+	socket.Lock()
+	socket.sentLines++
+	socket.sentBytes += uint64(len(data))
+	socket.Unlock()
+
 	err = socket.conn.WriteLine(data)
 	if err != nil {
 		socket.finalize()
@@ -166,6 +195,19 @@ func (socket *Socket) IsClosed() bool {
 	return socket.closed
 }
 
+// Stats returns the socket's current sendq size and traffic counters.
+func (socket *Socket) Stats() (result SocketStats) {
+	socket.Lock()
+	defer socket.Unlock()
+	return SocketStats{
+		SendQBytes:    socket.totalLength,
+		SentLines:     socket.sentLines,
+		SentBytes:     socket.sentBytes,
+		ReceivedLines: socket.receivedLines,
+		ReceivedBytes: socket.receivedBytes,
+	}
+}
+
 // is there data to write?
 func (socket *Socket) readyToWrite() bool {
 	socket.Lock()
@@ -0,0 +1,11 @@
+//go:build cgo
+// +build cgo
+
+// Copyright (c) 2026 agent <agent@local>
+// released under the MIT license
+
+package sqlite
+
+// Supported is whether this build can open SQLite databases; the driver
+// (github.com/mattn/go-sqlite3) is a cgo wrapper around the C library.
+const Supported = true
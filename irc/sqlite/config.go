@@ -0,0 +1,21 @@
+// Copyright (c) 2026 agent <agent@local>
+// released under the MIT license
+
+package sqlite
+
+import (
+	"time"
+)
+
+type Config struct {
+	// these are intended to be written directly into the config file:
+	Enabled bool
+	// the database file is created if it doesn't exist
+	DatabasePath string `yaml:"database-path"`
+	// also used as the busy timeout, i.e., how long to wait for a write lock
+	Timeout time.Duration
+
+	// XXX these are copied from elsewhere in the config:
+	ExpireTime           time.Duration
+	TrackAccountMessages bool
+}
@@ -0,0 +1,1161 @@
+// Copyright (c) 2026 agent <agent@local>
+// released under the MIT license
+
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ergochat/ergo/irc/history"
+	"github.com/ergochat/ergo/irc/logger"
+	"github.com/ergochat/ergo/irc/metrics"
+	"github.com/ergochat/ergo/irc/utils"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const (
+	// latest schema of the db
+	latestDbSchema   = "1"
+	keySchemaVersion = "db.version"
+	cleanupRowLimit  = 50
+	cleanupPauseTime = 10 * time.Minute
+)
+
+type e struct{}
+
+// SQLite is a persistent history backend stored in a single local file;
+// it uses the same tables and queries as the MySQL backend, adapted to
+// SQLite's dialect.
+type SQLite struct {
+	timeout              int64
+	trackAccountMessages uint32
+	db                   *sql.DB
+	logger               *logger.Manager
+
+	insertHistory        *sql.Stmt
+	insertSequence       *sql.Stmt
+	insertConversation   *sql.Stmt
+	insertCorrespondent  *sql.Stmt
+	insertAccountMessage *sql.Stmt
+
+	stateMutex sync.Mutex
+	config     Config
+
+	wakeForgetter chan e
+
+	// latency of the user-facing operations, by operation name
+	queryLatency *metrics.HistogramVec
+}
+
+func (sqlite *SQLite) Initialize(logger *logger.Manager, config Config) {
+	sqlite.logger = logger
+	sqlite.queryLatency = metrics.NewHistogramVec(metrics.DefaultLatencyBuckets, "operation")
+	sqlite.wakeForgetter = make(chan e, 1)
+	sqlite.SetConfig(config)
+}
+
+func (sqlite *SQLite) SetConfig(config Config) {
+	atomic.StoreInt64(&sqlite.timeout, int64(config.Timeout))
+	var trackAccountMessages uint32
+	if config.TrackAccountMessages {
+		trackAccountMessages = 1
+	}
+	atomic.StoreUint32(&sqlite.trackAccountMessages, trackAccountMessages)
+	sqlite.stateMutex.Lock()
+	sqlite.config = config
+	sqlite.stateMutex.Unlock()
+}
+
+// QueryLatency returns the latency histograms for database operations.
+func (sqlite *SQLite) QueryLatency() *metrics.HistogramVec {
+	return sqlite.queryLatency
+}
+
+func (sqlite *SQLite) observeLatency(operation string, start time.Time) {
+	sqlite.queryLatency.With(operation).ObserveSince(start)
+}
+
+func (sqlite *SQLite) getExpireTime() (expireTime time.Duration) {
+	sqlite.stateMutex.Lock()
+	expireTime = sqlite.config.ExpireTime
+	sqlite.stateMutex.Unlock()
+	return
+}
+
+func (config *Config) dsn() string {
+	// WAL mode lets readers proceed concurrently with the (single) writer;
+	// with WAL, synchronous=NORMAL is durable except against power loss
+	return fmt.Sprintf("%s?_busy_timeout=%d&_journal_mode=WAL&_synchronous=NORMAL",
+		config.DatabasePath, config.Timeout.Milliseconds())
+}
+
+func (sqlite *SQLite) Open() (err error) {
+	sqlite.db, err = sql.Open("sqlite3", sqlite.config.dsn())
+	if err != nil {
+		return err
+	}
+
+	err = sqlite.fixSchemas()
+	if err != nil {
+		return err
+	}
+
+	err = sqlite.prepareStatements()
+	if err != nil {
+		return err
+	}
+
+	go sqlite.cleanupLoop()
+	go sqlite.forgetLoop()
+
+	return nil
+}
+
+func (sqlite *SQLite) fixSchemas() (err error) {
+	_, err = sqlite.db.Exec(`CREATE TABLE IF NOT EXISTS metadata (
+		key_name TEXT PRIMARY KEY,
+		value TEXT NOT NULL
+	);`)
+	if err != nil {
+		return err
+	}
+
+	var schema string
+	err = sqlite.db.QueryRow(`SELECT value FROM metadata WHERE key_name = ?;`, keySchemaVersion).Scan(&schema)
+	if err == sql.ErrNoRows {
+		return sqlite.createTables()
+	} else if err == nil && schema != latestDbSchema {
+		// TODO figure out what to do about schema changes
+		return fmt.Errorf("incompatible schema: got %s, expected %s", schema, latestDbSchema)
+	}
+	return err
+}
+
+func (sqlite *SQLite) createTables() (err error) {
+	tx, err := sqlite.db.Begin()
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	// AUTOINCREMENT ensures that ids are never reused, even after the latest
+	// rows are deleted; Export relies on this for pagination
+	statements := []string{
+		`CREATE TABLE history (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			data BLOB NOT NULL,
+			msgid BLOB NOT NULL
+		);`,
+		`CREATE INDEX history_msgid ON history (msgid);`,
+
+		`CREATE TABLE sequence (
+			history_id INTEGER PRIMARY KEY,
+			target TEXT NOT NULL,
+			nanotime INTEGER NOT NULL
+		);`,
+		`CREATE INDEX sequence_target_nanotime ON sequence (target, nanotime);`,
+
+		`CREATE TABLE conversations (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			target TEXT NOT NULL,
+			correspondent TEXT NOT NULL,
+			nanotime INTEGER NOT NULL,
+			history_id INTEGER NOT NULL
+		);`,
+		`CREATE INDEX conversations_target_correspondent_nanotime ON conversations (target, correspondent, nanotime);`,
+		`CREATE INDEX conversations_history_id ON conversations (history_id);`,
+
+		`CREATE TABLE correspondents (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			target TEXT NOT NULL,
+			correspondent TEXT NOT NULL,
+			nanotime INTEGER NOT NULL,
+			UNIQUE (target, correspondent)
+		);`,
+		`CREATE INDEX correspondents_target_nanotime ON correspondents (target, nanotime);`,
+		`CREATE INDEX correspondents_nanotime ON correspondents (nanotime);`,
+
+		`CREATE TABLE account_messages (
+			history_id INTEGER PRIMARY KEY,
+			account TEXT NOT NULL
+		);`,
+		`CREATE INDEX account_messages_account_history_id ON account_messages (account, history_id);`,
+
+		`CREATE TABLE forget (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			account TEXT NOT NULL
+		);`,
+	}
+	for _, statement := range statements {
+		_, err = tx.Exec(statement)
+		if err != nil {
+			return
+		}
+	}
+
+	_, err = tx.Exec(`INSERT INTO metadata (key_name, value) VALUES (?, ?);`, keySchemaVersion, latestDbSchema)
+	if err != nil {
+		return
+	}
+	return tx.Commit()
+}
+
+func (sqlite *SQLite) cleanupLoop() {
+	defer func() {
+		if r := recover(); r != nil {
+			sqlite.logger.Error("sqlite",
+				fmt.Sprintf("Panic in cleanup routine: %v\n%s", r, debug.Stack()))
+			time.Sleep(cleanupPauseTime)
+			go sqlite.cleanupLoop()
+		}
+	}()
+
+	for {
+		expireTime := sqlite.getExpireTime()
+		if expireTime != 0 {
+			for {
+				startTime := time.Now()
+				rowsDeleted, err := sqlite.doCleanup(expireTime)
+				elapsed := time.Now().Sub(startTime)
+				sqlite.logError("error during row cleanup", err)
+				// keep going as long as we're accomplishing significant work
+				// (don't busy-wait on small numbers of rows expiring):
+				if rowsDeleted < (cleanupRowLimit / 10) {
+					break
+				}
+				// crude backpressure mechanism: if the database is slow,
+				// give it time to process other queries
+				time.Sleep(elapsed)
+			}
+		}
+		time.Sleep(cleanupPauseTime)
+	}
+}
+
+func (sqlite *SQLite) doCleanup(age time.Duration) (count int, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupPauseTime)
+	defer cancel()
+
+	ids, maxNanotime, err := sqlite.selectCleanupIDs(ctx, age)
+	if len(ids) == 0 {
+		sqlite.logger.Debug("sqlite", "found no rows to clean up")
+		return
+	}
+
+	sqlite.logger.Debug("sqlite", fmt.Sprintf("deleting %d history rows, max age %s", len(ids), utils.NanoToTimestamp(maxNanotime)))
+
+	if maxNanotime != 0 {
+		sqlite.deleteCorrespondents(ctx, maxNanotime)
+	}
+
+	return len(ids), sqlite.deleteHistoryIDs(ctx, ids)
+}
+
+func (sqlite *SQLite) deleteHistoryIDs(ctx context.Context, ids []uint64) (err error) {
+	// can't use ? binding for a variable number of arguments, build the IN clause manually
+	var inBuf strings.Builder
+	inBuf.WriteByte('(')
+	for i, id := range ids {
+		if i != 0 {
+			inBuf.WriteRune(',')
+		}
+		fmt.Fprintf(&inBuf, "%d", id)
+	}
+	inBuf.WriteRune(')')
+	inClause := inBuf.String()
+
+	_, err = sqlite.db.ExecContext(ctx, fmt.Sprintf(`DELETE FROM conversations WHERE history_id IN %s;`, inClause))
+	if err != nil {
+		return
+	}
+	_, err = sqlite.db.ExecContext(ctx, fmt.Sprintf(`DELETE FROM sequence WHERE history_id IN %s;`, inClause))
+	if err != nil {
+		return
+	}
+	if sqlite.isTrackingAccountMessages() {
+		_, err = sqlite.db.ExecContext(ctx, fmt.Sprintf(`DELETE FROM account_messages WHERE history_id IN %s;`, inClause))
+		if err != nil {
+			return
+		}
+	}
+	_, err = sqlite.db.ExecContext(ctx, fmt.Sprintf(`DELETE FROM history WHERE id IN %s;`, inClause))
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func (sqlite *SQLite) selectCleanupIDs(ctx context.Context, age time.Duration) (ids []uint64, maxNanotime int64, err error) {
+	rows, err := sqlite.db.QueryContext(ctx, `
+		SELECT history.id, sequence.nanotime, conversations.nanotime
+		FROM history
+		LEFT JOIN sequence ON history.id = sequence.history_id
+		LEFT JOIN conversations ON history.id = conversations.history_id
+		ORDER BY history.id LIMIT ?;`, cleanupRowLimit)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	idset := make(map[uint64]struct{}, cleanupRowLimit)
+	threshold := time.Now().Add(-age).UnixNano()
+	for rows.Next() {
+		var id uint64
+		var seqNano, convNano sql.NullInt64
+		err = rows.Scan(&id, &seqNano, &convNano)
+		if err != nil {
+			return
+		}
+		nanotime := extractNanotime(seqNano, convNano)
+		// returns 0 if not found; in that case the data is inconsistent
+		// and we should delete the entry
+		if nanotime < threshold {
+			idset[id] = struct{}{}
+			if nanotime > maxNanotime {
+				maxNanotime = nanotime
+			}
+		}
+	}
+	ids = make([]uint64, len(idset))
+	i := 0
+	for id := range idset {
+		ids[i] = id
+		i++
+	}
+	return
+}
+
+func (sqlite *SQLite) deleteCorrespondents(ctx context.Context, threshold int64) {
+	result, err := sqlite.db.ExecContext(ctx, `DELETE FROM correspondents WHERE nanotime <= ?;`, threshold)
+	if err != nil {
+		sqlite.logError("error deleting correspondents", err)
+	} else {
+		count, err := result.RowsAffected()
+		if !sqlite.logError("error deleting correspondents", err) {
+			sqlite.logger.Debug("sqlite", fmt.Sprintf("deleted %d correspondents entries", count))
+		}
+	}
+}
+
+// wait for forget queue items and process them one by one
+func (sqlite *SQLite) forgetLoop() {
+	defer func() {
+		if r := recover(); r != nil {
+			sqlite.logger.Error("sqlite",
+				fmt.Sprintf("Panic in forget routine: %v\n%s", r, debug.Stack()))
+			time.Sleep(cleanupPauseTime)
+			go sqlite.forgetLoop()
+		}
+	}()
+
+	for {
+		for {
+			found, err := sqlite.doForget()
+			sqlite.logError("error processing forget", err)
+			if err != nil {
+				time.Sleep(cleanupPauseTime)
+			}
+			if !found {
+				break
+			}
+		}
+
+		<-sqlite.wakeForgetter
+	}
+}
+
+// dequeue an item from the forget queue and process it
+func (sqlite *SQLite) doForget() (found bool, err error) {
+	id, account, err := func() (id int64, account string, err error) {
+		ctx, cancel := context.WithTimeout(context.Background(), cleanupPauseTime)
+		defer cancel()
+
+		row := sqlite.db.QueryRowContext(ctx,
+			`SELECT forget.id, forget.account FROM forget LIMIT 1;`)
+		err = row.Scan(&id, &account)
+		if err == sql.ErrNoRows {
+			return 0, "", nil
+		}
+		return
+	}()
+
+	if err != nil || account == "" {
+		return false, err
+	}
+
+	found = true
+
+	var count int
+	for {
+		start := time.Now()
+		count, err = sqlite.doForgetIteration(account)
+		elapsed := time.Since(start)
+		if err != nil {
+			return true, err
+		}
+		if count == 0 {
+			break
+		}
+		time.Sleep(elapsed)
+	}
+
+	sqlite.logger.Debug("sqlite", "forget complete for account", account)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupPauseTime)
+	defer cancel()
+	_, err = sqlite.db.ExecContext(ctx, `DELETE FROM forget WHERE id = ?;`, id)
+	return
+}
+
+func (sqlite *SQLite) doForgetIteration(account string) (count int, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupPauseTime)
+	defer cancel()
+
+	rows, err := sqlite.db.QueryContext(ctx, `
+		SELECT account_messages.history_id
+		FROM account_messages
+		WHERE account_messages.account = ?
+		LIMIT ?;`, account, cleanupRowLimit)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	var ids []uint64
+	for rows.Next() {
+		var id uint64
+		err = rows.Scan(&id)
+		if err != nil {
+			return
+		}
+		ids = append(ids, id)
+	}
+
+	if len(ids) == 0 {
+		return
+	}
+
+	sqlite.logger.Debug("sqlite", fmt.Sprintf("deleting %d history rows from account %s", len(ids), account))
+	err = sqlite.deleteHistoryIDs(ctx, ids)
+	return len(ids), err
+}
+
+func (sqlite *SQLite) prepareStatements() (err error) {
+	sqlite.insertHistory, err = sqlite.db.Prepare(`INSERT INTO history
+		(data, msgid) VALUES (?, ?);`)
+	if err != nil {
+		return
+	}
+	sqlite.insertSequence, err = sqlite.db.Prepare(`INSERT INTO sequence
+		(target, nanotime, history_id) VALUES (?, ?, ?);`)
+	if err != nil {
+		return
+	}
+	sqlite.insertConversation, err = sqlite.db.Prepare(`INSERT INTO conversations
+		(target, correspondent, nanotime, history_id) VALUES (?, ?, ?, ?);`)
+	if err != nil {
+		return
+	}
+	sqlite.insertCorrespondent, err = sqlite.db.Prepare(`INSERT INTO correspondents
+		(target, correspondent, nanotime) VALUES (?, ?, ?)
+		ON CONFLICT (target, correspondent)
+		DO UPDATE SET nanotime = MAX(correspondents.nanotime, excluded.nanotime);`)
+	if err != nil {
+		return
+	}
+	// OR IGNORE: an imported DM may be seen once from each participant's buffer
+	sqlite.insertAccountMessage, err = sqlite.db.Prepare(`INSERT OR IGNORE INTO account_messages
+		(history_id, account) VALUES (?, ?);`)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func (sqlite *SQLite) getTimeout() time.Duration {
+	return time.Duration(atomic.LoadInt64(&sqlite.timeout))
+}
+
+func (sqlite *SQLite) isTrackingAccountMessages() bool {
+	return atomic.LoadUint32(&sqlite.trackAccountMessages) != 0
+}
+
+func (sqlite *SQLite) logError(context string, err error) (quit bool) {
+	if err != nil {
+		sqlite.logger.Error("sqlite", context, err.Error())
+		return true
+	}
+	return false
+}
+
+func (sqlite *SQLite) Forget(account string) {
+	if sqlite.db == nil || account == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sqlite.getTimeout())
+	defer cancel()
+	defer sqlite.observeLatency("forget", time.Now())
+
+	_, err := sqlite.db.ExecContext(ctx, `INSERT INTO forget (account) VALUES (?);`, account)
+	if sqlite.logError("can't insert into forget table", err) {
+		return
+	}
+
+	// wake up the forget goroutine if it's blocked:
+	select {
+	case sqlite.wakeForgetter <- e{}:
+	default:
+	}
+}
+
+func (sqlite *SQLite) AddChannelItem(target string, item history.Item, account string) (err error) {
+	if sqlite.db == nil {
+		return
+	}
+
+	if target == "" {
+		return utils.ErrInvalidParams
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sqlite.getTimeout())
+	defer cancel()
+	defer sqlite.observeLatency("add_channel_item", time.Now())
+
+	id, err := sqlite.insertBase(ctx, item)
+	if err != nil {
+		return
+	}
+
+	return sqlite.addChannelEntries(ctx, target, item, id, account)
+}
+
+func (sqlite *SQLite) addChannelEntries(ctx context.Context, target string, item history.Item, id int64, account string) (err error) {
+	err = sqlite.insertSequenceEntry(ctx, target, item.Message.Time.UnixNano(), id)
+	if err != nil {
+		return
+	}
+
+	err = sqlite.insertAccountMessageEntry(ctx, id, account)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func (sqlite *SQLite) insertSequenceEntry(ctx context.Context, target string, messageTime int64, id int64) (err error) {
+	_, err = sqlite.insertSequence.ExecContext(ctx, target, messageTime, id)
+	sqlite.logError("could not insert sequence entry", err)
+	return
+}
+
+func (sqlite *SQLite) insertConversationEntry(ctx context.Context, target, correspondent string, messageTime int64, id int64) (err error) {
+	_, err = sqlite.insertConversation.ExecContext(ctx, target, correspondent, messageTime, id)
+	sqlite.logError("could not insert conversations entry", err)
+	return
+}
+
+func (sqlite *SQLite) insertCorrespondentsEntry(ctx context.Context, target, correspondent string, messageTime int64) (err error) {
+	_, err = sqlite.insertCorrespondent.ExecContext(ctx, target, correspondent, messageTime)
+	sqlite.logError("could not insert correspondents entry", err)
+	return
+}
+
+func (sqlite *SQLite) insertBase(ctx context.Context, item history.Item) (id int64, err error) {
+	value, err := marshalItem(&item)
+	if sqlite.logError("could not marshal item", err) {
+		return
+	}
+
+	msgidBytes, err := decodeMsgid(item.Message.Msgid)
+	if sqlite.logError("could not decode msgid", err) {
+		return
+	}
+
+	result, err := sqlite.insertHistory.ExecContext(ctx, value, msgidBytes)
+	if sqlite.logError("could not insert item", err) {
+		return
+	}
+	id, err = result.LastInsertId()
+	if sqlite.logError("could not insert item", err) {
+		return
+	}
+
+	return
+}
+
+func (sqlite *SQLite) insertAccountMessageEntry(ctx context.Context, id int64, account string) (err error) {
+	if account == "" || !sqlite.isTrackingAccountMessages() {
+		return
+	}
+	_, err = sqlite.insertAccountMessage.ExecContext(ctx, id, account)
+	sqlite.logError("could not insert account-message entry", err)
+	return
+}
+
+func (sqlite *SQLite) AddDirectMessage(sender, senderAccount, recipient, recipientAccount string, item history.Item) (err error) {
+	if sqlite.db == nil {
+		return
+	}
+
+	if senderAccount == "" && recipientAccount == "" {
+		return
+	}
+
+	if sender == "" || recipient == "" {
+		return utils.ErrInvalidParams
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sqlite.getTimeout())
+	defer cancel()
+	defer sqlite.observeLatency("add_direct_message", time.Now())
+
+	id, err := sqlite.insertBase(ctx, item)
+	if err != nil {
+		return
+	}
+
+	nanotime := item.Message.Time.UnixNano()
+
+	if senderAccount != "" {
+		err = sqlite.addConversationEntries(ctx, senderAccount, recipient, nanotime, id)
+		if err != nil {
+			return
+		}
+	}
+
+	if recipientAccount != "" && sender != recipient {
+		err = sqlite.addConversationEntries(ctx, recipientAccount, sender, nanotime, id)
+		if err != nil {
+			return
+		}
+	}
+
+	err = sqlite.insertAccountMessageEntry(ctx, id, senderAccount)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func (sqlite *SQLite) addConversationEntries(ctx context.Context, target, correspondent string, nanotime int64, id int64) (err error) {
+	err = sqlite.insertConversationEntry(ctx, target, correspondent, nanotime, id)
+	if err != nil {
+		return
+	}
+	return sqlite.insertCorrespondentsEntry(ctx, target, correspondent, nanotime)
+}
+
+// lookupOrInsertBase returns the id of the stored message with the item's msgid,
+// inserting the item if there is none.
+func (sqlite *SQLite) lookupOrInsertBase(ctx context.Context, item history.Item) (id int64, inserted bool, err error) {
+	if item.Message.Msgid == "" {
+		id, err = sqlite.insertBase(ctx, item)
+		return id, err == nil, err
+	}
+	msgidBytes, err := decodeMsgid(item.Message.Msgid)
+	if sqlite.logError("could not decode msgid", err) {
+		return
+	}
+	err = sqlite.db.QueryRowContext(ctx,
+		`SELECT history.id FROM history WHERE history.msgid = ? LIMIT 1;`, msgidBytes).Scan(&id)
+	if err == nil {
+		return id, false, nil
+	} else if err != sql.ErrNoRows {
+		sqlite.logError("could not look up msgid", err)
+		return
+	}
+	id, err = sqlite.insertBase(ctx, item)
+	return id, err == nil, err
+}
+
+// ImportChannelItem stores a message from an in-memory channel history buffer;
+// it does nothing if a message with the same msgid is already stored.
+func (sqlite *SQLite) ImportChannelItem(target string, item history.Item, account string) (err error) {
+	if sqlite.db == nil {
+		return
+	}
+
+	if target == "" {
+		return utils.ErrInvalidParams
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sqlite.getTimeout())
+	defer cancel()
+
+	id, inserted, err := sqlite.lookupOrInsertBase(ctx, item)
+	if err != nil || !inserted {
+		return
+	}
+
+	return sqlite.addChannelEntries(ctx, target, item, id, account)
+}
+
+// ImportDirectMessage stores a message from the in-memory history buffer of a client
+// logged into the account `target`; correspondent is the casefolded nickname of the
+// other participant, and senderAccount is the casefolded account of the sender, if any.
+// A message imported from both participants' buffers is stored only once.
+func (sqlite *SQLite) ImportDirectMessage(target, correspondent string, item history.Item, senderAccount string) (err error) {
+	if sqlite.db == nil {
+		return
+	}
+
+	if target == "" || correspondent == "" {
+		return utils.ErrInvalidParams
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sqlite.getTimeout())
+	defer cancel()
+
+	id, _, err := sqlite.lookupOrInsertBase(ctx, item)
+	if err != nil {
+		return
+	}
+
+	err = sqlite.addConversationEntries(ctx, target, correspondent, item.Message.Time.UnixNano(), id)
+	if err != nil {
+		return
+	}
+
+	return sqlite.insertAccountMessageEntry(ctx, id, senderAccount)
+}
+
+// note that accountName is the unfolded name; if notBefore is nonzero,
+// messages sent before it cannot be deleted
+func (sqlite *SQLite) DeleteMsgid(msgid, accountName string, notBefore time.Time) (err error) {
+	if sqlite.db == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sqlite.getTimeout())
+	defer cancel()
+	defer sqlite.observeLatency("delete_msgid", time.Now())
+
+	msgtime, id, data, err := sqlite.lookupMsgid(ctx, msgid, true)
+	if err != nil {
+		return
+	}
+
+	if accountName != "*" {
+		var item history.Item
+		err = unmarshalItem(data, &item)
+		// delete if the entry is corrupt
+		if err == nil && item.AccountName != accountName {
+			return history.ErrDisallowed
+		}
+	}
+
+	if msgtime.Before(notBefore) {
+		return history.ErrExpired
+	}
+
+	err = sqlite.deleteHistoryIDs(ctx, []uint64{id})
+	sqlite.logError("couldn't delete msgid", err)
+	return
+}
+
+// LookupMsgid returns the history item with the given msgid, and its targets.
+func (sqlite *SQLite) LookupMsgid(msgid string) (item history.Item, targets []string, err error) {
+	if sqlite.db == nil {
+		err = sql.ErrNoRows
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sqlite.getTimeout())
+	defer cancel()
+	defer sqlite.observeLatency("lookup_msgid", time.Now())
+
+	_, id, data, err := sqlite.lookupMsgid(ctx, msgid, true)
+	if err != nil {
+		return
+	}
+	err = unmarshalItem(data, &item)
+	if err != nil {
+		return
+	}
+	targets, err = sqlite.lookupTargets(ctx, id)
+	return
+}
+
+// lookupTargets returns the channel a message was sent to (from sequence),
+// or the accounts with a copy of a direct message (from conversations).
+func (sqlite *SQLite) lookupTargets(ctx context.Context, id uint64) (targets []string, err error) {
+	rows, err := sqlite.db.QueryContext(ctx, `
+		SELECT target FROM sequence WHERE history_id = ?
+		UNION ALL
+		SELECT target FROM conversations WHERE history_id = ?;`, id, id)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var target string
+		if err = rows.Scan(&target); err != nil {
+			return
+		}
+		targets = append(targets, target)
+	}
+	err = rows.Err()
+	return
+}
+
+func (sqlite *SQLite) Export(account string, writer io.Writer) {
+	if sqlite.db == nil {
+		return
+	}
+
+	var err error
+	var lastSeen uint64
+	for {
+		rows := func() (count int) {
+			ctx, cancel := context.WithTimeout(context.Background(), cleanupPauseTime)
+			defer cancel()
+
+			rows, rowsErr := sqlite.db.QueryContext(ctx, `
+				SELECT account_messages.history_id, history.data, sequence.target FROM account_messages
+				INNER JOIN history ON history.id = account_messages.history_id
+				INNER JOIN sequence ON account_messages.history_id = sequence.history_id
+				WHERE account_messages.account = ? AND account_messages.history_id > ?
+				ORDER BY account_messages.history_id
+				LIMIT ?`, account, lastSeen, cleanupRowLimit)
+			if rowsErr != nil {
+				err = rowsErr
+				return
+			}
+			defer rows.Close()
+			for rows.Next() {
+				var id uint64
+				var blob, jsonBlob []byte
+				var target string
+				var item history.Item
+				err = rows.Scan(&id, &blob, &target)
+				if err != nil {
+					return
+				}
+				err = unmarshalItem(blob, &item)
+				if err != nil {
+					return
+				}
+				item.CfCorrespondent = target
+				jsonBlob, err = json.Marshal(item)
+				if err != nil {
+					return
+				}
+				count++
+				if lastSeen < id {
+					lastSeen = id
+				}
+				writer.Write(jsonBlob)
+				writer.Write([]byte{'\n'})
+			}
+			return
+		}()
+		if rows == 0 || err != nil {
+			break
+		}
+	}
+
+	sqlite.logError("could not export history", err)
+	return
+}
+
+func (sqlite *SQLite) lookupMsgid(ctx context.Context, msgid string, includeData bool) (result time.Time, id uint64, data []byte, err error) {
+	decoded, err := decodeMsgid(msgid)
+	if err != nil {
+		return
+	}
+	cols := `sequence.nanotime, conversations.nanotime`
+	if includeData {
+		cols = `sequence.nanotime, conversations.nanotime, history.id, history.data`
+	}
+	row := sqlite.db.QueryRowContext(ctx, fmt.Sprintf(`
+		SELECT %s FROM history
+		LEFT JOIN sequence ON history.id = sequence.history_id
+		LEFT JOIN conversations ON history.id = conversations.history_id
+		WHERE history.msgid = ? LIMIT 1;`, cols), decoded)
+	var nanoSeq, nanoConv sql.NullInt64
+	if !includeData {
+		err = row.Scan(&nanoSeq, &nanoConv)
+	} else {
+		err = row.Scan(&nanoSeq, &nanoConv, &id, &data)
+	}
+	if err != sql.ErrNoRows {
+		sqlite.logError("could not resolve msgid to time", err)
+	}
+	if err != nil {
+		return
+	}
+	nanotime := extractNanotime(nanoSeq, nanoConv)
+	if nanotime == 0 {
+		err = sql.ErrNoRows
+		return
+	}
+	result = time.Unix(0, nanotime).UTC()
+	return
+}
+
+func extractNanotime(seq, conv sql.NullInt64) (result int64) {
+	if seq.Valid {
+		return seq.Int64
+	} else if conv.Valid {
+		return conv.Int64
+	}
+	return
+}
+
+func (sqlite *SQLite) selectItems(ctx context.Context, query string, args ...interface{}) (results []history.Item, err error) {
+	rows, err := sqlite.db.QueryContext(ctx, query, args...)
+	if sqlite.logError("could not select history items", err) {
+		return
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var blob []byte
+		var item history.Item
+		err = rows.Scan(&blob)
+		if sqlite.logError("could not scan history item", err) {
+			return
+		}
+		err = unmarshalItem(blob, &item)
+		if sqlite.logError("could not unmarshal history item", err) {
+			return
+		}
+		results = append(results, item)
+	}
+	return
+}
+
+func (sqlite *SQLite) betweenTimestamps(ctx context.Context, target, correspondent string, after, before, cutoff time.Time, limit int) (results []history.Item, err error) {
+	useSequence := correspondent == ""
+	table := "sequence"
+	if !useSequence {
+		table = "conversations"
+	}
+
+	after, before, ascending := history.MinMaxAsc(after, before, cutoff)
+	direction := "ASC"
+	if !ascending {
+		direction = "DESC"
+	}
+
+	var queryBuf strings.Builder
+
+	args := make([]interface{}, 0, 6)
+	fmt.Fprintf(&queryBuf,
+		"SELECT history.data FROM history INNER JOIN %[1]s ON history.id = %[1]s.history_id WHERE", table)
+	if useSequence {
+		fmt.Fprintf(&queryBuf, " sequence.target = ?")
+		args = append(args, target)
+	} else {
+		fmt.Fprintf(&queryBuf, " conversations.target = ? AND conversations.correspondent = ?")
+		args = append(args, target)
+		args = append(args, correspondent)
+	}
+	if !after.IsZero() {
+		fmt.Fprintf(&queryBuf, " AND %s.nanotime > ?", table)
+		args = append(args, after.UnixNano())
+	}
+	if !before.IsZero() {
+		fmt.Fprintf(&queryBuf, " AND %s.nanotime < ?", table)
+		args = append(args, before.UnixNano())
+	}
+	fmt.Fprintf(&queryBuf, " ORDER BY %[1]s.nanotime %[2]s LIMIT ?;", table, direction)
+	args = append(args, limit)
+
+	results, err = sqlite.selectItems(ctx, queryBuf.String(), args...)
+	if err == nil && !ascending {
+		history.Reverse(results)
+	}
+	return
+}
+
+func (sqlite *SQLite) listCorrespondentsInternal(ctx context.Context, target string, after, before, cutoff time.Time, limit int) (results []history.TargetListing, err error) {
+	after, before, ascending := history.MinMaxAsc(after, before, cutoff)
+	direction := "ASC"
+	if !ascending {
+		direction = "DESC"
+	}
+
+	var queryBuf strings.Builder
+	args := make([]interface{}, 0, 4)
+	queryBuf.WriteString(`SELECT correspondents.correspondent, correspondents.nanotime from correspondents
+		WHERE target = ?`)
+	args = append(args, target)
+	if !after.IsZero() {
+		queryBuf.WriteString(" AND correspondents.nanotime > ?")
+		args = append(args, after.UnixNano())
+	}
+	if !before.IsZero() {
+		queryBuf.WriteString(" AND correspondents.nanotime < ?")
+		args = append(args, before.UnixNano())
+	}
+	fmt.Fprintf(&queryBuf, " ORDER BY correspondents.nanotime %s LIMIT ?;", direction)
+	args = append(args, limit)
+	query := queryBuf.String()
+
+	rows, err := sqlite.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	var correspondent string
+	var nanotime int64
+	for rows.Next() {
+		err = rows.Scan(&correspondent, &nanotime)
+		if err != nil {
+			return
+		}
+		results = append(results, history.TargetListing{
+			CfName: correspondent,
+			Time:   time.Unix(0, nanotime),
+		})
+	}
+
+	if !ascending {
+		history.ReverseCorrespondents(results)
+	}
+
+	return
+}
+
+func (sqlite *SQLite) ListChannels(cfchannels []string) (results []history.TargetListing, err error) {
+	if sqlite.db == nil {
+		return
+	}
+
+	if len(cfchannels) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sqlite.getTimeout())
+	defer cancel()
+	defer sqlite.observeLatency("list_channels", time.Now())
+
+	var queryBuf strings.Builder
+	args := make([]interface{}, 0, len(cfchannels))
+	queryBuf.WriteString(`SELECT sequence.target, MAX(sequence.nanotime) FROM sequence
+		WHERE sequence.target IN (`)
+	for i, chname := range cfchannels {
+		if i != 0 {
+			queryBuf.WriteString(", ")
+		}
+		queryBuf.WriteByte('?')
+		args = append(args, chname)
+	}
+	queryBuf.WriteString(") GROUP BY sequence.target;")
+
+	rows, err := sqlite.db.QueryContext(ctx, queryBuf.String(), args...)
+	if sqlite.logError("could not query channel listings", err) {
+		return
+	}
+	defer rows.Close()
+
+	var target string
+	var nanotime int64
+	for rows.Next() {
+		err = rows.Scan(&target, &nanotime)
+		if sqlite.logError("could not scan channel listings", err) {
+			return
+		}
+		results = append(results, history.TargetListing{
+			CfName: target,
+			Time:   time.Unix(0, nanotime),
+		})
+	}
+	return
+}
+
+func (sqlite *SQLite) Close() {
+	// closing the database will close our prepared statements as well
+	if sqlite.db != nil {
+		sqlite.db.Close()
+	}
+	sqlite.db = nil
+}
+
+// implements history.Sequence, emulating a single history buffer (for a channel,
+// a single user's DMs, or a DM conversation)
+type sqliteHistorySequence struct {
+	sqlite        *SQLite
+	target        string
+	correspondent string
+	cutoff        time.Time
+}
+
+func (s *sqliteHistorySequence) Between(start, end history.Selector, limit int) (results []history.Item, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.sqlite.getTimeout())
+	defer cancel()
+	defer s.sqlite.observeLatency("between", time.Now())
+
+	startTime := start.Time
+	if start.Msgid != "" {
+		startTime, _, _, err = s.sqlite.lookupMsgid(ctx, start.Msgid, false)
+		if err != nil {
+			return nil, err
+		}
+	}
+	endTime := end.Time
+	if end.Msgid != "" {
+		endTime, _, _, err = s.sqlite.lookupMsgid(ctx, end.Msgid, false)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	results, err = s.sqlite.betweenTimestamps(ctx, s.target, s.correspondent, startTime, endTime, s.cutoff, limit)
+	return results, err
+}
+
+func (s *sqliteHistorySequence) Around(start history.Selector, limit int) (results []history.Item, err error) {
+	return history.GenericAround(s, start, limit)
+}
+
+func (seq *sqliteHistorySequence) ListCorrespondents(start, end history.Selector, limit int) (results []history.TargetListing, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), seq.sqlite.getTimeout())
+	defer cancel()
+	defer seq.sqlite.observeLatency("list_correspondents", time.Now())
+
+	// TODO accept msgids here?
+	startTime := start.Time
+	endTime := end.Time
+
+	results, err = seq.sqlite.listCorrespondentsInternal(ctx, seq.target, startTime, endTime, seq.cutoff, limit)
+	seq.sqlite.logError("could not read correspondents", err)
+	return
+}
+
+func (seq *sqliteHistorySequence) Cutoff() time.Time {
+	return seq.cutoff
+}
+
+func (seq *sqliteHistorySequence) Ephemeral() bool {
+	return false
+}
+
+func (sqlite *SQLite) MakeSequence(target, correspondent string, cutoff time.Time) history.Sequence {
+	return &sqliteHistorySequence{
+		target:        target,
+		correspondent: correspondent,
+		sqlite:        sqlite,
+		cutoff:        cutoff,
+	}
+}
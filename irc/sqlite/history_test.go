@@ -0,0 +1,230 @@
+// Copyright (c) 2026 agent <agent@local>
+// released under the MIT license
+
+package sqlite
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ergochat/ergo/irc/history"
+	"github.com/ergochat/ergo/irc/logger"
+	"github.com/ergochat/ergo/irc/utils"
+)
+
+func assertEqual(supplied, expected interface{}, t *testing.T) {
+	t.Helper()
+	if !reflect.DeepEqual(supplied, expected) {
+		t.Errorf("expected %v but got %v", expected, supplied)
+	}
+}
+
+func openTestDB(t *testing.T) *SQLite {
+	t.Helper()
+	if !Supported {
+		t.Skip("SQLite requires cgo")
+	}
+	logger, err := logger.NewManager(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := new(SQLite)
+	db.Initialize(logger, Config{
+		Enabled:              true,
+		DatabasePath:         filepath.Join(t.TempDir(), "history.db"),
+		Timeout:              3 * time.Second,
+		TrackAccountMessages: true,
+	})
+	if err := db.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.Close)
+	return db
+}
+
+var baseTime = time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+
+func makeItem(nick, account, message string, minutes int) (item history.Item) {
+	item.Type = history.Privmsg
+	item.Nick = nick + "!u@h"
+	item.AccountName = account
+	item.Message = utils.MakeMessage(message)
+	item.Message.Time = baseTime.Add(time.Duration(minutes) * time.Minute)
+	return
+}
+
+func toMessages(items []history.Item) (result []string) {
+	result = make([]string, len(items))
+	for i, item := range items {
+		result[i] = item.Message.Message
+	}
+	return
+}
+
+func TestChannelHistory(t *testing.T) {
+	db := openTestDB(t)
+
+	var items []history.Item
+	for i, message := range []string{"a", "b", "c", "d", "e"} {
+		item := makeItem("alice", "alice", message, i)
+		items = append(items, item)
+		if err := db.AddChannelItem("#ergo", item, "alice"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.AddChannelItem("#other", makeItem("bob", "bob", "x", 10), "bob")
+
+	seq := db.MakeSequence("#ergo", "", time.Time{})
+	results, err := seq.Between(history.Selector{}, history.Selector{}, 3)
+	assertEqual(err, nil, t)
+	// with no bounds, we get the latest messages:
+	assertEqual(toMessages(results), []string{"c", "d", "e"}, t)
+
+	results, _ = seq.Between(history.Selector{Msgid: items[0].Message.Msgid}, history.Selector{Msgid: items[4].Message.Msgid}, 10)
+	assertEqual(toMessages(results), []string{"b", "c", "d"}, t)
+
+	results, _ = seq.Around(history.Selector{Msgid: items[2].Message.Msgid}, 3)
+	assertEqual(toMessages(results), []string{"b", "c", "d"}, t)
+
+	// the cutoff excludes earlier messages:
+	seq = db.MakeSequence("#ergo", "", baseTime.Add(90*time.Second))
+	results, _ = seq.Between(history.Selector{}, history.Selector{}, 10)
+	assertEqual(toMessages(results), []string{"c", "d", "e"}, t)
+
+	listings, err := db.ListChannels([]string{"#ergo", "#other", "#empty"})
+	assertEqual(err, nil, t)
+	assertEqual(len(listings), 2, t)
+
+	item, targets, err := db.LookupMsgid(items[1].Message.Msgid)
+	assertEqual(err, nil, t)
+	assertEqual(item.Message.Message, "b", t)
+	assertEqual(targets, []string{"#ergo"}, t)
+
+	assertEqual(db.DeleteMsgid(items[1].Message.Msgid, "bob", time.Time{}), history.ErrDisallowed, t)
+	assertEqual(db.DeleteMsgid(items[1].Message.Msgid, "alice", baseTime.Add(time.Hour)), history.ErrExpired, t)
+	assertEqual(db.DeleteMsgid(items[1].Message.Msgid, "alice", time.Time{}), nil, t)
+	_, _, err = db.LookupMsgid(items[1].Message.Msgid)
+	if err == nil {
+		t.Errorf("deleted message should not be found")
+	}
+}
+
+func TestDirectMessages(t *testing.T) {
+	db := openTestDB(t)
+
+	dm := makeItem("alice", "alice", "hi bob", 0)
+	db.AddDirectMessage("alice", "alice", "bob", "bob", dm)
+	db.AddDirectMessage("bob", "bob", "alice", "alice", makeItem("bob", "bob", "hi alice", 1))
+	db.AddDirectMessage("carol", "", "alice", "alice", makeItem("carol", "*", "hi from carol", 2))
+	// neither party is logged in, this isn't stored:
+	db.AddDirectMessage("carol", "", "dave", "", makeItem("carol", "*", "hi dave", 3))
+
+	results, _ := db.MakeSequence("alice", "bob", time.Time{}).Between(history.Selector{}, history.Selector{}, 10)
+	assertEqual(toMessages(results), []string{"hi bob", "hi alice"}, t)
+	results, _ = db.MakeSequence("bob", "alice", time.Time{}).Between(history.Selector{}, history.Selector{}, 10)
+	assertEqual(toMessages(results), []string{"hi bob", "hi alice"}, t)
+
+	listings, err := db.MakeSequence("alice", "", time.Time{}).ListCorrespondents(history.Selector{}, history.Selector{}, 10)
+	assertEqual(err, nil, t)
+	assertEqual(len(listings), 2, t)
+	assertEqual(listings[0].CfName, "bob", t)
+	assertEqual(listings[0].Time.UnixNano(), baseTime.Add(time.Minute).UnixNano(), t)
+	assertEqual(listings[1].CfName, "carol", t)
+
+	listings, _ = db.MakeSequence("alice", "", time.Time{}).ListCorrespondents(history.Selector{Time: baseTime.Add(90 * time.Second)}, history.Selector{}, 10)
+	assertEqual(len(listings), 1, t)
+	assertEqual(listings[0].CfName, "carol", t)
+
+	// each participant's account holds a copy:
+	_, targets, err := db.LookupMsgid(dm.Message.Msgid)
+	assertEqual(err, nil, t)
+	sort.Strings(targets)
+	assertEqual(targets, []string{"alice", "bob"}, t)
+}
+
+func TestImport(t *testing.T) {
+	db := openTestDB(t)
+
+	channelItem := makeItem("alice", "alice", "hello", 0)
+	assertEqual(db.ImportChannelItem("#ergo", channelItem, "alice"), nil, t)
+	// importing again is a no-op:
+	assertEqual(db.ImportChannelItem("#ergo", channelItem, "alice"), nil, t)
+	results, _ := db.MakeSequence("#ergo", "", time.Time{}).Between(history.Selector{}, history.Selector{}, 10)
+	assertEqual(toMessages(results), []string{"hello"}, t)
+
+	// the same DM, as seen from each participant's buffer:
+	dm := makeItem("alice", "alice", "hi bob", 1)
+	dm.Params[0] = "bob"
+	assertEqual(db.ImportDirectMessage("alice", "bob", dm, "alice"), nil, t)
+	assertEqual(db.ImportDirectMessage("bob", "alice", dm, "alice"), nil, t)
+
+	results, _ = db.MakeSequence("bob", "alice", time.Time{}).Between(history.Selector{}, history.Selector{}, 10)
+	assertEqual(toMessages(results), []string{"hi bob"}, t)
+	listings, _ := db.MakeSequence("bob", "", time.Time{}).ListCorrespondents(history.Selector{}, history.Selector{}, 10)
+	assertEqual(len(listings), 1, t)
+
+	var count int
+	db.db.QueryRow(`SELECT COUNT(*) FROM history;`).Scan(&count)
+	assertEqual(count, 2, t)
+}
+
+func TestExportAndForget(t *testing.T) {
+	db := openTestDB(t)
+
+	db.AddChannelItem("#ergo", makeItem("alice", "alice", "first", 0), "alice")
+	db.AddChannelItem("#ergo", makeItem("bob", "bob", "second", 1), "bob")
+	db.AddChannelItem("#ergo", makeItem("alice", "alice", "third", 2), "alice")
+
+	var buf bytes.Buffer
+	db.Export("alice", &buf)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assertEqual(len(lines), 2, t)
+	var item history.Item
+	assertEqual(json.Unmarshal([]byte(lines[1]), &item), nil, t)
+	assertEqual(item.Message.Message, "third", t)
+	assertEqual(item.CfCorrespondent, "#ergo", t)
+
+	db.Forget("alice")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		results, _ := db.MakeSequence("#ergo", "", time.Time{}).Between(history.Selector{}, history.Selector{}, 10)
+		if len(results) == 1 {
+			assertEqual(toMessages(results), []string{"second"}, t)
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("forget did not complete, got %v", toMessages(results))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	db := openTestDB(t)
+
+	now := time.Now().UTC()
+	old := makeItem("alice", "alice", "old", 0)
+	old.Message.Time = now.Add(-2 * time.Hour)
+	recent := makeItem("alice", "alice", "recent", 0)
+	recent.Message.Time = now
+	db.AddChannelItem("#ergo", old, "alice")
+	db.AddChannelItem("#ergo", recent, "alice")
+	oldDM := makeItem("alice", "alice", "old dm", 0)
+	oldDM.Message.Time = now.Add(-2 * time.Hour)
+	db.AddDirectMessage("alice", "alice", "bob", "bob", oldDM)
+
+	count, err := db.doCleanup(time.Hour)
+	assertEqual(err, nil, t)
+	assertEqual(count, 2, t)
+
+	results, _ := db.MakeSequence("#ergo", "", time.Time{}).Between(history.Selector{}, history.Selector{}, 10)
+	assertEqual(toMessages(results), []string{"recent"}, t)
+	listings, _ := db.MakeSequence("alice", "", time.Time{}).ListCorrespondents(history.Selector{}, history.Selector{}, 10)
+	assertEqual(len(listings), 0, t)
+}
@@ -0,0 +1,11 @@
+//go:build !cgo
+// +build !cgo
+
+// Copyright (c) 2026 agent <agent@local>
+// released under the MIT license
+
+package sqlite
+
+// Supported is whether this build can open SQLite databases; without cgo,
+// the driver is only a stub that fails to open anything.
+const Supported = false
@@ -1,7 +1,14 @@
 package irc
 
 import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/ergochat/ergo/irc/utils"
 )
 
 type StatsValues struct {
@@ -96,3 +103,189 @@ func (s *Stats) GetValues() (result StatsValues) {
 	s.mutex.Unlock()
 	return
 }
+
+// statsLetter is a report available via `STATS <letter>`.
+type statsLetter struct {
+	public  bool   // available to non-operators
+	capab   string // operator capability required, if any
+	handler func(server *Server, client *Client, rb *ResponseBuffer)
+}
+
+var statsLetters = map[byte]statsLetter{
+	'd': {capab: "ban", handler: statsDlinesHandler},
+	'k': {capab: "ban", handler: statsKlinesHandler},
+	'l': {handler: statsLinkInfoHandler},
+	'm': {handler: statsCommandsHandler},
+	'o': {handler: statsOpersHandler},
+	'P': {handler: statsPortsHandler},
+	'u': {public: true, handler: statsUptimeHandler},
+	'y': {handler: statsClassesHandler},
+}
+
+func sortedBanKeys(bans map[string]IPBanInfo) (keys []string) {
+	keys = make([]string, 0, len(bans))
+	for key := range bans {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return
+}
+
+// STATS d: D-Lines
+func statsDlinesHandler(server *Server, client *Client, rb *ResponseBuffer) {
+	nick := client.Nick()
+	bans := server.dlines.AllBans()
+	for _, key := range sortedBanKeys(bans) {
+		rb.Add(nil, server.name, RPL_STATSDLINE, nick, "D", key, formatBanForListing(client, key, bans[key]))
+	}
+}
+
+// STATS k: K-Lines
+func statsKlinesHandler(server *Server, client *Client, rb *ResponseBuffer) {
+	nick := client.Nick()
+	bans := server.klines.AllBans()
+	for _, key := range sortedBanKeys(bans) {
+		rb.Add(nil, server.name, RPL_STATSKLINE, nick, "K", key, "*", "*", formatBanForListing(client, key, bans[key]))
+	}
+}
+
+// STATS l: sendq and traffic for each local session and server link, as
+// <name> <sendq> <sent lines> <sent KB> <received lines> <received KB> <seconds open>
+func statsLinkInfoHandler(server *Server, client *Client, rb *ResponseBuffer) {
+	nick := client.Nick()
+	now := time.Now().UTC()
+	addLine := func(name string, stats SocketStats, ctime time.Time) {
+		rb.Add(nil, server.name, RPL_STATSLINKINFO, nick, name,
+			strconv.Itoa(stats.SendQBytes),
+			strconv.FormatUint(stats.SentLines, 10), strconv.FormatUint(stats.SentBytes/1024, 10),
+			strconv.FormatUint(stats.ReceivedLines, 10), strconv.FormatUint(stats.ReceivedBytes/1024, 10),
+			strconv.FormatInt(int64(now.Sub(ctime)/time.Second), 10))
+	}
+
+	for _, target := range server.clients.AllClients() {
+		if target.remote != nil {
+			continue
+		}
+		targetNick := target.Nick()
+		for _, session := range target.Sessions() {
+			addLine(fmt.Sprintf("%s[%s]", targetNick, utils.IPStringToHostname(session.IP().String())), session.socket.Stats(), session.ctime)
+		}
+	}
+	for _, link := range server.links.allLinks() {
+		addLine(link.name, link.socket.Stats(), link.ctime)
+	}
+}
+
+// STATS m: number of times each command has been used
+func statsCommandsHandler(server *Server, client *Client, rb *ResponseBuffer) {
+	nick := client.Nick()
+	commands := make([]string, 0, len(server.metrics.commandCounters))
+	for command := range server.metrics.commandCounters {
+		commands = append(commands, command)
+	}
+	sort.Strings(commands)
+	for _, command := range commands {
+		if count := server.metrics.commandCounters[command].Value(); count != 0 {
+			rb.Add(nil, server.name, RPL_STATSCOMMANDS, nick, command, strconv.FormatUint(count, 10))
+		}
+	}
+}
+
+// STATS o: configured operators, their classes, and the classes' capabilities
+func statsOpersHandler(server *Server, client *Client, rb *ResponseBuffer) {
+	nick := client.Nick()
+	operators := server.Config().operators
+	names := make([]string, 0, len(operators))
+	for name := range operators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		class := operators[name].Class
+		capabs := make([]string, 0, len(class.Capabilities))
+		for capab := range class.Capabilities {
+			capabs = append(capabs, capab)
+		}
+		sort.Strings(capabs)
+		rb.Add(nil, server.name, RPL_STATSOLINE, nick, "O", "*", "*", name, class.Name, strings.Join(capabs, " "))
+	}
+}
+
+// STATS P: configured listeners
+func statsPortsHandler(server *Server, client *Client, rb *ResponseBuffer) {
+	nick := client.Nick()
+	listeners := server.Config().Server.trueListeners
+	addrs := make([]string, 0, len(listeners))
+	for addr := range listeners {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	for _, addr := range addrs {
+		listener := listeners[addr]
+		var flags []string
+		if listener.TLSConfig != nil {
+			flags = append(flags, "tls")
+		} else {
+			flags = append(flags, "plaintext")
+		}
+		if listener.RequireProxy {
+			flags = append(flags, "proxy")
+		}
+		if listener.Tor {
+			flags = append(flags, "tor")
+		}
+		if listener.STSOnly {
+			flags = append(flags, "sts-only")
+		}
+		if listener.WebSocket {
+			flags = append(flags, "websocket")
+		}
+		rb.Add(nil, server.name, RPL_STATSPLINE, nick, "P", addr, strings.Join(flags, " "))
+	}
+}
+
+// STATS u: server uptime
+func statsUptimeHandler(server *Server, client *Client, rb *ResponseBuffer) {
+	uptime := time.Since(server.ctime)
+	seconds := int(uptime / time.Second)
+	rb.Add(nil, server.name, RPL_STATSUPTIME, client.Nick(), fmt.Sprintf(client.t("Server Up %[1]d days %[2]d:%02[3]d:%02[4]d"),
+		seconds/86400, (seconds/3600)%24, (seconds/60)%60, seconds%60))
+}
+
+// STATS y: connection limiter classes, as
+// <class> <max concurrent connections> <max connections per window> <window>,
+// with * indicating no limit
+func statsClassesHandler(server *Server, client *Client, rb *ResponseBuffer) {
+	nick := client.Nick()
+	limits := server.Config().Server.IPLimits
+	limitString := func(enabled bool, limit int) string {
+		if !enabled {
+			return "*"
+		}
+		return strconv.Itoa(limit)
+	}
+	window := limits.Window.String()
+	if !limits.Throttle {
+		window = "*"
+	}
+
+	rb.Add(nil, server.name, RPL_STATSYLINE, nick, "Y", "default",
+		limitString(limits.Count, limits.MaxConcurrent), limitString(limits.Throttle, limits.MaxPerWindow), window,
+		fmt.Sprintf(client.t("Per-network limits, for IPv4 /%[1]d and IPv6 /%[2]d networks"), limits.CidrLenIPv4, limits.CidrLenIPv6))
+
+	names := make([]string, 0, len(limits.CustomLimits))
+	for name := range limits.CustomLimits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		custom := limits.CustomLimits[name]
+		rb.Add(nil, server.name, RPL_STATSYLINE, nick, "Y", name,
+			limitString(limits.Count, custom.MaxConcurrent), limitString(limits.Throttle, custom.MaxPerWindow), window,
+			strings.Join(custom.Nets, " "))
+	}
+
+	if len(limits.Exempted) != 0 {
+		rb.Add(nil, server.name, RPL_STATSYLINE, nick, "Y", "exempt", "*", "*", "*", strings.Join(limits.Exempted, " "))
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2026 agent <agent@local>
+// released under the MIT license
+
+package irc
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/ergochat/ergo/irc/flatip"
+)
+
+// stats sends STATS and returns the reply lines before RPL_ENDOFSTATS.
+func (c *testClient) stats(letter string) (lines []string) {
+	c.t.Helper()
+	c.Send("STATS " + letter)
+	for {
+		line, ok := c.ReadLine()
+		if !ok {
+			c.t.Fatalf("%s: no end of STATS %s", c.nick, letter)
+		}
+		if lineCommand(line) == RPL_ENDOFSTATS {
+			if fields := strings.Fields(line); fields[3] != letter {
+				c.t.Fatalf("%s: unexpected end of STATS: %q", c.nick, line)
+			}
+			return
+		}
+		lines = append(lines, line)
+	}
+}
+
+// statsReply returns the lines with the given numeric, with their
+// source, numeric, and nick removed, or nil if STATS was refused.
+func (c *testClient) statsReply(letter, numeric string) (result []string) {
+	c.t.Helper()
+	for _, line := range c.stats(letter) {
+		switch lineCommand(line) {
+		case ERR_NOPRIVILEGES:
+			return nil
+		case numeric:
+			result = append(result, strings.SplitN(line, " ", 4)[3])
+		default:
+			c.t.Fatalf("%s: unexpected line in STATS %s: %q", c.nick, letter, line)
+		}
+	}
+	if result == nil {
+		result = []string{}
+	}
+	return
+}
+
+func TestStats(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("pw"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, addr := newTestServer(t, map[string]interface{}{
+		"oper-classes.helper": map[interface{}]interface{}{
+			"title":        "Helper",
+			"capabilities": []interface{}{"snomasks"},
+		},
+		"opers": map[interface{}]interface{}{
+			"admin":  map[interface{}]interface{}{"class": "server-admin", "password": string(hash)},
+			"helper": map[interface{}]interface{}{"class": "helper", "password": string(hash)},
+		},
+	})
+	if err := server.klines.AddMask("spammer!*@*", time.Hour, "spam", "", "admin"); err != nil {
+		t.Fatal(err)
+	}
+	network, _ := flatip.ParseToNormalizedNet("192.0.2.0/24")
+	if err := server.dlines.AddNetwork(network, 0, false, "abuse", "", "admin"); err != nil {
+		t.Fatal(err)
+	}
+
+	alice := connectTestClient(t, addr, "alice", "")
+	admin := connectTestClient(t, addr, "admin", "")
+	admin.Send("OPER admin pw")
+	admin.Expect(RPL_YOUREOPER)
+	helper := connectTestClient(t, addr, "helper", "")
+	helper.Send("OPER helper pw")
+	helper.Expect(RPL_YOUREOPER)
+	for _, client := range []*testClient{alice, admin, helper} {
+		client.Sync()
+	}
+
+	// STATS u is public; the rest are for operators only:
+	for _, client := range []*testClient{alice, helper, admin} {
+		uptime := client.statsReply("u", RPL_STATSUPTIME)
+		if len(uptime) != 1 || !strings.HasPrefix(uptime[0], ":Server Up 0 days 0:00:") {
+			t.Errorf("%s: unexpected STATS u: %q", client.nick, uptime)
+		}
+	}
+	for _, letter := range []string{"d", "k", "l", "m", "o", "P", "y"} {
+		if lines := alice.stats(letter); len(lines) != 1 || lineCommand(lines[0]) != ERR_NOPRIVILEGES {
+			t.Errorf("unexpected STATS %s for a non-operator: %q", letter, lines)
+		}
+	}
+	// unknown letters return an empty report:
+	assertEqual(len(alice.stats("z")), 0, t)
+	assertEqual(len(admin.stats("kd")), 0, t)
+
+	// STATS d and k require the ban capability:
+	klines := admin.statsReply("k", RPL_STATSKLINE)
+	assertEqual(len(klines), 1, t)
+	assertEqual(strings.HasPrefix(klines[0], "K spammer!*@* * * :Ban - spammer!*@* - added by admin - spam ["), true, t)
+	assertEqual(helper.statsReply("k", RPL_STATSKLINE) == nil, true, t)
+	dlines := admin.statsReply("d", RPL_STATSDLINE)
+	assertEqual(len(dlines), 1, t)
+	assertEqual(dlines[0], "D 192.0.2.0/24 :Ban - 192.0.2.0/24 - added by admin - abuse", t)
+	assertEqual(helper.statsReply("d", RPL_STATSDLINE) == nil, true, t)
+
+	// the others are available to all operators:
+	assertEqual(helper.statsReply("o", RPL_STATSOLINE), []string{
+		"O * * admin server-admin :accreg ban chanreg defcon history kill linking massmessage nofakelag rehash relaymsg roleplay sajoin samode snomasks vhosts wallops",
+		"O * * helper helper snomasks",
+	}, t)
+	assertEqual(helper.statsReply("P", RPL_STATSPLINE), []string{"P " + testListenAddr + " plaintext"}, t)
+	classes := helper.statsReply("y", RPL_STATSYLINE)
+	if len(classes) == 0 || !strings.HasPrefix(classes[0], "Y default ") {
+		t.Errorf("unexpected STATS y: %q", classes)
+	}
+
+	links := helper.statsReply("l", RPL_STATSLINKINFO)
+	assertEqual(len(links), 3, t)
+	for _, nick := range []string{"admin", "alice", "helper"} {
+		found := false
+		for _, link := range links {
+			found = found || strings.HasPrefix(link, nick+"[127.0.0.1] ")
+		}
+		if !found {
+			t.Errorf("no STATS l line for %s: %q", nick, links)
+		}
+	}
+
+	commands := helper.statsReply("m", RPL_STATSCOMMANDS)
+	found := false
+	for _, command := range commands {
+		// admin and helper each sent one OPER:
+		found = found || command == "OPER 2"
+	}
+	if !found {
+		t.Errorf("unexpected STATS m: %q", commands)
+	}
+}
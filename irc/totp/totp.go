@@ -0,0 +1,95 @@
+// Copyright (c) 2026 agent <agent@local>
+// released under the MIT license
+
+// Package totp implements time-based one-time passwords (RFC 6238), with the
+// parameters authenticator apps expect: HMAC-SHA1, 6 digits, 30-second steps.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+const (
+	SecretLen = 20 // 160 bits, as recommended by RFC 4226
+	Digits    = 6
+	Period    = 30 // seconds
+
+	// codes from this many steps before or after the current one are accepted,
+	// to allow for clock skew and slow typists
+	skew = 1
+)
+
+var (
+	b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+)
+
+// GenerateSecret generates a new random shared secret.
+func GenerateSecret() (secret []byte, err error) {
+	secret = make([]byte, SecretLen)
+	_, err = rand.Read(secret)
+	return
+}
+
+// EncodeSecret encodes a secret in base32, for manual entry into an authenticator.
+func EncodeSecret(secret []byte) string {
+	return b32.EncodeToString(secret)
+}
+
+// ProvisioningURI returns the otpauth:// URI for a secret, which authenticators
+// accept (usually as a QR code) to set up the account.
+func ProvisioningURI(issuer, accountName string, secret []byte) string {
+	v := make(url.Values)
+	v.Set("secret", EncodeSecret(secret))
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprintf("%d", Digits))
+	v.Set("period", fmt.Sprintf("%d", Period))
+	label := url.PathEscape(issuer + ":" + accountName)
+	return fmt.Sprintf("otpauth://totp/%s?%s", label, v.Encode())
+}
+
+// Counter returns the time step for a point in time.
+func Counter(t time.Time) uint64 {
+	return uint64(t.Unix()) / Period
+}
+
+// GenerateCode computes the code for a secret and time step (RFC 4226).
+func GenerateCode(secret []byte, counter uint64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", Digits, value%1000000)
+}
+
+// Validate checks a code against the secret at time `now`. To prevent
+// replay, codes for time steps up to and including `lastCounter` (the step of
+// the last code accepted) are rejected. On success, it returns the time step
+// of the code, which the caller must store as the new `lastCounter`.
+func Validate(secret []byte, code string, now time.Time, lastCounter uint64) (counter uint64, ok bool) {
+	if len(code) != Digits {
+		return 0, false
+	}
+	current := Counter(now)
+	for i := -skew; i <= skew; i++ {
+		counter = uint64(int64(current) + int64(i))
+		if counter <= lastCounter {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(GenerateCode(secret, counter)), []byte(code)) == 1 {
+			return counter, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2026 agent <agent@local>
+// released under the MIT license
+
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRFC6238Vectors(t *testing.T) {
+	// RFC 6238, appendix B (SHA1); our codes are the last 6 of the 8 digits
+	secret := []byte("12345678901234567890")
+	vectors := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, v := range vectors {
+		if code := GenerateCode(secret, Counter(time.Unix(v.unix, 0))); code != v.code {
+			t.Errorf("at %d: expected %s, got %s", v.unix, v.code, code)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	current := Counter(now)
+
+	counter, ok := Validate(secret, GenerateCode(secret, current), now, 0)
+	if !ok || counter != current {
+		t.Errorf("current code should be accepted")
+	}
+	if _, ok := Validate(secret, GenerateCode(secret, current-1), now, 0); !ok {
+		t.Errorf("previous code should be accepted")
+	}
+	if _, ok := Validate(secret, GenerateCode(secret, current-3), now, 0); ok {
+		t.Errorf("old code should be rejected")
+	}
+	if _, ok := Validate(secret, GenerateCode(secret, current), now, current); ok {
+		t.Errorf("code should not be accepted twice")
+	}
+	if _, ok := Validate(secret, "", now, 0); ok {
+		t.Errorf("empty code should be rejected")
+	}
+}
+
+func TestProvisioningURI(t *testing.T) {
+	uri := ProvisioningURI("Example Net", "alice", []byte("12345678901234567890"))
+	if !strings.HasPrefix(uri, "otpauth://totp/Example%20Net:alice?") || !strings.Contains(uri, "secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ") {
+		t.Errorf("unexpected provisioning URI: %s", uri)
+	}
+}
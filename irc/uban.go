@@ -151,6 +151,35 @@ func sessionsForCIDR(server *Server, cidr flatip.IPNet, exclude *Session, requir
 	return
 }
 
+// killSessionsForCIDR disconnects the sessions (other than `exclude`) that are
+// connecting from a newly banned network
+func killSessionsForCIDR(server *Server, cidr flatip.IPNet, exclude *Session, requireSASL bool) (sessions []*Session, nicks []string) {
+	sessions, nicks = sessionsForCIDR(server, cidr, exclude, requireSASL)
+	for _, session := range sessions {
+		session.client.Quit("You have been banned from this server", session)
+		session.client.destroy(session)
+	}
+	return
+}
+
+// killClientsForNickmask disconnects the clients (other than `exclude`) that match
+// a newly banned NUH mask; always-on clients are not disconnected, and are
+// returned separately
+func killClientsForNickmask(server *Server, matcher *regexp.Regexp, exclude *Client) (killed, alwaysOn []string) {
+	for _, mcl := range server.clients.AllClients() {
+		if mcl != exclude && matcher.MatchString(mcl.NickMaskCasefolded()) {
+			if !mcl.AlwaysOn() {
+				killed = append(killed, mcl.Nick())
+				mcl.Quit("You have been banned from this server", nil)
+				mcl.destroy(nil)
+			} else {
+				alwaysOn = append(alwaysOn, mcl.Nick())
+			}
+		}
+	}
+	return
+}
+
 func ubanAddHandler(client *Client, target ubanTarget, params []string, rb *ResponseBuffer) bool {
 	duration, requireSASL, params, err := consumeDuration(params, rb)
 	if err != nil {
@@ -227,11 +256,7 @@ func ubanAddCIDR(client *Client, target ubanTarget, duration time.Duration, requ
 		return
 	}
 
-	sessions, nicks := sessionsForCIDR(client.server, target.cidr, rb.session, requireSASL)
-	for _, session := range sessions {
-		session.client.Quit("You have been banned from this server", session)
-		session.client.destroy(session)
-	}
+	sessions, nicks := killSessionsForCIDR(client.server, target.cidr, rb.session, requireSASL)
 
 	if len(sessions) != 0 {
 		rb.Notice(fmt.Sprintf(client.t("Killed %[1]d active client(s) from %[2]s, associated with %[3]d nickname(s):"), len(sessions), target.cidr.String(), len(nicks)))
@@ -252,19 +277,7 @@ func ubanAddNickmask(client *Client, target ubanTarget, duration time.Duration,
 		return
 	}
 
-	var killed []string
-	var alwaysOn []string
-	for _, mcl := range client.server.clients.AllClients() {
-		if mcl != client && target.matcher.MatchString(mcl.NickMaskCasefolded()) {
-			if !mcl.AlwaysOn() {
-				killed = append(killed, mcl.Nick())
-				mcl.Quit("You have been banned from this server", nil)
-				mcl.destroy(nil)
-			} else {
-				alwaysOn = append(alwaysOn, mcl.Nick())
-			}
-		}
-	}
+	killed, alwaysOn := killClientsForNickmask(client.server, target.matcher, client)
 	if len(killed) != 0 {
 		rb.Notice(fmt.Sprintf(client.t("Killed %d clients:"), len(killed)))
 		for _, line := range utils.BuildTokenLines(400, killed, " ") {
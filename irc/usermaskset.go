@@ -13,13 +13,36 @@ import (
 	"time"
 	"unsafe"
 
+	"github.com/ergochat/ergo/irc/modes"
 	"github.com/ergochat/ergo/irc/utils"
 )
 
+const (
+	// advertised in the EXTBAN token of RPL_ISUPPORT; m is the mute prefix,
+	// which is also accepted without the $ (as m:nick!user@host)
+	extbanPrefix = "$"
+	extbanTypes  = "acmorz"
+)
+
 type MaskInfo struct {
 	TimeCreated     time.Time
 	CreatorNickmask string
 	CreatorAccount  string
+	// duration of the entry; 0 means "permanent"
+	Duration time.Duration `json:",omitempty"`
+	// for CS AKICK entries: the reason shown to the affected user,
+	// and the reason shown only to channel operators
+	Reason        string `json:",omitempty"`
+	PrivateReason string `json:",omitempty"`
+}
+
+// Expiration returns the time at which the entry expires, or the zero time
+// if it is permanent.
+func (info MaskInfo) Expiration() (result time.Time) {
+	if info.Duration != 0 {
+		result = info.TimeCreated.Add(info.Duration)
+	}
+	return
 }
 
 // UserMaskSet holds a set of client masks and lets you match  hostnames to them.
@@ -29,15 +52,143 @@ type UserMaskSet struct {
 	masks                  map[string]MaskInfo
 	regexp                 unsafe.Pointer
 	muteRegexp             unsafe.Pointer
+	extbans                unsafe.Pointer // *extbanList
+}
+
+// extban matches a client on something other than its n!u@h:
+// $a (any logged-in client), $a:account, $c:#channel (members of another channel),
+// $o (opers), $r:realname, or $z (clients connected with TLS).
+// The account and realname parameters may contain wildcards.
+type extban struct {
+	kind    byte
+	param   string
+	pattern *regexp.Regexp
+}
+
+type extbanList struct {
+	bans  []extban
+	mutes []extban
 }
 
 func NewUserMaskSet() *UserMaskSet {
 	return new(UserMaskSet)
 }
 
+// canonicalizeListMask canonicalizes a ban, exception or invite list entry:
+// a n!u@h mask or an extban, either of which may be prefixed with m: to mute.
+func canonicalizeListMask(mask string) (result string, err error) {
+	mask = strings.TrimSpace(mask)
+	if len(mask) >= 3 && mask[0] == '$' && (mask[1] == 'm' || mask[1] == 'M') && mask[2] == ':' {
+		mask = mask[1:]
+	}
+	if len(mask) >= 2 && (mask[0] == 'm' || mask[0] == 'M') && mask[1] == ':' {
+		result, err = canonicalizeListMask(mask[2:])
+		if err != nil || strings.HasPrefix(result, "m:") {
+			return "", errInvalidParams
+		}
+		return "m:" + result, nil
+	}
+	if strings.HasPrefix(mask, extbanPrefix) {
+		return canonicalizeExtban(mask)
+	}
+	return CanonicalizeMaskWildcard(mask)
+}
+
+func canonicalizeExtban(mask string) (result string, err error) {
+	if len(mask) < 2 {
+		return "", errInvalidParams
+	}
+	kind := strings.ToLower(mask[1:2])
+	var param string
+	if len(mask) > 2 {
+		if mask[2] != ':' || len(mask) == 3 {
+			return "", errInvalidParams
+		}
+		param = mask[3:]
+	}
+
+	switch kind {
+	case "a":
+		if strings.ContainsAny(param, "*?") {
+			param, err = Casefold(param)
+		} else if param != "" {
+			param, err = CasefoldName(param)
+		}
+	case "c":
+		param, err = CasefoldChannel(param)
+	case "r":
+		if param == "" {
+			err = errInvalidParams
+		}
+		param = strings.ToLower(param)
+	case "o", "z":
+		if param != "" {
+			err = errInvalidParams
+		}
+	default:
+		err = errInvalidParams
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if param == "" {
+		return extbanPrefix + kind, nil
+	}
+	return extbanPrefix + kind + ":" + param, nil
+}
+
+// parseExtban parses a canonicalized extban.
+func parseExtban(mask string) (result extban, err error) {
+	result.kind = mask[1]
+	if len(mask) > 3 {
+		result.param = mask[3:]
+	}
+	if result.param != "" && (result.kind == 'a' || result.kind == 'r') {
+		result.pattern, err = utils.CompileGlob(result.param, false)
+	}
+	return
+}
+
+func (eb *extban) match(client *Client, details *ClientDetails) bool {
+	switch eb.kind {
+	case 'a':
+		return details.account != "" && (eb.pattern == nil || eb.pattern.MatchString(details.account))
+	case 'c':
+		for _, channel := range client.Channels() {
+			if channel.NameCasefolded() == eb.param {
+				return true
+			}
+		}
+	case 'o':
+		return client.HasMode(modes.Operator)
+	case 'r':
+		return eb.pattern.MatchString(strings.ToLower(details.realname))
+	case 'z':
+		return client.HasMode(modes.TLS)
+	}
+	return false
+}
+
 // Add adds the given mask to this set.
 func (set *UserMaskSet) Add(mask, creatorNickmask, creatorAccount string) (maskAdded string, err error) {
-	casefoldedMask, err := CanonicalizeMaskWildcard(mask)
+	return set.AddTimed(mask, creatorNickmask, creatorAccount, 0)
+}
+
+// AddTimed adds the given mask to this set; if duration is nonzero,
+// the mask will be removed by RemoveExpired once it has elapsed.
+func (set *UserMaskSet) AddTimed(mask, creatorNickmask, creatorAccount string, duration time.Duration) (maskAdded string, err error) {
+	return set.AddInfo(mask, MaskInfo{
+		CreatorNickmask: creatorNickmask,
+		CreatorAccount:  creatorAccount,
+		Duration:        duration,
+	})
+}
+
+// AddInfo adds the given mask to this set, with the given metadata;
+// the creation time is filled in automatically.
+func (set *UserMaskSet) AddInfo(mask string, info MaskInfo) (maskAdded string, err error) {
+	casefoldedMask, err := canonicalizeListMask(mask)
 	if err != nil {
 		return
 	}
@@ -52,11 +203,8 @@ func (set *UserMaskSet) Add(mask, creatorNickmask, creatorAccount string) (maskA
 	_, present := set.masks[casefoldedMask]
 	if !present {
 		maskAdded = casefoldedMask
-		set.masks[casefoldedMask] = MaskInfo{
-			TimeCreated:     time.Now().UTC(),
-			CreatorNickmask: creatorNickmask,
-			CreatorAccount:  creatorAccount,
-		}
+		info.TimeCreated = time.Now().UTC()
+		set.masks[casefoldedMask] = info
 	}
 	set.Unlock()
 
@@ -68,7 +216,7 @@ func (set *UserMaskSet) Add(mask, creatorNickmask, creatorAccount string) (maskA
 
 // Remove removes the given mask from this set.
 func (set *UserMaskSet) Remove(mask string) (maskRemoved string, err error) {
-	mask, err = CanonicalizeMaskWildcard(mask)
+	mask, err = canonicalizeListMask(mask)
 	if err != nil {
 		return
 	}
@@ -90,6 +238,40 @@ func (set *UserMaskSet) Remove(mask string) (maskRemoved string, err error) {
 	return
 }
 
+// RemoveExpired removes the masks that expired before `now`, returning them.
+func (set *UserMaskSet) RemoveExpired(now time.Time) (removed []string) {
+	set.serialCacheUpdateMutex.Lock()
+	defer set.serialCacheUpdateMutex.Unlock()
+
+	set.Lock()
+	for mask, info := range set.masks {
+		if expiration := info.Expiration(); !expiration.IsZero() && !expiration.After(now) {
+			removed = append(removed, mask)
+			delete(set.masks, mask)
+		}
+	}
+	set.Unlock()
+
+	if len(removed) != 0 {
+		set.setRegexp()
+	}
+	return
+}
+
+// NextExpiration returns the earliest expiration time of any mask in the set,
+// or the zero time if all masks are permanent.
+func (set *UserMaskSet) NextExpiration() (result time.Time) {
+	set.RLock()
+	defer set.RUnlock()
+
+	for _, info := range set.masks {
+		if expiration := info.Expiration(); !expiration.IsZero() && (result.IsZero() || expiration.Before(result)) {
+			result = expiration
+		}
+	}
+	return
+}
+
 func (set *UserMaskSet) SetMasks(masks map[string]MaskInfo) {
 	set.Lock()
 	set.masks = masks
@@ -132,6 +314,69 @@ func (set *UserMaskSet) MuteRegexp() *regexp.Regexp {
 	return (*regexp.Regexp)(atomic.LoadPointer(&set.muteRegexp))
 }
 
+func (set *UserMaskSet) extbanList() *extbanList {
+	return (*extbanList)(atomic.LoadPointer(&set.extbans))
+}
+
+// MatchClient matches the client against the standard bans and the extbans.
+func (set *UserMaskSet) MatchClient(client *Client, details *ClientDetails) bool {
+	if set.Match(details.nickMaskCasefolded) {
+		return true
+	}
+	if extbans := set.extbanList(); extbans != nil {
+		for i := range extbans.bans {
+			if extbans.bans[i].match(client, details) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// FindMatch returns the first mask (standard or extban, but not mute)
+// that matches the client, along with its metadata.
+func (set *UserMaskSet) FindMatch(client *Client, details *ClientDetails) (mask string, info MaskInfo, found bool) {
+	if !set.MatchClient(client, details) {
+		return
+	}
+
+	// extban matching can acquire channel locks, so don't hold the set's lock
+	for mask, info := range set.Masks() {
+		if strings.HasPrefix(mask, "m:") {
+			continue
+		}
+		if strings.HasPrefix(mask, extbanPrefix) {
+			if extban, err := parseExtban(mask); err == nil && extban.match(client, details) {
+				return mask, info, true
+			}
+		} else if re, err := utils.CompileGlob(mask, false); err == nil && re.MatchString(details.nickMaskCasefolded) {
+			return mask, info, true
+		}
+	}
+	return
+}
+
+// HasMutes returns whether the set contains any mutes (standard or extban).
+func (set *UserMaskSet) HasMutes() bool {
+	extbans := set.extbanList()
+	return set.MuteRegexp() != nil || (extbans != nil && len(extbans.mutes) != 0)
+}
+
+// MatchMuteClient matches the client against the mutes, including the mute extbans.
+func (set *UserMaskSet) MatchMuteClient(client *Client, details *ClientDetails) bool {
+	if set.MatchMute(details.nickMaskCasefolded) {
+		return true
+	}
+	if extbans := set.extbanList(); extbans != nil {
+		for i := range extbans.mutes {
+			if extbans.mutes[i].match(client, details) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func (set *UserMaskSet) Length() int {
 	set.RLock()
 	defer set.RUnlock()
@@ -142,9 +387,24 @@ func (set *UserMaskSet) setRegexp() {
 	set.RLock()
 	maskExprs := make([]string, 0, len(set.masks))
 	var muteExprs []string
+	var extbans extbanList
 	for mask := range set.masks {
-		if strings.HasPrefix(mask, "m:") {
-			muteExprs = append(muteExprs, mask[2:])
+		isMute := strings.HasPrefix(mask, "m:")
+		if isMute {
+			mask = mask[2:]
+		}
+		if strings.HasPrefix(mask, extbanPrefix) {
+			extban, err := parseExtban(mask)
+			if err != nil {
+				continue
+			}
+			if isMute {
+				extbans.mutes = append(extbans.mutes, extban)
+			} else {
+				extbans.bans = append(extbans.bans, extban)
+			}
+		} else if isMute {
+			muteExprs = append(muteExprs, mask)
 		} else {
 			maskExprs = append(maskExprs, mask)
 		}
@@ -164,4 +424,5 @@ func (set *UserMaskSet) setRegexp() {
 
 	atomic.StorePointer(&set.regexp, unsafe.Pointer(re))
 	atomic.StorePointer(&set.muteRegexp, unsafe.Pointer(muteRe))
+	atomic.StorePointer(&set.extbans, unsafe.Pointer(&extbans))
 }
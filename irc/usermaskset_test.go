@@ -5,6 +5,9 @@ package irc
 
 import (
 	"testing"
+	"time"
+
+	"github.com/ergochat/ergo/irc/modes"
 )
 
 func TestUserMaskSet(t *testing.T) {
@@ -34,3 +37,147 @@ func TestUserMaskSet(t *testing.T) {
 		t.Errorf("unexpected MatchMute() succeeded")
 	}
 }
+
+func TestCanonicalizeListMask(t *testing.T) {
+	for _, testCase := range []struct {
+		mask     string
+		expected string
+	}{
+		{"horse!*@*", "horse!*@*"},
+		{"m:Horse", "m:horse!*@*"},
+		{"$m:horse", "m:horse!*@*"},
+		{"$a", "$a"},
+		{"$a:Evan", "$a:evan"},
+		{"$A:Ev*", "$a:ev*"},
+		{"m:$a:evan", "m:$a:evan"},
+		{"$c:#Ergo", "$c:#ergo"},
+		{"$r:*Bot*", "$r:*bot*"},
+		{"$o", "$o"},
+		{"$z", "$z"},
+	} {
+		result, err := canonicalizeListMask(testCase.mask)
+		if err != nil || result != testCase.expected {
+			t.Errorf("expected %s to canonicalize to %s, got %s (%v)", testCase.mask, testCase.expected, result, err)
+		}
+	}
+
+	for _, mask := range []string{"$", "$q:horse", "$c", "$c:ergo", "$r", "$o:horse", "$z:", "$aevan", "m:m:horse"} {
+		if result, err := canonicalizeListMask(mask); err == nil {
+			t.Errorf("expected %s to be rejected, got %s", mask, result)
+		}
+	}
+}
+
+func TestExtbans(t *testing.T) {
+	channel := &Channel{nameCasefolded: "#ergo"}
+	client := &Client{channels: ChannelSet{channel: empty{}}}
+	details := ClientDetails{
+		WhoWas: WhoWas{
+			realname: "Evan's Bot",
+			account:  "evan",
+		},
+		nickMaskCasefolded: "horse!~evan@tor-network.onion",
+	}
+	anon := &Client{}
+	anonDetails := ClientDetails{nickMaskCasefolded: "anon!~anon@localhost"}
+
+	s := NewUserMaskSet()
+	s.Add("$a:ev*", "", "")
+	if !s.MatchClient(client, &details) {
+		t.Errorf("expected account extban to match")
+	}
+	if s.MatchClient(anon, &anonDetails) {
+		t.Errorf("account extban should not match a logged-out client")
+	}
+
+	for _, mask := range []string{"$a", "$c:#ergo", "$r:*bot"} {
+		s = NewUserMaskSet()
+		s.Add(mask, "", "")
+		if !s.MatchClient(client, &details) {
+			t.Errorf("expected %s to match", mask)
+		}
+		if s.MatchClient(anon, &anonDetails) {
+			t.Errorf("unexpected match of %s", mask)
+		}
+	}
+
+	s = NewUserMaskSet()
+	s.Add("$z", "", "")
+	if s.MatchClient(client, &details) {
+		t.Errorf("$z should not match a plaintext client")
+	}
+	client.SetMode(modes.TLS, true)
+	if !s.MatchClient(client, &details) {
+		t.Errorf("$z should match a TLS client")
+	}
+
+	s = NewUserMaskSet()
+	s.Add("m:$a:evan", "", "")
+	if s.MatchClient(client, &details) {
+		t.Errorf("mute extbans should not MatchClient()")
+	}
+	if !s.HasMutes() || !s.MatchMuteClient(client, &details) {
+		t.Errorf("expected MatchMuteClient() failed")
+	}
+	if s.MatchMuteClient(anon, &anonDetails) {
+		t.Errorf("unexpected MatchMuteClient() succeeded")
+	}
+}
+
+func TestRemoveExpired(t *testing.T) {
+	s := NewUserMaskSet()
+	s.Add("permanent!*@*", "", "")
+	s.AddTimed("short!*@*", "", "", time.Minute)
+	s.AddTimed("long!*@*", "", "", time.Hour)
+
+	now := time.Now().UTC()
+	if next := s.NextExpiration(); next.Before(now.Add(59*time.Second)) || next.After(now.Add(time.Minute)) {
+		t.Errorf("unexpected next expiration %v", next)
+	}
+	if removed := s.RemoveExpired(now); len(removed) != 0 {
+		t.Errorf("unexpected expired masks %v", removed)
+	}
+
+	removed := s.RemoveExpired(now.Add(2 * time.Minute))
+	if len(removed) != 1 || removed[0] != "short!*@*" {
+		t.Errorf("expected short!*@* to expire, got %v", removed)
+	}
+	if s.Match("short!u@h") || !s.Match("long!u@h") || !s.Match("permanent!u@h") {
+		t.Errorf("expired mask should no longer match")
+	}
+
+	s.RemoveExpired(now.Add(2 * time.Hour))
+	if s.Length() != 1 || !s.NextExpiration().IsZero() {
+		t.Errorf("only the permanent mask should remain")
+	}
+}
+
+func TestFindMatch(t *testing.T) {
+	client := &Client{}
+	details := ClientDetails{
+		WhoWas:             WhoWas{account: "evan"},
+		nickMaskCasefolded: "horse!~evan@tor-network.onion",
+	}
+
+	s := NewUserMaskSet()
+	s.AddInfo("m:horse!*@*", MaskInfo{Reason: "muted"})
+	s.AddInfo("*!*@localhost", MaskInfo{Reason: "local"})
+	if _, _, found := s.FindMatch(client, &details); found {
+		t.Errorf("mutes and non-matching masks should not be found")
+	}
+
+	s.AddInfo("$a:evan", MaskInfo{Reason: "spam", PrivateReason: "reported by dan", CreatorNickmask: "dan!d@localhost"})
+	mask, info, found := s.FindMatch(client, &details)
+	if !found || mask != "$a:evan" || info.Reason != "spam" || info.PrivateReason != "reported by dan" {
+		t.Errorf("expected account match, got %s %#v %t", mask, info, found)
+	}
+	if info.TimeCreated.IsZero() || info.CreatorNickmask != "dan!d@localhost" {
+		t.Errorf("metadata was not recorded: %#v", info)
+	}
+
+	s.Remove("$a:evan")
+	s.AddInfo("HORSE!*@*", MaskInfo{Reason: "nick"})
+	if mask, info, found = s.FindMatch(client, &details); !found || mask != "horse!*@*" || info.Reason != "nick" {
+		t.Errorf("expected nick match, got %s %#v %t", mask, info, found)
+	}
+}
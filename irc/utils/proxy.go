@@ -48,6 +48,7 @@ type ListenerConfig struct {
 	RequireProxy  bool
 	// these are just metadata for easier tracking,
 	// they are not used by ReloadableListener:
+	Addr      string
 	Tor       bool
 	STSOnly   bool
 	WebSocket bool
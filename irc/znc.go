@@ -115,6 +115,7 @@ func zncPlaybackPlayHandler(client *Client, command string, params []string, rb
 	switch len(params) {
 	case 2:
 		// #1205: this should have the same semantics as `LATEST *`
+		// (except that anything before the client's read markers is skipped)
 	case 3:
 		// #831: this should have the same semantics as `LATEST timestamp=qux`,
 		// or equivalently `BETWEEN timestamp=$now timestamp=qux`, as opposed to
@@ -165,7 +166,7 @@ func zncPlaybackPlayHandler(client *Client, command string, params []string, rb
 	}
 
 	if playPrivmsgs {
-		zncPlayPrivmsgsFromAll(client, rb, start, end)
+		zncPlayPrivmsgsFromAll(client, rb, start, end, len(params) == 2)
 	}
 
 	rb.session.zncPlaybackTimes = &zncPlaybackTimes{
@@ -189,6 +190,11 @@ func zncPlaybackPlayHandler(client *Client, command string, params []string, rb
 }
 
 func zncPlayPrivmsgsFrom(client *Client, rb *ResponseBuffer, target string, start, end time.Time) {
+	if end.IsZero() {
+		if marker := client.ReadMarker(target); !marker.IsZero() {
+			start, end = time.Now().UTC(), marker
+		}
+	}
 	_, sequence, err := client.server.GetHistorySequence(nil, client, target)
 	if sequence == nil || err != nil {
 		return
@@ -200,9 +206,14 @@ func zncPlayPrivmsgsFrom(client *Client, rb *ResponseBuffer, target string, star
 	}
 }
 
-func zncPlayPrivmsgsFromAll(client *Client, rb *ResponseBuffer, start, end time.Time) {
+// if skipRead is set, messages the user has already read (according to
+// their read markers) are not played
+func zncPlayPrivmsgsFromAll(client *Client, rb *ResponseBuffer, start, end time.Time, skipRead bool) {
 	zncMax := client.server.Config().History.ZNCMax
 	items, err := client.privmsgsBetween(start, end, maxDMTargetsForAutoplay, zncMax)
+	if skipRead {
+		items = client.filterReadPrivmsgs(items)
+	}
 	if err == nil && len(items) != 0 {
 		client.replayPrivmsgHistory(rb, items, "")
 	}